@@ -164,8 +164,10 @@ func main() {
 	cliSvc := resourceservice.NewCLIService(cliRepo, auditSvc)
 	patSvc := resourceservice.NewPATService(patRepo, auditSvc)
 	jobSvc := cicdservice.NewBuildJobService(jobRepo, repoRepo)
+	jobSvc.SetCredentials(credRepo)
 	runSvc := cicdservice.NewBuildRunService(runRepo, jobRepo)
-	webhookSvc := cicdservice.NewWebhookService(jobRepo, deliveryRepo, runSvc)
+	webhookSvc := cicdservice.NewWebhookService(jobRepo, repoRepo, deliveryRepo, runSvc)
+	webhookSvc.SetChangedFilesResolver(engine.NewGitDiffResolver(repoRepo, resourceservice.NewCredentialSecretResolver(credSvc)))
 
 	dashboardRepo := dashboardrepo.NewDashboardRepository(gdb)
 	dashboardSvc := dashboardservice.NewDashboardService(
@@ -183,6 +185,7 @@ func main() {
 	storageSvc, err := storageservice.NewStorageService(storageRepo, cfg.Storage.Root, storageservice.Limits{
 		AttachmentMaxBytes: cfg.Storage.AttachmentMaxBytes,
 		DocImportMaxBytes:  cfg.Storage.DocImportMaxBytes,
+		ArtifactMaxBytes:   cfg.Storage.ArtifactMaxBytes,
 	})
 	if err != nil {
 		logger.Fatal("Failed to init storage service", zap.Error(err))
@@ -215,10 +218,25 @@ func main() {
 	)
 	pipeline.SetAgentEventHook(agentSvc)
 	pipeline.SetTerminalNotifier(notifSvc)
+	pipeline.SetApprovalNotifier(notifSvc)
+	pipeline.SetArtifactStore(storageSvc)
+	commitStatuses := engine.NewCommitStatusReporter(runRepo, jobRepo, repoRepo,
+		resourceservice.NewCredentialSecretResolver(credSvc), cfg.Server.PublicURL, logger)
+	pipeline.SetCommitStatusReporter(commitStatuses)
+	runSvc.SetArtifactStore(storageSvc)
 	sched := engine.NewScheduler(cfg.Build.MaxConcurrent, pipeline, runRepo, logger)
 	runSvc.SetScheduler(sched)
 	cronSched := engine.NewCronScheduler(jobRepo, runRepo, runSvc, sched, logger)
 	jobSvc.SetCron(cronSched)
+	poller := engine.NewPollScheduler(jobRepo, repoRepo, runRepo, runSvc,
+		resourceservice.NewCredentialSecretResolver(credSvc), logger)
+	poller.SetBranchCache(repoSvc)
+	logCompactor := engine.NewLogCompactor(cfg.Build.LogDir, cfg.Build.LogCompressAfterDuration(), func(err error) {
+		logger.Warn("build log compression failed", zap.Error(err))
+	})
+	artifactSweeper := engine.NewArtifactSweeper(runRepo, jobRepo, storageSvc, cfg.Build.ArtifactSweepIntervalDuration(), logger)
+	pipeline.BuildCache().SetHardlinks(cfg.Build.CacheHardlinks)
+	cacheEvictor := engine.NewCacheEvictor(pipeline.BuildCache(), cfg.Build.CacheMaxBytes, cfg.Build.CacheSweepIntervalDuration(), logger)
 
 	credHandler := resourcehandler.NewCredentialHandler(credSvc, permSvc)
 	repoHandler := resourcehandler.NewRepositoryHandler(repoSvc, permSvc)
@@ -227,7 +245,7 @@ func main() {
 	tokenHandler := resourcehandler.NewTokenHandler(patSvc, permSvc)
 	jobHandler := cicdhandler.NewBuildJobHandler(jobSvc, runSvc, permSvc)
 	runHandler := cicdhandler.NewBuildRunHandler(runSvc, permSvc)
-	webhookHandler := cicdhandler.NewWebhookHandler(webhookSvc)
+	webhookHandler := cicdhandler.NewWebhookHandler(webhookSvc, permSvc)
 
 	r := gin.Default()
 	r.Use(gzip.Gzip(gzip.DefaultCompression))
@@ -250,7 +268,7 @@ func main() {
 	tokenHandler.RegisterRoutes(api, authMW)
 	jobHandler.RegisterRoutes(api, authMW)
 	runHandler.RegisterRoutes(api, authMW)
-	webhookHandler.RegisterRoutes(api)
+	webhookHandler.RegisterRoutes(api, authMW)
 	dashboardHandler.RegisterRoutes(api, authMW)
 	opsHandler.RegisterRoutes(api, authMW)
 	projectHandler.RegisterRoutes(api, authMW)
@@ -295,6 +313,10 @@ func main() {
 	if err := cronSched.Start(); err != nil {
 		logger.Error("cron start failed", zap.Error(err))
 	}
+	poller.Start()
+	logCompactor.Start()
+	artifactSweeper.Start()
+	cacheEvictor.Start()
 
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
 	srv := &http.Server{Addr: addr, Handler: r}
@@ -312,7 +334,12 @@ func main() {
 	logger.Info("Shutting down...")
 
 	cronSched.Stop()
+	poller.Stop()
+	logCompactor.Stop()
+	artifactSweeper.Stop()
+	cacheEvictor.Stop()
 	sched.Shutdown()
+	commitStatuses.Close()
 	devEnvSvc.Shutdown()
 	agentSvc.Shutdown()
 	hub.Shutdown()
@@ -39,8 +39,13 @@ const (
 
 // RepoBinding is the API projection of an agent↔repository checkout binding.
 type RepoBinding struct {
-	RepositoryID uint   `json:"repository_id"`
-	Branch       string `json:"branch"`
+	RepositoryID uint     `json:"repository_id"`
+	Branch       string   `json:"branch"`
+	Ref          string   `json:"ref"`
+	Shallow      bool     `json:"shallow"`
+	Submodules   bool     `json:"submodules"`
+	LFS          bool     `json:"lfs"`
+	SparsePaths  []string `json:"sparse_paths"`
 }
 
 // EnvVarView is the API projection of an agent env var (never includes plaintext value).
@@ -55,6 +60,11 @@ type AgentRepoBinding struct {
 	AgentID      uint      `json:"agent_id" gorm:"not null;uniqueIndex:uidx_agent_repo;index"`
 	RepositoryID uint      `json:"repository_id" gorm:"not null;uniqueIndex:uidx_agent_repo;index"`
 	Branch       string    `json:"branch" gorm:"size:200;not null;default:main"`
+	Ref          string    `json:"ref" gorm:"size:300"`
+	Shallow      bool      `json:"shallow" gorm:"not null;default:false"`
+	Submodules   bool      `json:"submodules" gorm:"not null;default:false"`
+	LFS          bool      `json:"lfs" gorm:"not null;default:false"`
+	SparsePaths  string    `json:"sparse_paths" gorm:"type:text"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 }
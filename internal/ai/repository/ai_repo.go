@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -48,6 +49,8 @@ func (r *AIRepository) ReplaceAgentRepoBindings(agentID uint, bindings []model.R
 		for _, b := range bindings {
 			row := model.AgentRepoBinding{
 				AgentID: agentID, RepositoryID: b.RepositoryID, Branch: b.Branch,
+				Ref: b.Ref, Shallow: b.Shallow, Submodules: b.Submodules, LFS: b.LFS,
+				SparsePaths: strings.Join(b.SparsePaths, "\n"),
 			}
 			if err := tx.Create(&row).Error; err != nil {
 				return err
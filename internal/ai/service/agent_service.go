@@ -20,6 +20,7 @@ import (
 	"bedrock/internal/ai/model"
 	"bedrock/internal/ai/repository"
 	cicdmodel "bedrock/internal/cicd/model"
+	"bedrock/internal/pkg"
 	resourcemodel "bedrock/internal/resource/model"
 	"bedrock/internal/ws"
 )
@@ -601,6 +602,9 @@ func (s *AgentService) ExecuteRun(ctx context.Context, id uint) {
 		return
 	}
 
+	// Mask agent env values and repo credentials in the run log, output and errors.
+	redact := pkg.NewRedactor(s.runSecrets(agent)...)
+
 	now := time.Now().UTC()
 	run.Status = model.JobRunning
 	run.StartedAt = &now
@@ -618,6 +622,7 @@ func (s *AgentService) ExecuteRun(ctx context.Context, id uint) {
 	}
 	defer logFile.Close()
 	writeLog := func(line string) {
+		line = redact.Redact(line)
 		_, _ = logFile.WriteString(line + "\n")
 		if s.hub != nil {
 			s.hub.BroadcastToChannel(fmt.Sprintf("ai-run:%d", run.ID), []byte(line))
@@ -636,7 +641,7 @@ func (s *AgentService) ExecuteRun(ctx context.Context, id uint) {
 
 	digests, repoDirs, err := s.SyncAgentWorkspace(agent, run.TriggeredBy, true)
 	if err != nil {
-		s.failRun(run, err)
+		s.failRun(run, redactErr(redact, err))
 		return
 	}
 	if len(digests) > 0 {
@@ -717,7 +722,7 @@ func (s *AgentService) ExecuteRun(ctx context.Context, id uint) {
 		buf := make([]byte, 0, 64*1024)
 		sc.Buffer(buf, 1024*1024)
 		for sc.Scan() {
-			line := sc.Text()
+			line := redact.Redact(sc.Text())
 			writeLog(line)
 			output.WriteString(line)
 			output.WriteByte('\n')
@@ -727,8 +732,9 @@ func (s *AgentService) ExecuteRun(ctx context.Context, id uint) {
 	wg.Add(2)
 	go func() { defer wg.Done(); copyStream(stdout) }()
 	go func() { defer wg.Done(); copyStream(stderr) }()
-	err = cmd.Wait()
+	// Drain both pipes before Wait closes them, or trailing output is lost.
 	wg.Wait()
+	err = cmd.Wait()
 
 	latest, _ := s.repo.FindRun(run.ID)
 	if latest != nil && latest.Status == model.JobCancelled {
@@ -745,7 +751,7 @@ func (s *AgentService) ExecuteRun(ctx context.Context, id uint) {
 	run.OutputText = output.String()
 	if err != nil {
 		run.Status = model.JobFailed
-		run.ErrorMessage = err.Error()
+		run.ErrorMessage = redact.Redact(err.Error())
 		writeLog("failed: " + err.Error())
 		_ = s.repo.UpdateRun(run)
 		s.notifyTerminal(run, model.JobFailed)
@@ -771,6 +777,34 @@ func (s *AgentService) ExecuteRun(ctx context.Context, id uint) {
 	s.notifyTerminal(run, model.JobSuccess)
 }
 
+// runSecrets lists values the run log must never show: decrypted agent env
+// values and the credentials of bound repositories.
+func (s *AgentService) runSecrets(agent *model.AiAgent) []string {
+	var out []string
+	if vars, err := decryptAgentEnvVars(agent.EnvVarsCipher); err == nil {
+		for _, v := range vars {
+			out = append(out, v)
+		}
+	}
+	if s.repos == nil {
+		return out
+	}
+	for _, b := range agent.RepoBindings {
+		repo, err := s.repos.FindByID(b.RepositoryID)
+		if err != nil {
+			continue
+		}
+		if auth, err := s.resolveRepoGitAuth(repo); err == nil {
+			out = append(out, auth.Secret, auth.Passphrase)
+		}
+	}
+	return out
+}
+
+func redactErr(r *pkg.Redactor, err error) error {
+	return errors.New(r.Redact(err.Error()))
+}
+
 func (s *AgentService) failRun(run *model.AgentRun, err error) {
 	finished := time.Now().UTC()
 	run.Status = model.JobFailed
@@ -16,6 +16,8 @@ import (
 	"bedrock/internal/ai/model"
 	"bedrock/internal/ai/repository"
 	"bedrock/internal/ai/service"
+	"bedrock/internal/engine"
+	"bedrock/internal/pkg"
 	"bedrock/internal/platform/config"
 	"bedrock/internal/platform/db"
 	"bedrock/internal/platform/migration"
@@ -39,10 +41,14 @@ func (f *stubRepoFinder) FindByID(id uint) (*resourcemodel.Repository, error) {
 	return repo, nil
 }
 
-func stubGitCheckout(_ context.Context, workDir, _repoURL, _authType, _username, _password, branch string, _logFn func(string)) error {
+func stubGitCheckout(_ context.Context, workDir, _repoURL string, _auth engine.GitAuth, opts engine.GitCheckoutOptions, _logFn func(string)) error {
 	if err := os.MkdirAll(workDir, 0o755); err != nil {
 		return err
 	}
+	branch := opts.Branch
+	if opts.Ref != "" {
+		branch = opts.Ref
+	}
 	return os.WriteFile(filepath.Join(workDir, "BRANCH"), []byte(branch), 0o644)
 }
 
@@ -191,6 +197,53 @@ func TestAgentWorkspaceDefaultBranchAndDuplicateRejected(t *testing.T) {
 	}
 }
 
+func TestAgentWorkspaceCheckoutOptions(t *testing.T) {
+	agents, _, _, _ := setupAgentWorkspace(t)
+	var got engine.GitCheckoutOptions
+	agents.SetGitCheckout(func(ctx context.Context, workDir, repoURL string, auth engine.GitAuth, opts engine.GitCheckoutOptions, logFn func(string)) error {
+		got = opts
+		return stubGitCheckout(ctx, workDir, repoURL, auth, opts, logFn)
+	})
+	repoID := uint(4)
+	agents.SetRepoCheckoutDeps(&stubRepoFinder{
+		repos: map[uint]*resourcemodel.Repository{
+			repoID: {ID: repoID, Name: "r", RepoURL: "https://example.com/r.git", AuthType: "none"},
+		},
+	}, nil)
+
+	agent, err := agents.CreateAgent(1, service.AgentInput{
+		Name: "opts", CliKey: "claude_code",
+		RepoBindings: []model.RepoBinding{{
+			RepositoryID: repoID, Ref: "refs/tags/v1.2.0", Shallow: true,
+			Submodules: true, SparsePaths: []string{"services/api", " ", "libs"},
+		}},
+		TimeoutSec: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent = waitWorkspaceStatus(t, agents, agent.ID, model.WorkspaceReady)
+	b := agent.RepoBindings[0]
+	if b.Ref != "refs/tags/v1.2.0" || !b.Shallow || !b.Submodules || b.LFS || strings.Join(b.SparsePaths, ",") != "services/api,libs" {
+		t.Fatalf("binding=%#v", b)
+	}
+	if got.Ref != b.Ref || got.Branch != "main" || !got.Submodules || len(got.SparsePaths) != 2 {
+		t.Fatalf("checkout opts=%#v", got)
+	}
+
+	for _, bad := range []model.RepoBinding{
+		{RepositoryID: repoID, Ref: "refs/../x"},
+		{RepositoryID: repoID, SparsePaths: []string{"../outside"}},
+		{RepositoryID: repoID, SparsePaths: []string{"src/*.go"}},
+	} {
+		if _, err := agents.CreateAgent(1, service.AgentInput{
+			Name: "bad", CliKey: "claude_code", RepoBindings: []model.RepoBinding{bad}, TimeoutSec: 10,
+		}); err == nil {
+			t.Fatalf("expected error for %#v", bad)
+		}
+	}
+}
+
 func TestAgentWorkspaceRemovesStaleJobLinksAndUnboundRepos(t *testing.T) {
 	agents, _, _, work := setupAgentWorkspace(t)
 	repoKeep := uint(1)
@@ -419,9 +472,9 @@ func TestAgentWorkspaceNoOpenCodeExternalDirs(t *testing.T) {
 func TestAgentManualRunRejectedWhileWorkspacePending(t *testing.T) {
 	agents, _, _, _ := setupAgentWorkspace(t)
 	block := make(chan struct{})
-	agents.SetGitCheckout(func(ctx context.Context, workDir, repoURL, authType, username, password, branch string, logFn func(string)) error {
+	agents.SetGitCheckout(func(ctx context.Context, workDir, repoURL string, auth engine.GitAuth, opts engine.GitCheckoutOptions, logFn func(string)) error {
 		<-block
-		return stubGitCheckout(ctx, workDir, repoURL, authType, username, password, branch, logFn)
+		return stubGitCheckout(ctx, workDir, repoURL, auth, opts, logFn)
 	})
 	repoID := uint(11)
 	agents.SetRepoCheckoutDeps(&stubRepoFinder{
@@ -770,3 +823,60 @@ func TestAgentRunNonStreamOutputCLIArgs(t *testing.T) {
 	}
 	t.Fatal("timeout")
 }
+
+func TestAgentRunLogRedactsEnvSecrets(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh required")
+	}
+	if err := pkg.InitEncryption(strings.Repeat("ef", 32)); err != nil {
+		t.Fatal(err)
+	}
+	agents, _, repo, _ := setupAgentWorkspace(t)
+	secret := "sk-agent-secret-1"
+	script := filepath.Join(t.TempDir(), "fake-cli-leak.sh")
+	content := "#!/bin/sh\necho \"key=$API_KEY\"\n"
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cli, err := repo.FindByKey("claude_code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli.InstalledPath = script
+	cli.InstallStatus = "installed"
+	cli.Healthy = true
+	if err := repo.Update(cli); err != nil {
+		t.Fatal(err)
+	}
+	agent, err := agents.CreateAgent(1, service.AgentInput{
+		Name: "leaky", CliKey: "claude_code", SystemPrompt: "do work", TimeoutSec: 30,
+		EnvVars: []service.EnvVarInput{{Key: "API_KEY", Value: &secret}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent = waitWorkspaceStatus(t, agents, agent.ID, model.WorkspaceReady)
+	run, err := agents.ManualRun(agent.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		got, _ := agents.GetRun(run.ID)
+		if got != nil && (got.Status == model.JobSuccess || got.Status == model.JobFailed) {
+			raw, err := os.ReadFile(got.LogPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(raw), secret) || strings.Contains(got.OutputText, secret) {
+				t.Fatalf("secret leaked:\n%s", raw)
+			}
+			if !strings.Contains(got.OutputText, "key=***") {
+				t.Fatalf("output=%q", got.OutputText)
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("timeout")
+}
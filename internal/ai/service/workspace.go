@@ -24,8 +24,8 @@ type SecretResolver interface {
 	Resolve(id uint) (typ, username, secret, passphrase string, err error)
 }
 
-// GitCheckoutFunc clones or updates a repository into workDir at branch.
-type GitCheckoutFunc func(ctx context.Context, workDir, repoURL, authType, username, password, branch string, logFn func(string)) error
+// GitCheckoutFunc clones or updates a repository into workDir as opts select.
+type GitCheckoutFunc func(ctx context.Context, workDir, repoURL string, auth engine.GitAuth, opts engine.GitCheckoutOptions, logFn func(string)) error
 
 // SetRepoCheckoutDeps wires repository + credential resolution for SyncAgentWorkspace.
 func (s *AgentService) SetRepoCheckoutDeps(repos RepositoryFinder, secrets SecretResolver) {
@@ -164,7 +164,7 @@ func (s *AgentService) syncRepoCheckouts(agentRoot string, bindings []model.Repo
 
 	checkout := s.gitCheckout
 	if checkout == nil {
-		checkout = engine.GitCloneOrPull
+		checkout = engine.GitCheckout
 	}
 
 	var synced []string
@@ -173,7 +173,7 @@ func (s *AgentService) syncRepoCheckouts(agentRoot string, bindings []model.Repo
 		if err != nil {
 			return nil, fmt.Errorf("仓库 %d 不存在: %w", b.RepositoryID, err)
 		}
-		authType, username, password, err := s.resolveRepoGitAuth(repo)
+		auth, err := s.resolveRepoGitAuth(repo)
 		if err != nil {
 			return nil, fmt.Errorf("仓库 %d 凭证错误: %w", b.RepositoryID, err)
 		}
@@ -183,8 +183,16 @@ func (s *AgentService) syncRepoCheckouts(agentRoot string, bindings []model.Repo
 				s.logger.Info("agent git", zap.Uint("repository_id", b.RepositoryID), zap.String("line", line))
 			}
 		}
-		if err := checkout(context.Background(), dest, repo.RepoURL, authType, username, password, b.Branch, logFn); err != nil {
-			return nil, fmt.Errorf("同步仓库 %d (%s) 失败: %w", b.RepositoryID, b.Branch, err)
+		opts := engine.GitCheckoutOptions{
+			Branch: b.Branch, Ref: b.Ref, Shallow: b.Shallow,
+			Submodules: b.Submodules, LFS: b.LFS, SparsePaths: b.SparsePaths,
+		}
+		target := b.Branch
+		if b.Ref != "" {
+			target = b.Ref
+		}
+		if err := checkout(context.Background(), dest, repo.RepoURL, auth, opts, logFn); err != nil {
+			return nil, fmt.Errorf("同步仓库 %d (%s) 失败: %w", b.RepositoryID, target, err)
 		}
 		absDest, err := filepath.Abs(dest)
 		if err != nil {
@@ -195,28 +203,26 @@ func (s *AgentService) syncRepoCheckouts(agentRoot string, bindings []model.Repo
 	return synced, nil
 }
 
-func (s *AgentService) resolveRepoGitAuth(repo *resourcemodel.Repository) (authType, username, password string, err error) {
+func (s *AgentService) resolveRepoGitAuth(repo *resourcemodel.Repository) (engine.GitAuth, error) {
 	switch strings.ToLower(strings.TrimSpace(repo.AuthType)) {
 	case "", "none":
-		return "none", "", "", nil
+		return engine.GitAuth{Type: engine.GitAuthNone}, nil
 	case "credential":
 		if repo.CredentialID == nil || *repo.CredentialID == 0 {
-			return "", "", "", fmt.Errorf("repository credential is empty")
+			return engine.GitAuth{}, fmt.Errorf("repository credential is empty")
 		}
 		if s.secrets == nil {
-			return "", "", "", fmt.Errorf("secret resolver not configured")
+			return engine.GitAuth{}, fmt.Errorf("secret resolver not configured")
 		}
-		typ, user, secret, _, err := s.secrets.Resolve(*repo.CredentialID)
+		typ, user, secret, passphrase, err := s.secrets.Resolve(*repo.CredentialID)
 		if err != nil {
-			return "", "", "", err
-		}
-		authType = "password"
-		if strings.EqualFold(typ, "token") || strings.EqualFold(typ, "api_key") {
-			authType = "token"
+			return engine.GitAuth{}, err
 		}
-		return authType, user, secret, nil
+		auth := engine.NewGitAuth(typ, user, secret, passphrase)
+		auth.KnownHosts = repo.SSHKnownHosts
+		return auth, nil
 	default:
-		return "none", "", "", nil
+		return engine.GitAuth{Type: engine.GitAuthNone}, nil
 	}
 }
 
@@ -309,6 +315,8 @@ func (s *AgentService) attachRepoBindings(agent *model.AiAgent) error {
 	for _, row := range rows {
 		bindings = append(bindings, model.RepoBinding{
 			RepositoryID: row.RepositoryID, Branch: row.Branch,
+			Ref: row.Ref, Shallow: row.Shallow, Submodules: row.Submodules, LFS: row.LFS,
+			SparsePaths: engine.ParseSparsePaths(row.SparsePaths),
 		})
 	}
 	agent.RepoBindings = bindings
@@ -333,12 +341,30 @@ func (s *AgentService) normalizeRepoBindings(in []model.RepoBinding) ([]model.Re
 		if branch == "" {
 			branch = "main"
 		}
+		ref := strings.TrimSpace(b.Ref)
+		if ref != "" {
+			if err := engine.ValidateGitRef(ref); err != nil {
+				return nil, fmt.Errorf("仓库 %d 的 ref 无效: %w", b.RepositoryID, err)
+			}
+		}
+		sparse := make([]string, 0, len(b.SparsePaths))
+		for _, p := range b.SparsePaths {
+			if p = strings.TrimSpace(p); p != "" {
+				sparse = append(sparse, p)
+			}
+		}
+		if err := engine.ValidateSparsePaths(sparse); err != nil {
+			return nil, fmt.Errorf("仓库 %d 的稀疏检出路径无效: %w", b.RepositoryID, err)
+		}
 		if s.repos != nil {
 			if _, err := s.repos.FindByID(b.RepositoryID); err != nil {
 				return nil, fmt.Errorf("仓库不存在: %d", b.RepositoryID)
 			}
 		}
-		out = append(out, model.RepoBinding{RepositoryID: b.RepositoryID, Branch: branch})
+		out = append(out, model.RepoBinding{
+			RepositoryID: b.RepositoryID, Branch: branch, Ref: ref, Shallow: b.Shallow,
+			Submodules: b.Submodules, LFS: b.LFS, SparsePaths: sparse,
+		})
 	}
 	return out, nil
 }
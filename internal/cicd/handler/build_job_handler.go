@@ -3,6 +3,7 @@ package handler
 import (
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 
@@ -32,10 +33,22 @@ func (h *BuildJobHandler) RegisterRoutes(rg *gin.RouterGroup, authMW gin.Handler
 	g.DELETE("/:id", rbacmw.RequirePermission(h.perm, "cicd_build_jobs:delete"), h.Delete)
 	g.GET("/:id/webhook-secret", rbacmw.RequirePermission(h.perm, "cicd_build_jobs:view"), h.GetWebhookSecret)
 	g.POST("/:id/webhook-secret/rotate", rbacmw.RequirePermission(h.perm, "cicd_build_jobs:update"), h.RotateWebhookSecret)
+	g.GET("/:id/artifact-retention/preview", rbacmw.RequirePermission(h.perm, "cicd_build_jobs:view"), h.PreviewArtifactRetention)
+	g.GET("/:id/releases", rbacmw.RequirePermission(h.perm, "cicd_build_runs:view"), h.ListReleases)
+	g.GET("/:id/releases/*version", rbacmw.RequirePermission(h.perm, "cicd_build_runs:view"), h.GetRelease)
+	g.GET("/:id/deployments", rbacmw.RequirePermission(h.perm, "cicd_build_runs:view"), h.ListDeployments)
+	g.GET("/:id/deployments/history", rbacmw.RequirePermission(h.perm, "cicd_build_runs:view"), h.ListDeploymentHistory)
+	g.POST("/:id/rollback", rbacmw.RequirePermission(h.perm, "cicd_build_jobs:execute"), h.Rollback)
 	// Execute: only cicd_build_jobs:execute required (not credentials:use) — DESIGN §4.5 / Wave 4 engine.
 	g.POST("/:id/runs", rbacmw.RequirePermission(h.perm, "cicd_build_jobs:execute"), h.EnqueueRun)
 }
 
+func (h *BuildJobHandler) canUseCredential(c *gin.Context) bool {
+	userID := authmiddleware.GetUserID(c)
+	isSuper := authmiddleware.IsSuperAdmin(c)
+	return h.perm.CheckAccess(userID, isSuper, "resource_credentials:use") == nil
+}
+
 func (h *BuildJobHandler) List(c *gin.Context) {
 	page := pkg.ParsePage(c)
 	var repoID *uint
@@ -73,7 +86,7 @@ func (h *BuildJobHandler) Create(c *gin.Context) {
 		pkg.Error(c, http.StatusBadRequest, "参数错误")
 		return
 	}
-	item, err := h.svc.Create(authmiddleware.GetUserID(c), req)
+	item, err := h.svc.Create(authmiddleware.GetUserID(c), req, h.canUseCredential(c))
 	if err != nil {
 		writeServiceError(c, err)
 		return
@@ -92,7 +105,7 @@ func (h *BuildJobHandler) Update(c *gin.Context) {
 		pkg.Error(c, http.StatusBadRequest, "参数错误")
 		return
 	}
-	item, err := h.svc.Update(id, req)
+	item, err := h.svc.Update(id, req, h.canUseCredential(c))
 	if err != nil {
 		writeServiceError(c, err)
 		return
@@ -162,3 +175,103 @@ func (h *BuildJobHandler) RotateWebhookSecret(c *gin.Context) {
 		"webhook_url":    "/api/v1/webhook/jobs/" + strconv.FormatUint(uint64(item.ID), 10) + "/" + item.WebhookSecret,
 	})
 }
+
+// PreviewArtifactRetention is a dry run of the job's artifact retention policy.
+func (h *BuildJobHandler) PreviewArtifactRetention(c *gin.Context) {
+	id, err := parseID(c)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效 ID")
+		return
+	}
+	plan, err := h.runs.PreviewArtifactRetention(id)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	pkg.Success(c, plan)
+}
+
+// ListReleases pages through the job's releases (successful tag runs).
+func (h *BuildJobHandler) ListReleases(c *gin.Context) {
+	id, err := parseID(c)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效 ID")
+		return
+	}
+	page := pkg.ParsePage(c)
+	items, total, err := h.runs.ListReleases(id, page.Page, page.PageSize)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	pkg.PageSuccess(c, items, total, page)
+}
+
+// ListDeployments returns what each deploy target runs now.
+func (h *BuildJobHandler) ListDeployments(c *gin.Context) {
+	id, err := parseID(c)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效 ID")
+		return
+	}
+	items, err := h.runs.ListDeployments(id)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	pkg.Success(c, items)
+}
+
+func (h *BuildJobHandler) ListDeploymentHistory(c *gin.Context) {
+	id, err := parseID(c)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效 ID")
+		return
+	}
+	var targetID *uint
+	if v := c.Query("deploy_target_id"); v != "" {
+		if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+			u := uint(n)
+			targetID = &u
+		}
+	}
+	page := pkg.ParsePage(c)
+	items, total, err := h.runs.ListDeploymentHistory(id, targetID, page.Page, page.PageSize)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	pkg.PageSuccess(c, items, total, page)
+}
+
+// Rollback redeploys the previous artifact to one target or all targets.
+func (h *BuildJobHandler) Rollback(c *gin.Context) {
+	id, err := parseID(c)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效 ID")
+		return
+	}
+	var req service.RollbackInput
+	_ = c.ShouldBindJSON(&req)
+	items, err := h.runs.Rollback(id, authmiddleware.GetUserID(c), authmiddleware.GetUsername(c), req)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, pkg.Response{Code: 0, Message: "accepted", Data: items})
+}
+
+// GetRelease looks up a release by version label (may contain "/").
+func (h *BuildJobHandler) GetRelease(c *gin.Context) {
+	id, err := parseID(c)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效 ID")
+		return
+	}
+	item, err := h.runs.GetRelease(id, strings.TrimPrefix(c.Param("version"), "/"))
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	pkg.Success(c, item)
+}
@@ -2,7 +2,6 @@ package handler
 
 import (
 	"net/http"
-	"os"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -28,10 +27,17 @@ func (h *BuildRunHandler) RegisterRoutes(rg *gin.RouterGroup, authMW gin.Handler
 	g.GET("", rbacmw.RequirePermission(h.perm, "cicd_build_runs:view"), h.List)
 	g.GET("/:id", rbacmw.RequirePermission(h.perm, "cicd_build_runs:view"), h.Get)
 	g.GET("/:id/log", rbacmw.RequirePermission(h.perm, "cicd_build_runs:view"), h.Log)
+	g.GET("/:id/log/lines", rbacmw.RequirePermission(h.perm, "cicd_build_runs:view"), h.LogLines)
+	g.GET("/:id/log/search", rbacmw.RequirePermission(h.perm, "cicd_build_runs:view"), h.LogSearch)
+	g.GET("/:id/tests", rbacmw.RequirePermission(h.perm, "cicd_build_runs:view"), h.Tests)
+	g.GET("/:id/tests/compare", rbacmw.RequirePermission(h.perm, "cicd_build_runs:view"), h.CompareTests)
 	g.GET("/:id/artifact", rbacmw.RequirePermission(h.perm, "cicd_build_runs:view"), h.Artifact)
 	g.POST("/:id/cancel", rbacmw.RequirePermission(h.perm, "cicd_build_jobs:execute"), h.Cancel)
 	g.POST("/:id/retry", rbacmw.RequirePermission(h.perm, "cicd_build_jobs:execute"), h.Retry)
 	g.POST("/:id/redeploy", rbacmw.RequirePermission(h.perm, "cicd_build_jobs:execute"), h.Redeploy)
+	g.POST("/:id/approval", rbacmw.RequirePermission(h.perm, "cicd_build_jobs:approve"), h.DecideApproval)
+	g.POST("/:id/pin", rbacmw.RequirePermission(h.perm, "cicd_build_jobs:update"), h.Pin)
+	g.DELETE("/:id/pin", rbacmw.RequirePermission(h.perm, "cicd_build_jobs:update"), h.Unpin)
 }
 
 func (h *BuildRunHandler) List(c *gin.Context) {
@@ -101,7 +107,7 @@ func (h *BuildRunHandler) Redeploy(c *gin.Context) {
 	}
 	var req service.RedeployInput
 	_ = c.ShouldBindJSON(&req)
-	item, err := h.svc.Redeploy(id, req)
+	item, err := h.svc.Redeploy(id, authmiddleware.GetUserID(c), authmiddleware.GetUsername(c), req)
 	if err != nil {
 		writeServiceError(c, err)
 		return
@@ -109,13 +115,50 @@ func (h *BuildRunHandler) Redeploy(c *gin.Context) {
 	c.JSON(http.StatusAccepted, pkg.Response{Code: 0, Message: "accepted", Data: item})
 }
 
+// DecideApproval approves or rejects the run's pending deployment approval.
+func (h *BuildRunHandler) DecideApproval(c *gin.Context) {
+	id, err := parseID(c)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效 ID")
+		return
+	}
+	var req service.ApprovalInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		pkg.Error(c, http.StatusBadRequest, "参数错误")
+		return
+	}
+	item, err := h.svc.DecideApproval(id, authmiddleware.GetUserID(c), authmiddleware.GetUsername(c), req)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, pkg.Response{Code: 0, Message: "accepted", Data: item})
+}
+
+func (h *BuildRunHandler) Pin(c *gin.Context)   { h.setPinned(c, true) }
+func (h *BuildRunHandler) Unpin(c *gin.Context) { h.setPinned(c, false) }
+
+func (h *BuildRunHandler) setPinned(c *gin.Context, pinned bool) {
+	id, err := parseID(c)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效 ID")
+		return
+	}
+	item, err := h.svc.SetArtifactPinned(id, pinned)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	pkg.Success(c, item)
+}
+
 func (h *BuildRunHandler) Artifact(c *gin.Context) {
 	id, err := parseID(c)
 	if err != nil {
 		pkg.Error(c, http.StatusBadRequest, "无效 ID")
 		return
 	}
-	path, filename, err := h.svc.ArtifactPath(id)
+	path, filename, err := h.svc.ArtifactPath(id, c.Query("name"))
 	if err != nil {
 		writeServiceError(c, err)
 		return
@@ -123,21 +166,86 @@ func (h *BuildRunHandler) Artifact(c *gin.Context) {
 	c.FileAttachment(path, filename)
 }
 
+// Log returns a line-aligned byte range of the log (default 1 MiB from offset,
+// or the last `tail` bytes) so large logs are fetched incrementally.
 func (h *BuildRunHandler) Log(c *gin.Context) {
 	id, err := parseID(c)
 	if err != nil {
 		pkg.Error(c, http.StatusBadRequest, "无效 ID")
 		return
 	}
-	path, err := h.svc.LogPath(id)
+	rng, err := h.svc.ReadLog(id, queryInt64(c, "offset"), queryInt64(c, "limit"), queryInt64(c, "tail"))
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.Header("X-Log-Offset", strconv.FormatInt(rng.Offset, 10))
+	c.Header("X-Log-Next-Offset", strconv.FormatInt(rng.Next, 10))
+	c.Header("X-Log-Size", strconv.FormatInt(rng.Size, 10))
+	if c.Query("format") == "ndjson" {
+		c.Data(http.StatusOK, "application/x-ndjson; charset=utf-8", rng.Data)
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", rng.Text())
+}
+
+func (h *BuildRunHandler) LogLines(c *gin.Context) {
+	id, err := parseID(c)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效 ID")
+		return
+	}
+	page, err := h.svc.LogLines(id, int(queryInt64(c, "from")), int(queryInt64(c, "limit")), c.Query("stage"))
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	pkg.Success(c, page)
+}
+
+func (h *BuildRunHandler) LogSearch(c *gin.Context) {
+	id, err := parseID(c)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效 ID")
+		return
+	}
+	page, err := h.svc.SearchLog(id, c.Query("q"), c.Query("stage"), int(queryInt64(c, "limit")))
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	pkg.Success(c, page)
+}
+
+func (h *BuildRunHandler) Tests(c *gin.Context) {
+	id, err := parseID(c)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效 ID")
+		return
+	}
+	out, err := h.svc.TestResults(id, c.Query("status"))
 	if err != nil {
 		writeServiceError(c, err)
 		return
 	}
-	data, err := os.ReadFile(path)
+	pkg.Success(c, out)
+}
+
+func (h *BuildRunHandler) CompareTests(c *gin.Context) {
+	id, err := parseID(c)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效 ID")
+		return
+	}
+	out, err := h.svc.CompareTests(id, uint(queryInt64(c, "base")))
 	if err != nil {
-		pkg.Error(c, http.StatusNotFound, "日志文件不存在")
+		writeServiceError(c, err)
 		return
 	}
-	c.Data(http.StatusOK, "text/plain; charset=utf-8", data)
+	pkg.Success(c, out)
+}
+
+func queryInt64(c *gin.Context, key string) int64 {
+	v, _ := strconv.ParseInt(c.Query(key), 10, 64)
+	return v
 }
@@ -63,7 +63,7 @@ func TestBuildRunHandler_ArtifactDownload(t *testing.T) {
 	}
 	job, err := jobSvc.Create(1, service.CreateBuildJobInput{
 		RepositoryID: repo.ID, Name: "h-job", BuildScript: "echo",
-	})
+	}, false)
 	if err != nil {
 		t.Fatal(err)
 	}
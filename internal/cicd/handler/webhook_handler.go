@@ -9,19 +9,29 @@ import (
 
 	"bedrock/internal/cicd/service"
 	"bedrock/internal/pkg"
+	rbacmw "bedrock/internal/rbac/middleware"
+	rbacservice "bedrock/internal/rbac/service"
 )
 
 type WebhookHandler struct {
-	svc *service.WebhookService
+	svc  *service.WebhookService
+	perm *rbacservice.PermissionService
 }
 
-func NewWebhookHandler(svc *service.WebhookService) *WebhookHandler {
-	return &WebhookHandler{svc: svc}
+func NewWebhookHandler(svc *service.WebhookService, perm *rbacservice.PermissionService) *WebhookHandler {
+	return &WebhookHandler{svc: svc, perm: perm}
 }
 
-func (h *WebhookHandler) RegisterRoutes(rg *gin.RouterGroup) {
+// RegisterRoutes mounts the unauthenticated receivers and the authenticated
+// delivery history of a build job.
+func (h *WebhookHandler) RegisterRoutes(rg *gin.RouterGroup, authMW gin.HandlerFunc) {
 	rg.POST("/webhook/jobs/:build_job_id/:secret", h.Receive)
-	rg.POST("/webhook/repos/:repository_id/:secret", h.ReceiveDeprecated)
+	rg.POST("/webhook/repos/:repository_id/:secret", h.ReceiveRepository)
+
+	g := rg.Group("/build-jobs", authMW)
+	g.GET("/:id/webhook-deliveries", rbacmw.RequirePermission(h.perm, "cicd_build_jobs:view"), h.ListDeliveries)
+	g.GET("/:id/webhook-deliveries/:delivery_id", rbacmw.RequirePermission(h.perm, "cicd_build_jobs:view"), h.GetDelivery)
+	g.POST("/:id/webhook-deliveries/:delivery_id/replay", rbacmw.RequirePermission(h.perm, "cicd_build_jobs:execute"), h.ReplayDelivery)
 }
 
 func (h *WebhookHandler) Receive(c *gin.Context) {
@@ -30,6 +40,24 @@ func (h *WebhookHandler) Receive(c *gin.Context) {
 		pkg.Error(c, http.StatusBadRequest, "无效构建任务 ID")
 		return
 	}
+	h.receive(c, func(secret string, headers map[string]string, body []byte) (*service.WebhookResult, error) {
+		return h.svc.Receive(uint(jobID), secret, headers, body)
+	})
+}
+
+// ReceiveRepository fans one repository hook out to all of its build jobs.
+func (h *WebhookHandler) ReceiveRepository(c *gin.Context) {
+	repoID, err := strconv.ParseUint(c.Param("repository_id"), 10, 64)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效仓库 ID")
+		return
+	}
+	h.receive(c, func(secret string, headers map[string]string, body []byte) (*service.WebhookResult, error) {
+		return h.svc.ReceiveRepository(uint(repoID), secret, headers, body)
+	})
+}
+
+func (h *WebhookHandler) receive(c *gin.Context, handle func(secret string, headers map[string]string, body []byte) (*service.WebhookResult, error)) {
 	secret := c.Param("secret")
 	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 2<<20))
 	if err != nil {
@@ -44,7 +72,7 @@ func (h *WebhookHandler) Receive(c *gin.Context) {
 		}
 	}
 
-	result, err := h.svc.Receive(uint(jobID), secret, headers, body)
+	result, err := handle(secret, headers, body)
 	if err != nil {
 		msg := service.RedactSecret(err.Error(), secret)
 		switch {
@@ -60,7 +88,64 @@ func (h *WebhookHandler) Receive(c *gin.Context) {
 	c.JSON(http.StatusAccepted, pkg.Response{Code: 0, Message: "accepted", Data: result})
 }
 
-func (h *WebhookHandler) ReceiveDeprecated(c *gin.Context) {
-	pkg.Error(c, http.StatusGone,
-		"仓库级 Webhook 已弃用，请改用构建任务 Webhook：POST /api/v1/webhook/jobs/:build_job_id/:secret")
+func (h *WebhookHandler) ListDeliveries(c *gin.Context) {
+	id, err := parseID(c)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效 ID")
+		return
+	}
+	page := pkg.ParsePage(c)
+	items, total, err := h.svc.ListDeliveries(id, c.Query("outcome"), page.Page, page.PageSize)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	pkg.PageSuccess(c, items, total, page)
+}
+
+func (h *WebhookHandler) GetDelivery(c *gin.Context) {
+	id, deliveryID, ok := parseDeliveryIDs(c)
+	if !ok {
+		return
+	}
+	item, err := h.svc.GetDelivery(id, deliveryID)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	pkg.Success(c, item)
+}
+
+type replayDeliveryRequest struct {
+	// BypassDedup builds even when the delivery was already processed.
+	BypassDedup bool `json:"bypass_dedup"`
+}
+
+func (h *WebhookHandler) ReplayDelivery(c *gin.Context) {
+	id, deliveryID, ok := parseDeliveryIDs(c)
+	if !ok {
+		return
+	}
+	var req replayDeliveryRequest
+	_ = c.ShouldBindJSON(&req)
+	result, err := h.svc.ReplayDelivery(id, deliveryID, req.BypassDedup)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, pkg.Response{Code: 0, Message: "accepted", Data: result})
+}
+
+func parseDeliveryIDs(c *gin.Context) (uint, uint, bool) {
+	id, err := parseID(c)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效 ID")
+		return 0, 0, false
+	}
+	deliveryID, err := strconv.ParseUint(c.Param("delivery_id"), 10, 64)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效投递 ID")
+		return 0, 0, false
+	}
+	return id, uint(deliveryID), true
 }
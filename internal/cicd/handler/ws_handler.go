@@ -1,11 +1,10 @@
 package handler
 
 import (
-	"bufio"
 	"fmt"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -89,18 +88,16 @@ func (h *WSHandler) HandleBuildRunLogs(c *gin.Context) {
 	h.hub.Register(client)
 	go ws.WritePump(client, h.hub)
 
+	// Replay only the last 256KB of the stored log. Older lines are not sent here;
+	// they are readable through the /log, /log/lines and /log/search endpoints.
 	if run.LogPath != "" {
-		if f, err := os.Open(run.LogPath); err == nil {
-			scanner := bufio.NewScanner(f)
-			buf := make([]byte, 0, 64*1024)
-			scanner.Buffer(buf, 1024*1024)
-			for scanner.Scan() {
+		if rng, err := h.runs.ReadLog(run.ID, 0, 0, 256*1024); err == nil && len(rng.Data) > 0 {
+			for _, line := range strings.Split(strings.TrimSuffix(string(rng.Text()), "\n"), "\n") {
 				select {
-				case client.Send <- []byte(scanner.Text()):
+				case client.Send <- []byte(line):
 				default:
 				}
 			}
-			f.Close()
 		}
 	}
 
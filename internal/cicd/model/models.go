@@ -36,11 +36,152 @@ type BuildJob struct {
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
 
-	DeployTargets []DeployTarget `json:"deploy_targets,omitempty" gorm:"foreignKey:BuildJobID"`
+	// PipelineFile pins the in-repo pipeline file; empty looks for the
+	// engine.DefaultPipelineFiles. A pipeline file's stages replace BuildScript.
+	PipelineFile string `json:"pipeline_file" gorm:"size:300"`
+
+	// TestReportPaths lists test report globs (JUnit XML, go test -json)
+	// collected after the build.
+	TestReportPaths string `json:"test_report_paths" gorm:"type:text"`
+
+	// Matrix fans a trigger out into one child run per cell.
+	MatrixJSON string       `json:"-" gorm:"type:text"`
+	Matrix     *BuildMatrix `json:"matrix" gorm:"-"`
+
+	// Parameters are the values a trigger may pass to the build.
+	ParametersJSON string           `json:"-" gorm:"type:text"`
+	Parameters     []BuildParameter `json:"parameters" gorm:"-"`
+
+	// Time limits in seconds (0 = none): whole run, then clone / build / distribute phases.
+	TimeoutSeconds           int `json:"timeout_seconds" gorm:"not null;default:0"`
+	CloneTimeoutSeconds      int `json:"clone_timeout_seconds" gorm:"not null;default:0"`
+	BuildTimeoutSeconds      int `json:"build_timeout_seconds" gorm:"not null;default:0"`
+	DistributeTimeoutSeconds int `json:"distribute_timeout_seconds" gorm:"not null;default:0"`
+
+	// Coverage report globs (Go coverprofile, Cobertura XML, LCOV). A run whose
+	// line coverage is below CoverageThreshold percent fails; 0 = no threshold.
+	CoverageReportPaths string  `json:"coverage_report_paths" gorm:"type:text"`
+	CoverageThreshold   float64 `json:"coverage_threshold" gorm:"not null;default:0"`
+
+	// Artifact retention besides MaxArtifacts (0 = no limit). Pinned runs and
+	// artifacts currently deployed to a DeployTarget are never removed.
+	ArtifactMaxAgeDays int `json:"artifact_max_age_days" gorm:"not null;default:0"`
+	ArtifactMaxTotalMB int `json:"artifact_max_total_mb" gorm:"not null;default:0"`
+
+	// Named artifacts archived after the build besides OutputDir; each run
+	// records them in BuildRun.Artifacts and DeployTargets pick one by name.
+	ArtifactDefsJSON string        `json:"-" gorm:"type:text"`
+	ArtifactDefs     []ArtifactDef `json:"artifact_defs" gorm:"-"`
+
+	// Build cache keying for CachePaths. The entry key is CacheKey (default
+	// "default") plus a digest of the CacheKeyFiles matches; on a miss the
+	// newest entry starting with a CacheRestoreKeys prefix is restored.
+	// CacheShared keys entries by repository so its jobs share them.
+	CacheKey         string `json:"cache_key" gorm:"size:100"`
+	CacheKeyFiles    string `json:"cache_key_files" gorm:"type:text"`
+	CacheRestoreKeys string `json:"cache_restore_keys" gorm:"type:text"`
+	CacheShared      bool   `json:"cache_shared" gorm:"not null;default:false"`
+
+	// Checkout options: GitRef (tag, full ref or commit SHA) replaces Branch
+	// when set; SparseCheckoutPaths lists cone-mode directories, one per line.
+	GitRef              string `json:"git_ref" gorm:"size:300"`
+	GitSubmodules       bool   `json:"git_submodules" gorm:"not null;default:false"`
+	GitLFS              bool   `json:"git_lfs" gorm:"not null;default:false"`
+	SparseCheckoutPaths string `json:"sparse_checkout_paths" gorm:"type:text"`
+
+	// ReportCommitStatus posts run progress as a commit status to the
+	// repository's Git platform (needs a token/password credential).
+	ReportCommitStatus bool `json:"report_commit_status" gorm:"not null;default:true"`
+
+	// Pull/merge request builds from webhooks (TriggerWebhook must be on too).
+	// PullRequestRef: head (PR head commit) | merge (platform merge ref, falls
+	// back to head where the platform has none). PR runs skip distribution
+	// unless PullRequestDistribute.
+	TriggerPullRequest    bool   `json:"trigger_pull_request" gorm:"not null;default:false"`
+	PullRequestRef        string `json:"pull_request_ref" gorm:"size:10;default:head"`
+	PullRequestDistribute bool   `json:"pull_request_distribute" gorm:"not null;default:false"`
+
+	// TriggerTag builds tag pushes (TriggerWebhook must be on too) whose name
+	// matches one of TagPatterns (one per line; see engine.MatchTag). Tag runs
+	// become releases labelled with the tag's version.
+	TriggerTag  bool   `json:"trigger_tag" gorm:"not null;default:false"`
+	TagPatterns string `json:"tag_patterns" gorm:"type:text"`
+
+	// Webhook and polling push filters, one glob per line (see
+	// engine.MatchBranch): BranchPatterns replaces the exact Branch match when
+	// set; a push builds only if a changed file is in PathIncludes (empty =
+	// any) and not in PathExcludes. Unknown changed files (new branch, diff
+	// failed) build.
+	BranchPatterns string `json:"branch_patterns" gorm:"type:text"`
+	PathIncludes   string `json:"path_includes" gorm:"type:text"`
+	PathExcludes   string `json:"path_excludes" gorm:"type:text"`
+
+	// TriggerPoll polls the repository (git ls-remote) for repositories a
+	// webhook can't reach and builds a branch whose head moved past the last
+	// built commit. A repository is polled once for all its jobs, at the
+	// smallest PollIntervalSeconds among them (engine.MinPollInterval floor).
+	TriggerPoll         bool `json:"trigger_poll" gorm:"not null;default:false"`
+	PollIntervalSeconds int  `json:"poll_interval_seconds" gorm:"not null;default:300"`
+
+	// Deployment approval: targets whose Environment is in ApprovalEnvironments
+	// (one per line) are gated as if RequiresApproval were set. A waiting run
+	// notifies DeployApproverIDs (the user who triggered it when empty).
+	ApprovalEnvironments  string `json:"approval_environments" gorm:"type:text"`
+	DeployApproverIDsJSON string `json:"-" gorm:"type:text"`
+	DeployApproverIDs     []uint `json:"deploy_approver_ids" gorm:"-"`
+
+	// DistributeStrategy: serial (default, one target at a time), parallel
+	// (DistributeConcurrency at a time), rolling (waves of DistributeConcurrency;
+	// stops once more than DistributeMaxFailures targets failed) or canary
+	// (the first target, then CanaryVerifyScript, then the rest).
+	DistributeStrategy    string `json:"distribute_strategy" gorm:"size:20;not null;default:serial"`
+	DistributeConcurrency int    `json:"distribute_concurrency" gorm:"not null;default:1"`
+	DistributeMaxFailures int    `json:"distribute_max_failures" gorm:"not null;default:0"`
+	CanaryVerifyScript    string `json:"canary_verify_script" gorm:"type:text"`
+
+	DeployTargets  []DeployTarget     `json:"deploy_targets,omitempty" gorm:"foreignKey:BuildJobID"`
+	CredentialEnvs []JobCredentialEnv `json:"credential_envs,omitempty" gorm:"foreignKey:BuildJobID"`
 }
 
 func (BuildJob) TableName() string { return "build_jobs" }
 
+// ArtifactDef is one named artifact of a BuildJob. Path is a directory, file
+// or glob ("**" allowed) relative to the repository root; matched files keep
+// their path below the pattern's literal prefix. Format: gzip | zip (empty =
+// BuildJob.ArtifactFormat).
+type ArtifactDef struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Format string `json:"format,omitempty"`
+}
+
+// BuildParameter is one typed input supplied when a run is triggered and
+// exported to the build as an env var of the same name.
+// type: string | choice | boolean | secret (secret values are never stored in plain text).
+type BuildParameter struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	Choices     []string `json:"choices,omitempty"`
+	Required    bool     `json:"required,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+}
+
+// BuildMatrix fans one trigger out into child BuildRuns, one per cell.
+// Cells are the cartesian product of Axes minus Exclude, plus Include.
+type BuildMatrix struct {
+	Axes    []MatrixAxis        `json:"axes"`
+	Include []map[string]string `json:"include,omitempty"`
+	Exclude []map[string]string `json:"exclude,omitempty"`
+}
+
+// MatrixAxis is one matrix dimension; Name is also the env var name.
+type MatrixAxis struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
 // DeployTarget is private to a BuildJob (1:N); not shared across jobs.
 type DeployTarget struct {
 	ID               uint      `json:"id" gorm:"primaryKey"`
@@ -52,14 +193,38 @@ type DeployTarget struct {
 	SortOrder        int       `json:"sort_order" gorm:"not null;default:0"`
 	CreatedAt        time.Time `json:"created_at"`
 	UpdatedAt        time.Time `json:"updated_at"`
+
+	// ArtifactName selects the run artifact to deploy; empty = OutputDir.
+	ArtifactName string `json:"artifact_name" gorm:"size:100"`
+
+	// Environment labels the target (production, staging, ...). Distribution
+	// to a target that RequiresApproval, or whose Environment the job lists in
+	// ApprovalEnvironments, waits for approval (see BuildDeployApproval).
+	Environment      string `json:"environment" gorm:"size:50"`
+	RequiresApproval bool   `json:"requires_approval" gorm:"not null;default:false"`
 }
 
 func (DeployTarget) TableName() string { return "deploy_targets" }
 
+// JobCredentialEnv exports a Credential to the build as env var Name. The value
+// is resolved at run time and never written to the run snapshot.
+// field: secret (default) | username | passphrase
+type JobCredentialEnv struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	BuildJobID   uint      `json:"build_job_id" gorm:"index;not null"`
+	Name         string    `json:"name" gorm:"size:100;not null"`
+	CredentialID uint      `json:"credential_id" gorm:"index;not null"`
+	Field        string    `json:"field" gorm:"size:20;not null;default:secret"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (JobCredentialEnv) TableName() string { return "build_job_credential_envs" }
+
 // BuildRun status (result) vs stage (activity) — see DESIGN §5.2.
-// status: queued|running|success|failed|cancelled|interrupted
-// stage: pending|cloning|building|archiving|distributing|idle
-// distribution_summary: none|running|all_success|partial|all_failed|cancelled
+// status: queued|running|success|failed|cancelled|interrupted|timed_out
+// stage: pending|cloning|building|archiving|distributing|waiting_approval|idle
+// distribution_summary: none|running|waiting_approval|all_success|partial|all_failed|aborted|rejected|cancelled|timed_out
+// matrix_summary (matrix parent only, same vocabulary): rollup of child run statuses.
 type BuildRun struct {
 	ID                  uint       `json:"id" gorm:"primaryKey"`
 	BuildJobID          uint       `json:"build_job_id" gorm:"uniqueIndex:idx_job_build_num;not null"`
@@ -81,11 +246,91 @@ type BuildRun struct {
 	FinishedAt          *time.Time `json:"finished_at"`
 	CreatedAt           time.Time  `json:"created_at"`
 
-	DeployAttempts []BuildDeployAttempt `json:"deploy_attempts,omitempty" gorm:"foreignKey:BuildRunID"`
+	// Artifacts are the run's extra archives; LogStages indexes the log by stage.
+	ArtifactsJSON string        `json:"-" gorm:"type:text"`
+	Artifacts     []RunArtifact `json:"artifacts" gorm:"-"`
+	LogStagesJSON string        `json:"-" gorm:"type:text"`
+	LogStages     []LogStage    `json:"log_stages,omitempty" gorm:"-"`
+
+	// Test and coverage results collected after the build.
+	TestSummaryJSON string           `json:"-" gorm:"type:text"`
+	TestSummary     *TestSummary     `json:"test_summary,omitempty" gorm:"-"`
+	CoverageJSON    string           `json:"-" gorm:"type:text"`
+	Coverage        *CoverageSummary `json:"coverage,omitempty" gorm:"-"`
+	CoveragePercent *float64         `json:"coverage_percent"`
+
+	// Matrix runs: a child records its parent and cell; the parent rolls up
+	// its children in MatrixSummary.
+	ParentRunID    *uint             `json:"parent_run_id" gorm:"index"`
+	MatrixCellJSON string            `json:"-" gorm:"type:text"`
+	MatrixCell     map[string]string `json:"matrix_cell,omitempty" gorm:"-"`
+	MatrixSummary  string            `json:"matrix_summary" gorm:"size:30;default:none"`
+
+	// ParamsCipher holds the resolved parameter values, encrypted.
+	ParamsCipher string `json:"-" gorm:"type:text"`
+
+	// ArtifactPinned keeps the run's artifacts forever. When retention removes
+	// them, ArtifactRemovedAt/Reason record when and which rule (count|age|size).
+	ArtifactPinned        bool       `json:"artifact_pinned" gorm:"not null;default:false"`
+	ArtifactRemovedAt     *time.Time `json:"artifact_removed_at"`
+	ArtifactRemovedReason string     `json:"artifact_removed_reason,omitempty" gorm:"size:20"`
+
+	// ArtifactObjectID is the storage object (kind artifact) behind ArtifactPath;
+	// nil for archives written to build.artifact_dir before the content store.
+	ArtifactObjectID *uint  `json:"artifact_object_id"`
+	ArtifactSHA256   string `json:"artifact_sha256,omitempty" gorm:"size:64"`
+	ArtifactName     string `json:"artifact_name,omitempty" gorm:"size:200"`
+
+	// Build cache outcome: hit (exact key), partial (restore key or legacy
+	// per-job cache), miss; empty when the job has no cache_paths.
+	CacheStatus      string `json:"cache_status,omitempty" gorm:"size:20"`
+	CacheKey         string `json:"cache_key,omitempty" gorm:"size:200"`
+	CacheRestoredKey string `json:"cache_restored_key,omitempty" gorm:"size:200"`
+
+	// GitRef is checked out instead of Branch (a pull request head or merge ref).
+	// PR runs record the PR (PRNumber > 0); PRHeadCommit is its head commit,
+	// which may differ from CommitHash when the merge ref is built.
+	GitRef         string `json:"git_ref,omitempty" gorm:"size:300"`
+	PRNumber       int    `json:"pr_number" gorm:"not null;default:0"`
+	PRSourceBranch string `json:"pr_source_branch,omitempty" gorm:"size:200"`
+	PRTargetBranch string `json:"pr_target_branch,omitempty" gorm:"size:200"`
+	PRAuthor       string `json:"pr_author,omitempty" gorm:"size:100"`
+	PRHeadCommit   string `json:"pr_head_commit,omitempty" gorm:"size:64"`
+
+	// Tag runs build refs/tags/<Tag>. A successful one is a release: its
+	// artifacts are pinned and it can be looked up by ReleaseVersion.
+	Tag            string `json:"tag,omitempty" gorm:"size:200"`
+	ReleaseVersion string `json:"release_version,omitempty" gorm:"size:200;index"`
+
+	DeployAttempts  []BuildDeployAttempt  `json:"deploy_attempts,omitempty" gorm:"foreignKey:BuildRunID"`
+	DeployApprovals []BuildDeployApproval `json:"deploy_approvals,omitempty" gorm:"foreignKey:BuildRunID"`
+	Children        []BuildRun            `json:"children,omitempty" gorm:"-"`
 }
 
 func (BuildRun) TableName() string { return "build_runs" }
 
+// RunArtifact is an extra archive kept by a BuildRun besides ArtifactPath
+// (e.g. a pipeline stage with `artifacts:` in .bedrock.yml).
+type RunArtifact struct {
+	Name   string `json:"name"`
+	Stage  string `json:"stage,omitempty"`
+	Path   string `json:"path"`
+	Format string `json:"format"`
+	Size   int64  `json:"size"`
+
+	// ObjectID/SHA256 identify the storage object; FileName is the download name.
+	ObjectID uint   `json:"object_id,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+	FileName string `json:"file_name,omitempty"`
+}
+
+// LogStage marks where a stage ("=== Stage: <name> ===") starts in the run log.
+type LogStage struct {
+	Name   string `json:"name"`
+	Line   int    `json:"line"`
+	Offset int64  `json:"offset"`
+}
+
 // BuildDeployAttempt is one target row in a distribute/redeploy batch (append-only in Wave 4).
 type BuildDeployAttempt struct {
 	ID                 uint       `json:"id" gorm:"primaryKey"`
@@ -99,6 +344,176 @@ type BuildDeployAttempt struct {
 	StartedAt          *time.Time `json:"started_at"`
 	FinishedAt         *time.Time `json:"finished_at"`
 	CreatedAt          time.Time  `json:"created_at"`
+
+	// ArtifactSHA256 is the digest of the artifact this attempt shipped.
+	ArtifactSHA256 string `json:"artifact_sha256,omitempty" gorm:"size:64"`
+
+	// Wave numbers the strategy step within the batch (1 = canary or first
+	// rolling wave; serial and parallel batches are a single wave).
+	Wave int `json:"wave" gorm:"not null;default:1"`
 }
 
 func (BuildDeployAttempt) TableName() string { return "build_deploy_attempts" }
+
+// BuildDeployApproval gates distribution batch BatchNo of a run while it
+// waits in stage waiting_approval. status: pending | approved | rejected.
+// A rejection skips the gated targets (the others still deploy) or cancels
+// the whole batch (RejectAction skip | cancel). TargetsJSON snapshots the
+// gated targets when approval was requested.
+type BuildDeployApproval struct {
+	ID            uint           `json:"id" gorm:"primaryKey"`
+	BuildRunID    uint           `json:"build_run_id" gorm:"index;not null"`
+	BatchNo       int            `json:"batch_no" gorm:"not null"`
+	Status        string         `json:"status" gorm:"size:20;not null;default:pending"`
+	Environments  string         `json:"environments" gorm:"size:300"`
+	TargetsJSON   string         `json:"-" gorm:"type:text"`
+	Targets       []DeployTarget `json:"targets" gorm:"-"`
+	RejectAction  string         `json:"reject_action,omitempty" gorm:"size:10"`
+	DecidedBy     *uint          `json:"decided_by"`
+	DecidedByName string         `json:"decided_by_name,omitempty" gorm:"size:100"`
+	Comment       string         `json:"comment" gorm:"size:500"`
+	DecidedAt     *time.Time     `json:"decided_at"`
+	// AppliedAt is set once the pipeline acted on the decision.
+	AppliedAt *time.Time `json:"applied_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (BuildDeployApproval) TableName() string { return "build_deploy_approvals" }
+
+// DeployTargetDeployment records a build going live on a DeployTarget: one
+// row per successful attempt. Current marks what the target runs now; the
+// other rows are its history. Kind: deploy (the run's own distribution),
+// redeploy or rollback. Rows follow a target across job edits (see
+// BuildJobRepository.ReplaceDeployTargets).
+type DeployTargetDeployment struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	BuildJobID     uint      `json:"build_job_id" gorm:"index;not null"`
+	DeployTargetID uint      `json:"deploy_target_id" gorm:"index;not null"`
+	BuildRunID     uint      `json:"build_run_id" gorm:"index;not null"`
+	BuildNumber    int       `json:"build_number"`
+	AttemptID      uint      `json:"attempt_id"`
+	CommitHash     string    `json:"commit_hash" gorm:"size:64"`
+	ArtifactSHA256 string    `json:"artifact_sha256,omitempty" gorm:"size:64"`
+	Kind           string    `json:"kind" gorm:"size:20;not null;default:deploy"`
+	Current        bool      `json:"current" gorm:"index;not null;default:false"`
+	DeployedBy     uint      `json:"deployed_by"`
+	DeployedByName string    `json:"deployed_by_name,omitempty" gorm:"size:100"`
+	DeployedAt     time.Time `json:"deployed_at"`
+}
+
+func (DeployTargetDeployment) TableName() string { return "deploy_target_deployments" }
+
+// TestSummary totals the test reports collected for a BuildRun.
+type TestSummary struct {
+	Total      int   `json:"total"`
+	Passed     int   `json:"passed"`
+	Failed     int   `json:"failed"`
+	Errors     int   `json:"errors"`
+	Skipped    int   `json:"skipped"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// CoverageSummary is line coverage merged from the coverage reports of a run.
+type CoverageSummary struct {
+	LinesCovered int               `json:"lines_covered"`
+	LinesTotal   int               `json:"lines_total"`
+	Percent      float64           `json:"percent"`
+	Threshold    float64           `json:"threshold,omitempty"`
+	Files        []string          `json:"files"`
+	Packages     []PackageCoverage `json:"packages"`
+}
+
+// PackageCoverage is line coverage of one package (Go import path, Cobertura
+// package, or source directory for LCOV).
+type PackageCoverage struct {
+	Name         string  `json:"name"`
+	LinesCovered int     `json:"lines_covered"`
+	LinesTotal   int     `json:"lines_total"`
+	Percent      float64 `json:"percent"`
+}
+
+// Test case status values.
+const (
+	TestPassed  = "passed"
+	TestFailed  = "failed"
+	TestError   = "error"
+	TestSkipped = "skipped"
+)
+
+// BuildTestSuite is one suite parsed from a test report: a JUnit <testsuite>,
+// or a Go package from `go test -json` output.
+type BuildTestSuite struct {
+	ID         uint            `json:"id" gorm:"primaryKey"`
+	BuildRunID uint            `json:"build_run_id" gorm:"index;not null"`
+	Name       string          `json:"name" gorm:"size:300"`
+	File       string          `json:"file" gorm:"size:500"`
+	Tests      int             `json:"tests"`
+	Failures   int             `json:"failures"`
+	Errors     int             `json:"errors"`
+	Skipped    int             `json:"skipped"`
+	DurationMs int64           `json:"duration_ms"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Cases      []BuildTestCase `json:"cases,omitempty" gorm:"foreignKey:SuiteID"`
+}
+
+func (BuildTestSuite) TableName() string { return "build_test_suites" }
+
+// BuildTestCase is one test result; Message/Details hold the failure or skip reason.
+type BuildTestCase struct {
+	ID         uint   `json:"id" gorm:"primaryKey"`
+	BuildRunID uint   `json:"build_run_id" gorm:"index;not null"`
+	SuiteID    uint   `json:"suite_id" gorm:"index;not null"`
+	SuiteName  string `json:"suite_name" gorm:"size:300"`
+	ClassName  string `json:"class_name" gorm:"size:300"`
+	Name       string `json:"name" gorm:"size:500"`
+	Status     string `json:"status" gorm:"size:20;not null"`
+	DurationMs int64  `json:"duration_ms"`
+	Message    string `json:"message,omitempty" gorm:"type:text"`
+	Details    string `json:"details,omitempty" gorm:"type:text"`
+}
+
+func (BuildTestCase) TableName() string { return "build_test_cases" }
+
+// Key identifies a case across runs of the same job.
+func (c *BuildTestCase) Key() string {
+	return c.SuiteName + "\x00" + c.ClassName + "\x00" + c.Name
+}
+
+// WebhookDeliveryRecord is the history of one received webhook: the request
+// (headers with credentials masked, body truncated), what was parsed from it
+// and the outcome. Job webhooks set BuildJobID; repository webhooks set
+// RepositoryID and list every job's result in Jobs.
+type WebhookDeliveryRecord struct {
+	ID            uint               `json:"id" gorm:"primaryKey"`
+	BuildJobID    uint               `json:"build_job_id" gorm:"index;not null;default:0"`
+	RepositoryID  uint               `json:"repository_id" gorm:"index;not null;default:0"`
+	DeliveryKey   string             `json:"delivery_key" gorm:"size:200"`
+	Platform      string             `json:"platform" gorm:"size:20"`
+	Event         string             `json:"event" gorm:"size:100"`
+	Ref           string             `json:"ref" gorm:"size:300"`
+	CommitHash    string             `json:"commit_hash" gorm:"size:64"`
+	Outcome       string             `json:"outcome" gorm:"size:30;index"`
+	Message       string             `json:"message" gorm:"size:500"`
+	RunIDsJSON    string             `json:"-" gorm:"type:text"`
+	RunIDs        []uint             `json:"run_ids" gorm:"-"`
+	JobsJSON      string             `json:"-" gorm:"type:text"`
+	Jobs          []WebhookJobResult `json:"jobs,omitempty" gorm:"-"`
+	HeadersJSON   string             `json:"-" gorm:"type:text"`
+	Headers       map[string]string  `json:"headers" gorm:"-"`
+	Body          string             `json:"body,omitempty" gorm:"type:text"`
+	BodySize      int                `json:"body_size"`
+	BodyTruncated bool               `json:"body_truncated"`
+	ReplayOf      *uint              `json:"replay_of,omitempty" gorm:"index"`
+	CreatedAt     time.Time          `json:"created_at"`
+}
+
+func (WebhookDeliveryRecord) TableName() string { return "webhook_delivery_records" }
+
+// WebhookJobResult is one build job's outcome of a repository webhook.
+type WebhookJobResult struct {
+	JobID     uint   `json:"job_id"`
+	JobName   string `json:"job_name"`
+	Triggered bool   `json:"triggered"`
+	RunID     uint   `json:"run_id,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
@@ -25,6 +25,12 @@ func (r *BuildJobRepository) Delete(id uint) error {
 		if err := tx.Where("build_job_id = ?", id).Delete(&model.DeployTarget{}).Error; err != nil {
 			return err
 		}
+		if err := tx.Where("build_job_id = ?", id).Delete(&model.JobCredentialEnv{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("build_job_id = ?", id).Delete(&model.DeployTargetDeployment{}).Error; err != nil {
+			return err
+		}
 		return tx.Delete(&model.BuildJob{}, id).Error
 	})
 }
@@ -33,6 +39,8 @@ func (r *BuildJobRepository) FindByID(id uint) (*model.BuildJob, error) {
 	var job model.BuildJob
 	if err := r.db.Preload("DeployTargets", func(db *gorm.DB) *gorm.DB {
 		return db.Order("sort_order ASC, id ASC")
+	}).Preload("CredentialEnvs", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id ASC")
 	}).First(&job, id).Error; err != nil {
 		return nil, err
 	}
@@ -57,8 +65,15 @@ func (r *BuildJobRepository) List(page, pageSize int, repositoryID *uint, keywor
 	return items, total, err
 }
 
+// ReplaceDeployTargets recreates the job's targets. Deployment records move to
+// the new target with the same method, server and path; a removed target's
+// deployment stops being current.
 func (r *BuildJobRepository) ReplaceDeployTargets(jobID uint, targets []model.DeployTarget) error {
 	return r.db.Transaction(func(tx *gorm.DB) error {
+		var old []model.DeployTarget
+		if err := tx.Where("build_job_id = ?", jobID).Find(&old).Error; err != nil {
+			return err
+		}
 		if err := tx.Where("build_job_id = ?", jobID).Delete(&model.DeployTarget{}).Error; err != nil {
 			return err
 		}
@@ -69,6 +84,58 @@ func (r *BuildJobRepository) ReplaceDeployTargets(jobID uint, targets []model.De
 				return err
 			}
 		}
+		moved := make(map[uint]bool, len(old))
+		for i := range targets {
+			for j := range old {
+				if moved[old[j].ID] || !sameDeployTarget(&old[j], &targets[i]) {
+					continue
+				}
+				moved[old[j].ID] = true
+				if err := tx.Model(&model.DeployTargetDeployment{}).
+					Where("deploy_target_id = ?", old[j].ID).
+					Update("deploy_target_id", targets[i].ID).Error; err != nil {
+					return err
+				}
+				break
+			}
+		}
+		for _, t := range old {
+			if moved[t.ID] {
+				continue
+			}
+			if err := tx.Model(&model.DeployTargetDeployment{}).
+				Where("deploy_target_id = ? AND current = ?", t.ID, true).
+				Update("current", false).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// sameDeployTarget reports whether a and b deploy to the same place.
+func sameDeployTarget(a, b *model.DeployTarget) bool {
+	if a.Method != b.Method || a.RemotePath != b.RemotePath {
+		return false
+	}
+	if a.ServerID == nil || b.ServerID == nil {
+		return a.ServerID == nil && b.ServerID == nil
+	}
+	return *a.ServerID == *b.ServerID
+}
+
+func (r *BuildJobRepository) ReplaceCredentialEnvs(jobID uint, envs []model.JobCredentialEnv) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("build_job_id = ?", jobID).Delete(&model.JobCredentialEnv{}).Error; err != nil {
+			return err
+		}
+		for i := range envs {
+			envs[i].ID = 0
+			envs[i].BuildJobID = jobID
+			if err := tx.Create(&envs[i]).Error; err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 }
@@ -86,8 +153,21 @@ func (r *BuildJobRepository) ListCronEnabled() ([]model.BuildJob, error) {
 	return items, err
 }
 
+// ListPollEnabled lists enabled jobs with the polling trigger on.
+func (r *BuildJobRepository) ListPollEnabled() ([]model.BuildJob, error) {
+	var items []model.BuildJob
+	err := r.db.Where("enabled = ? AND trigger_poll = ?", true, true).Order("id ASC").Find(&items).Error
+	return items, err
+}
+
 func (r *BuildJobRepository) ListByRepositoryID(repositoryID uint) ([]model.BuildJob, error) {
 	var items []model.BuildJob
 	err := r.db.Where("repository_id = ?", repositoryID).Order("id ASC").Find(&items).Error
 	return items, err
 }
+
+func (r *BuildJobRepository) ListAll() ([]model.BuildJob, error) {
+	var items []model.BuildJob
+	err := r.db.Order("id ASC").Find(&items).Error
+	return items, err
+}
@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"time"
+
 	"bedrock/internal/cicd/model"
 
 	"gorm.io/gorm"
@@ -20,6 +22,8 @@ func (r *BuildRunRepository) FindByID(id uint) (*model.BuildRun, error) {
 	var run model.BuildRun
 	if err := r.db.Preload("DeployAttempts", func(db *gorm.DB) *gorm.DB {
 		return db.Order("batch_no ASC, id ASC")
+	}).Preload("DeployApprovals", func(db *gorm.DB) *gorm.DB {
+		return db.Order("batch_no ASC, id ASC")
 	}).First(&run, id).Error; err != nil {
 		return nil, err
 	}
@@ -79,6 +83,33 @@ func (r *BuildRunRepository) UpdateAttempt(a *model.BuildDeployAttempt) error {
 	return r.db.Save(a).Error
 }
 
+func (r *BuildRunRepository) CreateApproval(a *model.BuildDeployApproval) error {
+	return r.db.Create(a).Error
+}
+
+// LatestApproval returns the run's newest deployment approval.
+func (r *BuildRunRepository) LatestApproval(runID uint) (*model.BuildDeployApproval, error) {
+	var a model.BuildDeployApproval
+	if err := r.db.Where("build_run_id = ?", runID).Order("id DESC").First(&a).Error; err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// DecideApproval records the decision on a pending approval; false when it
+// was decided already (concurrent approvers).
+func (r *BuildRunRepository) DecideApproval(id uint, fields map[string]interface{}) (bool, error) {
+	res := r.db.Model(&model.BuildDeployApproval{}).
+		Where("id = ? AND status = ?", id, "pending").
+		Updates(fields)
+	return res.RowsAffected > 0, res.Error
+}
+
+// MarkApprovalApplied records that the pipeline acted on the decision.
+func (r *BuildRunRepository) MarkApprovalApplied(id uint) error {
+	return r.db.Model(&model.BuildDeployApproval{}).Where("id = ?", id).Update("applied_at", time.Now()).Error
+}
+
 func (r *BuildRunRepository) NextBatchNo(runID uint) (int, error) {
 	var maxNum *int
 	err := r.db.Model(&model.BuildDeployAttempt{}).
@@ -122,9 +153,172 @@ func (r *BuildRunRepository) HasNonTerminal(jobID uint) (bool, error) {
 	return n > 0, err
 }
 
+// LastBuiltCommit returns the commit of the job's newest top-level branch run
+// (pull request and tag runs excluded) on branch; "" when there is none.
+func (r *BuildRunRepository) LastBuiltCommit(jobID uint, branch string) (string, error) {
+	var runs []model.BuildRun
+	err := r.db.Select("id", "commit_hash").
+		Where("build_job_id = ? AND branch = ? AND commit_hash <> '' AND parent_run_id IS NULL AND pr_number = 0 AND (tag = '' OR tag IS NULL)", jobID, branch).
+		Order("id DESC").Limit(1).Find(&runs).Error
+	if err != nil || len(runs) == 0 {
+		return "", err
+	}
+	return runs[0].CommitHash, nil
+}
+
+// ListActiveByPullRequest lists queued/running top-level runs (matrix parents
+// included, children not) of a job's pull request, and runs waiting for
+// deployment approval.
+func (r *BuildRunRepository) ListActiveByPullRequest(jobID uint, number int) ([]model.BuildRun, error) {
+	var runs []model.BuildRun
+	err := r.db.Where("build_job_id = ? AND pr_number = ? AND parent_run_id IS NULL AND (status IN ? OR stage = ?)",
+		jobID, number, []string{"queued", "running"}, "waiting_approval").
+		Order("id ASC").Find(&runs).Error
+	return runs, err
+}
+
+// ListReleases lists a job's successful top-level tag runs, newest first;
+// version filters to one release label.
+func (r *BuildRunRepository) ListReleases(jobID uint, version string, page, pageSize int) ([]model.BuildRun, int64, error) {
+	q := r.db.Model(&model.BuildRun{}).
+		Where("build_job_id = ? AND release_version <> '' AND parent_run_id IS NULL AND status = ?", jobID, "success")
+	if version != "" {
+		q = q.Where("release_version = ?", version)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var items []model.BuildRun
+	err := q.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&items).Error
+	return items, total, err
+}
+
 func (r *BuildRunRepository) ListArtifactsByJob(jobID uint) ([]model.BuildRun, error) {
 	var items []model.BuildRun
-	err := r.db.Where("build_job_id = ? AND artifact_path <> '' AND artifact_path IS NOT NULL", jobID).
+	err := r.db.Where("build_job_id = ? AND ((artifact_path <> '' AND artifact_path IS NOT NULL) OR (artifacts_json <> '' AND artifacts_json IS NOT NULL))", jobID).
 		Order("id DESC").Find(&items).Error
 	return items, err
 }
+
+// ListByParent returns the child runs of a matrix parent (cell order).
+func (r *BuildRunRepository) ListByParent(parentID uint) ([]model.BuildRun, error) {
+	var items []model.BuildRun
+	err := r.db.Where("parent_run_id = ?", parentID).Order("id ASC").Find(&items).Error
+	return items, err
+}
+
+// ReplaceTestResults swaps the stored test suites/cases of a run (retries re-ingest).
+func (r *BuildRunRepository) ReplaceTestResults(runID uint, suites []model.BuildTestSuite) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("build_run_id = ?", runID).Delete(&model.BuildTestCase{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("build_run_id = ?", runID).Delete(&model.BuildTestSuite{}).Error; err != nil {
+			return err
+		}
+		for i := range suites {
+			suites[i].ID = 0
+			suites[i].BuildRunID = runID
+			for j := range suites[i].Cases {
+				suites[i].Cases[j].ID = 0
+				suites[i].Cases[j].BuildRunID = runID
+			}
+			if err := tx.Create(&suites[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListTestSuites returns a run's suites with cases; status filters the cases
+// and drops suites left without any.
+func (r *BuildRunRepository) ListTestSuites(runID uint, status string) ([]model.BuildTestSuite, error) {
+	var items []model.BuildTestSuite
+	err := r.db.Where("build_run_id = ?", runID).
+		Preload("Cases", func(db *gorm.DB) *gorm.DB {
+			if status != "" {
+				db = db.Where("status = ?", status)
+			}
+			return db.Order("id ASC")
+		}).
+		Order("id ASC").Find(&items).Error
+	if err != nil || status == "" {
+		return items, err
+	}
+	out := items[:0]
+	for _, s := range items {
+		if len(s.Cases) > 0 {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+func (r *BuildRunRepository) ListTestCases(runID uint) ([]model.BuildTestCase, error) {
+	var items []model.BuildTestCase
+	err := r.db.Where("build_run_id = ?", runID).Order("id ASC").Find(&items).Error
+	return items, err
+}
+
+// FindPreviousWithTests returns the latest earlier run of the job that ingested test reports.
+func (r *BuildRunRepository) FindPreviousWithTests(jobID uint, beforeBuildNumber int) (*model.BuildRun, error) {
+	var run model.BuildRun
+	err := r.db.Where("build_job_id = ? AND build_number < ? AND test_summary_json <> '' AND test_summary_json IS NOT NULL", jobID, beforeBuildNumber).
+		Order("build_number DESC").First(&run).Error
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (r *BuildRunRepository) ListDeployedRunIDs(jobID uint) ([]uint, error) {
+	var ids []uint
+	err := r.db.Model(&model.DeployTargetDeployment{}).
+		Where("build_job_id = ? AND current = ?", jobID, true).
+		Distinct().Pluck("build_run_id", &ids).Error
+	return ids, err
+}
+
+// RecordDeployment makes d the target's current deployment.
+func (r *BuildRunRepository) RecordDeployment(d *model.DeployTargetDeployment) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.DeployTargetDeployment{}).
+			Where("deploy_target_id = ? AND current = ?", d.DeployTargetID, true).
+			Update("current", false).Error; err != nil {
+			return err
+		}
+		d.Current = true
+		return tx.Create(d).Error
+	})
+}
+
+// ListCurrentDeployments returns what each of the job's targets runs now.
+func (r *BuildRunRepository) ListCurrentDeployments(jobID uint) ([]model.DeployTargetDeployment, error) {
+	var items []model.DeployTargetDeployment
+	err := r.db.Where("build_job_id = ? AND current = ?", jobID, true).Order("deploy_target_id ASC").Find(&items).Error
+	return items, err
+}
+
+// ListTargetDeployments returns a target's deployments, newest first.
+func (r *BuildRunRepository) ListTargetDeployments(targetID uint, limit int) ([]model.DeployTargetDeployment, error) {
+	var items []model.DeployTargetDeployment
+	err := r.db.Where("deploy_target_id = ?", targetID).Order("id DESC").Limit(limit).Find(&items).Error
+	return items, err
+}
+
+// ListDeploymentHistory pages the job's deployments (optionally one target's), newest first.
+func (r *BuildRunRepository) ListDeploymentHistory(jobID uint, targetID *uint, page, pageSize int) ([]model.DeployTargetDeployment, int64, error) {
+	q := r.db.Model(&model.DeployTargetDeployment{}).Where("build_job_id = ?", jobID)
+	if targetID != nil {
+		q = q.Where("deploy_target_id = ?", *targetID)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var items []model.DeployTargetDeployment
+	err := q.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&items).Error
+	return items, total, err
+}
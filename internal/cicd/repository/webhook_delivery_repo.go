@@ -4,14 +4,18 @@ import (
 	"time"
 
 	"gorm.io/gorm"
+
+	"bedrock/internal/cicd/model"
 )
 
-// WebhookDelivery records processed deliveries for idempotency.
+// WebhookDelivery records processed deliveries for idempotency. Job webhook
+// deliveries set BuildJobID; repository webhook deliveries set RepositoryID.
 type WebhookDelivery struct {
-	ID          uint      `gorm:"primaryKey"`
-	BuildJobID  uint      `gorm:"uniqueIndex:idx_wh_delivery;not null"`
-	DeliveryKey string    `gorm:"size:200;uniqueIndex:idx_wh_delivery;not null"`
-	CreatedAt   time.Time `gorm:""`
+	ID           uint      `gorm:"primaryKey"`
+	BuildJobID   uint      `gorm:"uniqueIndex:idx_wh_delivery_scope;not null"`
+	RepositoryID uint      `gorm:"uniqueIndex:idx_wh_delivery_scope;not null;default:0"`
+	DeliveryKey  string    `gorm:"size:200;uniqueIndex:idx_wh_delivery_scope;not null"`
+	CreatedAt    time.Time `gorm:""`
 }
 
 func (WebhookDelivery) TableName() string { return "webhook_deliveries" }
@@ -24,7 +28,15 @@ func NewWebhookDeliveryRepository(db *gorm.DB) *WebhookDeliveryRepository {
 
 // TryInsert returns true if this delivery is new; false if duplicate.
 func (r *WebhookDeliveryRepository) TryInsert(buildJobID uint, deliveryKey string) (bool, error) {
-	row := &WebhookDelivery{BuildJobID: buildJobID, DeliveryKey: deliveryKey}
+	return r.tryInsert(&WebhookDelivery{BuildJobID: buildJobID, DeliveryKey: deliveryKey})
+}
+
+// TryInsertRepository is TryInsert for a repository-level webhook delivery.
+func (r *WebhookDeliveryRepository) TryInsertRepository(repositoryID uint, deliveryKey string) (bool, error) {
+	return r.tryInsert(&WebhookDelivery{RepositoryID: repositoryID, DeliveryKey: deliveryKey})
+}
+
+func (r *WebhookDeliveryRepository) tryInsert(row *WebhookDelivery) (bool, error) {
 	err := r.db.Create(row).Error
 	if err != nil {
 		// Unique violation → duplicate
@@ -36,6 +48,50 @@ func (r *WebhookDeliveryRepository) TryInsert(buildJobID uint, deliveryKey strin
 	return true, nil
 }
 
+// keepDeliveryRecords is how many delivery records each job / repository keeps.
+const keepDeliveryRecords = 200
+
+// CreateRecord stores a delivery's history and drops the scope's records
+// beyond the newest keepDeliveryRecords.
+func (r *WebhookDeliveryRepository) CreateRecord(rec *model.WebhookDeliveryRecord) error {
+	if err := r.db.Create(rec).Error; err != nil {
+		return err
+	}
+	scope := r.db.Model(&model.WebhookDeliveryRecord{}).
+		Where("build_job_id = ? AND repository_id = ?", rec.BuildJobID, rec.RepositoryID)
+	var cutoff []uint
+	if err := scope.Order("id DESC").Offset(keepDeliveryRecords).Limit(1).Pluck("id", &cutoff).Error; err != nil || len(cutoff) == 0 {
+		return err
+	}
+	return r.db.Where("build_job_id = ? AND repository_id = ? AND id <= ?", rec.BuildJobID, rec.RepositoryID, cutoff[0]).
+		Delete(&model.WebhookDeliveryRecord{}).Error
+}
+
+func (r *WebhookDeliveryRepository) FindRecord(id uint) (*model.WebhookDeliveryRecord, error) {
+	var rec model.WebhookDeliveryRecord
+	if err := r.db.First(&rec, id).Error; err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// ListRecords pages a job's delivery history, newest first: its own webhook's
+// deliveries and those of its repository's webhook. Bodies are omitted.
+func (r *WebhookDeliveryRepository) ListRecords(jobID, repositoryID uint, outcome string, page, pageSize int) ([]model.WebhookDeliveryRecord, int64, error) {
+	q := r.db.Model(&model.WebhookDeliveryRecord{}).
+		Where("build_job_id = ? OR (build_job_id = 0 AND repository_id = ?)", jobID, repositoryID)
+	if outcome != "" {
+		q = q.Where("outcome = ?", outcome)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var items []model.WebhookDeliveryRecord
+	err := q.Omit("body").Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&items).Error
+	return items, total, err
+}
+
 func isUniqueViolation(err error) bool {
 	if err == nil {
 		return false
@@ -4,19 +4,30 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
 	"bedrock/internal/cicd/model"
 	"bedrock/internal/cicd/repository"
+	"bedrock/internal/engine"
+	resourcemodel "bedrock/internal/resource/model"
 	resourcerepo "bedrock/internal/resource/repository"
 )
 
 type BuildJobService struct {
 	jobs  *repository.BuildJobRepository
 	repos *resourcerepo.RepositoryRepository
+	creds CredentialLookup
 	cron  CronRegistrar
 }
 
+// CredentialLookup checks that a bound credential exists.
+type CredentialLookup interface {
+	FindByID(id uint) (*resourcemodel.Credential, error)
+}
+
 // CronRegistrar updates in-process cron entries when jobs change.
 type CronRegistrar interface {
 	Add(job model.BuildJob) error
@@ -29,75 +40,198 @@ func NewBuildJobService(jobs *repository.BuildJobRepository, repos *resourcerepo
 
 func (s *BuildJobService) SetCron(c CronRegistrar) { s.cron = c }
 
+func (s *BuildJobService) SetCredentials(c CredentialLookup) { s.creds = c }
+
+// CredentialEnvInput binds a credential to an env var name.
+type CredentialEnvInput struct {
+	Name         string `json:"name"`
+	CredentialID uint   `json:"credential_id"`
+	Field        string `json:"field"`
+}
+
 type DeployTargetInput struct {
 	ServerID         *uint  `json:"server_id"`
 	RemotePath       string `json:"remote_path"`
 	Method           string `json:"method"`
 	PostDeployScript string `json:"post_deploy_script"`
 	SortOrder        int    `json:"sort_order"`
+	ArtifactName     string `json:"artifact_name"`
+	Environment      string `json:"environment"`
+	RequiresApproval bool   `json:"requires_approval"`
 }
 
 type CreateBuildJobInput struct {
-	RepositoryID       uint                `json:"repository_id"`
-	Name               string              `json:"name"`
-	Description        string              `json:"description"`
-	Enabled            *bool               `json:"enabled"`
-	Branch             string              `json:"branch"`
-	ShallowClone       *bool               `json:"shallow_clone"`
-	BuildScriptType    string              `json:"build_script_type"`
-	BuildScript        string              `json:"build_script"`
-	WorkDir            string              `json:"work_dir"`
-	OutputDir          string              `json:"output_dir"`
-	CachePaths         string              `json:"cache_paths"`
-	EnvVarNames        []string            `json:"env_var_names"`
-	TriggerManual      *bool               `json:"trigger_manual"`
-	TriggerWebhook     *bool               `json:"trigger_webhook"`
-	TriggerCron        *bool               `json:"trigger_cron"`
-	CronExpression     string              `json:"cron_expression"`
-	CronTimezone       string              `json:"cron_timezone"`
-	MaxArtifacts       int                 `json:"max_artifacts"`
-	ArtifactFormat     string              `json:"artifact_format"`
-	AgentTriggerEvent  string              `json:"agent_trigger_event"`
-	AgentID            *uint               `json:"agent_id"`
-	WebhookType        string              `json:"webhook_type"`
-	WebhookRefPath     string              `json:"webhook_ref_path"`
-	WebhookCommitPath  string              `json:"webhook_commit_path"`
-	WebhookMessagePath string              `json:"webhook_message_path"`
-	DeployTargets      []DeployTargetInput `json:"deploy_targets"`
+	RepositoryID       uint                   `json:"repository_id"`
+	Name               string                 `json:"name"`
+	Description        string                 `json:"description"`
+	Enabled            *bool                  `json:"enabled"`
+	Branch             string                 `json:"branch"`
+	ShallowClone       *bool                  `json:"shallow_clone"`
+	BuildScriptType    string                 `json:"build_script_type"`
+	BuildScript        string                 `json:"build_script"`
+	WorkDir            string                 `json:"work_dir"`
+	OutputDir          string                 `json:"output_dir"`
+	PipelineFile       string                 `json:"pipeline_file"`
+	CachePaths         string                 `json:"cache_paths"`
+	EnvVarNames        []string               `json:"env_var_names"`
+	Matrix             *model.BuildMatrix     `json:"matrix"`
+	Parameters         []model.BuildParameter `json:"parameters"`
+	TriggerManual      *bool                  `json:"trigger_manual"`
+	TriggerWebhook     *bool                  `json:"trigger_webhook"`
+	TriggerCron        *bool                  `json:"trigger_cron"`
+	CronExpression     string                 `json:"cron_expression"`
+	CronTimezone       string                 `json:"cron_timezone"`
+	MaxArtifacts       int                    `json:"max_artifacts"`
+	ArtifactFormat     string                 `json:"artifact_format"`
+	AgentTriggerEvent  string                 `json:"agent_trigger_event"`
+	AgentID            *uint                  `json:"agent_id"`
+	WebhookType        string                 `json:"webhook_type"`
+	WebhookRefPath     string                 `json:"webhook_ref_path"`
+	WebhookCommitPath  string                 `json:"webhook_commit_path"`
+	WebhookMessagePath string                 `json:"webhook_message_path"`
+	DeployTargets      []DeployTargetInput    `json:"deploy_targets"`
+	CredentialEnvs     []CredentialEnvInput   `json:"credential_envs"`
+
+	TimeoutSeconds           int `json:"timeout_seconds"`
+	CloneTimeoutSeconds      int `json:"clone_timeout_seconds"`
+	BuildTimeoutSeconds      int `json:"build_timeout_seconds"`
+	DistributeTimeoutSeconds int `json:"distribute_timeout_seconds"`
+
+	TestReportPaths     string  `json:"test_report_paths"`
+	CoverageReportPaths string  `json:"coverage_report_paths"`
+	CoverageThreshold   float64 `json:"coverage_threshold"`
+
+	ArtifactMaxAgeDays int `json:"artifact_max_age_days"`
+	ArtifactMaxTotalMB int `json:"artifact_max_total_mb"`
+
+	ArtifactDefs []model.ArtifactDef `json:"artifact_defs"`
+
+	CacheKey         string `json:"cache_key"`
+	CacheKeyFiles    string `json:"cache_key_files"`
+	CacheRestoreKeys string `json:"cache_restore_keys"`
+	CacheShared      bool   `json:"cache_shared"`
+
+	GitRef              string `json:"git_ref"`
+	GitSubmodules       bool   `json:"git_submodules"`
+	GitLFS              bool   `json:"git_lfs"`
+	SparseCheckoutPaths string `json:"sparse_checkout_paths"`
+
+	// ReportCommitStatus defaults to true.
+	ReportCommitStatus *bool `json:"report_commit_status"`
+
+	TriggerPullRequest    bool   `json:"trigger_pull_request"`
+	PullRequestRef        string `json:"pull_request_ref"`
+	PullRequestDistribute bool   `json:"pull_request_distribute"`
+
+	TriggerTag  bool   `json:"trigger_tag"`
+	TagPatterns string `json:"tag_patterns"`
+
+	BranchPatterns string `json:"branch_patterns"`
+	PathIncludes   string `json:"path_includes"`
+	PathExcludes   string `json:"path_excludes"`
+
+	TriggerPoll         bool `json:"trigger_poll"`
+	PollIntervalSeconds int  `json:"poll_interval_seconds"`
+
+	ApprovalEnvironments string `json:"approval_environments"`
+	DeployApproverIDs    []uint `json:"deploy_approver_ids"`
+
+	DistributeStrategy    string `json:"distribute_strategy"`
+	DistributeConcurrency int    `json:"distribute_concurrency"`
+	DistributeMaxFailures int    `json:"distribute_max_failures"`
+	CanaryVerifyScript    string `json:"canary_verify_script"`
 }
 
 type UpdateBuildJobInput struct {
-	Name               *string              `json:"name"`
-	Description        *string              `json:"description"`
-	Enabled            *bool                `json:"enabled"`
-	Branch             *string              `json:"branch"`
-	ShallowClone       *bool                `json:"shallow_clone"`
-	BuildScriptType    *string              `json:"build_script_type"`
-	BuildScript        *string              `json:"build_script"`
-	WorkDir            *string              `json:"work_dir"`
-	OutputDir          *string              `json:"output_dir"`
-	CachePaths         *string              `json:"cache_paths"`
-	EnvVarNames        *[]string            `json:"env_var_names"`
-	TriggerManual      *bool                `json:"trigger_manual"`
-	TriggerWebhook     *bool                `json:"trigger_webhook"`
-	TriggerCron        *bool                `json:"trigger_cron"`
-	CronExpression     *string              `json:"cron_expression"`
-	CronTimezone       *string              `json:"cron_timezone"`
-	MaxArtifacts       *int                 `json:"max_artifacts"`
-	ArtifactFormat     *string              `json:"artifact_format"`
-	AgentTriggerEvent  *string              `json:"agent_trigger_event"`
-	AgentID            *uint                `json:"agent_id"`
-	WebhookType        *string              `json:"webhook_type"`
-	WebhookRefPath     *string              `json:"webhook_ref_path"`
-	WebhookCommitPath  *string              `json:"webhook_commit_path"`
-	WebhookMessagePath *string              `json:"webhook_message_path"`
-	DeployTargets      *[]DeployTargetInput `json:"deploy_targets"`
-}
-
-func (s *BuildJobService) Create(createdBy uint, in CreateBuildJobInput) (*model.BuildJob, error) {
+	Name               *string                 `json:"name"`
+	Description        *string                 `json:"description"`
+	Enabled            *bool                   `json:"enabled"`
+	Branch             *string                 `json:"branch"`
+	ShallowClone       *bool                   `json:"shallow_clone"`
+	BuildScriptType    *string                 `json:"build_script_type"`
+	BuildScript        *string                 `json:"build_script"`
+	WorkDir            *string                 `json:"work_dir"`
+	OutputDir          *string                 `json:"output_dir"`
+	PipelineFile       *string                 `json:"pipeline_file"`
+	CachePaths         *string                 `json:"cache_paths"`
+	EnvVarNames        *[]string               `json:"env_var_names"`
+	Matrix             *model.BuildMatrix      `json:"matrix"`
+	Parameters         *[]model.BuildParameter `json:"parameters"`
+	TriggerManual      *bool                   `json:"trigger_manual"`
+	TriggerWebhook     *bool                   `json:"trigger_webhook"`
+	TriggerCron        *bool                   `json:"trigger_cron"`
+	CronExpression     *string                 `json:"cron_expression"`
+	CronTimezone       *string                 `json:"cron_timezone"`
+	MaxArtifacts       *int                    `json:"max_artifacts"`
+	ArtifactFormat     *string                 `json:"artifact_format"`
+	AgentTriggerEvent  *string                 `json:"agent_trigger_event"`
+	AgentID            *uint                   `json:"agent_id"`
+	WebhookType        *string                 `json:"webhook_type"`
+	WebhookRefPath     *string                 `json:"webhook_ref_path"`
+	WebhookCommitPath  *string                 `json:"webhook_commit_path"`
+	WebhookMessagePath *string                 `json:"webhook_message_path"`
+	DeployTargets      *[]DeployTargetInput    `json:"deploy_targets"`
+	CredentialEnvs     *[]CredentialEnvInput   `json:"credential_envs"`
+
+	TimeoutSeconds           *int `json:"timeout_seconds"`
+	CloneTimeoutSeconds      *int `json:"clone_timeout_seconds"`
+	BuildTimeoutSeconds      *int `json:"build_timeout_seconds"`
+	DistributeTimeoutSeconds *int `json:"distribute_timeout_seconds"`
+
+	TestReportPaths     *string  `json:"test_report_paths"`
+	CoverageReportPaths *string  `json:"coverage_report_paths"`
+	CoverageThreshold   *float64 `json:"coverage_threshold"`
+
+	ArtifactMaxAgeDays *int `json:"artifact_max_age_days"`
+	ArtifactMaxTotalMB *int `json:"artifact_max_total_mb"`
+
+	ArtifactDefs *[]model.ArtifactDef `json:"artifact_defs"`
+
+	CacheKey         *string `json:"cache_key"`
+	CacheKeyFiles    *string `json:"cache_key_files"`
+	CacheRestoreKeys *string `json:"cache_restore_keys"`
+	CacheShared      *bool   `json:"cache_shared"`
+
+	GitRef              *string `json:"git_ref"`
+	GitSubmodules       *bool   `json:"git_submodules"`
+	GitLFS              *bool   `json:"git_lfs"`
+	SparseCheckoutPaths *string `json:"sparse_checkout_paths"`
+
+	ReportCommitStatus *bool `json:"report_commit_status"`
+
+	TriggerPullRequest    *bool   `json:"trigger_pull_request"`
+	PullRequestRef        *string `json:"pull_request_ref"`
+	PullRequestDistribute *bool   `json:"pull_request_distribute"`
+
+	TriggerTag  *bool   `json:"trigger_tag"`
+	TagPatterns *string `json:"tag_patterns"`
+
+	BranchPatterns *string `json:"branch_patterns"`
+	PathIncludes   *string `json:"path_includes"`
+	PathExcludes   *string `json:"path_excludes"`
+
+	TriggerPoll         *bool `json:"trigger_poll"`
+	PollIntervalSeconds *int  `json:"poll_interval_seconds"`
+
+	ApprovalEnvironments *string `json:"approval_environments"`
+	DeployApproverIDs    *[]uint `json:"deploy_approver_ids"`
+
+	DistributeStrategy    *string `json:"distribute_strategy"`
+	DistributeConcurrency *int    `json:"distribute_concurrency"`
+	DistributeMaxFailures *int    `json:"distribute_max_failures"`
+	CanaryVerifyScript    *string `json:"canary_verify_script"`
+}
+
+// Create stores a job; binding credentials as env vars requires canUseCredential
+// (resource_credentials:use), same as binding one to a repository.
+func (s *BuildJobService) Create(createdBy uint, in CreateBuildJobInput, canUseCredential bool) (*model.BuildJob, error) {
 	if _, err := s.repos.FindByID(in.RepositoryID); err != nil {
 		return nil, errorsNew("所属仓库不存在")
 	}
+	credEnvs, err := s.mapCredentialEnvs(in.CredentialEnvs, nil, canUseCredential)
+	if err != nil {
+		return nil, err
+	}
 	name := strings.TrimSpace(in.Name)
 	if name == "" {
 		return nil, errorsNew("名称不能为空")
@@ -121,6 +255,7 @@ func (s *BuildJobService) Create(createdBy uint, in CreateBuildJobInput) (*model
 		BuildScript:        in.BuildScript,
 		WorkDir:            strings.TrimSpace(in.WorkDir),
 		OutputDir:          strings.TrimSpace(in.OutputDir),
+		PipelineFile:       strings.TrimSpace(in.PipelineFile),
 		CachePaths:         in.CachePaths,
 		TriggerManual:      boolOr(in.TriggerManual, true),
 		TriggerWebhook:     boolOr(in.TriggerWebhook, false),
@@ -137,19 +272,93 @@ func (s *BuildJobService) Create(createdBy uint, in CreateBuildJobInput) (*model
 		AgentTriggerEvent:  normalizeAgentEvent(in.AgentTriggerEvent),
 		AgentID:            in.AgentID,
 		CreatedBy:          createdBy,
+
+		TimeoutSeconds:           in.TimeoutSeconds,
+		CloneTimeoutSeconds:      in.CloneTimeoutSeconds,
+		BuildTimeoutSeconds:      in.BuildTimeoutSeconds,
+		DistributeTimeoutSeconds: in.DistributeTimeoutSeconds,
+
+		TestReportPaths:     strings.TrimSpace(in.TestReportPaths),
+		CoverageReportPaths: strings.TrimSpace(in.CoverageReportPaths),
+		CoverageThreshold:   in.CoverageThreshold,
+
+		ArtifactMaxAgeDays: in.ArtifactMaxAgeDays,
+		ArtifactMaxTotalMB: in.ArtifactMaxTotalMB,
+
+		CacheKey:         strings.TrimSpace(in.CacheKey),
+		CacheKeyFiles:    strings.TrimSpace(in.CacheKeyFiles),
+		CacheRestoreKeys: strings.TrimSpace(in.CacheRestoreKeys),
+		CacheShared:      in.CacheShared,
+
+		GitRef:              strings.TrimSpace(in.GitRef),
+		GitSubmodules:       in.GitSubmodules,
+		GitLFS:              in.GitLFS,
+		SparseCheckoutPaths: strings.TrimSpace(in.SparseCheckoutPaths),
+
+		ReportCommitStatus: boolOr(in.ReportCommitStatus, true),
+
+		TriggerPullRequest:    in.TriggerPullRequest,
+		PullRequestRef:        normalizePullRequestRef(in.PullRequestRef),
+		PullRequestDistribute: in.PullRequestDistribute,
+
+		TriggerTag:  in.TriggerTag,
+		TagPatterns: strings.TrimSpace(in.TagPatterns),
+
+		BranchPatterns: strings.TrimSpace(in.BranchPatterns),
+		PathIncludes:   strings.TrimSpace(in.PathIncludes),
+		PathExcludes:   strings.TrimSpace(in.PathExcludes),
+
+		TriggerPoll:         in.TriggerPoll,
+		PollIntervalSeconds: pollIntervalOr(in.PollIntervalSeconds),
+
+		ApprovalEnvironments: strings.TrimSpace(in.ApprovalEnvironments),
+
+		DistributeStrategy:    distributeStrategyOr(in.DistributeStrategy),
+		DistributeConcurrency: distributeConcurrencyOr(in.DistributeConcurrency),
+		DistributeMaxFailures: in.DistributeMaxFailures,
+		CanaryVerifyScript:    in.CanaryVerifyScript,
+	}
+	if err := validatePipelineFile(job.PipelineFile); err != nil {
+		return nil, err
+	}
+	if err := validateTimeouts(job); err != nil {
+		return nil, err
+	}
+	if err := validateReportSettings(job); err != nil {
+		return nil, err
 	}
 	if err := encodeEnvNames(job, in.EnvVarNames); err != nil {
 		return nil, err
 	}
+	if err := encodeMatrix(job, in.Matrix); err != nil {
+		return nil, err
+	}
+	if err := encodeParameters(job, in.Parameters); err != nil {
+		return nil, err
+	}
+	if err := encodeArtifactDefs(job, in.ArtifactDefs); err != nil {
+		return nil, err
+	}
+	if err := encodeDeployApprovers(job, in.DeployApproverIDs); err != nil {
+		return nil, err
+	}
+	targets, err := mapDeployTargets(in.DeployTargets)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDeployApprovers(job, targets); err != nil {
+		return nil, err
+	}
 	if err := s.jobs.Create(job); err != nil {
 		return nil, err
 	}
-	if len(in.DeployTargets) > 0 {
-		targets, err := mapDeployTargets(in.DeployTargets)
-		if err != nil {
+	if len(targets) > 0 {
+		if err := s.jobs.ReplaceDeployTargets(job.ID, targets); err != nil {
 			return nil, err
 		}
-		if err := s.jobs.ReplaceDeployTargets(job.ID, targets); err != nil {
+	}
+	if len(credEnvs) > 0 {
+		if err := s.jobs.ReplaceCredentialEnvs(job.ID, credEnvs); err != nil {
 			return nil, err
 		}
 	}
@@ -161,11 +370,18 @@ func (s *BuildJobService) Create(createdBy uint, in CreateBuildJobInput) (*model
 	return out, nil
 }
 
-func (s *BuildJobService) Update(id uint, in UpdateBuildJobInput) (*model.BuildJob, error) {
+func (s *BuildJobService) Update(id uint, in UpdateBuildJobInput, canUseCredential bool) (*model.BuildJob, error) {
 	job, err := s.jobs.FindByID(id)
 	if err != nil {
 		return nil, NewNotFound("构建任务不存在")
 	}
+	var credEnvs []model.JobCredentialEnv
+	if in.CredentialEnvs != nil {
+		credEnvs, err = s.mapCredentialEnvs(*in.CredentialEnvs, job.CredentialEnvs, canUseCredential)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if in.Name != nil {
 		job.Name = strings.TrimSpace(*in.Name)
 	}
@@ -193,6 +409,9 @@ func (s *BuildJobService) Update(id uint, in UpdateBuildJobInput) (*model.BuildJ
 	if in.OutputDir != nil {
 		job.OutputDir = strings.TrimSpace(*in.OutputDir)
 	}
+	if in.PipelineFile != nil {
+		job.PipelineFile = strings.TrimSpace(*in.PipelineFile)
+	}
 	if in.CachePaths != nil {
 		job.CachePaths = *in.CachePaths
 	}
@@ -201,6 +420,21 @@ func (s *BuildJobService) Update(id uint, in UpdateBuildJobInput) (*model.BuildJ
 			return nil, err
 		}
 	}
+	if in.Matrix != nil {
+		if err := encodeMatrix(job, in.Matrix); err != nil {
+			return nil, err
+		}
+	}
+	if in.Parameters != nil {
+		if err := encodeParameters(job, *in.Parameters); err != nil {
+			return nil, err
+		}
+	}
+	if in.ArtifactDefs != nil {
+		if err := encodeArtifactDefs(job, *in.ArtifactDefs); err != nil {
+			return nil, err
+		}
+	}
 	if in.TriggerManual != nil {
 		job.TriggerManual = *in.TriggerManual
 	}
@@ -231,6 +465,110 @@ func (s *BuildJobService) Update(id uint, in UpdateBuildJobInput) (*model.BuildJ
 	if in.MaxArtifacts != nil {
 		job.MaxArtifacts = intOr(*in.MaxArtifacts, 5)
 	}
+	if in.TimeoutSeconds != nil {
+		job.TimeoutSeconds = *in.TimeoutSeconds
+	}
+	if in.CloneTimeoutSeconds != nil {
+		job.CloneTimeoutSeconds = *in.CloneTimeoutSeconds
+	}
+	if in.BuildTimeoutSeconds != nil {
+		job.BuildTimeoutSeconds = *in.BuildTimeoutSeconds
+	}
+	if in.DistributeTimeoutSeconds != nil {
+		job.DistributeTimeoutSeconds = *in.DistributeTimeoutSeconds
+	}
+	if in.TestReportPaths != nil {
+		job.TestReportPaths = strings.TrimSpace(*in.TestReportPaths)
+	}
+	if in.CoverageReportPaths != nil {
+		job.CoverageReportPaths = strings.TrimSpace(*in.CoverageReportPaths)
+	}
+	if in.CoverageThreshold != nil {
+		job.CoverageThreshold = *in.CoverageThreshold
+	}
+	if in.ArtifactMaxAgeDays != nil {
+		job.ArtifactMaxAgeDays = *in.ArtifactMaxAgeDays
+	}
+	if in.ArtifactMaxTotalMB != nil {
+		job.ArtifactMaxTotalMB = *in.ArtifactMaxTotalMB
+	}
+	if in.CacheKey != nil {
+		job.CacheKey = strings.TrimSpace(*in.CacheKey)
+	}
+	if in.CacheKeyFiles != nil {
+		job.CacheKeyFiles = strings.TrimSpace(*in.CacheKeyFiles)
+	}
+	if in.CacheRestoreKeys != nil {
+		job.CacheRestoreKeys = strings.TrimSpace(*in.CacheRestoreKeys)
+	}
+	if in.CacheShared != nil {
+		job.CacheShared = *in.CacheShared
+	}
+	if in.GitRef != nil {
+		job.GitRef = strings.TrimSpace(*in.GitRef)
+	}
+	if in.GitSubmodules != nil {
+		job.GitSubmodules = *in.GitSubmodules
+	}
+	if in.GitLFS != nil {
+		job.GitLFS = *in.GitLFS
+	}
+	if in.SparseCheckoutPaths != nil {
+		job.SparseCheckoutPaths = strings.TrimSpace(*in.SparseCheckoutPaths)
+	}
+	if in.ReportCommitStatus != nil {
+		job.ReportCommitStatus = *in.ReportCommitStatus
+	}
+	if in.TriggerPullRequest != nil {
+		job.TriggerPullRequest = *in.TriggerPullRequest
+	}
+	if in.PullRequestRef != nil {
+		job.PullRequestRef = normalizePullRequestRef(*in.PullRequestRef)
+	}
+	if in.PullRequestDistribute != nil {
+		job.PullRequestDistribute = *in.PullRequestDistribute
+	}
+	if in.TriggerTag != nil {
+		job.TriggerTag = *in.TriggerTag
+	}
+	if in.TagPatterns != nil {
+		job.TagPatterns = strings.TrimSpace(*in.TagPatterns)
+	}
+	if in.BranchPatterns != nil {
+		job.BranchPatterns = strings.TrimSpace(*in.BranchPatterns)
+	}
+	if in.PathIncludes != nil {
+		job.PathIncludes = strings.TrimSpace(*in.PathIncludes)
+	}
+	if in.PathExcludes != nil {
+		job.PathExcludes = strings.TrimSpace(*in.PathExcludes)
+	}
+	if in.TriggerPoll != nil {
+		job.TriggerPoll = *in.TriggerPoll
+	}
+	if in.PollIntervalSeconds != nil {
+		job.PollIntervalSeconds = pollIntervalOr(*in.PollIntervalSeconds)
+	}
+	if in.ApprovalEnvironments != nil {
+		job.ApprovalEnvironments = strings.TrimSpace(*in.ApprovalEnvironments)
+	}
+	if in.DeployApproverIDs != nil {
+		if err := encodeDeployApprovers(job, *in.DeployApproverIDs); err != nil {
+			return nil, err
+		}
+	}
+	if in.DistributeStrategy != nil {
+		job.DistributeStrategy = distributeStrategyOr(*in.DistributeStrategy)
+	}
+	if in.DistributeConcurrency != nil {
+		job.DistributeConcurrency = distributeConcurrencyOr(*in.DistributeConcurrency)
+	}
+	if in.DistributeMaxFailures != nil {
+		job.DistributeMaxFailures = *in.DistributeMaxFailures
+	}
+	if in.CanaryVerifyScript != nil {
+		job.CanaryVerifyScript = *in.CanaryVerifyScript
+	}
 	if in.ArtifactFormat != nil {
 		job.ArtifactFormat = normalizeArtifactFormat(*in.ArtifactFormat)
 	}
@@ -247,15 +585,37 @@ func (s *BuildJobService) Update(id uint, in UpdateBuildJobInput) (*model.BuildJ
 	if job.Name == "" {
 		return nil, errorsNew("名称不能为空")
 	}
+	if err := validatePipelineFile(job.PipelineFile); err != nil {
+		return nil, err
+	}
+	if err := validateTimeouts(job); err != nil {
+		return nil, err
+	}
+	if err := validateReportSettings(job); err != nil {
+		return nil, err
+	}
+	var targets []model.DeployTarget
+	if in.DeployTargets != nil {
+		targets, err = mapDeployTargets(*in.DeployTargets)
+	} else {
+		targets, err = s.jobs.ListDeployTargets(job.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDeployApprovers(job, targets); err != nil {
+		return nil, err
+	}
 	if err := s.jobs.Update(job); err != nil {
 		return nil, err
 	}
 	if in.DeployTargets != nil {
-		targets, err := mapDeployTargets(*in.DeployTargets)
-		if err != nil {
+		if err := s.jobs.ReplaceDeployTargets(job.ID, targets); err != nil {
 			return nil, err
 		}
-		if err := s.jobs.ReplaceDeployTargets(job.ID, targets); err != nil {
+	}
+	if in.CredentialEnvs != nil {
+		if err := s.jobs.ReplaceCredentialEnvs(job.ID, credEnvs); err != nil {
 			return nil, err
 		}
 	}
@@ -293,6 +653,10 @@ func (s *BuildJobService) Get(id uint) (*model.BuildJob, error) {
 		return nil, NewNotFound("构建任务不存在")
 	}
 	decodeEnvNames(job)
+	engine.DecodeJobMatrix(job)
+	engine.DecodeJobParameters(job)
+	engine.DecodeJobArtifactDefs(job)
+	engine.DecodeDeployApprovers(job)
 	return publicJob(job, false), nil
 }
 
@@ -302,6 +666,10 @@ func (s *BuildJobService) GetWithSecret(id uint) (*model.BuildJob, error) {
 		return nil, NewNotFound("构建任务不存在")
 	}
 	decodeEnvNames(job)
+	engine.DecodeJobMatrix(job)
+	engine.DecodeJobParameters(job)
+	engine.DecodeJobArtifactDefs(job)
+	engine.DecodeDeployApprovers(job)
 	return publicJob(job, true), nil
 }
 
@@ -319,6 +687,10 @@ func (s *BuildJobService) RotateWebhookSecret(id uint) (*model.BuildJob, error)
 		return nil, err
 	}
 	decodeEnvNames(job)
+	engine.DecodeJobMatrix(job)
+	engine.DecodeJobParameters(job)
+	engine.DecodeJobArtifactDefs(job)
+	engine.DecodeDeployApprovers(job)
 	return publicJob(job, true), nil
 }
 
@@ -329,11 +701,63 @@ func (s *BuildJobService) List(page, pageSize int, repositoryID *uint, keyword s
 	}
 	for i := range items {
 		decodeEnvNames(&items[i])
+		engine.DecodeJobMatrix(&items[i])
+		engine.DecodeJobParameters(&items[i])
+		engine.DecodeJobArtifactDefs(&items[i])
+		engine.DecodeDeployApprovers(&items[i])
 		items[i] = *publicJob(&items[i], false)
 	}
 	return items, total, nil
 }
 
+// mapCredentialEnvs validates bindings. Keeping an existing binding unchanged
+// needs no permission; adding or repointing one requires canUseCredential.
+func (s *BuildJobService) mapCredentialEnvs(in []CredentialEnvInput, existing []model.JobCredentialEnv, canUseCredential bool) ([]model.JobCredentialEnv, error) {
+	kept := map[string]struct{}{}
+	for _, e := range existing {
+		kept[credentialEnvKey(e.Name, e.CredentialID, e.Field)] = struct{}{}
+	}
+	out := make([]model.JobCredentialEnv, 0, len(in))
+	seen := map[string]struct{}{}
+	for _, e := range in {
+		name := strings.TrimSpace(e.Name)
+		if err := engine.ValidateEnvVarName(name); err != nil {
+			return nil, errorsNew("凭证变量名无效: " + err.Error())
+		}
+		if _, dup := seen[name]; dup {
+			return nil, errorsNew("凭证变量名重复: " + name)
+		}
+		seen[name] = struct{}{}
+		field := strings.ToLower(strings.TrimSpace(e.Field))
+		switch field {
+		case "":
+			field = "secret"
+		case "secret", "username", "passphrase":
+		default:
+			return nil, errorsNew("凭证变量 field 无效（secret / username / passphrase）")
+		}
+		if e.CredentialID == 0 {
+			return nil, errorsNew("凭证变量必须提供 credential_id")
+		}
+		if _, ok := kept[credentialEnvKey(name, e.CredentialID, field)]; !ok {
+			if !canUseCredential {
+				return nil, NewForbidden("绑定凭证需要 resource_credentials:use 权限")
+			}
+			if s.creds != nil {
+				if _, err := s.creds.FindByID(e.CredentialID); err != nil {
+					return nil, errorsNew("凭证不存在")
+				}
+			}
+		}
+		out = append(out, model.JobCredentialEnv{Name: name, CredentialID: e.CredentialID, Field: field})
+	}
+	return out, nil
+}
+
+func credentialEnvKey(name string, credentialID uint, field string) string {
+	return name + "\x00" + strconv.FormatUint(uint64(credentialID), 10) + "\x00" + field
+}
+
 func mapDeployTargets(in []DeployTargetInput) ([]model.DeployTarget, error) {
 	out := make([]model.DeployTarget, 0, len(in))
 	for i, t := range in {
@@ -344,6 +768,16 @@ func mapDeployTargets(in []DeployTargetInput) ([]model.DeployTarget, error) {
 		if method != "local" && (t.ServerID == nil || *t.ServerID == 0) {
 			return nil, errorsNew("非 local 部署必须指定 server_id")
 		}
+		artifact := strings.TrimSpace(t.ArtifactName)
+		if artifact != "" {
+			if err := engine.ValidateArtifactName(artifact); err != nil {
+				return nil, errorsNew("部署目标制品名无效: " + err.Error())
+			}
+		}
+		env := strings.TrimSpace(t.Environment)
+		if len([]rune(env)) > 50 {
+			return nil, errorsNew("部署目标环境名不能超过 50 个字符")
+		}
 		order := t.SortOrder
 		if order == 0 {
 			order = i
@@ -354,6 +788,9 @@ func mapDeployTargets(in []DeployTargetInput) ([]model.DeployTarget, error) {
 			Method:           method,
 			PostDeployScript: t.PostDeployScript,
 			SortOrder:        order,
+			ArtifactName:     artifact,
+			Environment:      env,
+			RequiresApproval: t.RequiresApproval,
 		})
 	}
 	return out, nil
@@ -392,6 +829,200 @@ func decodeEnvNames(job *model.BuildJob) {
 	job.EnvVarNames = names
 }
 
+// encodeMatrix validates m and stores it on job; a matrix without axes or
+// include entries clears it (plain single-run job).
+func encodeMatrix(job *model.BuildJob, m *model.BuildMatrix) error {
+	cells, err := engine.ExpandMatrix(m)
+	if err != nil {
+		return errorsNew("构建矩阵无效: " + err.Error())
+	}
+	if len(cells) == 0 {
+		job.MatrixJSON = ""
+		job.Matrix = nil
+		return nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	job.MatrixJSON = string(b)
+	job.Matrix = m
+	return nil
+}
+
+// encodeParameters validates trigger parameter definitions and stores them on job.
+func encodeParameters(job *model.BuildJob, defs []model.BuildParameter) error {
+	if defs == nil {
+		defs = []model.BuildParameter{}
+	}
+	if err := engine.ValidateParameterDefs(defs); err != nil {
+		return errorsNew("构建参数无效: " + err.Error())
+	}
+	b, err := json.Marshal(defs)
+	if err != nil {
+		return err
+	}
+	job.ParametersJSON = string(b)
+	job.Parameters = defs
+	return nil
+}
+
+// encodeArtifactDefs validates named artifact definitions and stores them on job.
+func encodeArtifactDefs(job *model.BuildJob, defs []model.ArtifactDef) error {
+	if defs == nil {
+		defs = []model.ArtifactDef{}
+	}
+	for i := range defs {
+		defs[i].Name = strings.TrimSpace(defs[i].Name)
+		defs[i].Path = strings.TrimSpace(defs[i].Path)
+		defs[i].Format = strings.ToLower(strings.TrimSpace(defs[i].Format))
+	}
+	if err := engine.ValidateArtifactDefs(defs); err != nil {
+		return errorsNew("制品定义无效: " + err.Error())
+	}
+	b, err := json.Marshal(defs)
+	if err != nil {
+		return err
+	}
+	job.ArtifactDefsJSON = string(b)
+	job.ArtifactDefs = defs
+	return nil
+}
+
+// encodeDeployApprovers stores the users notified of deployment approvals
+// (empty = whoever triggered the run).
+func encodeDeployApprovers(job *model.BuildJob, ids []uint) error {
+	cleaned := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if id != 0 && !slices.Contains(cleaned, id) {
+			cleaned = append(cleaned, id)
+		}
+	}
+	b, err := json.Marshal(cleaned)
+	if err != nil {
+		return err
+	}
+	job.DeployApproverIDsJSON = string(b)
+	job.DeployApproverIDs = cleaned
+	return nil
+}
+
+// validateDeployApprovers requires approvers for gated targets of a job that
+// builds without a user (webhook, cron, polling): such runs have nobody else
+// to notify.
+func validateDeployApprovers(job *model.BuildJob, targets []model.DeployTarget) error {
+	engine.DecodeDeployApprovers(job)
+	if len(job.DeployApproverIDs) > 0 || !(job.TriggerWebhook || job.TriggerCron || job.TriggerPoll) {
+		return nil
+	}
+	for i := range targets {
+		if engine.NeedsApproval(job, &targets[i]) {
+			return errorsNew("部署目标需要审批时，开启 Webhook、定时或轮询触发的任务必须配置部署审批人")
+		}
+	}
+	return nil
+}
+
+// validatePipelineFile requires a workspace-relative path (empty = auto-detect .bedrock.yml).
+func validatePipelineFile(p string) error {
+	if p == "" {
+		return nil
+	}
+	clean := filepath.ToSlash(filepath.Clean(p))
+	if filepath.IsAbs(p) || strings.HasPrefix(p, "/") || clean == ".." || strings.HasPrefix(clean, "../") {
+		return errorsNew("pipeline_file 必须是仓库内的相对路径")
+	}
+	return nil
+}
+
+// maxTimeoutSeconds caps job/stage limits at one week.
+const maxTimeoutSeconds = 7 * 24 * 3600
+
+func validateTimeouts(job *model.BuildJob) error {
+	for _, v := range []int{job.TimeoutSeconds, job.CloneTimeoutSeconds, job.BuildTimeoutSeconds, job.DistributeTimeoutSeconds} {
+		if v < 0 || v > maxTimeoutSeconds {
+			return errorsNew("超时时间须在 0（不限制）到 604800 秒之间")
+		}
+	}
+	return nil
+}
+
+func validateReportSettings(job *model.BuildJob) error {
+	if err := engine.ValidateReportPaths(job.TestReportPaths); err != nil {
+		return errorsNew("测试报告路径无效: " + err.Error())
+	}
+	if err := engine.ValidateReportPaths(job.CoverageReportPaths); err != nil {
+		return errorsNew("覆盖率报告路径无效: " + err.Error())
+	}
+	if job.CoverageThreshold < 0 || job.CoverageThreshold > 100 {
+		return errorsNew("覆盖率阈值须在 0（不检查）到 100 之间")
+	}
+	if job.ArtifactMaxAgeDays < 0 || job.ArtifactMaxTotalMB < 0 {
+		return errorsNew("制品保留天数和总容量上限不能为负数（0 表示不限制）")
+	}
+	if err := engine.ValidateCacheSettings(job); err != nil {
+		return errorsNew("缓存设置无效: " + err.Error())
+	}
+	if job.GitRef != "" {
+		if err := engine.ValidateGitRef(job.GitRef); err != nil {
+			return errorsNew("Git 引用无效: " + err.Error())
+		}
+	}
+	if err := engine.ValidateSparsePaths(engine.ParseSparsePaths(job.SparseCheckoutPaths)); err != nil {
+		return errorsNew("稀疏检出路径无效: " + err.Error())
+	}
+	patterns := engine.ParseTagPatterns(job.TagPatterns)
+	if err := engine.ValidateTagPatterns(patterns); err != nil {
+		return errorsNew("标签匹配规则无效: " + err.Error())
+	}
+	if job.TriggerTag && len(patterns) == 0 {
+		return errorsNew("开启标签触发时须填写标签匹配规则")
+	}
+	for _, f := range []struct{ label, raw string }{
+		{"分支匹配规则", job.BranchPatterns},
+		{"包含路径", job.PathIncludes},
+		{"排除路径", job.PathExcludes},
+	} {
+		if err := engine.ValidateFilterPatterns(engine.ParseFilterPatterns(f.raw)); err != nil {
+			return errorsNew(f.label + "无效: " + err.Error())
+		}
+	}
+	minPoll, maxPoll := int(engine.MinPollInterval.Seconds()), int(engine.MaxPollInterval.Seconds())
+	if job.PollIntervalSeconds < minPoll || job.PollIntervalSeconds > maxPoll {
+		return errorsNew("轮询间隔须在 " + strconv.Itoa(minPoll) + " 到 " + strconv.Itoa(maxPoll) + " 秒之间")
+	}
+	if err := engine.ValidateDistributeStrategy(job); err != nil {
+		return errorsNew("分发策略无效: " + err.Error())
+	}
+	return nil
+}
+
+// pollIntervalOr defaults an unset poll interval to five minutes.
+func pollIntervalOr(seconds int) int {
+	if seconds == 0 {
+		return 300
+	}
+	return seconds
+}
+
+// distributeStrategyOr defaults an empty strategy to serial; unknown values
+// are left for validateReportSettings to reject.
+func distributeStrategyOr(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return engine.DistributeSerial
+	}
+	return s
+}
+
+// distributeConcurrencyOr defaults an unset concurrency to one target at a time.
+func distributeConcurrencyOr(n int) int {
+	if n == 0 {
+		return 1
+	}
+	return n
+}
+
 func normalizeArtifactFormat(f string) string {
 	if strings.ToLower(strings.TrimSpace(f)) == "zip" {
 		return "zip"
@@ -399,6 +1030,14 @@ func normalizeArtifactFormat(f string) string {
 	return "gzip"
 }
 
+// normalizePullRequestRef: merge builds the platform's merge ref, anything else the PR head.
+func normalizePullRequestRef(r string) string {
+	if strings.ToLower(strings.TrimSpace(r)) == "merge" {
+		return "merge"
+	}
+	return "head"
+}
+
 func normalizeAgentEvent(e string) string {
 	switch strings.ToLower(strings.TrimSpace(e)) {
 	case "distribution_finished", "none":
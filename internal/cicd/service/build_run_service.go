@@ -4,8 +4,11 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-	"os"
+	"errors"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,6 +22,7 @@ type BuildRunService struct {
 	runs      *repository.BuildRunRepository
 	jobs      *repository.BuildJobRepository
 	scheduler engine.RunScheduler
+	store     engine.ArtifactStore
 }
 
 func NewBuildRunService(runs *repository.BuildRunRepository, jobs *repository.BuildJobRepository) *BuildRunService {
@@ -29,19 +33,42 @@ func (s *BuildRunService) SetScheduler(sched engine.RunScheduler) {
 	s.scheduler = sched
 }
 
+// SetArtifactStore resolves downloads of artifacts kept in the content store.
+func (s *BuildRunService) SetArtifactStore(store engine.ArtifactStore) {
+	s.store = store
+}
+
 type EnqueueRunInput struct {
-	Branch        string `json:"branch"`
-	TriggerType   string `json:"trigger_type"`
-	CommitHash    string `json:"commit_hash"`
-	CommitMessage string `json:"commit_message"`
+	Branch        string            `json:"branch"`
+	TriggerType   string            `json:"trigger_type"`
+	CommitHash    string            `json:"commit_hash"`
+	CommitMessage string            `json:"commit_message"`
+	Params        map[string]string `json:"params"`
 }
 
 type RedeployInput struct {
 	TargetIDs []uint `json:"target_ids"`
 }
 
+// ApprovalInput decides a run's pending deployment approval. RejectAction
+// ("skip" or "cancel", default skip) applies when Approve is false.
+type ApprovalInput struct {
+	Approve      bool   `json:"approve"`
+	RejectAction string `json:"reject_action"`
+	Comment      string `json:"comment"`
+}
+
 func (s *BuildRunService) List(page, pageSize int, buildJobID *uint, status string) ([]model.BuildRun, int64, error) {
-	return s.runs.List(page, pageSize, buildJobID, status)
+	items, total, err := s.runs.List(page, pageSize, buildJobID, status)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range items {
+		engine.DecodeRunArtifacts(&items[i])
+		engine.DecodeMatrixCell(&items[i])
+		engine.DecodeTestSummary(&items[i])
+	}
+	return items, total, nil
 }
 
 func (s *BuildRunService) Get(id uint) (*model.BuildRun, error) {
@@ -49,6 +76,24 @@ func (s *BuildRunService) Get(id uint) (*model.BuildRun, error) {
 	if err != nil {
 		return nil, NewNotFound("构建执行不存在")
 	}
+	engine.DecodeRunArtifacts(run)
+	engine.DecodeMatrixCell(run)
+	engine.DecodeLogStages(run)
+	engine.DecodeTestSummary(run)
+	engine.DecodeCoverage(run)
+	for i := range run.DeployApprovals {
+		engine.DecodeApprovalTargets(&run.DeployApprovals[i])
+	}
+	if engine.IsMatrixParent(run) {
+		children, err := s.runs.ListByParent(run.ID)
+		if err != nil {
+			return nil, err
+		}
+		for i := range children {
+			engine.DecodeMatrixCell(&children[i])
+		}
+		run.Children = children
+	}
 	return run, nil
 }
 
@@ -58,6 +103,7 @@ func (s *BuildRunService) Enqueue(jobID, triggeredBy uint, in EnqueueRunInput) (
 		TriggerType:   in.TriggerType,
 		CommitHash:    in.CommitHash,
 		CommitMessage: in.CommitMessage,
+		Params:        in.Params,
 	})
 }
 
@@ -71,6 +117,15 @@ func (s *BuildRunService) EnqueueInternal(jobID, triggeredBy uint, in engine.Enq
 		return nil, errorsNew("构建任务已禁用")
 	}
 	decodeEnvNames(job)
+	engine.DecodeJobParameters(job)
+	params, err := engine.ResolveParams(job.Parameters, in.Params)
+	if err != nil {
+		return nil, errorsNew(err.Error())
+	}
+	paramsCipher, err := engine.EncryptRunParams(params)
+	if err != nil {
+		return nil, err
+	}
 	branch := in.Branch
 	if branch == "" {
 		branch = job.Branch
@@ -96,7 +151,32 @@ func (s *BuildRunService) EnqueueInternal(jobID, triggeredBy uint, in engine.Enq
 		"triggered_by":    triggeredBy,
 		"enqueued_at":     time.Now().UTC().Format(time.RFC3339),
 	}
-	snapBytes, _ := json.Marshal(snapshot)
+	if len(params) > 0 {
+		snapshot["params"] = engine.MaskParams(job.Parameters, params)
+	}
+	if len(job.CredentialEnvs) > 0 {
+		names := make([]string, 0, len(job.CredentialEnvs))
+		for _, e := range job.CredentialEnvs {
+			names = append(names, e.Name)
+		}
+		snapshot["credential_env_names"] = names
+	}
+	if timeouts := jobTimeouts(job); len(timeouts) > 0 {
+		snapshot["timeouts"] = timeouts
+	}
+	gitRef := in.GitRef
+	if in.Tag != "" && gitRef == "" {
+		gitRef = "refs/tags/" + in.Tag
+	}
+	if gitRef != "" {
+		snapshot["git_ref"] = gitRef
+	}
+	if in.Tag != "" {
+		snapshot["tag"] = in.Tag
+	}
+	if in.PullRequest != nil {
+		snapshot["pull_request"] = in.PullRequest
+	}
 	run := &model.BuildRun{
 		BuildJobID:          jobID,
 		BuildNumber:         num,
@@ -108,8 +188,35 @@ func (s *BuildRunService) EnqueueInternal(jobID, triggeredBy uint, in engine.Enq
 		CommitHash:          in.CommitHash,
 		CommitMessage:       in.CommitMessage,
 		DistributionSummary: "none",
-		SnapshotJSON:        string(snapBytes),
+		MatrixSummary:       "none",
+		ParamsCipher:        paramsCipher,
+		GitRef:              gitRef,
+	}
+	if in.Tag != "" {
+		run.Tag = in.Tag
+		run.ReleaseVersion = engine.ReleaseVersion(in.Tag)
+	}
+	if pr := in.PullRequest; pr != nil {
+		run.PRNumber = pr.Number
+		run.PRSourceBranch = pr.SourceBranch
+		run.PRTargetBranch = pr.TargetBranch
+		run.PRAuthor = pr.Author
+		run.PRHeadCommit = pr.HeadCommit
 	}
+	var cells []map[string]string
+	if len(in.MatrixCell) > 0 {
+		setMatrixCell(run, snapshot, in.MatrixCell)
+	} else {
+		engine.DecodeJobMatrix(job)
+		if cells, err = engine.ExpandMatrix(job.Matrix); err != nil {
+			return nil, errorsNew("构建矩阵无效: " + err.Error())
+		}
+	}
+	if len(cells) > 0 {
+		return s.enqueueMatrix(run, snapshot, job.Matrix, cells)
+	}
+	snapBytes, _ := json.Marshal(snapshot)
+	run.SnapshotJSON = string(snapBytes)
 	if err := s.runs.Create(run); err != nil {
 		return nil, err
 	}
@@ -119,11 +226,145 @@ func (s *BuildRunService) EnqueueInternal(jobID, triggeredBy uint, in engine.Enq
 	return run, nil
 }
 
+// enqueueMatrix creates the parent run (never executed; status rolls up from
+// children) and one queued child run per matrix cell.
+func (s *BuildRunService) enqueueMatrix(
+	parent *model.BuildRun,
+	snapshot map[string]interface{},
+	matrix *model.BuildMatrix,
+	cells []map[string]string,
+) (*model.BuildRun, error) {
+	now := time.Now()
+	parent.Status = "running"
+	parent.Stage = "building"
+	parent.MatrixSummary = "running"
+	parent.StartedAt = &now
+	parentSnap := make(map[string]interface{}, len(snapshot)+1)
+	for k, v := range snapshot {
+		parentSnap[k] = v
+	}
+	parentSnap["matrix"] = matrix
+	snapBytes, _ := json.Marshal(parentSnap)
+	parent.SnapshotJSON = string(snapBytes)
+	if err := s.runs.Create(parent); err != nil {
+		return nil, err
+	}
+	var childIDs []uint
+	for _, cell := range cells {
+		num, err := s.runs.NextBuildNumber(parent.BuildJobID)
+		if err != nil {
+			s.failMatrixParent(parent.ID, childIDs, err)
+			return nil, err
+		}
+		child := &model.BuildRun{
+			BuildJobID:          parent.BuildJobID,
+			BuildNumber:         num,
+			Status:              "queued",
+			Stage:               "pending",
+			TriggerType:         parent.TriggerType,
+			TriggeredBy:         parent.TriggeredBy,
+			Branch:              parent.Branch,
+			CommitHash:          parent.CommitHash,
+			CommitMessage:       parent.CommitMessage,
+			DistributionSummary: "none",
+			MatrixSummary:       "none",
+			ParamsCipher:        parent.ParamsCipher,
+			ParentRunID:         &parent.ID,
+			GitRef:              parent.GitRef,
+			PRNumber:            parent.PRNumber,
+			PRSourceBranch:      parent.PRSourceBranch,
+			PRTargetBranch:      parent.PRTargetBranch,
+			PRAuthor:            parent.PRAuthor,
+			PRHeadCommit:        parent.PRHeadCommit,
+			Tag:                 parent.Tag,
+			ReleaseVersion:      parent.ReleaseVersion,
+		}
+		childSnap := make(map[string]interface{}, len(snapshot)+2)
+		for k, v := range snapshot {
+			childSnap[k] = v
+		}
+		childSnap["parent_run_id"] = parent.ID
+		setMatrixCell(child, childSnap, cell)
+		snapBytes, _ := json.Marshal(childSnap)
+		child.SnapshotJSON = string(snapBytes)
+		if err := s.runs.Create(child); err != nil {
+			s.failMatrixParent(parent.ID, childIDs, err)
+			return nil, err
+		}
+		childIDs = append(childIDs, child.ID)
+	}
+	if s.scheduler != nil {
+		for _, id := range childIDs {
+			_ = s.scheduler.Submit(id)
+		}
+	}
+	return parent, nil
+}
+
+// jobTimeouts lists the job's configured limits (seconds) by scope for the run snapshot.
+func jobTimeouts(job *model.BuildJob) map[string]int {
+	out := map[string]int{}
+	for scope, v := range map[string]int{
+		engine.TimeoutJob:        job.TimeoutSeconds,
+		engine.TimeoutClone:      job.CloneTimeoutSeconds,
+		engine.TimeoutBuild:      job.BuildTimeoutSeconds,
+		engine.TimeoutDistribute: job.DistributeTimeoutSeconds,
+	} {
+		if v > 0 {
+			out[scope] = v
+		}
+	}
+	return out
+}
+
+func setMatrixCell(run *model.BuildRun, snapshot map[string]interface{}, cell map[string]string) {
+	raw, _ := json.Marshal(cell)
+	run.MatrixCellJSON = string(raw)
+	run.MatrixCell = cell
+	snapshot["matrix_cell"] = cell
+}
+
+// failMatrixParent aborts a half-created fan-out: children created so far were
+// never submitted, so they are cancelled alongside the failed parent.
+func (s *BuildRunService) failMatrixParent(parentID uint, childIDs []uint, cause error) {
+	now := time.Now()
+	for _, id := range childIDs {
+		_ = s.runs.UpdateFields(id, map[string]interface{}{
+			"status":      "cancelled",
+			"stage":       "idle",
+			"finished_at": now,
+		})
+	}
+	_ = s.runs.UpdateFields(parentID, map[string]interface{}{
+		"status":         "failed",
+		"stage":          "idle",
+		"matrix_summary": "all_failed",
+		"error_message":  "创建矩阵子构建失败: " + cause.Error(),
+		"finished_at":    now,
+	})
+}
+
+// refreshMatrixParent recomputes a matrix parent after a child changed outside the pipeline.
+func (s *BuildRunService) refreshMatrixParent(parentID uint) {
+	parent, err := s.runs.FindByID(parentID)
+	if err != nil {
+		return
+	}
+	children, err := s.runs.ListByParent(parentID)
+	if err != nil {
+		return
+	}
+	_ = s.runs.UpdateFields(parentID, engine.MatrixRollupFields(parent, children))
+}
+
 func (s *BuildRunService) Cancel(id uint) (*model.BuildRun, error) {
 	run, err := s.runs.FindByID(id)
 	if err != nil {
 		return nil, NewNotFound("构建执行不存在")
 	}
+	if engine.IsMatrixParent(run) {
+		return s.cancelMatrix(run)
+	}
 	switch run.Status {
 	case "queued":
 		now := time.Now()
@@ -132,12 +373,18 @@ func (s *BuildRunService) Cancel(id uint) (*model.BuildRun, error) {
 			"stage":       "idle",
 			"finished_at": now,
 		})
+		if run.ParentRunID != nil {
+			s.refreshMatrixParent(*run.ParentRunID)
+		}
 	case "running":
 		if s.scheduler != nil {
 			s.scheduler.Cancel(id)
 		}
 		// Pipeline cancelRun persists terminal state; also mark eagerly if still queued race.
 	case "success":
+		if run.Stage == engine.StageWaitingApproval && s.cancelApproval(run) {
+			break
+		}
 		// Cancel in-flight distribution only.
 		if s.scheduler != nil {
 			s.scheduler.Cancel(id)
@@ -154,29 +401,148 @@ func (s *BuildRunService) Cancel(id uint) (*model.BuildRun, error) {
 	return s.runs.FindByID(id)
 }
 
+// cancelApproval rejects the parked run's pending approval with RejectCancel
+// so it can no longer be approved, and records the gated targets as
+// cancelled. False when the approval was decided meanwhile.
+func (s *BuildRunService) cancelApproval(run *model.BuildRun) bool {
+	approval, err := s.runs.LatestApproval(run.ID)
+	if err != nil || approval.Status != engine.ApprovalPending {
+		return false
+	}
+	now := time.Now()
+	ok, err := s.runs.DecideApproval(approval.ID, map[string]interface{}{
+		"status":        engine.ApprovalRejected,
+		"reject_action": engine.RejectCancel,
+		"comment":       "构建已取消",
+		"decided_at":    &now,
+		"applied_at":    &now,
+	})
+	if err != nil || !ok {
+		return false
+	}
+	engine.DecodeApprovalTargets(approval)
+	for _, t := range approval.Targets {
+		snap, _ := json.Marshal(t)
+		id := t.ID
+		_ = s.runs.CreateAttempt(&model.BuildDeployAttempt{
+			BuildRunID:         run.ID,
+			BatchNo:            approval.BatchNo,
+			Wave:               1,
+			DeployTargetID:     &id,
+			TargetSnapshotJSON: string(snap),
+			Status:             "cancelled",
+			ErrorMessage:       "构建已取消",
+			StartedAt:          &now,
+			FinishedAt:         &now,
+		})
+	}
+	_ = s.runs.UpdateFields(run.ID, map[string]interface{}{
+		"stage":                "idle",
+		"distribution_summary": "cancelled",
+	})
+	return true
+}
+
+// CancelPullRequestRuns cancels the job's unfinished runs of pull request
+// number except keep (the run of the newer push that supersedes them) and
+// returns their ids.
+func (s *BuildRunService) CancelPullRequestRuns(jobID uint, number int, keep uint) []uint {
+	runs, err := s.runs.ListActiveByPullRequest(jobID, number)
+	if err != nil {
+		return nil
+	}
+	var ids []uint
+	for _, r := range runs {
+		if r.ID == keep {
+			continue
+		}
+		if _, err := s.Cancel(r.ID); err == nil {
+			ids = append(ids, r.ID)
+		}
+	}
+	return ids
+}
+
+// cancelMatrix cancels every unfinished child; the parent rolls up from them.
+func (s *BuildRunService) cancelMatrix(parent *model.BuildRun) (*model.BuildRun, error) {
+	if parent.Status != "running" {
+		return nil, NewConflict("当前状态不可取消: " + parent.Status)
+	}
+	children, err := s.runs.ListByParent(parent.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range children {
+		if c.Status == "queued" || c.Status == "running" {
+			_, _ = s.Cancel(c.ID)
+		}
+	}
+	s.refreshMatrixParent(parent.ID)
+	return s.runs.FindByID(parent.ID)
+}
+
+// Retry enqueues a new run on the same branch with the same parameter values
+// (parameters since removed from the job are dropped). Retrying a matrix child
+// reruns only its cell (as a standalone run); retrying a parent fans out again.
 func (s *BuildRunService) Retry(id, triggeredBy uint) (*model.BuildRun, error) {
 	prev, err := s.runs.FindByID(id)
 	if err != nil {
 		return nil, NewNotFound("构建执行不存在")
 	}
+	engine.DecodeMatrixCell(prev)
+	params, err := engine.DecryptRunParams(prev.ParamsCipher)
+	if err != nil {
+		return nil, err
+	}
+	if job, err := s.jobs.FindByID(prev.BuildJobID); err == nil {
+		engine.DecodeJobParameters(job)
+		defined := map[string]bool{}
+		for _, d := range job.Parameters {
+			defined[d.Name] = true
+		}
+		for k := range params {
+			if !defined[k] {
+				delete(params, k)
+			}
+		}
+	}
+	var pr *engine.PullRequestInfo
+	if prev.PRNumber > 0 {
+		// Rebuilds the PR ref as it is now; the head commit is resolved at checkout.
+		pr = &engine.PullRequestInfo{
+			Number:       prev.PRNumber,
+			SourceBranch: prev.PRSourceBranch,
+			TargetBranch: prev.PRTargetBranch,
+			Author:       prev.PRAuthor,
+		}
+	}
 	return s.EnqueueInternal(prev.BuildJobID, triggeredBy, engine.EnqueueParams{
 		Branch:        prev.Branch,
 		TriggerType:   "retry",
 		CommitHash:    "",
 		CommitMessage: "",
+		Params:        params,
+		MatrixCell:    prev.MatrixCell,
+		GitRef:        prev.GitRef,
+		PullRequest:   pr,
+		Tag:           prev.Tag,
 	})
 }
 
-func (s *BuildRunService) Redeploy(id uint, in RedeployInput) (*model.BuildRun, error) {
+func (s *BuildRunService) Redeploy(id, userID uint, username string, in RedeployInput) (*model.BuildRun, error) {
+	return s.redeploy(id, userID, username, in.TargetIDs, engine.DeploymentRedeploy)
+}
+
+// redeploy submits a successful run again to distribute its stored artifacts
+// to targetIDs (all when empty); kind and the user end up in the targets'
+// deployment history.
+func (s *BuildRunService) redeploy(id, userID uint, username string, targetIDs []uint, kind string) (*model.BuildRun, error) {
 	run, err := s.runs.FindByID(id)
 	if err != nil {
 		return nil, NewNotFound("构建执行不存在")
 	}
-	if run.Status != "success" {
-		return nil, NewConflict("仅成功的构建可重新分发")
-	}
-	if strings.TrimSpace(run.ArtifactPath) == "" {
-		return nil, NewConflict("无制品可分发")
+	if err := checkRedeployable(run); err != nil {
+		return nil, err
 	}
 	// Merge redeploy filter into snapshot (append attempts on same run).
 	var snap map[string]interface{}
@@ -186,11 +552,14 @@ func (s *BuildRunService) Redeploy(id uint, in RedeployInput) (*model.BuildRun,
 	if snap == nil {
 		snap = map[string]interface{}{}
 	}
-	if len(in.TargetIDs) > 0 {
-		snap["redeploy_target_ids"] = in.TargetIDs
+	if len(targetIDs) > 0 {
+		snap["redeploy_target_ids"] = targetIDs
 	} else {
 		delete(snap, "redeploy_target_ids")
 	}
+	snap["redeploy_kind"] = kind
+	snap["redeploy_by"] = userID
+	snap["redeploy_by_name"] = username
 	snapBytes, _ := json.Marshal(snap)
 	_ = s.runs.UpdateFields(id, map[string]interface{}{
 		"trigger_type":         "redeploy",
@@ -205,35 +574,466 @@ func (s *BuildRunService) Redeploy(id uint, in RedeployInput) (*model.BuildRun,
 	return s.runs.FindByID(id)
 }
 
+func checkRedeployable(run *model.BuildRun) error {
+	if run.Status != "success" {
+		return NewConflict("仅成功的构建可重新分发")
+	}
+	if run.Stage == engine.StageWaitingApproval {
+		return NewConflict("构建正在等待部署审批")
+	}
+	if strings.TrimSpace(run.ArtifactPath) == "" && strings.TrimSpace(run.ArtifactsJSON) == "" {
+		return NewConflict("无制品可分发")
+	}
+	return nil
+}
+
+// DecideApproval approves or rejects the run's pending deployment approval
+// and submits the run again to apply the decision.
+func (s *BuildRunService) DecideApproval(id, userID uint, username string, in ApprovalInput) (*model.BuildRun, error) {
+	run, err := s.runs.FindByID(id)
+	if err != nil {
+		return nil, NewNotFound("构建执行不存在")
+	}
+	approval, err := s.runs.LatestApproval(id)
+	if err != nil || approval.Status != engine.ApprovalPending || run.Stage != engine.StageWaitingApproval {
+		return nil, NewConflict("构建没有待审批的部署")
+	}
+	status := engine.ApprovalApproved
+	rejectAction := ""
+	if !in.Approve {
+		status = engine.ApprovalRejected
+		rejectAction = strings.TrimSpace(in.RejectAction)
+		if rejectAction == "" {
+			rejectAction = engine.RejectSkip
+		}
+		if rejectAction != engine.RejectSkip && rejectAction != engine.RejectCancel {
+			return nil, errorsNew("reject_action 仅支持 skip 或 cancel")
+		}
+	}
+	comment := strings.TrimSpace(in.Comment)
+	if len([]rune(comment)) > 500 {
+		return nil, errorsNew("审批意见不能超过 500 个字符")
+	}
+	now := time.Now()
+	ok, err := s.runs.DecideApproval(approval.ID, map[string]interface{}{
+		"status":          status,
+		"reject_action":   rejectAction,
+		"decided_by":      userID,
+		"decided_by_name": username,
+		"comment":         comment,
+		"decided_at":      &now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, NewConflict("该部署已被审批")
+	}
+	_ = s.runs.UpdateFields(id, map[string]interface{}{
+		"stage":                "distributing",
+		"distribution_summary": "running",
+	})
+	if s.scheduler != nil {
+		_ = s.scheduler.Submit(id)
+	}
+	return s.Get(id)
+}
+
 // ArtifactPath returns absolute path for download; empty if unavailable.
-func (s *BuildRunService) ArtifactPath(id uint) (path string, filename string, err error) {
+// name selects a named run artifact (e.g. a pipeline stage); empty = main artifact.
+func (s *BuildRunService) ArtifactPath(id uint, name string) (path string, filename string, err error) {
 	run, err := s.runs.FindByID(id)
 	if err != nil {
 		return "", "", NewNotFound("构建执行不存在")
 	}
+	if name = strings.TrimSpace(name); name != "" {
+		engine.DecodeRunArtifacts(run)
+		for _, a := range run.Artifacts {
+			if a.Name != name || (strings.TrimSpace(a.Path) == "" && a.ObjectID == 0) {
+				continue
+			}
+			if path, err = engine.ArtifactFile(s.store, a.ObjectID, a.Path); err != nil {
+				return "", "", NewNotFound("制品文件不存在")
+			}
+			if filename = a.FileName; filename == "" {
+				filename = filepath.Base(path)
+			}
+			return path, filename, nil
+		}
+		return "", "", NewNotFound("制品不存在")
+	}
 	if run.Status != "success" && run.ArtifactPath == "" {
 		return "", "", NewConflict("制品不可用")
 	}
-	path = strings.TrimSpace(run.ArtifactPath)
-	if path == "" {
+	if strings.TrimSpace(run.ArtifactPath) == "" && run.ArtifactObjectID == nil {
 		return "", "", NewNotFound("制品不存在")
 	}
-	if _, err := os.Stat(path); err != nil {
+	var objectID uint
+	if run.ArtifactObjectID != nil {
+		objectID = *run.ArtifactObjectID
+	}
+	if path, err = engine.ArtifactFile(s.store, objectID, run.ArtifactPath); err != nil {
 		return "", "", NewNotFound("制品文件不存在")
 	}
-	return path, filepath.Base(path), nil
+	if filename = run.ArtifactName; filename == "" {
+		filename = filepath.Base(path)
+	}
+	return path, filename, nil
 }
 
-// LogPath returns the build log file path if present.
-func (s *BuildRunService) LogPath(id uint) (string, error) {
+// SetArtifactPinned pins or unpins a run's artifacts; pinned runs are exempt
+// from the job's retention policy.
+func (s *BuildRunService) SetArtifactPinned(id uint, pinned bool) (*model.BuildRun, error) {
 	run, err := s.runs.FindByID(id)
 	if err != nil {
-		return "", NewNotFound("构建执行不存在")
+		return nil, NewNotFound("构建执行不存在")
+	}
+	if pinned && run.ArtifactRemovedAt != nil {
+		return nil, NewConflict("制品已被清理，无法固定")
+	}
+	if pinned && strings.TrimSpace(run.ArtifactPath) == "" && strings.TrimSpace(run.ArtifactsJSON) == "" {
+		return nil, NewConflict("该构建没有制品")
+	}
+	if err := s.runs.UpdateFields(id, map[string]interface{}{"artifact_pinned": pinned}); err != nil {
+		return nil, err
+	}
+	return s.runs.FindByID(id)
+}
+
+// ListReleases lists the job's releases (successful tag runs), newest first.
+func (s *BuildRunService) ListReleases(jobID uint, page, pageSize int) ([]model.BuildRun, int64, error) {
+	if _, err := s.jobs.FindByID(jobID); err != nil {
+		return nil, 0, NewNotFound("构建任务不存在")
+	}
+	items, total, err := s.runs.ListReleases(jobID, "", page, pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range items {
+		engine.DecodeRunArtifacts(&items[i])
+	}
+	return items, total, nil
+}
+
+// GetRelease returns the newest successful run of a release version; "v1.2.3"
+// and "1.2.3" name the same release.
+func (s *BuildRunService) GetRelease(jobID uint, version string) (*model.BuildRun, error) {
+	version = engine.ReleaseVersion(strings.TrimSpace(version))
+	if version == "" {
+		return nil, errorsNew("版本号不能为空")
+	}
+	items, _, err := s.runs.ListReleases(jobID, version, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, NewNotFound("发布版本不存在")
+	}
+	return s.Get(items[0].ID)
+}
+
+// TargetDeployment is one of a job's targets with the deployment it runs now
+// and the one a rollback would restore (nil when there is none).
+type TargetDeployment struct {
+	Target   model.DeployTarget            `json:"target"`
+	Current  *model.DeployTargetDeployment `json:"current"`
+	Previous *model.DeployTargetDeployment `json:"previous"`
+}
+
+// RollbackInput selects the target to roll back; 0 rolls back every target
+// of the job that has a previous deployment.
+type RollbackInput struct {
+	DeployTargetID uint `json:"deploy_target_id"`
+}
+
+// rollbackLookback bounds how far back a target's history is searched for a
+// deployment whose artifact is still kept.
+const rollbackLookback = 50
+
+// ListDeployments returns the current and rollback deployment of each of the
+// job's targets.
+func (s *BuildRunService) ListDeployments(jobID uint) ([]TargetDeployment, error) {
+	job, err := s.jobs.FindByID(jobID)
+	if err != nil {
+		return nil, NewNotFound("构建任务不存在")
+	}
+	items := make([]TargetDeployment, 0, len(job.DeployTargets))
+	for _, t := range job.DeployTargets {
+		item := TargetDeployment{Target: t}
+		if item.Current, item.Previous, err = s.targetDeployments(t.ID); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// ListDeploymentHistory pages the job's deployments, newest first; targetID
+// limits it to one target.
+func (s *BuildRunService) ListDeploymentHistory(jobID uint, targetID *uint, page, pageSize int) ([]model.DeployTargetDeployment, int64, error) {
+	if _, err := s.jobs.FindByID(jobID); err != nil {
+		return nil, 0, NewNotFound("构建任务不存在")
+	}
+	return s.runs.ListDeploymentHistory(jobID, targetID, page, pageSize)
+}
+
+// Rollback redeploys the previous deployment's artifact to one target or to
+// every target of the job. Targets are grouped by the run that built their
+// previous artifact, and each run is redeployed to its targets; the result is
+// recorded as a rollback in the targets' history.
+func (s *BuildRunService) Rollback(jobID, userID uint, username string, in RollbackInput) ([]model.BuildRun, error) {
+	job, err := s.jobs.FindByID(jobID)
+	if err != nil {
+		return nil, NewNotFound("构建任务不存在")
+	}
+	targets := job.DeployTargets
+	if in.DeployTargetID != 0 {
+		i := slices.IndexFunc(targets, func(t model.DeployTarget) bool { return t.ID == in.DeployTargetID })
+		if i < 0 {
+			return nil, NewNotFound("部署目标不存在")
+		}
+		targets = targets[i : i+1]
+	}
+	byRun := map[uint][]uint{}
+	var runIDs []uint
+	for _, t := range targets {
+		_, prev, err := s.targetDeployments(t.ID)
+		if err != nil {
+			return nil, err
+		}
+		if prev == nil {
+			continue
+		}
+		if byRun[prev.BuildRunID] == nil {
+			runIDs = append(runIDs, prev.BuildRunID)
+		}
+		byRun[prev.BuildRunID] = append(byRun[prev.BuildRunID], t.ID)
+	}
+	if len(runIDs) == 0 {
+		return nil, NewConflict("没有可回滚的上一次部署")
+	}
+	// Check every run first so a rollback is not submitted halfway.
+	for _, id := range runIDs {
+		run, err := s.runs.FindByID(id)
+		if err != nil {
+			return nil, NewNotFound("构建执行不存在")
+		}
+		if err := checkRedeployable(run); err != nil {
+			return nil, err
+		}
+		if run.DistributionSummary == "running" {
+			return nil, NewConflict("构建 #" + strconv.Itoa(run.BuildNumber) + " 正在分发")
+		}
+	}
+	out := make([]model.BuildRun, 0, len(runIDs))
+	for _, id := range runIDs {
+		run, err := s.redeploy(id, userID, username, byRun[id], engine.DeploymentRollback)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *run)
+	}
+	return out, nil
+}
+
+// targetDeployments returns the target's current deployment and the newest
+// earlier one of another artifact whose run still keeps it.
+func (s *BuildRunService) targetDeployments(targetID uint) (current, previous *model.DeployTargetDeployment, err error) {
+	history, err := s.runs.ListTargetDeployments(targetID, rollbackLookback)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range history {
+		d := &history[i]
+		if d.Current {
+			current = d
+			continue
+		}
+		if current == nil || d.BuildRunID == current.BuildRunID ||
+			(d.ArtifactSHA256 != "" && d.ArtifactSHA256 == current.ArtifactSHA256) {
+			continue
+		}
+		run, err := s.runs.FindByID(d.BuildRunID)
+		if err != nil || checkRedeployable(run) != nil {
+			continue
+		}
+		return current, d, nil
+	}
+	return current, nil, nil
+}
+
+// PreviewArtifactRetention reports which runs the job's retention policy
+// would remove now, without deleting anything.
+func (s *BuildRunService) PreviewArtifactRetention(jobID uint) (*engine.RetentionPlan, error) {
+	job, err := s.jobs.FindByID(jobID)
+	if err != nil {
+		return nil, NewNotFound("构建任务不存在")
+	}
+	return engine.ApplyArtifactRetention(s.runs, s.store, job, time.Now(), true)
+}
+
+// ReadLog returns a line-aligned byte range of the stored (NDJSON) log;
+// tail > 0 returns the last tail bytes instead of starting at offset.
+func (s *BuildRunService) ReadLog(id uint, offset, limit, tail int64) (*engine.LogRange, error) {
+	run, err := s.logRun(id)
+	if err != nil {
+		return nil, err
+	}
+	out, err := engine.ReadLogRange(run.LogPath, offset, limit, tail)
+	return out, logReadError(err)
+}
+
+// LogLines returns numbered lines from line from, optionally one stage only.
+func (s *BuildRunService) LogLines(id uint, from, limit int, stage string) (*engine.LogPage, error) {
+	run, err := s.logRun(id)
+	if err != nil {
+		return nil, err
+	}
+	out, err := engine.ReadLogLines(run.LogPath, logStages(run), from, limit, strings.TrimSpace(stage))
+	return out, logReadError(err)
+}
+
+// SearchLog returns lines whose text matches the regular expression pattern.
+func (s *BuildRunService) SearchLog(id uint, pattern, stage string, limit int) (*engine.LogPage, error) {
+	if strings.TrimSpace(pattern) == "" {
+		return nil, errorsNew("搜索表达式不能为空")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errorsNew("搜索表达式无效: " + err.Error())
+	}
+	run, err := s.logRun(id)
+	if err != nil {
+		return nil, err
+	}
+	out, err := engine.SearchLog(run.LogPath, logStages(run), re, strings.TrimSpace(stage), limit)
+	return out, logReadError(err)
+}
+
+func (s *BuildRunService) logRun(id uint) (*model.BuildRun, error) {
+	run, err := s.runs.FindByID(id)
+	if err != nil {
+		return nil, NewNotFound("构建执行不存在")
 	}
 	if strings.TrimSpace(run.LogPath) == "" {
-		return "", NewNotFound("日志不存在")
+		return nil, NewNotFound("日志不存在")
+	}
+	return run, nil
+}
+
+// logStages prefers the recorded index and rebuilds it for older logs.
+func logStages(run *model.BuildRun) []model.LogStage {
+	engine.DecodeLogStages(run)
+	if len(run.LogStages) > 0 {
+		return run.LogStages
+	}
+	stages, _ := engine.ScanLogStages(run.LogPath)
+	return stages
+}
+
+func logReadError(err error) error {
+	if errors.Is(err, engine.ErrLogNotFound) {
+		return NewNotFound("日志文件不存在")
+	}
+	return err
+}
+
+// TestResults is the per-run test report view.
+type TestResults struct {
+	Summary *model.TestSummary     `json:"summary"`
+	Suites  []model.BuildTestSuite `json:"suites"`
+}
+
+// TestComparison lists cases whose outcome changed against a base run.
+type TestComparison struct {
+	BaseRunID       uint                  `json:"base_run_id"`
+	BaseBuildNumber int                   `json:"base_build_number"`
+	Summary         *model.TestSummary    `json:"summary"`
+	BaseSummary     *model.TestSummary    `json:"base_summary"`
+	NewFailures     []model.BuildTestCase `json:"new_failures"`
+	Fixed           []model.BuildTestCase `json:"fixed"`
+	StillFailing    []model.BuildTestCase `json:"still_failing"`
+}
+
+// TestResults returns the run's suites and cases; status filters cases
+// (passed|failed|error|skipped).
+func (s *BuildRunService) TestResults(id uint, status string) (*TestResults, error) {
+	run, err := s.runs.FindByID(id)
+	if err != nil {
+		return nil, NewNotFound("构建执行不存在")
+	}
+	status = strings.TrimSpace(status)
+	switch status {
+	case "", model.TestPassed, model.TestFailed, model.TestError, model.TestSkipped:
+	default:
+		return nil, errorsNew("status 仅支持 passed/failed/error/skipped")
+	}
+	suites, err := s.runs.ListTestSuites(run.ID, status)
+	if err != nil {
+		return nil, err
+	}
+	engine.DecodeTestSummary(run)
+	return &TestResults{Summary: run.TestSummary, Suites: suites}, nil
+}
+
+// CompareTests diffs the run's cases against baseID, or by default the latest
+// earlier run of the same job that has test results. Cases are matched by
+// suite, class and name; "failing" means failed or error.
+func (s *BuildRunService) CompareTests(id, baseID uint) (*TestComparison, error) {
+	run, err := s.runs.FindByID(id)
+	if err != nil {
+		return nil, NewNotFound("构建执行不存在")
+	}
+	var base *model.BuildRun
+	if baseID > 0 {
+		if base, err = s.runs.FindByID(baseID); err != nil {
+			return nil, NewNotFound("对比的构建执行不存在")
+		}
+		if base.BuildJobID != run.BuildJobID {
+			return nil, errorsNew("只能与同一构建任务的执行对比")
+		}
+	} else if base, err = s.runs.FindPreviousWithTests(run.BuildJobID, run.BuildNumber); err != nil {
+		return nil, NewNotFound("没有可对比的历史测试结果")
 	}
-	return run.LogPath, nil
+	cur, err := s.runs.ListTestCases(run.ID)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := s.runs.ListTestCases(base.ID)
+	if err != nil {
+		return nil, err
+	}
+	engine.DecodeTestSummary(run)
+	engine.DecodeTestSummary(base)
+	out := &TestComparison{
+		BaseRunID:       base.ID,
+		BaseBuildNumber: base.BuildNumber,
+		Summary:         run.TestSummary,
+		BaseSummary:     base.TestSummary,
+		NewFailures:     []model.BuildTestCase{},
+		Fixed:           []model.BuildTestCase{},
+		StillFailing:    []model.BuildTestCase{},
+	}
+	prevFailing := map[string]bool{}
+	for i := range prev {
+		prevFailing[prev[i].Key()] = isFailingCase(prev[i].Status)
+	}
+	for _, c := range cur {
+		wasFailing := prevFailing[c.Key()]
+		switch {
+		case isFailingCase(c.Status) && wasFailing:
+			out.StillFailing = append(out.StillFailing, c)
+		case isFailingCase(c.Status):
+			out.NewFailures = append(out.NewFailures, c)
+		case c.Status == model.TestPassed && wasFailing:
+			out.Fixed = append(out.Fixed, c)
+		}
+	}
+	return out, nil
+}
+
+func isFailingCase(status string) bool {
+	return status == model.TestFailed || status == model.TestError
 }
 
 // Ensure Compile-time interface satisfaction.
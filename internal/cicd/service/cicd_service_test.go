@@ -5,11 +5,15 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"bedrock/internal/cicd/model"
 	"bedrock/internal/cicd/repository"
 	"bedrock/internal/cicd/service"
+	"bedrock/internal/engine"
 	"bedrock/internal/pkg"
 	"bedrock/internal/platform/config"
 	"bedrock/internal/platform/db"
@@ -26,7 +30,7 @@ type stubGit struct {
 	err      error
 }
 
-func (s stubGit) ListBranches(repoURL, authType, username, password string) ([]string, error) {
+func (s stubGit) ListBranches(repoURL string, auth engine.GitAuth) ([]string, error) {
 	if s.err != nil {
 		return nil, s.err
 	}
@@ -74,6 +78,7 @@ func setupCICD(t *testing.T) (
 	repoSvc.SetGitLister(stubGit{branches: []string{"main", "develop"}})
 	serverSvc := resourceservice.NewServerService(serverRepo, credSvc)
 	jobSvc := service.NewBuildJobService(jobRepo, repoRepo)
+	jobSvc.SetCredentials(credRepo)
 	runSvc := service.NewBuildRunService(runRepo, jobRepo)
 	return credSvc, repoSvc, serverSvc, jobSvc, runSvc, gdb
 }
@@ -171,7 +176,7 @@ func TestRepository_CRUD_and_deleteProtection(t *testing.T) {
 		RepositoryID: repo.ID,
 		Name:         "job-a",
 		BuildScript:  "echo hi",
-	})
+	}, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -227,7 +232,7 @@ func TestServer_CRUD_and_deleteProtection(t *testing.T) {
 		DeployTargets: []service.DeployTargetInput{
 			{ServerID: &sid, RemotePath: "/var/www", Method: "rsync", SortOrder: 0},
 		},
-	})
+	}, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -270,7 +275,7 @@ func TestBuildJob_and_BuildRun_enqueue(t *testing.T) {
 		DeployTargets: []service.DeployTargetInput{
 			{Method: "local", RemotePath: "/tmp/out", SortOrder: 1},
 		},
-	})
+	}, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -310,13 +315,13 @@ func TestBuildJob_TwoJobsSameRepo_EachEnqueue(t *testing.T) {
 	}
 	jobA, err := jobSvc.Create(1, service.CreateBuildJobInput{
 		RepositoryID: repo.ID, Name: "job-a", BuildScript: "echo a",
-	})
+	}, false)
 	if err != nil {
 		t.Fatal(err)
 	}
 	jobB, err := jobSvc.Create(1, service.CreateBuildJobInput{
 		RepositoryID: repo.ID, Name: "job-b", BuildScript: "echo b",
-	})
+	}, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -350,7 +355,7 @@ func TestBuildRun_RetryAfterInterrupted(t *testing.T) {
 	}
 	job, err := jobSvc.Create(1, service.CreateBuildJobInput{
 		RepositoryID: repo.ID, Name: "retry-job", BuildScript: "echo",
-	})
+	}, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -393,7 +398,7 @@ func TestBuildRun_ArtifactPathDownloadable(t *testing.T) {
 	}
 	job, err := jobSvc.Create(1, service.CreateBuildJobInput{
 		RepositoryID: repo.ID, Name: "art-job", BuildScript: "echo", ArtifactFormat: "gzip",
-	})
+	}, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -415,7 +420,7 @@ func TestBuildRun_ArtifactPathDownloadable(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	path, filename, err := runSvc.ArtifactPath(run.ID)
+	path, filename, err := runSvc.ArtifactPath(run.ID, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -428,5 +433,771 @@ func TestBuildRun_ArtifactPathDownloadable(t *testing.T) {
 	}
 }
 
+func TestBuildRun_MatrixFanOut(t *testing.T) {
+	_, repoSvc, _, jobSvc, runSvc, _ := setupCICD(t)
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "r-matrix", RepoURL: "https://example.com/matrix.git",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "bad-matrix", BuildScript: "make",
+		Matrix: &model.BuildMatrix{Axes: []model.MatrixAxis{{Name: "GO OS", Values: []string{"linux"}}}},
+	}, false); err == nil {
+		t.Fatal("expected invalid axis name to be rejected")
+	}
+	job, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "cross", BuildScript: "go build",
+		Matrix: &model.BuildMatrix{
+			Axes: []model.MatrixAxis{
+				{Name: "GOOS", Values: []string{"linux", "darwin"}},
+				{Name: "GOARCH", Values: []string{"amd64", "arm64"}},
+			},
+			Exclude: []map[string]string{{"GOOS": "darwin", "GOARCH": "amd64"}},
+		},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Matrix == nil || len(job.Matrix.Axes) != 2 {
+		t.Fatalf("matrix=%+v", job.Matrix)
+	}
+
+	parent, err := runSvc.Enqueue(job.ID, 1, service.EnqueueRunInput{TriggerType: "manual"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent.Status != "running" || parent.MatrixSummary != "running" {
+		t.Fatalf("parent=%+v", parent)
+	}
+	got, err := runSvc.Get(parent.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Children) != 3 {
+		t.Fatalf("children=%d want 3", len(got.Children))
+	}
+	for _, c := range got.Children {
+		if c.ParentRunID == nil || *c.ParentRunID != parent.ID || c.Status != "queued" {
+			t.Fatalf("child=%+v", c)
+		}
+		if c.MatrixCell["GOOS"] == "darwin" && c.MatrixCell["GOARCH"] == "amd64" {
+			t.Fatal("excluded cell was enqueued")
+		}
+	}
+
+	retried, err := runSvc.Retry(got.Children[0].ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried.ParentRunID != nil || engine.MatrixCellKey(retried.MatrixCell) != engine.MatrixCellKey(got.Children[0].MatrixCell) {
+		t.Fatalf("retry of child should rerun its cell standalone: %+v", retried)
+	}
+
+	cancelled, err := runSvc.Cancel(parent.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled.Status != "cancelled" || cancelled.MatrixSummary != "cancelled" {
+		t.Fatalf("parent after cancel status=%s summary=%s", cancelled.Status, cancelled.MatrixSummary)
+	}
+}
+
+func TestBuildRun_ParametersMaskedAndRetried(t *testing.T) {
+	_, repoSvc, _, jobSvc, runSvc, _ := setupCICD(t)
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "r-params", RepoURL: "https://example.com/params.git",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "deploy", BuildScript: "make deploy",
+		Parameters: []model.BuildParameter{
+			{Name: "TARGET", Type: "choice", Choices: []string{"staging", "prod"}},
+			{Name: "API_TOKEN", Type: "secret", Required: true},
+		},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(job.Parameters) != 2 || job.Parameters[0].Default != "staging" {
+		t.Fatalf("parameters=%+v", job.Parameters)
+	}
+	if _, err := runSvc.Enqueue(job.ID, 1, service.EnqueueRunInput{TriggerType: "manual"}); err == nil {
+		t.Fatal("expected missing required secret to be rejected")
+	}
+	if _, err := runSvc.Enqueue(job.ID, 1, service.EnqueueRunInput{
+		Params: map[string]string{"API_TOKEN": "t", "TARGET": "dev"},
+	}); err == nil {
+		t.Fatal("expected invalid choice to be rejected")
+	}
+
+	run, err := runSvc.Enqueue(job.ID, 1, service.EnqueueRunInput{
+		TriggerType: "manual",
+		Params:      map[string]string{"TARGET": "prod", "API_TOKEN": "s3cr3t-token"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(run.SnapshotJSON, "s3cr3t-token") || strings.Contains(run.ParamsCipher, "s3cr3t-token") {
+		t.Fatal("secret parameter stored in plain text")
+	}
+	var snap struct {
+		Params map[string]string `json:"params"`
+	}
+	if err := json.Unmarshal([]byte(run.SnapshotJSON), &snap); err != nil {
+		t.Fatal(err)
+	}
+	if snap.Params["TARGET"] != "prod" || snap.Params["API_TOKEN"] != "***" {
+		t.Fatalf("snapshot params=%v", snap.Params)
+	}
+
+	retried, err := runSvc.Retry(run.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := engine.DecryptRunParams(retried.ParamsCipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["TARGET"] != "prod" || values["API_TOKEN"] != "s3cr3t-token" {
+		t.Fatalf("retry params=%v", values)
+	}
+}
+
+func TestBuildJob_CredentialEnvsRequireUsePermission(t *testing.T) {
+	credSvc, repoSvc, _, jobSvc, runSvc, _ := setupCICD(t)
+	cred, err := credSvc.Create(1, resourceservice.CreateCredentialInput{
+		Name: "deploy-token", Type: "token", Secret: "tok-plain",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "r-cred-env", RepoURL: "https://example.com/cred-env.git",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "publish", BuildScript: "make publish",
+		CredentialEnvs: []service.CredentialEnvInput{{Name: "NPM_TOKEN", CredentialID: cred.ID}},
+	}
+	if _, err := jobSvc.Create(1, in, false); err == nil || !service.IsForbidden(err) {
+		t.Fatalf("expected forbidden without credentials:use, got %v", err)
+	}
+	bad := in
+	bad.CredentialEnvs = []service.CredentialEnvInput{{Name: "NPM-TOKEN", CredentialID: cred.ID}}
+	if _, err := jobSvc.Create(1, bad, true); err == nil {
+		t.Fatal("expected invalid env name to be rejected")
+	}
+	job, err := jobSvc.Create(1, in, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(job.CredentialEnvs) != 1 || job.CredentialEnvs[0].Field != "secret" {
+		t.Fatalf("credential_envs=%+v", job.CredentialEnvs)
+	}
+
+	// Keeping an existing binding is allowed without credentials:use; adding one is not.
+	keep := []service.CredentialEnvInput{{Name: "NPM_TOKEN", CredentialID: cred.ID, Field: "secret"}}
+	if _, err := jobSvc.Update(job.ID, service.UpdateBuildJobInput{Description: strPtr("d"), CredentialEnvs: &keep}, false); err != nil {
+		t.Fatal(err)
+	}
+	more := append(keep, service.CredentialEnvInput{Name: "NPM_USER", CredentialID: cred.ID, Field: "username"})
+	if _, err := jobSvc.Update(job.ID, service.UpdateBuildJobInput{CredentialEnvs: &more}, false); err == nil || !service.IsForbidden(err) {
+		t.Fatalf("expected forbidden adding binding, got %v", err)
+	}
+
+	run, err := runSvc.Enqueue(job.ID, 1, service.EnqueueRunInput{TriggerType: "manual"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(run.SnapshotJSON, "tok-plain") || !strings.Contains(run.SnapshotJSON, "NPM_TOKEN") {
+		t.Fatalf("snapshot=%s", run.SnapshotJSON)
+	}
+	if err := credSvc.Delete(cred.ID); err == nil || !resourceservice.IsConflict(err) {
+		t.Fatalf("expected conflict deleting bound credential, got %v", err)
+	}
+}
+
 func strPtr(s string) *string { return &s }
 func boolPtr(b bool) *bool    { return &b }
+
+func TestBuildJob_TimeoutsValidatedAndSnapshotted(t *testing.T) {
+	_, repoSvc, _, jobSvc, runSvc, _ := setupCICD(t)
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "rt", RepoURL: "https://example.com/t.git",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "neg", BuildScript: "make", BuildTimeoutSeconds: -1,
+	}, false); err == nil {
+		t.Fatal("negative timeout accepted")
+	}
+	job, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "t", BuildScript: "make", TimeoutSeconds: 3600, CloneTimeoutSeconds: 120,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zero := 0
+	job, err = jobSvc.Update(job.ID, service.UpdateBuildJobInput{CloneTimeoutSeconds: &zero}, false)
+	if err != nil || job.TimeoutSeconds != 3600 || job.CloneTimeoutSeconds != 0 {
+		t.Fatalf("job=%+v err=%v", job, err)
+	}
+	run, err := runSvc.Enqueue(job.ID, 1, service.EnqueueRunInput{TriggerType: "manual"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(run.SnapshotJSON, `"timeouts":{"job":3600}`) {
+		t.Fatalf("snapshot=%s", run.SnapshotJSON)
+	}
+}
+
+func TestBuildRun_CompareTestsAgainstPreviousRun(t *testing.T) {
+	_, repoSvc, _, jobSvc, runSvc, gdb := setupCICD(t)
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "rt", RepoURL: "https://example.com/t.git",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "bad", BuildScript: "make", TestReportPaths: "../out/*.xml",
+	}, false); err == nil {
+		t.Fatal("report path outside workspace accepted")
+	}
+	job, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "t", BuildScript: "make", TestReportPaths: "**/TEST-*.xml",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := repository.NewBuildRunRepository(gdb)
+	ingest := func(xml string) *model.BuildRun {
+		t.Helper()
+		run, err := runSvc.Enqueue(job.ID, 1, service.EnqueueRunInput{TriggerType: "manual"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		suites, err := engine.ParseTestReport([]byte(xml))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := runs.ReplaceTestResults(run.ID, suites); err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := json.Marshal(engine.SummarizeTests(suites))
+		if err := runs.UpdateFields(run.ID, map[string]interface{}{"status": "failed", "test_summary_json": string(raw)}); err != nil {
+			t.Fatal(err)
+		}
+		return run
+	}
+	first := ingest(`<testsuite name="s"><testcase classname="c" name="a"/><testcase classname="c" name="b"><failure message="boom"/></testcase><testcase classname="c" name="d"><error/></testcase></testsuite>`)
+	second := ingest(`<testsuite name="s"><testcase classname="c" name="a"><failure message="x"/></testcase><testcase classname="c" name="b"/><testcase classname="c" name="d"><failure/></testcase><testcase classname="c" name="e"><skipped/></testcase></testsuite>`)
+
+	cmp, err := runSvc.CompareTests(second.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmp.BaseRunID != first.ID || len(cmp.NewFailures) != 1 || cmp.NewFailures[0].Name != "a" ||
+		len(cmp.Fixed) != 1 || cmp.Fixed[0].Name != "b" ||
+		len(cmp.StillFailing) != 1 || cmp.StillFailing[0].Name != "d" {
+		t.Fatalf("compare=%+v", cmp)
+	}
+	if cmp.Summary == nil || cmp.Summary.Total != 4 || cmp.Summary.Skipped != 1 || cmp.BaseSummary.Errors != 1 {
+		t.Fatalf("summary=%+v base=%+v", cmp.Summary, cmp.BaseSummary)
+	}
+	if _, err := runSvc.CompareTests(first.ID, 0); err == nil {
+		t.Fatal("first run has nothing to compare against")
+	}
+
+	res, err := runSvc.TestResults(second.ID, model.TestFailed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Suites) != 1 || len(res.Suites[0].Cases) != 2 || res.Suites[0].Tests != 4 {
+		t.Fatalf("results=%+v", res)
+	}
+	got, err := runSvc.Get(second.ID)
+	if err != nil || got.TestSummary == nil || got.TestSummary.Failed != 2 {
+		t.Fatalf("run=%+v err=%v", got, err)
+	}
+}
+
+func TestBuildJob_CoverageSettingsValidated(t *testing.T) {
+	_, repoSvc, _, jobSvc, _, _ := setupCICD(t)
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "rc", RepoURL: "https://example.com/c.git",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "hi", BuildScript: "make", CoverageThreshold: 101,
+	}, false); err == nil {
+		t.Fatal("threshold above 100 accepted")
+	}
+	job, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "c", BuildScript: "make",
+		CoverageReportPaths: "coverage/lcov.info\n**/cover.out", CoverageThreshold: 75.5,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := "/etc/cover.out"
+	if _, err := jobSvc.Update(job.ID, service.UpdateBuildJobInput{CoverageReportPaths: &bad}, false); err == nil {
+		t.Fatal("absolute coverage path accepted")
+	}
+	got, err := jobSvc.Get(job.ID)
+	if err != nil || got.CoverageThreshold != 75.5 || got.CoverageReportPaths != "coverage/lcov.info\n**/cover.out" {
+		t.Fatalf("job=%+v err=%v", got, err)
+	}
+}
+
+func TestBuildRun_PinExemptsFromRetentionPreview(t *testing.T) {
+	_, repoSvc, _, jobSvc, runSvc, gdb := setupCICD(t)
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "rp", RepoURL: "https://example.com/p.git",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "neg", BuildScript: "make", ArtifactMaxAgeDays: -1,
+	}, false); err == nil {
+		t.Fatal("negative artifact_max_age_days accepted")
+	}
+	job, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "p", BuildScript: "make", MaxArtifacts: 1,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint
+	for i := 0; i < 2; i++ {
+		run, err := runSvc.Enqueue(job.ID, 1, service.EnqueueRunInput{TriggerType: "manual"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := runSvc.SetArtifactPinned(run.ID, true); err == nil || !service.IsConflict(err) {
+			t.Fatalf("pin without artifact: %v", err)
+		}
+		path := filepath.Join(t.TempDir(), "artifact.tar.gz")
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := gdb.Model(run).Updates(map[string]interface{}{"status": "success", "artifact_path": path}).Error; err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, run.ID)
+	}
+
+	plan, err := runSvc.PreviewArtifactRetention(job.ID)
+	if err != nil || !plan.DryRun || len(plan.Remove) != 1 || plan.Remove[0].RunID != ids[0] {
+		t.Fatalf("plan=%+v err=%v", plan, err)
+	}
+	pinned, err := runSvc.SetArtifactPinned(ids[0], true)
+	if err != nil || !pinned.ArtifactPinned {
+		t.Fatalf("pinned=%+v err=%v", pinned, err)
+	}
+	if plan, err = runSvc.PreviewArtifactRetention(job.ID); err != nil || len(plan.Remove) != 0 || plan.Protected != 1 {
+		t.Fatalf("plan=%+v err=%v", plan, err)
+	}
+	if got, err := runSvc.SetArtifactPinned(ids[0], false); err != nil || got.ArtifactPinned {
+		t.Fatalf("unpin=%+v err=%v", got, err)
+	}
+}
+
+func TestBuildJob_ArtifactDefsAndTargetArtifactName(t *testing.T) {
+	_, repoSvc, _, jobSvc, _, _ := setupCICD(t)
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "ra", RepoURL: "https://example.com/a.git",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "dup", BuildScript: "make",
+		ArtifactDefs: []model.ArtifactDef{{Name: "web", Path: "a"}, {Name: "web", Path: "b"}},
+	}, false); err == nil {
+		t.Fatal("duplicate artifact name accepted")
+	}
+	if _, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "bad-target", BuildScript: "make",
+		DeployTargets: []service.DeployTargetInput{{Method: "local", RemotePath: "/srv", ArtifactName: "../x"}},
+	}, false); err == nil {
+		t.Fatal("invalid target artifact_name accepted")
+	}
+	job, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "a", BuildScript: "make",
+		ArtifactDefs:  []model.ArtifactDef{{Name: "web", Path: " web/dist ", Format: "ZIP"}},
+		DeployTargets: []service.DeployTargetInput{{Method: "local", RemotePath: "/srv/web", ArtifactName: "web"}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := jobSvc.Get(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.ArtifactDefs) != 1 || got.ArtifactDefs[0].Path != "web/dist" || got.ArtifactDefs[0].Format != "zip" {
+		t.Fatalf("artifact_defs=%+v", got.ArtifactDefs)
+	}
+	if len(got.DeployTargets) != 1 || got.DeployTargets[0].ArtifactName != "web" {
+		t.Fatalf("deploy_targets=%+v", got.DeployTargets)
+	}
+	empty := []model.ArtifactDef{}
+	if _, err := jobSvc.Update(job.ID, service.UpdateBuildJobInput{ArtifactDefs: &empty}, false); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = jobSvc.Get(job.ID); len(got.ArtifactDefs) != 0 {
+		t.Fatalf("artifact_defs after clear=%+v", got.ArtifactDefs)
+	}
+}
+
+func TestBuildJob_CacheSettingsValidated(t *testing.T) {
+	_, repoSvc, _, jobSvc, _, _ := setupCICD(t)
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "rk", RepoURL: "https://example.com/k.git",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range []service.CreateBuildJobInput{
+		{CachePaths: "../outside"},
+		{CachePaths: "node_modules", CacheKeyFiles: "/etc/passwd"},
+		{CachePaths: "node_modules", CacheKey: "npm cache"},
+		{CachePaths: "node_modules", CacheRestoreKeys: "npm-\n-bad"},
+	} {
+		in.RepositoryID, in.Name, in.BuildScript = repo.ID, "bad", "make"
+		if _, err := jobSvc.Create(1, in, false); err == nil {
+			t.Fatalf("accepted %+v", in)
+		}
+	}
+	job, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "k", BuildScript: "npm ci",
+		CachePaths: "node_modules", CacheKey: "npm", CacheKeyFiles: "package-lock.json",
+		CacheRestoreKeys: "npm-", CacheShared: true,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	off := false
+	got, err := jobSvc.Update(job.ID, service.UpdateBuildJobInput{CacheShared: &off}, false)
+	if err != nil || got.CacheShared || got.CacheKey != "npm" || got.CacheKeyFiles != "package-lock.json" {
+		t.Fatalf("job=%+v err=%v", got, err)
+	}
+}
+
+func TestBuildJob_GitCheckoutOptionsValidated(t *testing.T) {
+	_, repoSvc, _, jobSvc, _, _ := setupCICD(t)
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "rg", RepoURL: "https://example.com/g.git",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range []service.CreateBuildJobInput{
+		{GitRef: "refs/heads/../main"},
+		{GitRef: "-upload-pack=x"},
+		{SparseCheckoutPaths: "services/api\n/etc"},
+		{SparseCheckoutPaths: "services/*"},
+	} {
+		in.RepositoryID, in.Name, in.BuildScript = repo.ID, "bad", "make"
+		if _, err := jobSvc.Create(1, in, false); err == nil {
+			t.Fatalf("accepted %+v", in)
+		}
+	}
+	job, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "g", BuildScript: "make",
+		GitRef: "refs/tags/v1.0.0", GitSubmodules: true, SparseCheckoutPaths: "services/api\nlibs",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, lfs := "", true
+	got, err := jobSvc.Update(job.ID, service.UpdateBuildJobInput{GitRef: &ref, GitLFS: &lfs}, false)
+	if err != nil || got.GitRef != "" || !got.GitLFS || !got.GitSubmodules || got.SparseCheckoutPaths != "services/api\nlibs" {
+		t.Fatalf("job=%+v err=%v", got, err)
+	}
+}
+
+func TestBuildRun_DecideDeployApproval(t *testing.T) {
+	_, repoSvc, _, jobSvc, runSvc, gdb := setupCICD(t)
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "r-approval", RepoURL: "https://example.com/approval.git",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "approval-job", BuildScript: "make", OutputDir: "dist",
+		ApprovalEnvironments: "prod", DeployApproverIDs: []uint{3, 0, 3},
+		DeployTargets: []service.DeployTargetInput{{Method: "local", RemotePath: "/srv/app", Environment: " prod ", RequiresApproval: true}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(job.DeployApproverIDs) != 1 || job.DeployApproverIDs[0] != 3 {
+		t.Fatalf("approvers=%v", job.DeployApproverIDs)
+	}
+	if got := job.DeployTargets; len(got) != 1 || got[0].Environment != "prod" || !got[0].RequiresApproval {
+		t.Fatalf("deploy_targets=%+v", got)
+	}
+	// Automatically triggered runs have nobody to notify without approvers.
+	if _, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "approval-webhook", BuildScript: "make", TriggerWebhook: boolPtr(true),
+		DeployTargets: []service.DeployTargetInput{{Method: "local", RemotePath: "/srv/app", RequiresApproval: true}},
+	}, false); err == nil || !strings.Contains(err.Error(), "审批人") {
+		t.Fatalf("webhook job with gated targets and no approvers: err=%v", err)
+	}
+	none := []uint{}
+	if _, err := jobSvc.Update(job.ID, service.UpdateBuildJobInput{DeployApproverIDs: &none, TriggerCron: boolPtr(true), CronExpression: strPtr("0 * * * *")}, false); err == nil || !strings.Contains(err.Error(), "审批人") {
+		t.Fatalf("cron job with gated targets and no approvers: err=%v", err)
+	}
+	run, err := runSvc.Enqueue(job.ID, 1, service.EnqueueRunInput{TriggerType: "manual", Branch: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runSvc.DecideApproval(run.ID, 3, "ops", service.ApprovalInput{Approve: true}); err == nil {
+		t.Fatal("decided a run without a pending approval")
+	}
+
+	// The pipeline parks the finished build in waiting_approval.
+	if err := gdb.Model(run).Updates(map[string]interface{}{
+		"status": "success", "stage": engine.StageWaitingApproval, "artifact_path": "job-1/build-001.tar.gz",
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := gdb.Create(&model.BuildDeployApproval{BuildRunID: run.ID, BatchNo: 1, Status: engine.ApprovalPending}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runSvc.Redeploy(run.ID, 1, "admin", service.RedeployInput{}); err == nil {
+		t.Fatal("redeploy accepted while waiting for approval")
+	}
+	if _, err := runSvc.DecideApproval(run.ID, 3, "ops", service.ApprovalInput{RejectAction: "later"}); err == nil {
+		t.Fatal("invalid reject_action accepted")
+	}
+	got, err := runSvc.DecideApproval(run.ID, 3, "ops", service.ApprovalInput{Comment: " not today "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Stage != "distributing" || len(got.DeployApprovals) != 1 {
+		t.Fatalf("run=%+v", got)
+	}
+	a := got.DeployApprovals[0]
+	if a.Status != engine.ApprovalRejected || a.RejectAction != engine.RejectSkip || a.DecidedBy == nil || *a.DecidedBy != 3 ||
+		a.DecidedByName != "ops" || a.Comment != "not today" || a.DecidedAt == nil {
+		t.Fatalf("approval=%+v", a)
+	}
+	if _, err := runSvc.DecideApproval(run.ID, 3, "ops", service.ApprovalInput{Approve: true}); err == nil {
+		t.Fatal("approval decided twice")
+	}
+}
+
+func TestBuildJob_DistributeStrategyValidated(t *testing.T) {
+	_, repoSvc, _, jobSvc, _, _ := setupCICD(t)
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "rd", RepoURL: "https://example.com/d.git",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range []service.CreateBuildJobInput{
+		{DistributeStrategy: "blue-green"},
+		{DistributeStrategy: "parallel", DistributeConcurrency: 51},
+		{DistributeStrategy: "rolling", DistributeConcurrency: -1},
+		{DistributeStrategy: "rolling", DistributeMaxFailures: -1},
+	} {
+		in.RepositoryID, in.Name, in.BuildScript = repo.ID, "bad", "make"
+		if _, err := jobSvc.Create(1, in, false); err == nil {
+			t.Fatalf("accepted %+v", in)
+		}
+	}
+	job, err := jobSvc.Create(1, service.CreateBuildJobInput{RepositoryID: repo.ID, Name: "d", BuildScript: "make"}, false)
+	if err != nil || job.DistributeStrategy != "serial" || job.DistributeConcurrency != 1 {
+		t.Fatalf("defaults: job=%+v err=%v", job, err)
+	}
+	strategy, conc := " Rolling ", 3
+	got, err := jobSvc.Update(job.ID, service.UpdateBuildJobInput{DistributeStrategy: &strategy, DistributeConcurrency: &conc}, false)
+	if err != nil || got.DistributeStrategy != "rolling" || got.DistributeConcurrency != 3 || got.DistributeMaxFailures != 0 {
+		t.Fatalf("job=%+v err=%v", got, err)
+	}
+}
+
+func TestBuildJob_TargetDeploymentsAndRollback(t *testing.T) {
+	_, repoSvc, _, jobSvc, runSvc, gdb := setupCICD(t)
+	runRepo := repository.NewBuildRunRepository(gdb)
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "r-rollback", RepoURL: "https://example.com/rollback.git",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := []service.DeployTargetInput{
+		{Method: "local", RemotePath: "/srv/a"},
+		{Method: "local", RemotePath: "/srv/b"},
+	}
+	job, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "rollback-job", BuildScript: "make", OutputDir: "dist", DeployTargets: targets,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := job.DeployTargets[0].ID, job.DeployTargets[1].ID
+	if _, err := runSvc.Rollback(job.ID, 1, "admin", service.RollbackInput{}); err == nil {
+		t.Fatal("rolled back a job that was never deployed")
+	}
+
+	var runs []*model.BuildRun
+	for i := 1; i <= 2; i++ {
+		run := &model.BuildRun{
+			BuildJobID: job.ID, BuildNumber: i, Status: "success", Stage: "idle", DistributionSummary: "all_success",
+			CommitHash: strings.Repeat(strconv.Itoa(i), 40), ArtifactPath: filepath.Join(t.TempDir(), "a.tar.gz"),
+		}
+		if err := gdb.Create(run).Error; err != nil {
+			t.Fatal(err)
+		}
+		runs = append(runs, run)
+		for _, id := range []uint{a, b} {
+			if err := runRepo.RecordDeployment(&model.DeployTargetDeployment{
+				BuildJobID: job.ID, DeployTargetID: id, BuildRunID: run.ID, BuildNumber: i,
+				CommitHash: run.CommitHash, ArtifactSHA256: strings.Repeat(strconv.Itoa(i), 64),
+				Kind: engine.DeploymentDeploy, DeployedBy: 1, DeployedAt: time.Now(),
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	items, err := runSvc.ListDeployments(job.ID)
+	if err != nil || len(items) != 2 {
+		t.Fatalf("deployments=%+v err=%v", items, err)
+	}
+	for _, item := range items {
+		if item.Current == nil || item.Current.BuildRunID != runs[1].ID || item.Previous == nil || item.Previous.BuildRunID != runs[0].ID {
+			t.Fatalf("target %d: current=%+v previous=%+v", item.Target.ID, item.Current, item.Previous)
+		}
+	}
+	history, total, err := runSvc.ListDeploymentHistory(job.ID, &a, 1, 10)
+	if err != nil || total != 2 || !history[0].Current || history[1].Current {
+		t.Fatalf("history=%+v total=%d err=%v", history, total, err)
+	}
+
+	// One target: its previous run is redeployed to it alone, as a rollback.
+	got, err := runSvc.Rollback(job.ID, 7, "ops", service.RollbackInput{DeployTargetID: a})
+	if err != nil || len(got) != 1 || got[0].ID != runs[0].ID || got[0].TriggerType != "redeploy" {
+		t.Fatalf("rollback=%+v err=%v", got, err)
+	}
+	var snap map[string]interface{}
+	_ = json.Unmarshal([]byte(got[0].SnapshotJSON), &snap)
+	if snap["redeploy_kind"] != engine.DeploymentRollback || snap["redeploy_by_name"] != "ops" {
+		t.Fatalf("snapshot=%v", snap)
+	}
+	if ids, _ := snap["redeploy_target_ids"].([]interface{}); len(ids) != 1 || ids[0] != float64(a) {
+		t.Fatalf("target ids=%v", snap["redeploy_target_ids"])
+	}
+	if _, err := runSvc.Rollback(job.ID, 7, "ops", service.RollbackInput{DeployTargetID: 999}); err == nil {
+		t.Fatal("rolled back an unknown target")
+	}
+
+	// A removed artifact cannot be rolled back to.
+	if err := gdb.Model(runs[0]).Update("artifact_path", "").Error; err != nil {
+		t.Fatal(err)
+	}
+	if items, _ = runSvc.ListDeployments(job.ID); items[0].Previous != nil {
+		t.Fatalf("previous without artifact=%+v", items[0].Previous)
+	}
+
+	// Editing the job keeps the history of unchanged targets; a removed
+	// target no longer holds its artifact.
+	kept := []service.DeployTargetInput{targets[0], {Method: "local", RemotePath: "/srv/c"}}
+	job, err = jobSvc.Update(job.ID, service.UpdateBuildJobInput{DeployTargets: &kept}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, _ = runSvc.ListDeployments(job.ID)
+	if items[0].Target.ID == a || items[0].Current == nil || items[0].Current.BuildRunID != runs[1].ID || items[1].Current != nil {
+		t.Fatalf("after edit: %+v", items)
+	}
+	deployed, err := runRepo.ListDeployedRunIDs(job.ID)
+	if err != nil || len(deployed) != 1 || deployed[0] != runs[1].ID {
+		t.Fatalf("deployed=%v err=%v", deployed, err)
+	}
+	if _, total, _ := runSvc.ListDeploymentHistory(job.ID, nil, 1, 10); total != 4 {
+		t.Fatalf("history total=%d", total)
+	}
+}
+
+func TestBuildRun_CancelWhileWaitingForApproval(t *testing.T) {
+	_, repoSvc, _, jobSvc, runSvc, gdb := setupCICD(t)
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "r-approval-cancel", RepoURL: "https://example.com/approval-cancel.git",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := jobSvc.Create(1, service.CreateBuildJobInput{
+		RepositoryID: repo.ID, Name: "approval-cancel", BuildScript: "make", OutputDir: "dist",
+		DeployTargets: []service.DeployTargetInput{{Method: "local", RemotePath: "/srv/app", RequiresApproval: true}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	park := func(fields map[string]interface{}) *model.BuildRun {
+		run, err := runSvc.Enqueue(job.ID, 1, service.EnqueueRunInput{TriggerType: "manual", Branch: "main"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields["status"], fields["stage"], fields["distribution_summary"] = "success", engine.StageWaitingApproval, engine.StageWaitingApproval
+		fields["artifact_path"] = "job-1/build.tar.gz"
+		if err := gdb.Model(run).Updates(fields).Error; err != nil {
+			t.Fatal(err)
+		}
+		targets, _ := json.Marshal(job.DeployTargets)
+		if err := gdb.Create(&model.BuildDeployApproval{
+			BuildRunID: run.ID, BatchNo: 1, Status: engine.ApprovalPending, TargetsJSON: string(targets),
+		}).Error; err != nil {
+			t.Fatal(err)
+		}
+		return run
+	}
+	check := func(id uint) {
+		t.Helper()
+		got, err := runSvc.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Stage != "idle" || got.DistributionSummary != "cancelled" || got.Status != "success" {
+			t.Fatalf("run=%+v", got)
+		}
+		if a := got.DeployApprovals[0]; a.Status != engine.ApprovalRejected || a.RejectAction != engine.RejectCancel {
+			t.Fatalf("approval=%+v", a)
+		}
+		if len(got.DeployAttempts) != 1 || got.DeployAttempts[0].Status != "cancelled" || got.DeployAttempts[0].BatchNo != 1 {
+			t.Fatalf("attempts=%+v", got.DeployAttempts)
+		}
+		if _, err := runSvc.DecideApproval(id, 3, "ops", service.ApprovalInput{Approve: true}); err == nil {
+			t.Fatal("approved a cancelled run")
+		}
+	}
+
+	run := park(map[string]interface{}{})
+	if _, err := runSvc.Cancel(run.ID); err != nil {
+		t.Fatal(err)
+	}
+	check(run.ID)
+
+	// A newer push to the pull request supersedes a parked PR run.
+	run = park(map[string]interface{}{"pr_number": 12})
+	if ids := runSvc.CancelPullRequestRuns(job.ID, 12, 0); len(ids) != 1 || ids[0] != run.ID {
+		t.Fatalf("cancelled=%v", ids)
+	}
+	check(run.ID)
+}
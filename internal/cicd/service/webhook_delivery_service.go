@@ -0,0 +1,217 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+
+	"bedrock/internal/cicd/model"
+)
+
+// Delivery outcomes recorded in WebhookDeliveryRecord.Outcome.
+const (
+	DeliveryUnauthorized     = "unauthorized"
+	DeliveryInvalid          = "invalid"
+	DeliveryIgnored          = "ignored"
+	DeliveryDisabled         = "disabled"
+	DeliveryDuplicate        = "duplicate"
+	DeliveryBranchNotMatched = "branch_not_matched"
+	DeliveryTagNotMatched    = "tag_not_matched"
+	DeliveryPathNotMatched   = "path_not_matched"
+	DeliveryEnqueueFailed    = "enqueue_failed"
+	DeliveryTriggered        = "triggered"
+	// DeliverySkipped: a repository delivery no job built; see Jobs for why.
+	DeliverySkipped = "skipped"
+)
+
+// maxRecordedBody is how much of a payload a delivery record keeps (fits a
+// MySQL TEXT column). A truncated delivery can't be replayed.
+const maxRecordedBody = 60 << 10
+
+// ListDeliveries pages a job's webhook deliveries, newest first, including
+// its repository webhook's deliveries. outcome optionally filters.
+func (s *WebhookService) ListDeliveries(jobID uint, outcome string, page, pageSize int) ([]model.WebhookDeliveryRecord, int64, error) {
+	job, err := s.jobs.FindByID(jobID)
+	if err != nil {
+		return nil, 0, NewNotFound("构建任务不存在")
+	}
+	items, total, err := s.deliveries.ListRecords(job.ID, job.RepositoryID, strings.TrimSpace(outcome), page, pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range items {
+		decodeDeliveryRecord(&items[i])
+	}
+	return items, total, nil
+}
+
+// GetDelivery returns one delivery of the job with its recorded body.
+func (s *WebhookService) GetDelivery(jobID, deliveryID uint) (*model.WebhookDeliveryRecord, error) {
+	job, err := s.jobs.FindByID(jobID)
+	if err != nil {
+		return nil, NewNotFound("构建任务不存在")
+	}
+	return s.jobDelivery(job, deliveryID)
+}
+
+// ReplayDelivery runs a recorded delivery again through the pipeline that
+// received it: a job delivery for the job, a repository delivery for every
+// job of the repository. Secret and signature checks are skipped (the caller
+// is an authorized user); bypassDedup also skips the duplicate check so an
+// already processed delivery builds again. The replay is recorded too.
+func (s *WebhookService) ReplayDelivery(jobID, deliveryID uint, bypassDedup bool) (*WebhookResult, error) {
+	job, err := s.jobs.FindByID(jobID)
+	if err != nil {
+		return nil, NewNotFound("构建任务不存在")
+	}
+	orig, err := s.jobDelivery(job, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	if orig.BodyTruncated {
+		return nil, errorsNew("请求体超出记录上限已被截断，无法重放")
+	}
+
+	body := []byte(orig.Body)
+	call := webhookCall{replay: true, bypassDedup: bypassDedup}
+	rec := newDeliveryRecord(orig.Headers, body)
+	rec.ReplayOf = &orig.ID
+	var res *WebhookResult
+	if orig.BuildJobID != 0 {
+		rec.BuildJobID = job.ID
+		res, err = s.receiveJob(job, call, orig.Headers, body, rec)
+	} else {
+		repo, findErr := s.repos.FindByID(orig.RepositoryID)
+		if findErr != nil {
+			return nil, NewNotFound("仓库不存在")
+		}
+		rec.RepositoryID = repo.ID
+		res, err = s.receiveRepository(repo, call, orig.Headers, body, rec)
+	}
+	s.saveDelivery(rec, res, err)
+	return res, err
+}
+
+// jobDelivery loads a delivery listed for the job (its own or its repository's).
+func (s *WebhookService) jobDelivery(job *model.BuildJob, deliveryID uint) (*model.WebhookDeliveryRecord, error) {
+	rec, err := s.deliveries.FindRecord(deliveryID)
+	if err != nil || !(rec.BuildJobID == job.ID || (rec.BuildJobID == 0 && rec.RepositoryID == job.RepositoryID)) {
+		return nil, NewNotFound("投递记录不存在")
+	}
+	decodeDeliveryRecord(rec)
+	return rec, nil
+}
+
+// newDeliveryRecord captures the request of a delivery. Credential headers
+// are masked and the secrets are removed from headers and body (Gitee, for
+// one, echoes the hook password in the payload).
+func newDeliveryRecord(headers map[string]string, body []byte, secrets ...string) *model.WebhookDeliveryRecord {
+	redact := func(text string) string {
+		for _, secret := range secrets {
+			text = RedactSecret(text, secret)
+		}
+		return text
+	}
+	masked := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if isCredentialHeader(k) {
+			v = "***"
+		}
+		masked[k] = redact(v)
+	}
+	text := redact(string(body))
+	rec := &model.WebhookDeliveryRecord{
+		Event:    truncateUTF8(webhookEventName(headers), 100),
+		Headers:  masked,
+		Body:     truncateUTF8(text, maxRecordedBody),
+		BodySize: len(body),
+	}
+	rec.BodyTruncated = len(rec.Body) < len(text)
+	return rec
+}
+
+// saveDelivery stores the delivery's outcome. History is best effort: a
+// failed write never fails the webhook.
+func (s *WebhookService) saveDelivery(rec *model.WebhookDeliveryRecord, res *WebhookResult, err error) {
+	rec.Outcome = deliveryOutcome(res, err)
+	if err != nil {
+		rec.Message = err.Error()
+	} else {
+		rec.Message = res.Message
+		rec.RunIDs = res.RunIDs
+		rec.Jobs = res.Jobs
+	}
+	rec.Ref = truncateUTF8(rec.Ref, 300)
+	rec.CommitHash = truncateUTF8(rec.CommitHash, 64)
+	rec.Message = truncateUTF8(rec.Message, 500)
+	if raw, jsonErr := json.Marshal(rec.Headers); jsonErr == nil {
+		rec.HeadersJSON = string(raw)
+	}
+	if len(rec.RunIDs) > 0 {
+		raw, _ := json.Marshal(rec.RunIDs)
+		rec.RunIDsJSON = string(raw)
+	}
+	if rec.Jobs != nil {
+		raw, _ := json.Marshal(rec.Jobs)
+		rec.JobsJSON = string(raw)
+	}
+	if s.deliveries.CreateRecord(rec) == nil && res != nil {
+		res.DeliveryID = rec.ID
+	}
+}
+
+// deliveryOutcome classifies a webhook result for the delivery history.
+func deliveryOutcome(res *WebhookResult, err error) string {
+	switch {
+	case err != nil && IsUnauthorized(err):
+		return DeliveryUnauthorized
+	case err != nil:
+		return DeliveryInvalid
+	case res.Outcome != "":
+		return res.Outcome
+	}
+	return DeliveryIgnored
+}
+
+func decodeDeliveryRecord(rec *model.WebhookDeliveryRecord) {
+	rec.Headers = map[string]string{}
+	rec.RunIDs = []uint{}
+	if rec.HeadersJSON != "" {
+		_ = json.Unmarshal([]byte(rec.HeadersJSON), &rec.Headers)
+	}
+	if rec.RunIDsJSON != "" {
+		_ = json.Unmarshal([]byte(rec.RunIDsJSON), &rec.RunIDs)
+	}
+	if rec.JobsJSON != "" {
+		_ = json.Unmarshal([]byte(rec.JobsJSON), &rec.Jobs)
+	}
+}
+
+// isCredentialHeader reports headers that carry a signature, token or secret.
+func isCredentialHeader(name string) bool {
+	n := strings.ToLower(name)
+	return n == "authorization" || n == "cookie" ||
+		strings.Contains(n, "signature") || strings.Contains(n, "token") || strings.Contains(n, "secret")
+}
+
+// webhookEventName is the platform's event header (push, Merge Request Hook, ...).
+func webhookEventName(h map[string]string) string {
+	for _, key := range []string{"X-GitHub-Event", "X-Gitea-Event", "X-Gitee-Event", "X-Gitlab-Event", "X-Event-Key"} {
+		if v := header(h, key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// truncateUTF8 cuts s to at most max bytes without splitting a character.
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
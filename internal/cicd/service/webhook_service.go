@@ -12,21 +12,36 @@ import (
 	"bedrock/internal/cicd/model"
 	"bedrock/internal/cicd/repository"
 	"bedrock/internal/engine"
+	resourcemodel "bedrock/internal/resource/model"
+	resourcerepo "bedrock/internal/resource/repository"
 )
 
 // WebhookService verifies signatures, dedups deliveries, matches branch policy, enqueues runs.
 type WebhookService struct {
 	jobs       *repository.BuildJobRepository
+	repos      *resourcerepo.RepositoryRepository
 	deliveries *repository.WebhookDeliveryRepository
 	runs       *BuildRunService
+	files      ChangedFilesResolver
+}
+
+// ChangedFilesResolver diffs two commits of a repository (engine.GitDiffResolver)
+// for path filters when the push payload does not list the changed files.
+type ChangedFilesResolver interface {
+	ChangedFiles(repositoryID uint, before, after string) ([]string, error)
 }
 
 func NewWebhookService(
 	jobs *repository.BuildJobRepository,
+	repos *resourcerepo.RepositoryRepository,
 	deliveries *repository.WebhookDeliveryRepository,
 	runs *BuildRunService,
 ) *WebhookService {
-	return &WebhookService{jobs: jobs, deliveries: deliveries, runs: runs}
+	return &WebhookService{jobs: jobs, repos: repos, deliveries: deliveries, runs: runs}
+}
+
+func (s *WebhookService) SetChangedFilesResolver(r ChangedFilesResolver) {
+	s.files = r
 }
 
 type WebhookResult struct {
@@ -37,6 +52,12 @@ type WebhookResult struct {
 	RunIDs    []uint `json:"run_ids,omitempty"`
 	JobIDs    []uint `json:"job_ids,omitempty"`
 	Message   string `json:"message,omitempty"`
+	// Outcome is the delivery outcome (DeliveryTriggered, DeliveryBranchNotMatched, ...).
+	Outcome string `json:"outcome"`
+	// Jobs lists every job of a repository webhook with why it did or did not build.
+	Jobs []model.WebhookJobResult `json:"jobs,omitempty"`
+	// DeliveryID is the delivery's history record.
+	DeliveryID uint `json:"delivery_id,omitempty"`
 }
 
 type webhookEvent struct {
@@ -44,6 +65,69 @@ type webhookEvent struct {
 	CommitHash    string
 	CommitMessage string
 	DeliveryKey   string
+	// PullRequest is set for PR/MR events; Ref is then the target branch.
+	PullRequest *pullRequestEvent
+	// Ignored is why an accepted event triggers nothing (e.g. a PR was closed).
+	Ignored string
+	// Before is the commit a push moved the ref from. ChangedFiles is only
+	// meaningful when FilesListed: the payload listed every pushed commit's files.
+	Before       string
+	ChangedFiles []string
+	FilesListed  bool
+}
+
+// pushCommit is a commit of a GitHub / Gitea / Gitee / GitLab push payload.
+type pushCommit struct {
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Modified []string `json:"modified"`
+}
+
+// maxPayloadCommits is how many commits GitHub and GitLab put in a push
+// payload; a push of more has an incomplete file list.
+const maxPayloadCommits = 20
+
+// setPushFiles records the pushed commits' files. total is the platform's
+// commit count (0 = not reported); an empty or truncated list stays unlisted.
+func (e *webhookEvent) setPushFiles(commits []pushCommit, total int) {
+	if len(commits) == 0 || len(commits) < total || (total == 0 && len(commits) >= maxPayloadCommits) {
+		return
+	}
+	seen := map[string]bool{}
+	for _, c := range commits {
+		for _, list := range [][]string{c.Added, c.Removed, c.Modified} {
+			for _, f := range list {
+				if !seen[f] {
+					seen[f] = true
+					e.ChangedFiles = append(e.ChangedFiles, f)
+				}
+			}
+		}
+	}
+	e.FilesListed = true
+}
+
+// pullRequestEvent is a PR/MR that was opened, reopened or pushed to.
+// HeadRef/MergeRef are the refs the platform publishes for it; MergeRef is
+// empty where there is none (Gitea, Bitbucket).
+type pullRequestEvent struct {
+	Number       int
+	Title        string
+	SourceBranch string
+	TargetBranch string
+	Author       string
+	HeadSHA      string
+	HeadRef      string
+	MergeRef     string
+}
+
+// webhookCall is how a delivery reaches the pipeline: from the platform with
+// its URL secret, or replayed by an operator (no secret or signature check,
+// dedup optional).
+type webhookCall struct {
+	urlSecret   string
+	replay      bool
+	bypassDedup bool
 }
 
 // Receive processes a build-job webhook. URL secret must match. Platform signature preferred when present.
@@ -58,19 +142,35 @@ func (s *WebhookService) Receive(
 	if err != nil {
 		return nil, NewNotFound("构建任务不存在")
 	}
-	if job.WebhookSecret == "" || !secureEqual(job.WebhookSecret, urlSecret) {
+	rec := newDeliveryRecord(headers, body, urlSecret, job.WebhookSecret)
+	rec.BuildJobID = job.ID
+	res, err := s.receiveJob(job, webhookCall{urlSecret: urlSecret}, headers, body, rec)
+	s.saveDelivery(rec, res, err)
+	return res, err
+}
+
+func (s *WebhookService) receiveJob(
+	job *model.BuildJob,
+	call webhookCall,
+	headers map[string]string,
+	body []byte,
+	rec *model.WebhookDeliveryRecord,
+) (*WebhookResult, error) {
+	if !call.replay && (job.WebhookSecret == "" || !secureEqual(job.WebhookSecret, call.urlSecret)) {
 		return nil, errUnauthorized("无效的 webhook secret")
 	}
+	platform := detectWebhookPlatform(headers, job)
+	rec.Platform = platform
 	if !job.Enabled || !job.TriggerWebhook {
 		return &WebhookResult{
 			Accepted:  true,
 			Triggered: 0,
 			Message:   "webhook trigger disabled",
+			Outcome:   DeliveryDisabled,
 		}, nil
 	}
 
-	platform := detectWebhookPlatform(headers, job)
-	if hasSignatureHeaders(headers) {
+	if !call.replay && hasSignatureHeaders(headers) {
 		if err := verifyPlatformSignature(platform, headers, body, job.WebhookSecret); err != nil {
 			return nil, errUnauthorized("签名校验失败")
 		}
@@ -80,22 +180,169 @@ func (s *WebhookService) Receive(
 	if err != nil {
 		return nil, errorsNew(err.Error())
 	}
+	rec.Ref, rec.CommitHash = event.Ref, event.CommitHash
+	if event.Ignored != "" {
+		return &WebhookResult{Accepted: true, Message: event.Ignored, Outcome: DeliveryIgnored}, nil
+	}
+	if reason := eventSkipReason(job, event); reason != "" {
+		return &WebhookResult{Accepted: true, Message: reason, Outcome: DeliveryDisabled}, nil
+	}
+
+	rec.DeliveryKey = webhookDeliveryKey(event, headers, body)
+	if !call.bypassDedup {
+		ok, err := s.deliveries.TryInsert(job.ID, rec.DeliveryKey)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return &WebhookResult{Accepted: true, Duplicate: true, Message: "duplicate delivery", Outcome: DeliveryDuplicate}, nil
+		}
+	}
+	return s.dispatch(job, event)
+}
+
+// ReceiveRepository processes a repository webhook: the delivery is verified
+// and deduped once, then every build job of the repository is evaluated
+// against its own trigger, branch, tag and path rules.
+func (s *WebhookService) ReceiveRepository(
+	repositoryID uint,
+	urlSecret string,
+	headers map[string]string,
+	body []byte,
+) (*WebhookResult, error) {
+	repo, err := s.repos.FindByID(repositoryID)
+	if err != nil {
+		return nil, NewNotFound("仓库不存在")
+	}
+	rec := newDeliveryRecord(headers, body, urlSecret, repo.WebhookSecret)
+	rec.RepositoryID = repo.ID
+	res, err := s.receiveRepository(repo, webhookCall{urlSecret: urlSecret}, headers, body, rec)
+	s.saveDelivery(rec, res, err)
+	return res, err
+}
+
+func (s *WebhookService) receiveRepository(
+	repo *resourcemodel.Repository,
+	call webhookCall,
+	headers map[string]string,
+	body []byte,
+	rec *model.WebhookDeliveryRecord,
+) (*WebhookResult, error) {
+	if !call.replay && (repo.WebhookSecret == "" || !secureEqual(repo.WebhookSecret, call.urlSecret)) {
+		return nil, errUnauthorized("无效的 webhook secret")
+	}
 
-	deliveryKey := event.DeliveryKey
-	if deliveryKey == "" {
-		deliveryKey = headersDeliveryID(headers)
+	// No job's generic JSON paths apply: generic payloads use the default fields.
+	none := &model.BuildJob{}
+	platform := detectWebhookPlatform(headers, none)
+	rec.Platform = platform
+	if !call.replay && hasSignatureHeaders(headers) {
+		if err := verifyPlatformSignature(platform, headers, body, repo.WebhookSecret); err != nil {
+			return nil, errUnauthorized("签名校验失败")
+		}
+	}
+
+	event, err := parseWebhookPayload(platform, none, headers, body)
+	if err != nil {
+		return nil, errorsNew(err.Error())
 	}
-	if deliveryKey == "" {
-		sum := sha256.Sum256(append([]byte(event.Ref+"|"), body...))
-		deliveryKey = "body:" + hex.EncodeToString(sum[:16])
+	rec.Ref, rec.CommitHash = event.Ref, event.CommitHash
+	if event.Ignored != "" {
+		return &WebhookResult{Accepted: true, Message: event.Ignored, Outcome: DeliveryIgnored}, nil
 	}
 
-	ok, err := s.deliveries.TryInsert(jobID, deliveryKey)
+	rec.DeliveryKey = webhookDeliveryKey(event, headers, body)
+	if !call.bypassDedup {
+		ok, err := s.deliveries.TryInsertRepository(repo.ID, rec.DeliveryKey)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return &WebhookResult{Accepted: true, Duplicate: true, Message: "duplicate delivery", Outcome: DeliveryDuplicate}, nil
+		}
+	}
+
+	jobs, err := s.jobs.ListByRepositoryID(repo.ID)
 	if err != nil {
 		return nil, err
 	}
-	if !ok {
-		return &WebhookResult{Accepted: true, Duplicate: true, Message: "duplicate delivery"}, nil
+	result := &WebhookResult{Accepted: true, Branch: eventBranch(event), Jobs: []model.WebhookJobResult{}}
+	if len(jobs) == 0 {
+		result.Message = "no build jobs"
+	}
+	enqueueFailed := false
+	for i := range jobs {
+		job := &jobs[i]
+		item := model.WebhookJobResult{JobID: job.ID, JobName: job.Name}
+		if item.Reason = eventSkipReason(job, event); item.Reason == "" {
+			res, err := s.dispatch(job, event)
+			if err != nil {
+				item.Reason = err.Error()
+			} else {
+				item.Reason = res.Message
+				enqueueFailed = enqueueFailed || res.Outcome == DeliveryEnqueueFailed
+				if len(res.RunIDs) > 0 {
+					item.Triggered, item.RunID = true, res.RunIDs[0]
+					result.Triggered++
+					result.RunIDs = append(result.RunIDs, res.RunIDs[0])
+					result.JobIDs = append(result.JobIDs, job.ID)
+				}
+			}
+		}
+		result.Jobs = append(result.Jobs, item)
+	}
+	// Nothing built because enqueueing failed is a failure, not a skip.
+	switch {
+	case result.Triggered > 0:
+		result.Outcome = DeliveryTriggered
+	case enqueueFailed:
+		result.Outcome = DeliveryEnqueueFailed
+	default:
+		result.Outcome = DeliverySkipped
+	}
+	return result, nil
+}
+
+// eventSkipReason is why a job ignores an event whatever its ref: the job or
+// the trigger for this kind of event (push, pull request, tag) is off.
+func eventSkipReason(job *model.BuildJob, event *webhookEvent) string {
+	switch {
+	case !job.Enabled || !job.TriggerWebhook:
+		return "webhook trigger disabled"
+	case event.PullRequest != nil && !job.TriggerPullRequest:
+		return "pull request trigger disabled"
+	case strings.HasPrefix(event.Ref, "refs/tags/") && !job.TriggerTag:
+		return "tag trigger disabled"
+	}
+	return ""
+}
+
+// webhookDeliveryKey identifies a delivery for dedup: the payload's own id,
+// the platform delivery header, else a hash of ref and body.
+func webhookDeliveryKey(event *webhookEvent, headers map[string]string, body []byte) string {
+	if event.DeliveryKey != "" {
+		return event.DeliveryKey
+	}
+	if key := headersDeliveryID(headers); key != "" {
+		return key
+	}
+	sum := sha256.Sum256(append([]byte(event.Ref+"|"), body...))
+	return "body:" + hex.EncodeToString(sum[:16])
+}
+
+// eventBranch is the branch, or tag, an event was pushed to.
+func eventBranch(event *webhookEvent) string {
+	if tag, ok := strings.CutPrefix(event.Ref, "refs/tags/"); ok {
+		return tag
+	}
+	return extractBranchFromRef(event.Ref)
+}
+
+// dispatch applies a job's branch / tag / path rules to an accepted event and
+// enqueues a run when they match.
+func (s *WebhookService) dispatch(job *model.BuildJob, event *webhookEvent) (*WebhookResult, error) {
+	if tag, isTag := strings.CutPrefix(event.Ref, "refs/tags/"); isTag {
+		return s.enqueueTag(job, tag, event)
 	}
 
 	branch := extractBranchFromRef(event.Ref)
@@ -105,23 +352,47 @@ func (s *WebhookService) Receive(
 			Branch:    branch,
 			Triggered: 0,
 			Message:   "branch not matched",
+			Outcome:   DeliveryBranchNotMatched,
 		}, nil
 	}
+	message := ""
+	if event.PullRequest == nil {
+		matched, known := s.pushTouchesPaths(job, event)
+		if !matched {
+			return &WebhookResult{Accepted: true, Branch: branch, Message: "no matching paths changed", Outcome: DeliveryPathNotMatched}, nil
+		}
+		if !known {
+			message = "changed paths unknown, path filters skipped"
+		}
+	}
 
-	run, err := s.runs.EnqueueInternal(job.ID, 0, engine.EnqueueParams{
+	params := engine.EnqueueParams{
 		Branch:        branch,
 		TriggerType:   "webhook",
 		CommitHash:    event.CommitHash,
 		CommitMessage: event.CommitMessage,
-	})
+	}
+	if pr := event.PullRequest; pr != nil {
+		var err error
+		if params, err = pullRequestParams(job, pr); err != nil {
+			return nil, errorsNew(err.Error())
+		}
+	}
+	run, err := s.runs.EnqueueInternal(job.ID, 0, params)
 	if err != nil {
 		return &WebhookResult{
 			Accepted:  true,
 			Branch:    branch,
 			Triggered: 0,
-			Message:   "enqueue failed",
+			Message:   "enqueue failed: " + err.Error(),
+			Outcome:   DeliveryEnqueueFailed,
 		}, nil
 	}
+	if pr := event.PullRequest; pr != nil {
+		// The new run supersedes the PR's unfinished ones; they are kept
+		// when it could not be enqueued.
+		s.runs.CancelPullRequestRuns(job.ID, pr.Number, run.ID)
+	}
 
 	return &WebhookResult{
 		Accepted:  true,
@@ -129,10 +400,63 @@ func (s *WebhookService) Receive(
 		Triggered: 1,
 		RunIDs:    []uint{run.ID},
 		JobIDs:    []uint{job.ID},
+		Message:   message,
+		Outcome:   DeliveryTriggered,
 	}, nil
 }
 
+// pushTouchesPaths applies the job's path filters to a push. Files come from
+// the payload, else from a git diff of before..after; when neither is
+// available the push builds (known = false).
+func (s *WebhookService) pushTouchesPaths(job *model.BuildJob, event *webhookEvent) (matched, known bool) {
+	includes := engine.ParseFilterPatterns(job.PathIncludes)
+	excludes := engine.ParseFilterPatterns(job.PathExcludes)
+	if len(includes) == 0 && len(excludes) == 0 {
+		return true, true
+	}
+	files := event.ChangedFiles
+	if !event.FilesListed {
+		if s.files == nil || event.Before == "" || isZeroCommit(event.Before) || event.CommitHash == "" {
+			return true, false
+		}
+		var err error
+		if files, err = s.files.ChangedFiles(job.RepositoryID, event.Before, event.CommitHash); err != nil {
+			return true, false
+		}
+	}
+	return engine.MatchChangedPaths(includes, excludes, files), true
+}
+
+// enqueueTag builds a pushed tag that matches the job's tag patterns.
+func (s *WebhookService) enqueueTag(job *model.BuildJob, tag string, event *webhookEvent) (*WebhookResult, error) {
+	if !engine.MatchTag(engine.ParseTagPatterns(job.TagPatterns), tag) {
+		return &WebhookResult{Accepted: true, Branch: tag, Message: "tag not matched", Outcome: DeliveryTagNotMatched}, nil
+	}
+	run, err := s.runs.EnqueueInternal(job.ID, 0, engine.EnqueueParams{
+		Branch:        tag,
+		TriggerType:   "tag",
+		CommitHash:    event.CommitHash,
+		CommitMessage: event.CommitMessage,
+		Tag:           tag,
+	})
+	if err != nil {
+		return &WebhookResult{Accepted: true, Branch: tag, Message: "enqueue failed: " + err.Error(), Outcome: DeliveryEnqueueFailed}, nil
+	}
+	return &WebhookResult{
+		Accepted:  true,
+		Branch:    tag,
+		Triggered: 1,
+		RunIDs:    []uint{run.ID},
+		JobIDs:    []uint{job.ID},
+		Outcome:   DeliveryTriggered,
+	}, nil
+}
+
+// jobMatchesBranch: BranchPatterns globs when set, otherwise the job's branch.
 func jobMatchesBranch(job model.BuildJob, branch string) bool {
+	if patterns := engine.ParseFilterPatterns(job.BranchPatterns); len(patterns) > 0 {
+		return engine.MatchBranch(patterns, branch)
+	}
 	return job.Branch == branch
 }
 
@@ -272,8 +596,12 @@ func parseWebhookPayload(platform string, job *model.BuildJob, h map[string]stri
 }
 
 type githubPushPayload struct {
-	Ref        string `json:"ref"`
-	After      string `json:"after"`
+	Ref        string       `json:"ref"`
+	Before     string       `json:"before"`
+	After      string       `json:"after"`
+	Deleted    bool         `json:"deleted"`
+	Commits    []pushCommit `json:"commits"`
+	TotalCount int          `json:"total_commits_count"`
 	HeadCommit struct {
 		ID      string `json:"id"`
 		Message string `json:"message"`
@@ -291,63 +619,198 @@ func parseGitHubLike(h map[string]string, body []byte, platform string) (*webhoo
 	if eventHdr != "" {
 		et := strings.ToLower(strings.TrimSpace(eventHdr))
 		if platform == "gitee" {
+			if et == "merge request hook" {
+				return parseGitHubLikePullRequest(body, platform)
+			}
 			if et != "push hook" && et != "tag push hook" {
-				return nil, fmt.Errorf("仅支持 Push Hook / Tag Push Hook 事件")
+				return nil, fmt.Errorf("仅支持 Push Hook / Tag Push Hook / Merge Request Hook 事件")
 			}
+		} else if et == "pull_request" {
+			return parseGitHubLikePullRequest(body, platform)
 		} else if et != "push" {
-			return nil, fmt.Errorf("仅支持 push 事件")
+			return nil, fmt.Errorf("仅支持 push / pull_request 事件")
 		}
 	}
 	var payload githubPushPayload
 	if err := json.Unmarshal(body, &payload); err != nil || payload.Ref == "" {
 		return nil, fmt.Errorf("无法解析 push payload")
 	}
+	if payload.Deleted || isZeroCommit(payload.After) {
+		return &webhookEvent{Ignored: "ref deleted"}, nil
+	}
 	commitHash := payload.HeadCommit.ID
 	if commitHash == "" {
 		commitHash = payload.After
 	}
-	return &webhookEvent{
+	ev := &webhookEvent{
 		Ref:           payload.Ref,
 		CommitHash:    commitHash,
 		CommitMessage: payload.HeadCommit.Message,
-	}, nil
+		Before:        payload.Before,
+	}
+	ev.setPushFiles(payload.Commits, payload.TotalCount)
+	return ev, nil
+}
+
+// parseGitHubLikePullRequest reads GitHub / Gitea pull_request and Gitee
+// Merge Request Hook payloads, which share the pull_request object.
+func parseGitHubLikePullRequest(body []byte, platform string) (*webhookEvent, error) {
+	var payload struct {
+		Action      string `json:"action"`
+		ActionDesc  string `json:"action_desc"`
+		Number      int    `json:"number"`
+		PullRequest *struct {
+			Number int    `json:"number"`
+			Title  string `json:"title"`
+			Head   struct {
+				Ref string `json:"ref"`
+				SHA string `json:"sha"`
+			} `json:"head"`
+			Base struct {
+				Ref string `json:"ref"`
+			} `json:"base"`
+			User struct {
+				Login string `json:"login"`
+			} `json:"user"`
+		} `json:"pull_request"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.PullRequest == nil {
+		return nil, fmt.Errorf("无法解析 pull request payload")
+	}
+	action := strings.ToLower(payload.Action)
+	build := false
+	switch platform {
+	case "gitee":
+		// "update" also fires for title/label edits; only new commits rebuild.
+		build = action == "open" || action == "reopen" || (action == "update" && payload.ActionDesc == "source_branch_changed")
+	default:
+		build = action == "opened" || action == "reopened" || action == "synchronize" || action == "synchronized"
+	}
+	if !build {
+		return &webhookEvent{Ignored: "pull request action ignored: " + payload.Action}, nil
+	}
+	pr := payload.PullRequest
+	number := pr.Number
+	if number == 0 {
+		number = payload.Number
+	}
+	ev := &pullRequestEvent{
+		Number:       number,
+		Title:        pr.Title,
+		SourceBranch: pr.Head.Ref,
+		TargetBranch: pr.Base.Ref,
+		Author:       pr.User.Login,
+		HeadSHA:      pr.Head.SHA,
+		HeadRef:      fmt.Sprintf("refs/pull/%d/head", number),
+	}
+	switch platform {
+	case "github":
+		ev.MergeRef = fmt.Sprintf("refs/pull/%d/merge", number)
+	case "gitee":
+		ev.MergeRef = fmt.Sprintf("refs/pull/%d/MERGE", number)
+	}
+	return pullRequestWebhookEvent(ev)
 }
 
 func parseGitLab(h map[string]string, body []byte) (*webhookEvent, error) {
-	if et := header(h, "X-Gitlab-Event"); et != "" && !strings.EqualFold(et, "Push Hook") {
-		return nil, fmt.Errorf("仅支持 push 事件")
+	et := header(h, "X-Gitlab-Event")
+	if strings.EqualFold(et, "Merge Request Hook") {
+		return parseGitLabMergeRequest(body)
+	}
+	if et != "" && !strings.EqualFold(et, "Push Hook") && !strings.EqualFold(et, "Tag Push Hook") {
+		return nil, fmt.Errorf("仅支持 Push Hook / Tag Push Hook / Merge Request Hook 事件")
 	}
 	var payload struct {
 		Ref         string `json:"ref"`
+		Before      string `json:"before"`
+		After       string `json:"after"`
 		CheckoutSha string `json:"checkout_sha"`
 		Commit      *struct {
 			Message string `json:"message"`
 		} `json:"commit"`
+		Commits    []pushCommit `json:"commits"`
+		TotalCount int          `json:"total_commits_count"`
 	}
 	if err := json.Unmarshal(body, &payload); err != nil || payload.Ref == "" {
 		return nil, fmt.Errorf("无法解析 GitLab push payload")
 	}
+	if isZeroCommit(payload.After) {
+		return &webhookEvent{Ignored: "ref deleted"}, nil
+	}
 	msg := ""
 	if payload.Commit != nil {
 		msg = payload.Commit.Message
 	}
-	return &webhookEvent{Ref: payload.Ref, CommitHash: payload.CheckoutSha, CommitMessage: msg}, nil
+	ev := &webhookEvent{Ref: payload.Ref, CommitHash: payload.CheckoutSha, CommitMessage: msg, Before: payload.Before}
+	ev.setPushFiles(payload.Commits, payload.TotalCount)
+	return ev, nil
+}
+
+func parseGitLabMergeRequest(body []byte) (*webhookEvent, error) {
+	var payload struct {
+		User struct {
+			Username string `json:"username"`
+		} `json:"user"`
+		Attrs *struct {
+			IID          int    `json:"iid"`
+			Action       string `json:"action"`
+			Title        string `json:"title"`
+			SourceBranch string `json:"source_branch"`
+			TargetBranch string `json:"target_branch"`
+			OldRev       string `json:"oldrev"`
+			LastCommit   struct {
+				ID      string `json:"id"`
+				Message string `json:"message"`
+			} `json:"last_commit"`
+		} `json:"object_attributes"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Attrs == nil {
+		return nil, fmt.Errorf("无法解析 GitLab merge request payload")
+	}
+	mr := payload.Attrs
+	// "update" with oldrev means new commits; without it only metadata changed.
+	if mr.Action != "open" && mr.Action != "reopen" && !(mr.Action == "update" && mr.OldRev != "") {
+		return &webhookEvent{Ignored: "merge request action ignored: " + mr.Action}, nil
+	}
+	return pullRequestWebhookEvent(&pullRequestEvent{
+		Number:       mr.IID,
+		Title:        mr.Title,
+		SourceBranch: mr.SourceBranch,
+		TargetBranch: mr.TargetBranch,
+		Author:       payload.User.Username,
+		HeadSHA:      mr.LastCommit.ID,
+		HeadRef:      fmt.Sprintf("refs/merge-requests/%d/head", mr.IID),
+		MergeRef:     fmt.Sprintf("refs/merge-requests/%d/merge", mr.IID),
+	})
 }
 
 func parseBitbucket(h map[string]string, body []byte) (*webhookEvent, error) {
-	if et := header(h, "X-Event-Key"); et != "" && et != "repo:push" {
-		return nil, fmt.Errorf("仅支持 repo:push 事件")
+	et := header(h, "X-Event-Key")
+	if et == "pullrequest:created" || et == "pullrequest:updated" {
+		return parseBitbucketPullRequest(body)
+	}
+	if strings.HasPrefix(et, "pullrequest:") {
+		return &webhookEvent{Ignored: "pull request event ignored: " + et}, nil
+	}
+	if et != "" && et != "repo:push" {
+		return nil, fmt.Errorf("仅支持 repo:push / pullrequest:created / pullrequest:updated 事件")
 	}
 	var payload struct {
 		Push struct {
 			Changes []struct {
 				New *struct {
+					Type   string `json:"type"`
 					Name   string `json:"name"`
 					Target struct {
 						Hash    string `json:"hash"`
 						Message string `json:"message"`
 					} `json:"target"`
 				} `json:"new"`
+				Old *struct {
+					Target struct {
+						Hash string `json:"hash"`
+					} `json:"target"`
+				} `json:"old"`
 			} `json:"changes"`
 		} `json:"push"`
 	}
@@ -358,10 +821,104 @@ func parseBitbucket(h map[string]string, body []byte) (*webhookEvent, error) {
 	if change.New == nil {
 		return nil, fmt.Errorf("bitbucket payload 缺少分支信息")
 	}
-	return &webhookEvent{
-		Ref:           "refs/heads/" + change.New.Name,
+	ref := "refs/heads/" + change.New.Name
+	if change.New.Type == "tag" {
+		ref = "refs/tags/" + change.New.Name
+	}
+	ev := &webhookEvent{
+		Ref:           ref,
 		CommitHash:    change.New.Target.Hash,
 		CommitMessage: change.New.Target.Message,
+	}
+	// Bitbucket lists no files; path filters diff against the old head.
+	if change.Old != nil {
+		ev.Before = change.Old.Target.Hash
+	}
+	return ev, nil
+}
+
+// parseBitbucketPullRequest builds the source branch at the PR commit;
+// Bitbucket publishes no PR refs, so PRs from forks can't be built.
+func parseBitbucketPullRequest(body []byte) (*webhookEvent, error) {
+	var payload struct {
+		PullRequest *struct {
+			ID     int    `json:"id"`
+			Title  string `json:"title"`
+			Author struct {
+				Nickname    string `json:"nickname"`
+				DisplayName string `json:"display_name"`
+			} `json:"author"`
+			Source struct {
+				Branch struct {
+					Name string `json:"name"`
+				} `json:"branch"`
+				Commit struct {
+					Hash string `json:"hash"`
+				} `json:"commit"`
+			} `json:"source"`
+			Destination struct {
+				Branch struct {
+					Name string `json:"name"`
+				} `json:"branch"`
+			} `json:"destination"`
+		} `json:"pullrequest"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.PullRequest == nil {
+		return nil, fmt.Errorf("无法解析 Bitbucket pull request payload")
+	}
+	pr := payload.PullRequest
+	author := pr.Author.Nickname
+	if author == "" {
+		author = pr.Author.DisplayName
+	}
+	return pullRequestWebhookEvent(&pullRequestEvent{
+		Number:       pr.ID,
+		Title:        pr.Title,
+		SourceBranch: pr.Source.Branch.Name,
+		TargetBranch: pr.Destination.Branch.Name,
+		Author:       author,
+		HeadSHA:      pr.Source.Commit.Hash,
+		HeadRef:      "refs/heads/" + pr.Source.Branch.Name,
+	})
+}
+
+// pullRequestWebhookEvent checks the parsed PR and matches it on its target branch.
+func pullRequestWebhookEvent(pr *pullRequestEvent) (*webhookEvent, error) {
+	if pr.Number <= 0 || pr.TargetBranch == "" || pr.SourceBranch == "" {
+		return nil, fmt.Errorf("pull request payload 缺少编号或分支")
+	}
+	return &webhookEvent{
+		Ref:           "refs/heads/" + pr.TargetBranch,
+		CommitHash:    pr.HeadSHA,
+		CommitMessage: pr.Title,
+		PullRequest:   pr,
+	}, nil
+}
+
+// pullRequestParams picks the ref a PR run checks out: the merge ref when the
+// job asks for it and the platform has one, the PR head otherwise. Head builds
+// pin the event's head commit; merge builds resolve the merge commit at checkout.
+func pullRequestParams(job *model.BuildJob, pr *pullRequestEvent) (engine.EnqueueParams, error) {
+	ref, commit := pr.HeadRef, pr.HeadSHA
+	if job.PullRequestRef == "merge" && pr.MergeRef != "" {
+		ref, commit = pr.MergeRef, ""
+	}
+	if err := engine.ValidateGitRef(ref); err != nil {
+		return engine.EnqueueParams{}, err
+	}
+	return engine.EnqueueParams{
+		Branch:        pr.SourceBranch,
+		TriggerType:   "pull_request",
+		CommitHash:    commit,
+		CommitMessage: pr.Title,
+		GitRef:        ref,
+		PullRequest: &engine.PullRequestInfo{
+			Number:       pr.Number,
+			SourceBranch: pr.SourceBranch,
+			TargetBranch: pr.TargetBranch,
+			Author:       pr.Author,
+			HeadCommit:   pr.HeadSHA,
+		},
 	}, nil
 }
 
@@ -388,6 +945,7 @@ func parseGeneric(job *model.BuildJob, body []byte) (*webhookEvent, error) {
 	var payload struct {
 		Ref     string `json:"ref"`
 		Branch  string `json:"branch"`
+		Before  string `json:"before"`
 		After   string `json:"after"`
 		Commit  string `json:"commit"`
 		Message string `json:"message"`
@@ -406,7 +964,12 @@ func parseGeneric(job *model.BuildJob, body []byte) (*webhookEvent, error) {
 	if hash == "" {
 		hash = payload.Commit
 	}
-	return &webhookEvent{Ref: ref, CommitHash: hash, CommitMessage: payload.Message}, nil
+	return &webhookEvent{Ref: ref, CommitHash: hash, CommitMessage: payload.Message, Before: payload.Before}, nil
+}
+
+// isZeroCommit reports the all-zero SHA platforms send as "after" for a deleted ref.
+func isZeroCommit(sha string) bool {
+	return len(sha) >= 40 && strings.Trim(sha, "0") == ""
 }
 
 func extractBranchFromRef(ref string) string {
@@ -4,12 +4,15 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"strings"
 	"testing"
 
 	"bedrock/internal/cicd/model"
 	"bedrock/internal/cicd/repository"
 	"bedrock/internal/cicd/service"
+	resourcerepo "bedrock/internal/resource/repository"
 	resourceservice "bedrock/internal/resource/service"
 	"gorm.io/gorm"
 )
@@ -190,6 +193,498 @@ func TestWebhook_ValidGitHubSignature(t *testing.T) {
 	}
 }
 
+func TestWebhook_PullRequestBuilds(t *testing.T) {
+	_, repoSvc, _, jobSvc, runSvc, gdb := setupCICD(t)
+
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "wh-pr", RepoURL: "https://example.com/pr.git", AuthType: "none",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, secret := createWebhookJob(t, jobSvc, repo.ID, "job-pr", "main", true)
+	wh := newWebhookSvc(gdb, runSvc)
+	opened := []byte(`{"action":"opened","number":7,"pull_request":{"number":7,"title":"Add feature",
+		"head":{"ref":"feature/x","sha":"1111111111111111111111111111111111111111"},
+		"base":{"ref":"main"},"user":{"login":"alice"}}}`)
+	ghHeaders := func(id string) map[string]string {
+		return map[string]string{"X-GitHub-Event": "pull_request", "X-GitHub-Delivery": id}
+	}
+
+	res, err := wh.Receive(job.ID, secret, ghHeaders("pr-0"), opened)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Triggered != 0 || res.Message != "pull request trigger disabled" {
+		t.Fatalf("PR built without trigger_pull_request: %+v", res)
+	}
+	if _, err := jobSvc.Update(job.ID, service.UpdateBuildJobInput{TriggerPullRequest: boolPtr(true)}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = wh.Receive(job.ID, secret, ghHeaders("pr-1"), opened)
+	if err != nil || res.Triggered != 1 || res.Branch != "main" {
+		t.Fatalf("opened: %+v err=%v", res, err)
+	}
+	first, _ := runSvc.Get(res.RunIDs[0])
+	if first.TriggerType != "pull_request" || first.PRNumber != 7 || first.PRSourceBranch != "feature/x" ||
+		first.PRTargetBranch != "main" || first.PRAuthor != "alice" || first.GitRef != "refs/pull/7/head" ||
+		first.CommitHash != "1111111111111111111111111111111111111111" || first.Branch != "feature/x" {
+		t.Fatalf("PR run=%+v", first)
+	}
+
+	// A push to the PR cancels the queued run; with pull_request_ref=merge the merge ref is built.
+	if _, err := jobSvc.Update(job.ID, service.UpdateBuildJobInput{PullRequestRef: strPtr("merge")}, false); err != nil {
+		t.Fatal(err)
+	}
+	pushed := []byte(strings.Replace(strings.Replace(string(opened), `"opened"`, `"synchronize"`, 1),
+		"1111111111111111111111111111111111111111", "2222222222222222222222222222222222222222", 1))
+	res, err = wh.Receive(job.ID, secret, ghHeaders("pr-2"), pushed)
+	if err != nil || res.Triggered != 1 {
+		t.Fatalf("synchronize: %+v err=%v", res, err)
+	}
+	if first, _ = runSvc.Get(first.ID); first.Status != "cancelled" {
+		t.Fatalf("superseded run status=%s", first.Status)
+	}
+	second, _ := runSvc.Get(res.RunIDs[0])
+	if second.GitRef != "refs/pull/7/merge" || second.CommitHash != "" ||
+		second.PRHeadCommit != "2222222222222222222222222222222222222222" {
+		t.Fatalf("merge run=%+v", second)
+	}
+
+	// A push whose run can't be enqueued leaves the current run alone.
+	required := []model.BuildParameter{{Name: "VERSION", Type: "string", Required: true}}
+	if _, err := jobSvc.Update(job.ID, service.UpdateBuildJobInput{Parameters: &required}, false); err != nil {
+		t.Fatal(err)
+	}
+	failed := []byte(strings.Replace(string(pushed), "2222222222222222222222222222222222222222", "3333333333333333333333333333333333333333", 1))
+	if res, err = wh.Receive(job.ID, secret, ghHeaders("pr-4"), failed); err != nil || res.Outcome != service.DeliveryEnqueueFailed {
+		t.Fatalf("failed enqueue: %+v err=%v", res, err)
+	}
+	if second, _ = runSvc.Get(second.ID); second.Status != "queued" {
+		t.Fatalf("failed enqueue cancelled the run: %s", second.Status)
+	}
+
+	closed := []byte(strings.Replace(string(opened), `"opened"`, `"closed"`, 1))
+	if res, err = wh.Receive(job.ID, secret, ghHeaders("pr-3"), closed); err != nil || res.Triggered != 0 {
+		t.Fatalf("closed: %+v err=%v", res, err)
+	}
+	if second, _ = runSvc.Get(second.ID); second.Status != "queued" {
+		t.Fatalf("closed event touched the run: %s", second.Status)
+	}
+}
+
+func TestWebhook_TagPushRecordsRelease(t *testing.T) {
+	_, repoSvc, _, jobSvc, runSvc, gdb := setupCICD(t)
+
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "wh-tag", RepoURL: "https://example.com/tag.git", AuthType: "none",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, secret := createWebhookJob(t, jobSvc, repo.ID, "job-tag", "main", true)
+	if _, err := jobSvc.Update(job.ID, service.UpdateBuildJobInput{TriggerTag: boolPtr(true)}, false); err == nil {
+		t.Fatal("tag trigger without patterns accepted")
+	}
+	if _, err := jobSvc.Update(job.ID, service.UpdateBuildJobInput{TagPatterns: strPtr("v[0-9")}, false); err == nil {
+		t.Fatal("bad glob accepted")
+	}
+	wh := newWebhookSvc(gdb, runSvc)
+	push := func(id, body string) *service.WebhookResult {
+		t.Helper()
+		res, err := wh.Receive(job.ID, secret, map[string]string{"X-GitHub-Event": "push", "X-GitHub-Delivery": id}, []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	tagBody := `{"ref":"refs/tags/v1.2.3","after":"3333333333333333333333333333333333333333","head_commit":{"id":"3333333333333333333333333333333333333333","message":"release"}}`
+
+	if res := push("t-0", tagBody); res.Triggered != 0 || res.Message != "tag trigger disabled" {
+		t.Fatalf("tag built with trigger off: %+v", res)
+	}
+	if _, err := jobSvc.Update(job.ID, service.UpdateBuildJobInput{
+		TriggerTag: boolPtr(true), TagPatterns: strPtr("v*.*.*\nsemver:>=2.0.0"),
+	}, false); err != nil {
+		t.Fatal(err)
+	}
+	if res := push("t-1", strings.Replace(tagBody, "v1.2.3", "nightly", 1)); res.Triggered != 0 || res.Message != "tag not matched" {
+		t.Fatalf("nightly: %+v", res)
+	}
+	if res := push("t-2", `{"ref":"refs/tags/v1.2.3","deleted":true,"after":"0000000000000000000000000000000000000000"}`); res.Triggered != 0 {
+		t.Fatalf("deleted tag built: %+v", res)
+	}
+	res := push("t-3", tagBody)
+	if res.Triggered != 1 {
+		t.Fatalf("v1.2.3: %+v", res)
+	}
+	run, _ := runSvc.Get(res.RunIDs[0])
+	if run.TriggerType != "tag" || run.Tag != "v1.2.3" || run.ReleaseVersion != "1.2.3" || run.GitRef != "refs/tags/v1.2.3" {
+		t.Fatalf("tag run=%+v", run)
+	}
+
+	if _, err := runSvc.GetRelease(job.ID, "v1.2.3"); err == nil {
+		t.Fatal("unfinished run listed as a release")
+	}
+	if err := gdb.Model(&model.BuildRun{}).Where("id = ?", run.ID).Update("status", "success").Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"1.2.3", "v1.2.3"} {
+		rel, err := runSvc.GetRelease(job.ID, v)
+		if err != nil || rel.ID != run.ID {
+			t.Fatalf("GetRelease(%s)=%+v err=%v", v, rel, err)
+		}
+	}
+	if items, total, err := runSvc.ListReleases(job.ID, 1, 20); err != nil || total != 1 || items[0].ID != run.ID {
+		t.Fatalf("ListReleases total=%d err=%v", total, err)
+	}
+}
+
+// fakeChangedFiles stands in for the git diff behind path filters.
+type fakeChangedFiles struct {
+	files []string
+	calls int
+}
+
+func (f *fakeChangedFiles) ChangedFiles(uint, string, string) ([]string, error) {
+	f.calls++
+	return f.files, nil
+}
+
+func TestWebhook_BranchGlobsAndPathFilters(t *testing.T) {
+	_, repoSvc, _, jobSvc, runSvc, gdb := setupCICD(t)
+
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "wh-mono", RepoURL: "https://example.com/mono.git", AuthType: "none",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, secret := createWebhookJob(t, jobSvc, repo.ID, "job-api", "main", true)
+	if _, err := jobSvc.Update(job.ID, service.UpdateBuildJobInput{
+		BranchPatterns: strPtr("main\nrelease/*\nfeature/**"),
+		PathIncludes:   strPtr("services/api/**\ngo.mod"),
+		PathExcludes:   strPtr("**/*.md"),
+	}, false); err != nil {
+		t.Fatal(err)
+	}
+	diff := &fakeChangedFiles{}
+	wh := newWebhookSvc(gdb, runSvc)
+	wh.SetChangedFilesResolver(diff)
+	n := 0
+	push := func(headers map[string]string, body string) *service.WebhookResult {
+		t.Helper()
+		n++
+		headers["X-Request-Id"] = fmt.Sprintf("mono-%d", n)
+		res, err := wh.Receive(job.ID, secret, headers, []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	github := func() map[string]string { return map[string]string{"X-GitHub-Event": "push"} }
+	const sha = "4444444444444444444444444444444444444444"
+	githubPush := func(ref string, files ...string) string {
+		return fmt.Sprintf(`{"ref":%q,"before":"5555555555555555555555555555555555555555","after":%q,"commits":[{"modified":%s}]}`,
+			ref, sha, toJSON(t, files))
+	}
+
+	if res := push(github(), githubPush("refs/heads/develop", "services/api/main.go")); res.Message != "branch not matched" {
+		t.Fatalf("develop: %+v", res)
+	}
+	if res := push(github(), githubPush("refs/heads/feature/team/x", "README.md", "services/web/app.ts")); res.Triggered != 0 || res.Message != "no matching paths changed" {
+		t.Fatalf("README-only push built: %+v", res)
+	}
+	if res := push(github(), githubPush("refs/heads/release/1.2", "services/api/README.md", "services/api/handler.go")); res.Triggered != 1 {
+		t.Fatalf("api push not built: %+v", res)
+	}
+	if diff.calls != 0 {
+		t.Fatalf("payload listed the files but git diff ran %d times", diff.calls)
+	}
+
+	// Bitbucket lists no files: diff old..new head.
+	bitbucket := `{"push":{"changes":[{"new":{"type":"branch","name":"main","target":{"hash":"` + sha + `"}},"old":{"target":{"hash":"abc"}}}]}}`
+	diff.files = []string{"docs/guide.md"}
+	if res := push(map[string]string{"X-Event-Key": "repo:push"}, bitbucket); res.Triggered != 0 || diff.calls != 1 {
+		t.Fatalf("bitbucket docs push: %+v calls=%d", res, diff.calls)
+	}
+	diff.files = []string{"go.mod"}
+	if res := push(map[string]string{"X-Event-Key": "repo:push"}, bitbucket); res.Triggered != 1 {
+		t.Fatalf("bitbucket go.mod push: %+v", res)
+	}
+
+	// A new branch has nothing to diff against: build rather than miss a change.
+	created := `{"ref":"refs/heads/feature/new","before":"0000000000000000000000000000000000000000","after":"` + sha + `","commits":[]}`
+	if res := push(github(), created); res.Triggered != 1 || res.Message == "" {
+		t.Fatalf("new branch: %+v", res)
+	}
+}
+
+func TestWebhook_RepositoryFansOutToJobs(t *testing.T) {
+	_, repoSvc, _, jobSvc, runSvc, gdb := setupCICD(t)
+
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "wh-fanout", RepoURL: "https://example.com/fanout.git", AuthType: "none",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := json.Marshal(repo)
+	if repo.WebhookSecret == "" || strings.Contains(string(raw), repo.WebhookSecret) {
+		t.Fatalf("repository secret missing or leaked in JSON: %s", raw)
+	}
+	web, _ := createWebhookJob(t, jobSvc, repo.ID, "fan-web", "main", true)
+	api, _ := createWebhookJob(t, jobSvc, repo.ID, "fan-api", "main", true)
+	if _, err := jobSvc.Update(api.ID, service.UpdateBuildJobInput{PathIncludes: strPtr("api/**")}, false); err != nil {
+		t.Fatal(err)
+	}
+	dev, _ := createWebhookJob(t, jobSvc, repo.ID, "fan-dev", "develop", true)
+	off, _ := createWebhookJob(t, jobSvc, repo.ID, "fan-off", "main", false)
+
+	wh := newWebhookSvc(gdb, runSvc)
+	if _, err := wh.ReceiveRepository(repo.ID, "wrong", map[string]string{}, []byte(`{}`)); !service.IsUnauthorized(err) {
+		t.Fatalf("wrong secret: err=%v", err)
+	}
+	revealed, err := repoSvc.GetWebhookSecret(repo.ID)
+	if err != nil || revealed.WebhookSecret != repo.WebhookSecret {
+		t.Fatalf("webhook secret=%+v err=%v", revealed, err)
+	}
+
+	body := []byte(`{"ref":"refs/heads/main","before":"1111111111111111111111111111111111111111","after":"2222222222222222222222222222222222222222","commits":[{"modified":["web/app.ts"]}]}`)
+	mac := hmac.New(sha256.New, []byte(repo.WebhookSecret))
+	mac.Write(body)
+	headers := map[string]string{
+		"X-GitHub-Event":      "push",
+		"X-GitHub-Delivery":   "fanout-1",
+		"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(mac.Sum(nil)),
+	}
+	res, err := wh.ReceiveRepository(repo.ID, repo.WebhookSecret, headers, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Triggered != 1 || len(res.JobIDs) != 1 || res.JobIDs[0] != web.ID || res.Branch != "main" || res.Outcome != service.DeliveryTriggered {
+		t.Fatalf("result=%+v", res)
+	}
+	want := map[uint]string{
+		web.ID: "",
+		api.ID: "no matching paths changed",
+		dev.ID: "branch not matched",
+		off.ID: "webhook trigger disabled",
+	}
+	if len(res.Jobs) != len(want) {
+		t.Fatalf("jobs=%+v", res.Jobs)
+	}
+	for _, item := range res.Jobs {
+		if reason, ok := want[item.JobID]; !ok || item.Reason != reason || item.Triggered != (item.JobID == web.ID) {
+			t.Fatalf("job %d (%s): %+v", item.JobID, item.JobName, item)
+		}
+	}
+
+	// Dedup is per repository: the same delivery again enqueues nothing.
+	res, err = wh.ReceiveRepository(repo.ID, repo.WebhookSecret, headers, body)
+	if err != nil || !res.Duplicate || res.Triggered != 0 {
+		t.Fatalf("duplicate: %+v err=%v", res, err)
+	}
+
+	// When the only matching job fails to enqueue, the delivery failed.
+	required := []model.BuildParameter{{Name: "VERSION", Type: "string", Required: true}}
+	if _, err := jobSvc.Update(web.ID, service.UpdateBuildJobInput{Parameters: &required}, false); err != nil {
+		t.Fatal(err)
+	}
+	headers["X-GitHub-Delivery"] = "fanout-2"
+	res, err = wh.ReceiveRepository(repo.ID, repo.WebhookSecret, headers, body)
+	if err != nil || res.Triggered != 0 || res.Outcome != service.DeliveryEnqueueFailed {
+		t.Fatalf("enqueue failed: %+v err=%v", res, err)
+	}
+
+	// Rotation invalidates the old URL secret.
+	rotated, err := repoSvc.RotateWebhookSecret(repo.ID)
+	if err != nil || rotated.WebhookSecret == repo.WebhookSecret {
+		t.Fatalf("rotate: %+v err=%v", rotated, err)
+	}
+	if _, err := wh.ReceiveRepository(repo.ID, repo.WebhookSecret, map[string]string{}, body); !service.IsUnauthorized(err) {
+		t.Fatalf("old secret after rotation: err=%v", err)
+	}
+}
+
+func TestWebhook_DeliveryHistoryAndReplay(t *testing.T) {
+	_, repoSvc, _, jobSvc, runSvc, gdb := setupCICD(t)
+
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "wh-history", RepoURL: "https://example.com/history.git", AuthType: "none",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, secret := createWebhookJob(t, jobSvc, repo.ID, "job-history", "main", true)
+	wh := newWebhookSvc(gdb, runSvc)
+
+	if _, err := wh.Receive(job.ID, "wrong-secret", map[string]string{}, []byte(`{"ref":"refs/heads/main"}`)); !service.IsUnauthorized(err) {
+		t.Fatalf("err=%v", err)
+	}
+	// Gitee echoes the hook password in the payload and the token header.
+	gitee := func(ref, delivery string) (*service.WebhookResult, error) {
+		body := fmt.Sprintf(`{"ref":%q,"after":"abc123","password":%q,"head_commit":{"id":"abc123","message":"m"}}`, ref, secret)
+		return wh.Receive(job.ID, secret, map[string]string{
+			"X-Gitee-Event": "Push Hook", "X-Gitee-Token": secret, "X-Request-Id": delivery,
+		}, []byte(body))
+	}
+	first, err := gitee("refs/heads/main", "hist-1")
+	if err != nil || first.Triggered != 1 || first.DeliveryID == 0 {
+		t.Fatalf("first=%+v err=%v", first, err)
+	}
+	if res, err := gitee("refs/heads/main", "hist-1"); err != nil || !res.Duplicate {
+		t.Fatalf("duplicate=%+v err=%v", res, err)
+	}
+	if _, err := gitee("refs/heads/develop", "hist-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	items, total, err := wh.ListDeliveries(job.ID, "", 1, 20)
+	if err != nil || total != 4 {
+		t.Fatalf("total=%d err=%v", total, err)
+	}
+	var outcomes []string
+	for _, it := range items {
+		outcomes = append(outcomes, it.Outcome)
+		if it.Body != "" {
+			t.Fatalf("list returned a body: %+v", it)
+		}
+	}
+	if got := strings.Join(outcomes, ","); got != "branch_not_matched,duplicate,triggered,unauthorized" {
+		t.Fatalf("outcomes=%s", got)
+	}
+
+	rec, err := wh.GetDelivery(job.ID, first.DeliveryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Platform != "gitee" || rec.Event != "Push Hook" || rec.Ref != "refs/heads/main" || rec.CommitHash != "abc123" ||
+		len(rec.RunIDs) != 1 || rec.RunIDs[0] != first.RunIDs[0] || rec.Headers["X-Gitee-Token"] != "***" {
+		t.Fatalf("record=%+v", rec)
+	}
+	if strings.Contains(rec.Body, secret) || strings.Contains(rec.HeadersJSON, secret) || !strings.Contains(rec.Body, `"password":"***"`) {
+		t.Fatalf("secret recorded: headers=%s body=%s", rec.HeadersJSON, rec.Body)
+	}
+
+	// Replay honours dedup unless asked to bypass it.
+	if res, err := wh.ReplayDelivery(job.ID, first.DeliveryID, false); err != nil || !res.Duplicate {
+		t.Fatalf("replay=%+v err=%v", res, err)
+	}
+	res, err := wh.ReplayDelivery(job.ID, first.DeliveryID, true)
+	if err != nil || res.Triggered != 1 || res.RunIDs[0] == first.RunIDs[0] {
+		t.Fatalf("bypass replay=%+v err=%v", res, err)
+	}
+	replayed, err := wh.GetDelivery(job.ID, res.DeliveryID)
+	if err != nil || replayed.ReplayOf == nil || *replayed.ReplayOf != first.DeliveryID {
+		t.Fatalf("replay record=%+v err=%v", replayed, err)
+	}
+	if _, total, _ := wh.ListDeliveries(job.ID, "triggered", 1, 20); total != 2 {
+		t.Fatalf("triggered deliveries=%d", total)
+	}
+
+	// Truncated payloads are kept for inspection but can't be replayed.
+	big := fmt.Sprintf(`{"ref":"refs/heads/main","after":"def456","message":%q}`, strings.Repeat("x", 70<<10))
+	res, err = wh.Receive(job.ID, secret, map[string]string{"X-Request-Id": "hist-big"}, []byte(big))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := wh.GetDelivery(job.ID, res.DeliveryID); !rec.BodyTruncated || rec.BodySize != len(big) {
+		t.Fatalf("big record=%+v", rec)
+	}
+	if _, err := wh.ReplayDelivery(job.ID, res.DeliveryID, true); err == nil {
+		t.Fatal("replayed a truncated payload")
+	}
+
+	// An enqueue error is classified as such and kept in the message.
+	required := []model.BuildParameter{{Name: "VERSION", Type: "string", Required: true}}
+	if _, err := jobSvc.Update(job.ID, service.UpdateBuildJobInput{Parameters: &required}, false); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = gitee("refs/heads/main", "hist-3"); err != nil || res.Outcome != service.DeliveryEnqueueFailed {
+		t.Fatalf("enqueue failed=%+v err=%v", res, err)
+	}
+	if rec, _ := wh.GetDelivery(job.ID, res.DeliveryID); rec.Outcome != service.DeliveryEnqueueFailed || !strings.Contains(rec.Message, "VERSION") {
+		t.Fatalf("enqueue failed record=%+v", rec)
+	}
+
+	// Another job can't read or replay this job's deliveries.
+	other, _ := createWebhookJob(t, jobSvc, repo.ID, "job-other", "main", true)
+	if _, err := wh.ReplayDelivery(other.ID, first.DeliveryID, true); !service.IsNotFound(err) {
+		t.Fatalf("cross-job replay err=%v", err)
+	}
+}
+
+func toJSON(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestWebhook_MergeRequestPayloads(t *testing.T) {
+	_, repoSvc, _, jobSvc, runSvc, gdb := setupCICD(t)
+
+	repo, err := repoSvc.Create(1, resourceservice.CreateRepositoryInput{
+		Name: "wh-mr", RepoURL: "https://example.com/mr.git", AuthType: "none",
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, secret := createWebhookJob(t, jobSvc, repo.ID, "job-mr", "main", true)
+	if _, err := jobSvc.Update(job.ID, service.UpdateBuildJobInput{TriggerPullRequest: boolPtr(true)}, false); err != nil {
+		t.Fatal(err)
+	}
+	wh := newWebhookSvc(gdb, runSvc)
+	cases := []struct {
+		headers map[string]string
+		body    string
+		ref     string
+		author  string
+	}{
+		{
+			map[string]string{"X-Gitlab-Event": "Merge Request Hook", "X-Gitlab-Token": secret, "X-Gitlab-Event-UUID": "mr-1"},
+			`{"user":{"username":"bob"},"object_attributes":{"iid":3,"action":"open","title":"t",
+				"source_branch":"fix","target_branch":"main","last_commit":{"id":"abc"}}}`,
+			"refs/merge-requests/3/head", "bob",
+		},
+		{
+			map[string]string{"X-Gitee-Event": "Merge Request Hook", "X-Gitee-Token": secret, "X-Request-Id": "mr-2"},
+			`{"action":"update","action_desc":"source_branch_changed","pull_request":{"number":4,"title":"t",
+				"head":{"ref":"fix","sha":"abc"},"base":{"ref":"main"},"user":{"login":"carol"}}}`,
+			"refs/pull/4/head", "carol",
+		},
+		{
+			map[string]string{"X-Event-Key": "pullrequest:created", "X-Request-Id": "mr-3"},
+			`{"pullrequest":{"id":5,"title":"t","author":{"nickname":"dave"},
+				"source":{"branch":{"name":"fix"},"commit":{"hash":"abc123def456"}},
+				"destination":{"branch":{"name":"main"}}}}`,
+			"refs/heads/fix", "dave",
+		},
+	}
+	for i, c := range cases {
+		res, err := wh.Receive(job.ID, secret, c.headers, []byte(c.body))
+		if err != nil || res.Triggered != 1 {
+			t.Fatalf("case %d: %+v err=%v", i, res, err)
+		}
+		run, _ := runSvc.Get(res.RunIDs[0])
+		if run.GitRef != c.ref || run.PRAuthor != c.author || run.PRTargetBranch != "main" {
+			t.Fatalf("case %d: run=%+v", i, run)
+		}
+	}
+
+	// GitLab MR updates without new commits (no oldrev) are ignored.
+	res, err := wh.Receive(job.ID, secret,
+		map[string]string{"X-Gitlab-Event": "Merge Request Hook", "X-Gitlab-Token": secret, "X-Gitlab-Event-UUID": "mr-4"},
+		[]byte(`{"object_attributes":{"iid":3,"action":"update","source_branch":"fix","target_branch":"main"}}`))
+	if err != nil || res.Triggered != 0 {
+		t.Fatalf("metadata update: %+v err=%v", res, err)
+	}
+}
+
 func createWebhookJob(
 	t *testing.T,
 	jobSvc *service.BuildJobService,
@@ -204,7 +699,7 @@ func createWebhookJob(
 		Branch:         branch,
 		TriggerWebhook: boolPtr(triggerWebhook),
 		BuildScript:    "echo ok",
-	})
+	}, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -225,6 +720,7 @@ func createWebhookJob(
 func newWebhookSvc(gdb *gorm.DB, runSvc *service.BuildRunService) *service.WebhookService {
 	return service.NewWebhookService(
 		repository.NewBuildJobRepository(gdb),
+		resourcerepo.NewRepositoryRepository(gdb),
 		repository.NewWebhookDeliveryRepository(gdb),
 		runSvc,
 	)
@@ -3,6 +3,7 @@ package handler
 import (
 	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 
@@ -28,6 +29,7 @@ func (h *DashboardHandler) RegisterRoutes(rg *gin.RouterGroup, authMW gin.Handle
 	g.GET("/layout", h.GetLayout)
 	g.PUT("/layout", h.PutLayout)
 	g.GET("/build-summary", rbacmw.RequirePermission(h.perm, "cicd_build_runs:view"), h.BuildSummary)
+	g.GET("/coverage-trend", rbacmw.RequirePermission(h.perm, "cicd_build_runs:view"), h.CoverageTrend)
 	g.GET("/agent-run-summary", rbacmw.RequirePermission(h.perm, "ai_runs:view"), h.AgentRunSummary)
 	g.GET("/system-info", rbacmw.RequirePermission(h.perm, "dashboard:system_info"), h.SystemInfo)
 	g.GET("/system-status", rbacmw.RequirePermission(h.perm, "dashboard:system_status"), h.SystemStatus)
@@ -77,6 +79,21 @@ func (h *DashboardHandler) BuildSummary(c *gin.Context) {
 	pkg.Success(c, result)
 }
 
+func (h *DashboardHandler) CoverageTrend(c *gin.Context) {
+	jobID, err := strconv.ParseUint(c.Query("build_job_id"), 10, 64)
+	if err != nil || jobID == 0 {
+		pkg.Error(c, http.StatusBadRequest, "build_job_id 无效")
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	result, err := h.svc.CoverageTrend(uint(jobID), limit)
+	if err != nil {
+		pkg.Error(c, http.StatusInternalServerError, "读取覆盖率趋势失败")
+		return
+	}
+	pkg.Success(c, result)
+}
+
 func (h *DashboardHandler) AgentRunSummary(c *gin.Context) {
 	result, err := h.svc.AgentRunSummary()
 	if err != nil {
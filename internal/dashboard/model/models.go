@@ -48,6 +48,24 @@ type RecentRun struct {
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// CoverageTrend is line coverage of one BuildJob's recent runs, oldest first.
+type CoverageTrend struct {
+	BuildJobID uint            `json:"build_job_id"`
+	Points     []CoveragePoint `json:"points"`
+	Latest     *float64        `json:"latest"`
+	// Delta is latest minus the previous point (nil with fewer than two points).
+	Delta *float64 `json:"delta"`
+}
+
+type CoveragePoint struct {
+	RunID           uint      `json:"run_id" gorm:"column:id"`
+	BuildNumber     int       `json:"build_number"`
+	Status          string    `json:"status"`
+	Branch          string    `json:"branch"`
+	CoveragePercent float64   `json:"coverage_percent"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
 type AgentRunSummary struct {
 	Running     int64            `json:"running"`
 	Queued      int64            `json:"queued"`
@@ -37,7 +37,7 @@ func (r *DashboardRepository) CountRunsByStatus(status string) (int64, error) {
 
 func (r *DashboardRepository) CountFinishedRuns() (total, success int64, err error) {
 	err = r.db.Table("build_runs").
-		Where("status IN ?", []string{"success", "failed", "cancelled", "interrupted"}).
+		Where("status IN ?", []string{"success", "failed", "cancelled", "interrupted", "timed_out"}).
 		Count(&total).Error
 	if err != nil {
 		return 0, 0, err
@@ -54,6 +54,16 @@ func (r *DashboardRepository) ListRecentRuns(limit int) ([]model.RecentRun, erro
 	return rows, err
 }
 
+// ListCoveragePoints returns the latest runs of a job that recorded coverage, newest first.
+func (r *DashboardRepository) ListCoveragePoints(jobID uint, limit int) ([]model.CoveragePoint, error) {
+	var rows []model.CoveragePoint
+	err := r.db.Table("build_runs").
+		Select("id, build_number, status, branch, coverage_percent, created_at").
+		Where("build_job_id = ? AND coverage_percent IS NOT NULL", jobID).
+		Order("id DESC").Limit(limit).Scan(&rows).Error
+	return rows, err
+}
+
 func (r *DashboardRepository) CountAgentRunsByStatus(status string) (int64, error) {
 	var total int64
 	err := r.db.Table("agent_runs").Where("status = ?", status).Count(&total).Error
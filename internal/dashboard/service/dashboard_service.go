@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -131,6 +132,39 @@ func (s *DashboardService) BuildSummary() (*model.BuildSummary, error) {
 	return &model.BuildSummary{Running: running, Queued: queued, SuccessRate: rate, Recent: recent}, nil
 }
 
+// Coverage trend window: default and maximum number of runs.
+const (
+	defaultCoveragePoints = 30
+	maxCoveragePoints     = 200
+)
+
+func (s *DashboardService) CoverageTrend(jobID uint, limit int) (*model.CoverageTrend, error) {
+	if limit <= 0 {
+		limit = defaultCoveragePoints
+	}
+	if limit > maxCoveragePoints {
+		limit = maxCoveragePoints
+	}
+	points, err := s.repo.ListCoveragePoints(jobID, limit)
+	if err != nil {
+		return nil, err
+	}
+	// Chart order: oldest first.
+	for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+		points[i], points[j] = points[j], points[i]
+	}
+	trend := &model.CoverageTrend{BuildJobID: jobID, Points: points}
+	if n := len(points); n > 0 {
+		latest := points[n-1].CoveragePercent
+		trend.Latest = &latest
+		if n > 1 {
+			delta := math.Round((latest-points[n-2].CoveragePercent)*100) / 100
+			trend.Delta = &delta
+		}
+	}
+	return trend, nil
+}
+
 func (s *DashboardService) AgentRunSummary() (*model.AgentRunSummary, error) {
 	running, err := s.repo.CountAgentRunsByStatus("running")
 	if err != nil {
@@ -14,6 +14,8 @@ import (
 	"bedrock/internal/platform/db"
 	"bedrock/internal/platform/migration"
 	_ "bedrock/internal/platform/migration/migrations"
+
+	"gorm.io/gorm"
 )
 
 func TestSystemStatusReportsHostDiskAndDirectorySizes(t *testing.T) {
@@ -254,7 +256,50 @@ func TestDefaultLayoutIncludesGeometry(t *testing.T) {
 	}
 }
 
+func TestCoverageTrendIsChronologicalWithDelta(t *testing.T) {
+	gdb := newDashboardDB(t)
+	svc := NewDashboardService(repository.NewDashboardRepository(gdb), "test", time.Now(), []string{"."})
+	for i, pct := range []interface{}{71.5, nil, 80.25, 78.0} {
+		err := gdb.Exec(
+			"INSERT INTO build_runs (build_job_id, build_number, status, stage, coverage_percent, created_at) VALUES (?, ?, 'success', 'idle', ?, ?)",
+			7, i+1, pct, time.Now(),
+		).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := gdb.Exec(
+		"INSERT INTO build_runs (build_job_id, build_number, status, stage, coverage_percent, created_at) VALUES (8, 1, 'success', 'idle', 10, ?)",
+		time.Now(),
+	).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	trend, err := svc.CoverageTrend(7, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trend.Points) != 3 || trend.Points[0].BuildNumber != 1 || trend.Points[2].BuildNumber != 4 || trend.Points[2].RunID == 0 {
+		t.Fatalf("points = %#v", trend.Points)
+	}
+	if trend.Latest == nil || *trend.Latest != 78 || trend.Delta == nil || *trend.Delta != -2.25 {
+		t.Fatalf("latest = %v delta = %v", trend.Latest, trend.Delta)
+	}
+	trend, err = svc.CoverageTrend(7, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trend.Points) != 1 || trend.Delta != nil {
+		t.Fatalf("limited trend = %#v", trend)
+	}
+}
+
 func newDashboardRepository(t *testing.T) *repository.DashboardRepository {
+	t.Helper()
+	return repository.NewDashboardRepository(newDashboardDB(t))
+}
+
+func newDashboardDB(t *testing.T) *gorm.DB {
 	t.Helper()
 	gdb, err := db.Open(&config.DatabaseConfig{
 		Driver: "sqlite",
@@ -272,5 +317,5 @@ func newDashboardRepository(t *testing.T) *repository.DashboardRepository {
 	if err := migration.Up(context.Background(), gdb, "sqlite"); err != nil {
 		t.Fatal(err)
 	}
-	return repository.NewDashboardRepository(gdb)
+	return gdb
 }
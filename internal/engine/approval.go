@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"bedrock/internal/cicd/model"
+	"bedrock/internal/pkg"
+)
+
+// Deployment approval (BuildDeployApproval). A run whose distribution
+// reaches a gated target parks in StageWaitingApproval and returns its
+// scheduler slot; deciding the approval submits it again to distribute.
+const (
+	StageWaitingApproval = "waiting_approval"
+
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+
+	// RejectSkip skips the gated targets and deploys the others;
+	// RejectCancel cancels the whole batch.
+	RejectSkip   = "skip"
+	RejectCancel = "cancel"
+)
+
+// ApprovalNotifier tells approvers a run waits for a deployment decision.
+type ApprovalNotifier interface {
+	NotifyDeployApproval(userIDs []uint, buildRunID uint, buildNumber int, jobName, environments string)
+}
+
+// SetApprovalNotifier wires approval requests to the approvers' inbox.
+func (p *Pipeline) SetApprovalNotifier(n ApprovalNotifier) {
+	p.approvals = n
+}
+
+// NeedsApproval reports whether deploying to t waits for approval: the target
+// requires it or its environment is one of the job's ApprovalEnvironments.
+func NeedsApproval(job *model.BuildJob, t *model.DeployTarget) bool {
+	if t.RequiresApproval {
+		return true
+	}
+	env := strings.TrimSpace(t.Environment)
+	if env == "" {
+		return false
+	}
+	return slices.ContainsFunc(ParseFilterPatterns(job.ApprovalEnvironments), func(e string) bool {
+		return strings.EqualFold(e, env)
+	})
+}
+
+// DecodeDeployApprovers fills job.DeployApproverIDs from its JSON column.
+func DecodeDeployApprovers(job *model.BuildJob) {
+	job.DeployApproverIDs = []uint{}
+	if strings.TrimSpace(job.DeployApproverIDsJSON) != "" {
+		_ = json.Unmarshal([]byte(job.DeployApproverIDsJSON), &job.DeployApproverIDs)
+	}
+}
+
+// DecodeApprovalTargets fills a.Targets from its snapshot.
+func DecodeApprovalTargets(a *model.BuildDeployApproval) {
+	a.Targets = []model.DeployTarget{}
+	if strings.TrimSpace(a.TargetsJSON) != "" {
+		_ = json.Unmarshal([]byte(a.TargetsJSON), &a.Targets)
+	}
+}
+
+// requestApproval parks the run when one of targets is gated: it records a
+// pending approval for the next batch and notifies the approvers. It reports
+// whether distribution must stop here. Redeploys are gated too.
+func (p *Pipeline) requestApproval(run *model.BuildRun, job *model.BuildJob, targets []model.DeployTarget, writeLine func(string)) bool {
+	var gated []model.DeployTarget
+	var envs, labels []string
+	for i := range targets {
+		t := &targets[i]
+		if !NeedsApproval(job, t) {
+			continue
+		}
+		gated = append(gated, *t)
+		labels = append(labels, fmt.Sprintf("#%d %s → %s", t.ID, t.Method, t.RemotePath))
+		if env := strings.TrimSpace(t.Environment); env != "" && !slices.Contains(envs, env) {
+			envs = append(envs, env)
+		}
+	}
+	if len(gated) == 0 {
+		return false
+	}
+	batchNo, err := p.runs.NextBatchNo(run.ID)
+	if err != nil || batchNo < 1 {
+		batchNo = 1
+	}
+	snap, _ := json.Marshal(gated)
+	approval := &model.BuildDeployApproval{
+		BuildRunID:   run.ID,
+		BatchNo:      batchNo,
+		Status:       ApprovalPending,
+		Environments: strings.Join(envs, ","),
+		TargetsJSON:  string(snap),
+	}
+	if err := p.runs.CreateApproval(approval); err != nil {
+		// Never deploy past the gate without a decision.
+		writeLine("ERROR: 创建部署审批失败: " + err.Error())
+		_ = p.runs.UpdateFields(run.ID, map[string]interface{}{
+			"stage":                "idle",
+			"distribution_summary": "all_failed",
+		})
+		p.broadcastRunRefresh(run.ID)
+		return true
+	}
+	run.Stage = StageWaitingApproval
+	_ = p.runs.UpdateFields(run.ID, map[string]interface{}{
+		"status":               "success",
+		"stage":                StageWaitingApproval,
+		"distribution_summary": StageWaitingApproval,
+	})
+	p.broadcastRunRefresh(run.ID)
+	writeLine(fmt.Sprintf("=== Waiting for deployment approval (batch %d): %s ===", batchNo, strings.Join(labels, ", ")))
+	p.statuses.Report(run.ID, CommitStateSuccess, "Build succeeded, waiting for deployment approval")
+
+	DecodeDeployApprovers(job)
+	approvers := job.DeployApproverIDs
+	if _, by, _ := deploymentOrigin(run); len(approvers) == 0 && by != 0 {
+		approvers = []uint{by}
+	}
+	if len(approvers) == 0 {
+		writeLine("WARNING: 没有可通知的部署审批人（任务未配置审批人且构建无触发用户），请由有审批权限的用户在运行详情中审批")
+	} else if p.approvals != nil {
+		p.approvals.NotifyDeployApproval(approvers, run.ID, run.BuildNumber, job.Name, approval.Environments)
+	}
+	return true
+}
+
+// decidedApproval returns the run's latest approval when it was decided but
+// not applied yet.
+func (p *Pipeline) decidedApproval(runID uint) *model.BuildDeployApproval {
+	approval, err := p.runs.LatestApproval(runID)
+	if err != nil || approval.Status == ApprovalPending || approval.AppliedAt != nil {
+		return nil
+	}
+	return approval
+}
+
+// resumeApproval applies the decision on the run's waiting batch: approved
+// deploys every target; rejected records the gated targets (or, with
+// RejectCancel, every target) as not deployed and deploys the rest.
+// Artifacts come from the store, as for a redeploy.
+func (p *Pipeline) resumeApproval(ctx context.Context, run *model.BuildRun, job *model.BuildJob, approval *model.BuildDeployApproval, redact *pkg.Redactor, writeLine func(string)) {
+	decision := approval.Status
+	if decision == ApprovalRejected {
+		decision += " (" + approval.RejectAction + ")"
+	}
+	writeLine(fmt.Sprintf("=== Deployment %s by %s ===", decision, approval.DecidedByName))
+	// Applied even when no target is left: a later redeploy is gated again.
+	if err := p.runs.MarkApprovalApplied(approval.ID); err != nil {
+		writeLine("ERROR: 记录审批执行失败: " + err.Error())
+		_ = p.runs.UpdateFields(run.ID, map[string]interface{}{
+			"stage":                "idle",
+			"distribution_summary": "all_failed",
+		})
+		p.broadcastRunRefresh(run.ID)
+		return
+	}
+	if approval.Comment != "" {
+		writeLine("Comment: " + approval.Comment)
+	}
+	targets, err := p.jobs.ListDeployTargets(job.ID)
+	if err != nil {
+		writeLine("ERROR: load deploy targets: " + err.Error())
+		_ = p.runs.UpdateFields(run.ID, map[string]interface{}{
+			"stage":                "idle",
+			"distribution_summary": "all_failed",
+		})
+		p.broadcastRunRefresh(run.ID)
+		return
+	}
+	DecodeRunArtifacts(run)
+	// A gated redeploy keeps its target selection.
+	targets = filterDeployTargets(targets, parseTargetFilterFromSnapshot(run.SnapshotJSON))
+	if approval.Status == ApprovalApproved {
+		p.distribute(ctx, run, job, "", redact, writeLine, targets, approval.BatchNo)
+		return
+	}
+
+	status := "skipped"
+	if approval.RejectAction == RejectCancel {
+		status = "cancelled"
+	}
+	var rest []model.DeployTarget
+	for i := range targets {
+		if approval.RejectAction == RejectCancel || NeedsApproval(job, &targets[i]) {
+			p.recordAttemptStopped(run, approval.BatchNo, 1, &targets[i], status, "部署审批被拒绝")
+			continue
+		}
+		rest = append(rest, targets[i])
+	}
+	if len(rest) == 0 {
+		_ = p.runs.UpdateFields(run.ID, map[string]interface{}{
+			"status":               "success",
+			"stage":                "idle",
+			"distribution_summary": "rejected",
+		})
+		p.broadcastRunRefresh(run.ID)
+		writeLine("=== Distribution phase finished (rejected) ===")
+		p.statuses.Report(run.ID, CommitStateSuccess, "Build succeeded, deployment rejected")
+		return
+	}
+	p.distribute(ctx, run, job, "", redact, writeLine, rest, approval.BatchNo)
+}
@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+	resourcemodel "bedrock/internal/resource/model"
+)
+
+type approvalRecorder struct{ userIDs []uint }
+
+func (r *approvalRecorder) NotifyDeployApproval(userIDs []uint, _ uint, _ int, _, _ string) {
+	r.userIDs = append(r.userIDs, userIDs...)
+}
+
+func TestNeedsApproval(t *testing.T) {
+	t.Parallel()
+	job := &model.BuildJob{ApprovalEnvironments: "prod\nPre"}
+	cases := []struct {
+		target model.DeployTarget
+		want   bool
+	}{
+		{model.DeployTarget{}, false},
+		{model.DeployTarget{Environment: "staging"}, false},
+		{model.DeployTarget{Environment: "prod"}, true},
+		{model.DeployTarget{Environment: "pre"}, true},
+		{model.DeployTarget{Environment: "staging", RequiresApproval: true}, true},
+	}
+	for i, c := range cases {
+		if got := NeedsApproval(job, &c.target); got != c.want {
+			t.Errorf("case %d: got %v want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestPipeline_deployApprovalGate(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	repoDir := initLocalGitRepo(t)
+	tmp := t.TempDir()
+	stagingDest, prodDest := filepath.Join(tmp, "staging"), filepath.Join(tmp, "prod")
+
+	setup := func(runID uint) (*memRunStore, *Pipeline, *approvalRecorder) {
+		store := newMemRunStore(&model.BuildRun{ID: runID, BuildJobID: 10, BuildNumber: int(runID), Status: "queued", Stage: "pending", Branch: "main"})
+		jobStore := &memJobStore{
+			job: &model.BuildJob{
+				ID: 10, RepositoryID: 1, Branch: "main",
+				BuildScript: "mkdir -p dist && echo bin > dist/app", OutputDir: "dist", MaxArtifacts: 5,
+				ApprovalEnvironments: "prod", DeployApproverIDsJSON: "[7]",
+			},
+			targets: []model.DeployTarget{
+				{ID: 1, BuildJobID: 10, Method: "local", RemotePath: stagingDest, Environment: "staging"},
+				{ID: 2, BuildJobID: 10, Method: "local", RemotePath: prodDest, Environment: "prod"},
+			},
+		}
+		repoStore := &memRepoStore{repo: &resourcemodel.Repository{ID: 1, RepoURL: repoDir, AuthType: "none"}}
+		p := NewPipeline(store, jobStore, repoStore, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(),
+			filepath.Join(tmp, "ws"), filepath.Join(tmp, "a"), filepath.Join(tmp, "l"), filepath.Join(tmp, "c"))
+		p.SetArtifactStore(newMemArtifactStore(t))
+		notified := &approvalRecorder{}
+		p.SetApprovalNotifier(notified)
+		return store, p, notified
+	}
+
+	// The build parks before any target is deployed.
+	store, p, notified := setup(1)
+	p.Execute(context.Background(), 1)
+	run, _ := store.FindByID(1)
+	if run.Status != "success" || run.Stage != StageWaitingApproval || run.DistributionSummary != StageWaitingApproval {
+		t.Fatalf("status=%s stage=%s summary=%s err=%q", run.Status, run.Stage, run.DistributionSummary, run.ErrorMessage)
+	}
+	if len(store.attempts) != 0 || len(store.approvals) != 1 || len(notified.userIDs) != 1 || notified.userIDs[0] != 7 {
+		t.Fatalf("attempts=%+v approvals=%+v notified=%v", store.attempts, store.approvals, notified.userIDs)
+	}
+	a := store.approvals[0]
+	if a.Status != ApprovalPending || a.BatchNo != 1 || a.Environments != "prod" {
+		t.Fatalf("approval=%+v", a)
+	}
+
+	// Approved: every target deploys from the stored artifact in the approval's batch.
+	store.approvals[0].Status = ApprovalApproved
+	p.Execute(context.Background(), 1)
+	run, _ = store.FindByID(1)
+	if run.DistributionSummary != "all_success" || run.Stage != "idle" {
+		t.Fatalf("approved: stage=%s summary=%s", run.Stage, run.DistributionSummary)
+	}
+	if len(store.attempts) != 2 || store.attempts[0].BatchNo != 1 || store.attempts[1].BatchNo != 1 {
+		t.Fatalf("approved attempts=%+v", store.attempts)
+	}
+	for _, dest := range []string{stagingDest, prodDest} {
+		if _, err := os.Stat(filepath.Join(dest, "app")); err != nil {
+			t.Fatalf("%s not deployed: %v", dest, err)
+		}
+	}
+
+	// A redeploy is gated again, as the next batch.
+	_ = store.UpdateFields(1, map[string]interface{}{"trigger_type": "redeploy"})
+	p.Execute(context.Background(), 1)
+	run, _ = store.FindByID(1)
+	if run.Stage != StageWaitingApproval || len(store.approvals) != 2 || store.approvals[1].BatchNo != 2 || len(store.attempts) != 2 {
+		t.Fatalf("redeploy: stage=%s approvals=%+v attempts=%d", run.Stage, store.approvals, len(store.attempts))
+	}
+
+	// An approval with no target left to deploy is still applied: the next
+	// redeploy waits for a new decision.
+	store, p, _ = setup(3)
+	p.Execute(context.Background(), 3)
+	jobs := p.jobs.(*memJobStore)
+	saved := jobs.targets
+	jobs.targets = nil
+	store.approvals[0].Status = ApprovalApproved
+	p.Execute(context.Background(), 3)
+	if len(store.attempts) != 0 || store.approvals[0].AppliedAt == nil {
+		t.Fatalf("empty approval: attempts=%+v approval=%+v", store.attempts, store.approvals[0])
+	}
+	jobs.targets = saved
+	_ = store.UpdateFields(3, map[string]interface{}{"trigger_type": "redeploy"})
+	p.Execute(context.Background(), 3)
+	run, _ = store.FindByID(3)
+	if run.Stage != StageWaitingApproval || len(store.approvals) != 2 || len(store.attempts) != 0 {
+		t.Fatalf("redeploy after empty approval: stage=%s approvals=%+v attempts=%+v", run.Stage, store.approvals, store.attempts)
+	}
+
+	// Rejected with skip: the gated target is recorded as skipped, the others deploy.
+	_ = os.RemoveAll(stagingDest)
+	_ = os.RemoveAll(prodDest)
+	store, p, _ = setup(4)
+	p.Execute(context.Background(), 4)
+	store.approvals[0].Status = ApprovalRejected
+	store.approvals[0].RejectAction = RejectSkip
+	p.Execute(context.Background(), 4)
+	run, _ = store.FindByID(4)
+	if run.DistributionSummary != "all_success" || len(store.attempts) != 2 {
+		t.Fatalf("rejected: summary=%s attempts=%+v", run.DistributionSummary, store.attempts)
+	}
+	byTarget := map[uint]string{}
+	for _, at := range store.attempts {
+		byTarget[*at.DeployTargetID] = at.Status
+	}
+	if byTarget[1] != "success" || byTarget[2] != "skipped" {
+		t.Fatalf("rejected attempts=%v", byTarget)
+	}
+	if _, err := os.Stat(prodDest); !os.IsNotExist(err) {
+		t.Fatalf("rejected target deployed: %v", err)
+	}
+}
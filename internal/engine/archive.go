@@ -11,8 +11,14 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+// artifactModTime is stamped on every archive entry (owners are dropped too),
+// so rebuilding identical output yields byte-identical archives that the
+// artifact store deduplicates.
+var artifactModTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
 func artifactArchiveName(buildNumber int, format string) string {
 	if NormalizeArtifactFormat(format) == "zip" {
 		return fmt.Sprintf("build-%03d.zip", buildNumber)
@@ -20,6 +26,13 @@ func artifactArchiveName(buildNumber int, format string) string {
 	return fmt.Sprintf("build-%03d.tar.gz", buildNumber)
 }
 
+func artifactContentType(format string) string {
+	if NormalizeArtifactFormat(format) == "zip" {
+		return "application/zip"
+	}
+	return "application/gzip"
+}
+
 // NormalizeArtifactFormat returns "zip" or "gzip" (default).
 func NormalizeArtifactFormat(format string) string {
 	switch strings.ToLower(strings.TrimSpace(format)) {
@@ -63,6 +76,9 @@ func createTarGz(targetPath, sourceDir string) error {
 			return err
 		}
 		header.Name = relPath
+		header.ModTime = artifactModTime
+		header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+		header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
 		if err := tw.WriteHeader(header); err != nil {
 			return err
 		}
@@ -106,6 +122,7 @@ func createZip(targetPath, sourceDir string) error {
 			return err
 		}
 		header.Name = filepath.ToSlash(relPath)
+		header.Modified = artifactModTime
 		if info.IsDir() {
 			header.Name += "/"
 		} else {
@@ -277,30 +294,6 @@ func parseCachePaths(raw string) []string {
 	return result
 }
 
-func copyDir(src, dst string) error {
-	srcInfo, err := os.Stat(src)
-	if err != nil {
-		return err
-	}
-	if err := os.MkdirAll(dst, srcInfo.Mode()); err != nil {
-		return err
-	}
-	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		relPath, err := filepath.Rel(src, path)
-		if err != nil {
-			return err
-		}
-		dstPath := filepath.Join(dst, relPath)
-		if info.IsDir() {
-			return os.MkdirAll(dstPath, info.Mode())
-		}
-		return copyFile(path, dstPath, info.Mode())
-	})
-}
-
 func copyFile(src, dst string, mode os.FileMode) error {
 	in, err := os.Open(src)
 	if err != nil {
@@ -0,0 +1,210 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"bedrock/internal/cicd/model"
+)
+
+// maxArtifactDefs bounds how many named artifacts one job may declare.
+const maxArtifactDefs = 20
+
+var artifactNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,99}$`)
+
+// ValidateArtifactName checks a named artifact (or DeployTarget reference).
+func ValidateArtifactName(name string) error {
+	if !artifactNamePattern.MatchString(name) {
+		return fmt.Errorf("%q must match [A-Za-z0-9][A-Za-z0-9._-]* (max 100)", name)
+	}
+	return nil
+}
+
+// ValidateArtifactDefs requires unique names and workspace-relative paths.
+func ValidateArtifactDefs(defs []model.ArtifactDef) error {
+	if len(defs) > maxArtifactDefs {
+		return fmt.Errorf("at most %d artifacts", maxArtifactDefs)
+	}
+	seen := map[string]bool{}
+	for _, d := range defs {
+		if err := ValidateArtifactName(d.Name); err != nil {
+			return err
+		}
+		if seen[d.Name] {
+			return fmt.Errorf("duplicate artifact %q", d.Name)
+		}
+		seen[d.Name] = true
+		if strings.TrimSpace(d.Path) == "" {
+			return fmt.Errorf("artifact %q: path is required", d.Name)
+		}
+		if err := validateRelPath(d.Path); err != nil {
+			return fmt.Errorf("artifact %q: path %w", d.Name, err)
+		}
+		switch strings.ToLower(strings.TrimSpace(d.Format)) {
+		case "", "gzip", "zip":
+		default:
+			return fmt.Errorf("artifact %q: format must be gzip or zip", d.Name)
+		}
+	}
+	return nil
+}
+
+// DecodeJobArtifactDefs fills BuildJob.ArtifactDefs from ArtifactDefsJSON.
+func DecodeJobArtifactDefs(job *model.BuildJob) {
+	if job == nil || job.ArtifactDefs != nil {
+		return
+	}
+	job.ArtifactDefs = []model.ArtifactDef{}
+	if strings.TrimSpace(job.ArtifactDefsJSON) == "" {
+		return
+	}
+	var defs []model.ArtifactDef
+	if err := json.Unmarshal([]byte(job.ArtifactDefsJSON), &defs); err == nil && defs != nil {
+		job.ArtifactDefs = defs
+	}
+}
+
+// collectNamedArtifacts archives every ArtifactDef of job after a successful
+// build and appends them to run.Artifacts (after any pipeline stage artifacts).
+// A definition that matches nothing is a warning, not a failure.
+func (p *Pipeline) collectNamedArtifacts(run *model.BuildRun, job *model.BuildJob, workDir string, writeLine func(string)) {
+	DecodeJobArtifactDefs(job)
+	if len(job.ArtifactDefs) == 0 {
+		return
+	}
+	writeLine("=== Stage: Collecting Named Artifacts ===")
+	taken := map[string]bool{}
+	for _, a := range run.Artifacts {
+		taken[a.Name] = true
+	}
+	added := 0
+	for _, d := range job.ArtifactDefs {
+		if taken[d.Name] {
+			writeLine(fmt.Sprintf("WARNING: 制品 %s 与流水线阶段产物重名，已跳过", d.Name))
+			continue
+		}
+		src, cleanup, err := stageArtifactSource(workDir, d.Path)
+		if err != nil {
+			writeLine(fmt.Sprintf("WARNING: 制品 %s 收集失败: %s", d.Name, err.Error()))
+			continue
+		}
+		format := d.Format
+		if strings.TrimSpace(format) == "" {
+			format = job.ArtifactFormat
+		}
+		art, err := p.archiveNamedArtifact(run, job, d.Name, src, format)
+		cleanup()
+		if err != nil {
+			writeLine(fmt.Sprintf("WARNING: 打包制品 %s 失败: %s", d.Name, err.Error()))
+			continue
+		}
+		run.Artifacts = append(run.Artifacts, *art)
+		taken[d.Name] = true
+		added++
+		writeLine(fmt.Sprintf("Artifact %s saved: %s (%d bytes, sha256 %s)", d.Name, art.FileName, art.Size, art.SHA256))
+	}
+	if added > 0 {
+		raw, _ := json.Marshal(run.Artifacts)
+		run.ArtifactsJSON = string(raw)
+		_ = p.runs.UpdateFields(run.ID, map[string]interface{}{"artifacts_json": run.ArtifactsJSON})
+		p.broadcastRunRefresh(run.ID)
+	}
+}
+
+// archiveNamedArtifact packs src as build-NNN-<name> and moves it into the store.
+func (p *Pipeline) archiveNamedArtifact(run *model.BuildRun, job *model.BuildJob, name, src, format string) (*model.RunArtifact, error) {
+	format = NormalizeArtifactFormat(format)
+	dir := filepath.Join(p.artifact, fmt.Sprintf("job-%d", job.ID))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	base := artifactArchiveName(run.BuildNumber, format)
+	ext := ".tar.gz"
+	if format == "zip" {
+		ext = ".zip"
+	}
+	safe := stageFileNameUnsafe.ReplaceAllString(name, "_")
+	target := filepath.Join(dir, strings.TrimSuffix(base, ext)+"-"+safe+ext)
+	if err := CreateArtifactArchive(target, src, format); err != nil {
+		_ = os.Remove(target)
+		return nil, err
+	}
+	art, err := p.storeArtifact(target, format, run.TriggeredBy)
+	if err != nil {
+		_ = os.Remove(target)
+		return nil, err
+	}
+	art.Name, art.FileName = name, filepath.Base(target)
+	return art, nil
+}
+
+// stageArtifactSource returns the directory to archive for pattern: a matched
+// directory as is, otherwise a temp copy of the matched files laid out below
+// the pattern's literal prefix ("web/dist/**/*.js" keeps paths under web/dist).
+func stageArtifactSource(workDir, pattern string) (string, func(), error) {
+	noop := func() {}
+	pattern = path.Clean(filepath.ToSlash(strings.TrimSpace(pattern)))
+	if !strings.ContainsAny(pattern, "*?[") {
+		full, err := resolveInWorkspace(workDir, pattern)
+		if err != nil {
+			return "", noop, err
+		}
+		info, err := os.Stat(full)
+		if err != nil {
+			return "", noop, fmt.Errorf("%s 不存在", pattern)
+		}
+		if info.IsDir() {
+			return full, noop, nil
+		}
+	}
+	files, err := globWorkspace(workDir, []string{pattern})
+	if err != nil {
+		return "", noop, err
+	}
+	if len(files) == 0 {
+		return "", noop, fmt.Errorf("%s 未匹配到文件", pattern)
+	}
+	tmp, err := os.MkdirTemp("", "bedrock-artifact-*")
+	if err != nil {
+		return "", noop, err
+	}
+	cleanup := func() { _ = os.RemoveAll(tmp) }
+	prefix := globLiteralPrefix(pattern)
+	for _, rel := range files {
+		name := strings.TrimPrefix(rel, prefix)
+		src := filepath.Join(workDir, filepath.FromSlash(rel))
+		dst := filepath.Join(tmp, filepath.FromSlash(name))
+		info, err := os.Stat(src)
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(dst), 0755)
+		}
+		if err == nil {
+			err = copyFile(src, dst, info.Mode())
+		}
+		if err != nil {
+			cleanup()
+			return "", noop, err
+		}
+	}
+	return tmp, cleanup, nil
+}
+
+// globLiteralPrefix is the leading directories of pattern without wildcards,
+// with a trailing slash ("" when the first segment already has one). A literal
+// file path yields its directory.
+func globLiteralPrefix(pattern string) string {
+	segs := strings.Split(pattern, "/")
+	n := 0
+	for n < len(segs)-1 && !strings.ContainsAny(segs[n], "*?[") {
+		n++
+	}
+	if n == 0 {
+		return ""
+	}
+	return strings.Join(segs[:n], "/") + "/"
+}
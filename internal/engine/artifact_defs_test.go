@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+	resourcemodel "bedrock/internal/resource/model"
+)
+
+func TestValidateArtifactDefs(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		defs []model.ArtifactDef
+		ok   bool
+	}{
+		{[]model.ArtifactDef{{Name: "web", Path: "web/dist"}, {Name: "bin", Path: "bin/*", Format: "zip"}}, true},
+		{[]model.ArtifactDef{{Name: "web", Path: "a"}, {Name: "web", Path: "b"}}, false},
+		{[]model.ArtifactDef{{Name: "-web", Path: "a"}}, false},
+		{[]model.ArtifactDef{{Name: "web", Path: ""}}, false},
+		{[]model.ArtifactDef{{Name: "web", Path: "../etc"}}, false},
+		{[]model.ArtifactDef{{Name: "web", Path: "a", Format: "rar"}}, false},
+	}
+	for i, c := range cases {
+		if err := ValidateArtifactDefs(c.defs); (err == nil) != c.ok {
+			t.Errorf("case %d: err=%v want ok=%v", i, err, c.ok)
+		}
+	}
+}
+
+func TestStageArtifactSource(t *testing.T) {
+	t.Parallel()
+	ws := t.TempDir()
+	for _, f := range []string{"web/dist/index.html", "web/dist/js/app.js", "web/src/main.ts", "bin/tool"} {
+		full := filepath.Join(ws, filepath.FromSlash(f))
+		_ = os.MkdirAll(filepath.Dir(full), 0755)
+		_ = os.WriteFile(full, []byte(f), 0644)
+	}
+	list := func(dir string) []string {
+		var out []string
+		_ = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				rel, _ := filepath.Rel(dir, p)
+				out = append(out, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		return out
+	}
+
+	dir, cleanup, err := stageArtifactSource(ws, "web/dist")
+	if err != nil || dir != filepath.Join(ws, "web", "dist") {
+		t.Fatalf("dir: %q %v", dir, err)
+	}
+	cleanup()
+
+	dir, cleanup, err = stageArtifactSource(ws, "web/dist/**/*.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := list(dir); len(got) != 1 || got[0] != "js/app.js" {
+		t.Fatalf("glob files=%v want [js/app.js]", got)
+	}
+	cleanup()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("temp dir not removed: %v", err)
+	}
+
+	dir, cleanup, err = stageArtifactSource(ws, "bin/tool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := list(dir); len(got) != 1 || got[0] != "tool" {
+		t.Fatalf("file=%v want [tool]", got)
+	}
+	cleanup()
+
+	if _, _, err := stageArtifactSource(ws, "missing/*.txt"); err == nil {
+		t.Fatal("expected error for glob without matches")
+	}
+}
+
+func TestPipeline_namedArtifactsDeployPerTarget(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	repoDir := initLocalGitRepo(t)
+	tmp := t.TempDir()
+	mainDest, webDest := filepath.Join(tmp, "main"), filepath.Join(tmp, "web")
+	store := newMemRunStore(&model.BuildRun{ID: 1, BuildJobID: 10, BuildNumber: 4, Status: "queued", Stage: "pending", Branch: "main"})
+	jobStore := &memJobStore{
+		job: &model.BuildJob{
+			ID: 10, RepositoryID: 1, Branch: "main",
+			BuildScript:      "mkdir -p dist web/dist && echo bin > dist/app && echo page > web/dist/index.html",
+			OutputDir:        "dist",
+			MaxArtifacts:     5,
+			ArtifactDefsJSON: `[{"name":"web","path":"web/dist","format":"zip"},{"name":"none","path":"nothing/*"}]`,
+		},
+		targets: []model.DeployTarget{
+			{ID: 1, BuildJobID: 10, Method: "local", RemotePath: mainDest},
+			{ID: 2, BuildJobID: 10, Method: "local", RemotePath: webDest, ArtifactName: "web"},
+		},
+	}
+	repoStore := &memRepoStore{repo: &resourcemodel.Repository{ID: 1, RepoURL: repoDir, AuthType: "none"}}
+	p := NewPipeline(store, jobStore, repoStore, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(),
+		filepath.Join(tmp, "ws"), filepath.Join(tmp, "a"), filepath.Join(tmp, "l"), filepath.Join(tmp, "c"))
+	p.SetArtifactStore(newMemArtifactStore(t))
+
+	p.Execute(context.Background(), 1)
+	run, _ := store.FindByID(1)
+	if run.Status != "success" || run.DistributionSummary != "all_success" {
+		t.Fatalf("status=%s summary=%s err=%q", run.Status, run.DistributionSummary, run.ErrorMessage)
+	}
+	run.Artifacts = nil
+	DecodeRunArtifacts(run)
+	if len(run.Artifacts) != 1 {
+		t.Fatalf("artifacts=%+v want only web", run.Artifacts)
+	}
+	web := run.Artifacts[0]
+	if web.Name != "web" || web.FileName != "build-004-web.zip" || len(web.SHA256) != 64 || web.ObjectID == 0 {
+		t.Fatalf("web artifact=%+v", web)
+	}
+	if b, err := os.ReadFile(filepath.Join(mainDest, "app")); err != nil || strings.TrimSpace(string(b)) != "bin" {
+		t.Fatalf("main target: %q %v", b, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(webDest, "index.html")); err != nil || strings.TrimSpace(string(b)) != "page" {
+		t.Fatalf("web target: %q %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(webDest, "app")); !os.IsNotExist(err) {
+		t.Fatalf("web target received the main artifact: %v", err)
+	}
+	if len(store.attempts) != 2 || store.attempts[1].ArtifactSHA256 != web.SHA256 {
+		t.Fatalf("attempts=%+v", store.attempts)
+	}
+
+	// Redeploy extracts from the store; an unknown artifact fails only its target.
+	_ = os.RemoveAll(webDest)
+	jobStore.targets = append(jobStore.targets, model.DeployTarget{ID: 3, BuildJobID: 10, Method: "local", RemotePath: filepath.Join(tmp, "x"), ArtifactName: "none"})
+	p.executeRedeployOnly(context.Background(), run, jobStore.job, nil, func(string) {})
+	if b, err := os.ReadFile(filepath.Join(webDest, "index.html")); err != nil || strings.TrimSpace(string(b)) != "page" {
+		t.Fatalf("redeployed web target: %q %v", b, err)
+	}
+	run, _ = store.FindByID(1)
+	if run.DistributionSummary != "partial" {
+		t.Fatalf("summary=%s want partial", run.DistributionSummary)
+	}
+}
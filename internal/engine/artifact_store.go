@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"bedrock/internal/cicd/model"
+	storagemodel "bedrock/internal/storage/model"
+)
+
+// storeArtifact moves a finished archive into the content store and returns
+// where it now lives. Without a store the archive stays where it was written;
+// its digest is still recorded.
+func (p *Pipeline) storeArtifact(archive, format string, createdBy uint) (*model.RunArtifact, error) {
+	info, err := os.Stat(archive)
+	if err != nil {
+		return nil, err
+	}
+	art := &model.RunArtifact{Path: archive, Format: format, Size: info.Size()}
+	if p.store == nil {
+		if art.SHA256, err = fileSHA256(archive); err != nil {
+			return nil, err
+		}
+		return art, nil
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := p.store.Put(storagemodel.KindArtifact, artifactContentType(format), f, info.Size(), createdBy)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	path, err := p.store.LocalPath(obj.ID)
+	if err != nil {
+		_ = p.store.Delete(obj.ID)
+		return nil, err
+	}
+	_ = os.Remove(archive)
+	art.Path, art.ObjectID, art.SHA256, art.Size = path, obj.ID, obj.SHA256, obj.Size
+	return art, nil
+}
+
+// ArtifactFile resolves an artifact to a readable local file: through the
+// store when it has an object id, else the recorded path (older archives).
+func ArtifactFile(store ArtifactStore, objectID uint, path string) (string, error) {
+	if objectID != 0 {
+		if store == nil {
+			return "", fmt.Errorf("制品存储未配置")
+		}
+		return store.LocalPath(objectID)
+	}
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return "", os.ErrNotExist
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// releaseArtifact drops one store reference, or deletes a loose file.
+func releaseArtifact(store ArtifactStore, objectID uint, path string) {
+	if objectID != 0 {
+		if store != nil {
+			_ = store.Delete(objectID)
+		}
+		return
+	}
+	if strings.TrimSpace(path) != "" {
+		_ = os.Remove(path)
+	}
+}
+
+func runObjectID(r *model.BuildRun) uint {
+	if r.ArtifactObjectID == nil {
+		return 0
+	}
+	return *r.ArtifactObjectID
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
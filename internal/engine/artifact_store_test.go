@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+	resourcemodel "bedrock/internal/resource/model"
+	storagemodel "bedrock/internal/storage/model"
+)
+
+// memArtifactStore is a content-addressed ArtifactStore over a temp dir.
+type memArtifactStore struct {
+	mu      sync.Mutex
+	dir     string
+	objects map[uint]*storagemodel.StorageObject
+	nextID  uint
+}
+
+func newMemArtifactStore(t *testing.T) *memArtifactStore {
+	return &memArtifactStore{dir: t.TempDir(), objects: map[uint]*storagemodel.StorageObject{}, nextID: 1}
+}
+
+func (m *memArtifactStore) Put(kind, contentType string, source io.Reader, _ int64, createdBy uint) (*storagemodel.StorageObject, error) {
+	data, err := io.ReadAll(source)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, o := range m.objects {
+		if o.SHA256 == digest {
+			o.RefCount++
+			cp := *o
+			return &cp, nil
+		}
+	}
+	if err := os.WriteFile(filepath.Join(m.dir, digest), data, 0o644); err != nil {
+		return nil, err
+	}
+	o := &storagemodel.StorageObject{
+		ID: m.nextID, Kind: kind, SHA256: digest, Size: int64(len(data)),
+		ContentType: contentType, Path: digest, RefCount: 1, CreatedBy: createdBy,
+	}
+	m.objects[o.ID] = o
+	m.nextID++
+	cp := *o
+	return &cp, nil
+}
+
+func (m *memArtifactStore) LocalPath(id uint) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.objects[id]
+	if !ok || o.RefCount <= 0 {
+		return "", os.ErrNotExist
+	}
+	return filepath.Join(m.dir, o.Path), nil
+}
+
+func (m *memArtifactStore) Delete(id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if o, ok := m.objects[id]; ok && o.RefCount > 0 {
+		o.RefCount--
+	}
+	return nil
+}
+
+func (m *memArtifactStore) PurgeExpired(time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, o := range m.objects {
+		if o.RefCount == 0 {
+			_ = os.Remove(filepath.Join(m.dir, o.Path))
+			delete(m.objects, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func TestPipeline_identicalRebuildsShareStoredArtifact(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	repoDir := initLocalGitRepo(t)
+	tmp := t.TempDir()
+	store := newMemRunStore(
+		&model.BuildRun{ID: 1, BuildJobID: 10, BuildNumber: 1, Status: "queued", Stage: "pending", Branch: "main"},
+		&model.BuildRun{ID: 2, BuildJobID: 10, BuildNumber: 2, Status: "queued", Stage: "pending", Branch: "main"},
+	)
+	jobStore := &memJobStore{job: &model.BuildJob{
+		ID: 10, RepositoryID: 1, Branch: "main",
+		// Fresh mtimes each run: only normalized archives deduplicate.
+		BuildScript:  "rm -rf dist && mkdir -p dist && echo hello > dist/app.txt && sleep 1",
+		OutputDir:    "dist",
+		MaxArtifacts: 1,
+	}}
+	repoStore := &memRepoStore{repo: &resourcemodel.Repository{ID: 1, RepoURL: repoDir, AuthType: "none"}}
+	p := NewPipeline(store, jobStore, repoStore, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(),
+		filepath.Join(tmp, "ws"), filepath.Join(tmp, "a"), filepath.Join(tmp, "l"), filepath.Join(tmp, "c"))
+	objects := newMemArtifactStore(t)
+	p.SetArtifactStore(objects)
+
+	p.Execute(context.Background(), 1)
+	first, _ := store.FindByID(1)
+	if first.Status != "success" || first.ArtifactObjectID == nil || len(first.ArtifactSHA256) != 64 {
+		t.Fatalf("run 1: status=%s object=%v sha=%q err=%q", first.Status, first.ArtifactObjectID, first.ArtifactSHA256, first.ErrorMessage)
+	}
+	if first.ArtifactName != "build-001.tar.gz" {
+		t.Fatalf("artifact_name=%q", first.ArtifactName)
+	}
+	if digest, err := fileSHA256(first.ArtifactPath); err != nil || digest != first.ArtifactSHA256 {
+		t.Fatalf("stored file digest=%s err=%v want %s", digest, err, first.ArtifactSHA256)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(tmp, "a", "job-10")); len(entries) != 0 {
+		t.Fatalf("archive left in artifact dir: %d entries", len(entries))
+	}
+
+	p.Execute(context.Background(), 2)
+	second, _ := store.FindByID(2)
+	if second.ArtifactObjectID == nil || *second.ArtifactObjectID != *first.ArtifactObjectID || second.ArtifactSHA256 != first.ArtifactSHA256 {
+		t.Fatalf("rebuild not deduplicated: %v/%s vs %v/%s",
+			second.ArtifactObjectID, second.ArtifactSHA256, first.ArtifactObjectID, first.ArtifactSHA256)
+	}
+
+	// MaxArtifacts=1 released run 1's reference; the shared bytes stay for run 2.
+	first, _ = store.FindByID(1)
+	if first.ArtifactRemovedReason != RetentionCount || first.ArtifactObjectID != nil {
+		t.Fatalf("run 1 after retention: reason=%q object=%v", first.ArtifactRemovedReason, first.ArtifactObjectID)
+	}
+	if path, err := ArtifactFile(objects, *second.ArtifactObjectID, ""); err != nil || path != second.ArtifactPath {
+		t.Fatalf("run 2 artifact path=%q err=%v", path, err)
+	}
+}
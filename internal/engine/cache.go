@@ -0,0 +1,530 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+)
+
+// Build cache layout under build.cache_dir:
+//
+//	entries/<scope>/<key>/meta.json
+//	entries/<scope>/<key>/data/<cache path>...
+//
+// scope is job-N, or repo-N when the job shares its cache with the repository.
+// Entries are assembled in a temp dir and renamed into place, so a restore
+// never sees half an entry.
+const (
+	cacheEntriesDir = "entries"
+	cacheMetaFile   = "meta.json"
+	cacheDataDir    = "data"
+	cacheTempPrefix = ".tmp-"
+	// cacheTempMaxAge is when a temp dir counts as left over by a crashed save.
+	cacheTempMaxAge = 24 * time.Hour
+)
+
+// Build cache outcomes recorded in BuildRun.CacheStatus.
+const (
+	CacheHit     = "hit"
+	CachePartial = "partial"
+	CacheMiss    = "miss"
+)
+
+type cacheMeta struct {
+	Key        string    `json:"key"`
+	Paths      []string  `json:"paths"`
+	SizeBytes  int64     `json:"size_bytes"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// BuildCache stores CachePaths entries keyed by content. Restores hold the
+// read lock, so Evict never deletes an entry while it is linked into a workspace.
+type BuildCache struct {
+	root      string
+	hardlinks bool
+	mu        sync.RWMutex
+}
+
+func NewBuildCache(root string) *BuildCache {
+	return &BuildCache{root: root, hardlinks: true}
+}
+
+// SetHardlinks picks the restore fallback when the filesystem cannot reflink:
+// hardlinks (read-only files shared between entry and workspace) or full
+// copies. Saves always reflink or copy, and hardlinks are never used as root.
+func (c *BuildCache) SetHardlinks(on bool) { c.hardlinks = on }
+
+// cachePlan is one run's cache: the entry it saves to and what it may restore.
+type cachePlan struct {
+	scope       string
+	key         string
+	restoreKeys []string
+	paths       []string
+	keyFiles    int
+	// immutable entries (keyed by files) are written once; an unkeyed entry
+	// is replaced after every successful build, like the old per-job cache.
+	immutable bool
+	legacyDir string
+	hit       bool
+}
+
+// ValidateCacheKey checks a BuildJob cache key or restore-key prefix.
+func ValidateCacheKey(key string) error {
+	if !artifactNamePattern.MatchString(key) {
+		return fmt.Errorf("%q must match [A-Za-z0-9][A-Za-z0-9._-]* (max 100)", key)
+	}
+	return nil
+}
+
+// ValidateCacheSettings checks a job's cache paths, key files and keys.
+func ValidateCacheSettings(job *model.BuildJob) error {
+	if err := ValidateReportPaths(job.CachePaths); err != nil {
+		return fmt.Errorf("cache_paths: %w", err)
+	}
+	if err := ValidateReportPaths(job.CacheKeyFiles); err != nil {
+		return fmt.Errorf("cache_key_files: %w", err)
+	}
+	if key := strings.TrimSpace(job.CacheKey); key != "" {
+		if err := ValidateCacheKey(key); err != nil {
+			return fmt.Errorf("cache_key: %w", err)
+		}
+	}
+	for _, prefix := range parseCachePaths(job.CacheRestoreKeys) {
+		if err := ValidateCacheKey(prefix); err != nil {
+			return fmt.Errorf("cache_restore_keys: %w", err)
+		}
+	}
+	return nil
+}
+
+// planCache derives the entry key: CacheKey (plus the matrix cell) and a digest
+// of the cache paths and every CacheKeyFiles match in workDir.
+func (c *BuildCache) planCache(job *model.BuildJob, repoID uint, cell map[string]string, workDir string) (*cachePlan, error) {
+	plan := &cachePlan{
+		scope:     fmt.Sprintf("job-%d", job.ID),
+		legacyDir: filepath.Join(c.root, jobDirName(job.ID, cell)),
+	}
+	if job.CacheShared {
+		plan.scope = fmt.Sprintf("repo-%d", repoID)
+	}
+	for _, cp := range parseCachePaths(job.CachePaths) {
+		if err := validateRelPath(cp); err != nil {
+			return nil, fmt.Errorf("cache path %q %w", cp, err)
+		}
+		plan.paths = append(plan.paths, filepath.ToSlash(filepath.Clean(cp)))
+	}
+	sort.Strings(plan.paths)
+
+	name := strings.TrimSpace(job.CacheKey)
+	if name == "" {
+		name = "default"
+	}
+	if len(cell) > 0 {
+		name += "-" + stageFileNameUnsafe.ReplaceAllString(MatrixCellKey(cell), "_")
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "paths\x00%s\x00", strings.Join(plan.paths, "\x00"))
+	keyPatterns := parseCachePaths(job.CacheKeyFiles)
+	if len(keyPatterns) > 0 {
+		files, err := globWorkspace(workDir, keyPatterns)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		for _, rel := range files {
+			digest, err := fileSHA256(filepath.Join(workDir, filepath.FromSlash(rel)))
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(h, "%s\x00%s\x00", rel, digest)
+		}
+		plan.keyFiles = len(files)
+		plan.immutable = true
+	}
+	plan.key = name + "-" + hex.EncodeToString(h.Sum(nil))[:16]
+
+	plan.restoreKeys = parseCachePaths(job.CacheRestoreKeys)
+	if len(plan.restoreKeys) == 0 {
+		plan.restoreKeys = []string{name + "-"}
+	}
+	return plan, nil
+}
+
+func (c *BuildCache) scopeDir(scope string) string {
+	return filepath.Join(c.root, cacheEntriesDir, scope)
+}
+
+// Restore links the best entry for plan into workDir: the exact key, else the
+// newest entry matching a restore key in order, else the per-job cache dir
+// used before entries were keyed. It returns the status and restored key.
+func (c *BuildCache) Restore(plan *cachePlan, workDir string, now time.Time) (string, string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	scopeDir := c.scopeDir(plan.scope)
+	if meta, err := readCacheMeta(filepath.Join(scopeDir, plan.key)); err == nil {
+		plan.hit = true
+		return CacheHit, meta.Key, c.restoreEntry(scopeDir, meta, plan, workDir, now)
+	}
+	metas := listCacheMetas(scopeDir)
+	for _, prefix := range plan.restoreKeys {
+		var best *cacheMeta
+		for i := range metas {
+			if strings.HasPrefix(metas[i].Key, prefix) && (best == nil || metas[i].CreatedAt.After(best.CreatedAt)) {
+				best = &metas[i]
+			}
+		}
+		if best != nil {
+			return CachePartial, best.Key, c.restoreEntry(scopeDir, best, plan, workDir, now)
+		}
+	}
+	if info, err := os.Stat(plan.legacyDir); err == nil && info.IsDir() {
+		n, err := c.restorePaths(plan.legacyDir, plan.paths, workDir)
+		if n > 0 || err != nil {
+			return CachePartial, filepath.Base(plan.legacyDir), err
+		}
+	}
+	return CacheMiss, "", nil
+}
+
+func (c *BuildCache) restoreEntry(scopeDir string, meta *cacheMeta, plan *cachePlan, workDir string, now time.Time) error {
+	entryDir := filepath.Join(scopeDir, meta.Key)
+	if _, err := c.restorePaths(filepath.Join(entryDir, cacheDataDir), plan.paths, workDir); err != nil {
+		return err
+	}
+	meta.LastUsedAt = now
+	return writeCacheMeta(entryDir, meta)
+}
+
+func (c *BuildCache) restorePaths(dataDir string, paths []string, workDir string) (int, error) {
+	restored := 0
+	for _, cp := range paths {
+		src := filepath.Join(dataDir, filepath.FromSlash(cp))
+		if _, err := os.Lstat(src); err != nil {
+			continue
+		}
+		if err := linkTree(src, filepath.Join(workDir, filepath.FromSlash(cp)), c.hardlinks && canHardlinkEntries()); err != nil {
+			return restored, fmt.Errorf("%s: %w", cp, err)
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+// Save stores plan's paths from workDir under plan.key and returns the entry
+// size; saved is false when nothing was written (immutable entry present, or
+// no cache path exists). A legacy per-job cache dir is removed once saved.
+func (c *BuildCache) Save(plan *cachePlan, workDir string, now time.Time) (saved bool, size int64, err error) {
+	scopeDir := c.scopeDir(plan.scope)
+	entryDir := filepath.Join(scopeDir, plan.key)
+	if plan.immutable {
+		if _, err := readCacheMeta(entryDir); err == nil {
+			return false, 0, nil
+		}
+	}
+	if err := os.MkdirAll(scopeDir, 0755); err != nil {
+		return false, 0, err
+	}
+	tmp, err := os.MkdirTemp(scopeDir, cacheTempPrefix+"*")
+	if err != nil {
+		return false, 0, err
+	}
+	defer os.RemoveAll(tmp)
+
+	meta := &cacheMeta{Key: plan.key, CreatedAt: now, LastUsedAt: now}
+	for _, cp := range plan.paths {
+		src := filepath.Join(workDir, filepath.FromSlash(cp))
+		if _, err := os.Lstat(src); err != nil {
+			continue
+		}
+		// Never hardlinked: the entry must not share inodes with the workspace.
+		if err := linkTree(src, filepath.Join(tmp, cacheDataDir, filepath.FromSlash(cp)), false); err != nil {
+			return false, 0, fmt.Errorf("%s: %w", cp, err)
+		}
+		meta.Paths = append(meta.Paths, cp)
+	}
+	if len(meta.Paths) == 0 {
+		return false, 0, nil
+	}
+	if meta.SizeBytes, err = treeSize(filepath.Join(tmp, cacheDataDir)); err != nil {
+		return false, 0, err
+	}
+	if err := readOnlyTree(filepath.Join(tmp, cacheDataDir)); err != nil {
+		return false, 0, err
+	}
+	if err := writeCacheMeta(tmp, meta); err != nil {
+		return false, 0, err
+	}
+
+	c.mu.RLock()
+	err = c.publish(scopeDir, entryDir, tmp, plan.immutable)
+	c.mu.RUnlock()
+	if err != nil {
+		return false, 0, err
+	}
+	_ = os.RemoveAll(plan.legacyDir)
+	return true, meta.SizeBytes, nil
+}
+
+// publish renames tmp to entryDir; a mutable entry already there is moved
+// aside first, so concurrent restores see the old or the new entry.
+func (c *BuildCache) publish(scopeDir, entryDir, tmp string, immutable bool) error {
+	if _, err := os.Stat(entryDir); err == nil {
+		if immutable {
+			return nil
+		}
+		old, err := os.MkdirTemp(scopeDir, cacheTempPrefix+"old-*")
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(entryDir, filepath.Join(old, "entry")); err != nil {
+			_ = os.RemoveAll(old)
+			return err
+		}
+		defer os.RemoveAll(old)
+	}
+	return os.Rename(tmp, entryDir)
+}
+
+// Evict deletes least recently used entries until the cache fits maxBytes
+// (0 = no size limit), plus temp dirs left behind by crashed saves.
+func (c *BuildCache) Evict(maxBytes int64, now time.Time) (removed int, freed int64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	base := filepath.Join(c.root, cacheEntriesDir)
+	scopes, err := os.ReadDir(base)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	type entry struct {
+		dir  string
+		meta cacheMeta
+	}
+	var entries []entry
+	var total int64
+	for _, s := range scopes {
+		if !s.IsDir() {
+			continue
+		}
+		scopeDir := filepath.Join(base, s.Name())
+		items, _ := os.ReadDir(scopeDir)
+		for _, it := range items {
+			dir := filepath.Join(scopeDir, it.Name())
+			if strings.HasPrefix(it.Name(), cacheTempPrefix) {
+				if info, err := it.Info(); err == nil && now.Sub(info.ModTime()) > cacheTempMaxAge {
+					_ = os.RemoveAll(dir)
+				}
+				continue
+			}
+			meta, err := readCacheMeta(dir)
+			if err != nil {
+				// Not a cache entry (no readable meta.json): nothing restores it.
+				_ = os.RemoveAll(dir)
+				continue
+			}
+			entries = append(entries, entry{dir: dir, meta: *meta})
+			total += meta.SizeBytes
+		}
+		if left, _ := os.ReadDir(scopeDir); len(left) == 0 {
+			_ = os.Remove(scopeDir)
+		}
+	}
+	if maxBytes <= 0 || total <= maxBytes {
+		return 0, 0, nil
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].meta.LastUsedAt.Before(entries[j].meta.LastUsedAt) })
+	for _, e := range entries {
+		if total <= maxBytes {
+			break
+		}
+		if err := os.RemoveAll(e.dir); err != nil {
+			return removed, freed, err
+		}
+		removed++
+		freed += e.meta.SizeBytes
+		total -= e.meta.SizeBytes
+	}
+	return removed, freed, nil
+}
+
+func readCacheMeta(entryDir string) (*cacheMeta, error) {
+	raw, err := os.ReadFile(filepath.Join(entryDir, cacheMetaFile))
+	if err != nil {
+		return nil, err
+	}
+	var meta cacheMeta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// writeCacheMeta replaces meta.json atomically; restores update LastUsedAt
+// concurrently and the last writer wins.
+func writeCacheMeta(entryDir string, meta *cacheMeta) error {
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(entryDir, cacheTempPrefix+"meta-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(raw)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(entryDir, cacheMetaFile))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+func listCacheMetas(scopeDir string) []cacheMeta {
+	items, err := os.ReadDir(scopeDir)
+	if err != nil {
+		return nil
+	}
+	var out []cacheMeta
+	for _, it := range items {
+		if !it.IsDir() || strings.HasPrefix(it.Name(), cacheTempPrefix) {
+			continue
+		}
+		if meta, err := readCacheMeta(filepath.Join(scopeDir, it.Name())); err == nil {
+			out = append(out, *meta)
+		}
+	}
+	return out
+}
+
+func treeSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// restoreCache plans and restores the job's cache, logging and recording the
+// outcome on run; nil means caching is skipped for this run.
+func (p *Pipeline) restoreCache(run *model.BuildRun, job *model.BuildJob, repoID uint, workDir string, writeLine func(string)) *cachePlan {
+	plan, err := p.cache.planCache(job, repoID, run.MatrixCell, workDir)
+	if err != nil {
+		writeLine("WARNING: 缓存已跳过: " + err.Error())
+		return nil
+	}
+	if plan.immutable {
+		writeLine(fmt.Sprintf("Cache key: %s (%s, %d key files)", plan.key, plan.scope, plan.keyFiles))
+	} else {
+		writeLine(fmt.Sprintf("Cache key: %s (%s)", plan.key, plan.scope))
+	}
+	started := time.Now()
+	status, restored, err := p.cache.Restore(plan, workDir, started)
+	elapsed := time.Since(started).Round(time.Millisecond)
+	switch {
+	case err != nil:
+		writeLine(fmt.Sprintf("WARNING: 恢复缓存 %s 失败: %s", restored, err.Error()))
+		status = CacheMiss
+	case status == CacheHit:
+		writeLine(fmt.Sprintf("Cache hit: %s (restored in %s)", restored, elapsed))
+	case status == CachePartial:
+		writeLine(fmt.Sprintf("Cache partial hit: restored %s (restored in %s)", restored, elapsed))
+	default:
+		writeLine("Cache miss: no entry matches the key or restore keys")
+	}
+	run.CacheStatus, run.CacheKey, run.CacheRestoredKey = status, plan.key, restored
+	_ = p.runs.UpdateFields(run.ID, map[string]interface{}{
+		"cache_status":       status,
+		"cache_key":          plan.key,
+		"cache_restored_key": restored,
+	})
+	p.broadcastRunRefresh(run.ID)
+	return plan
+}
+
+// saveCache stores the workspace's cache paths after a successful build.
+func (p *Pipeline) saveCache(plan *cachePlan, workDir string, writeLine func(string)) {
+	if plan.immutable && plan.hit {
+		writeLine(fmt.Sprintf("Cache hit on %s; not saving", plan.key))
+		return
+	}
+	saved, size, err := p.cache.Save(plan, workDir, time.Now())
+	switch {
+	case err != nil:
+		writeLine(fmt.Sprintf("WARNING: 保存缓存 %s 失败: %s", plan.key, err.Error()))
+	case saved:
+		writeLine(fmt.Sprintf("Saved cache: %s (%s, %d bytes)", plan.key, strings.Join(plan.paths, ", "), size))
+	default:
+		writeLine(fmt.Sprintf("Cache %s not saved (entry exists or no cache path present)", plan.key))
+	}
+}
+
+// CacheEvictor trims the build cache to its size cap periodically.
+type CacheEvictor struct {
+	cache    *BuildCache
+	maxBytes int64
+	every    time.Duration
+	logger   *zap.Logger
+	stop     chan struct{}
+	once     sync.Once
+}
+
+func NewCacheEvictor(cache *BuildCache, maxBytes int64, every time.Duration, logger *zap.Logger) *CacheEvictor {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &CacheEvictor{cache: cache, maxBytes: maxBytes, every: every, logger: logger, stop: make(chan struct{})}
+}
+
+func (e *CacheEvictor) Start() {
+	if e.every <= 0 || e.cache == nil {
+		return
+	}
+	go func() {
+		t := time.NewTicker(e.every)
+		defer t.Stop()
+		for {
+			e.EvictOnce(time.Now())
+			select {
+			case <-e.stop:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+func (e *CacheEvictor) Stop() { e.once.Do(func() { close(e.stop) }) }
+
+func (e *CacheEvictor) EvictOnce(now time.Time) {
+	removed, freed, err := e.cache.Evict(e.maxBytes, now)
+	if err != nil {
+		e.logger.Warn("build cache eviction failed", zap.Error(err))
+	}
+	if removed > 0 {
+		e.logger.Info("build cache evicted", zap.Int("entries", removed), zap.Int64("bytes", freed))
+	}
+}
@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// linkTree mirrors src (a directory or file) at dst for the build cache.
+// Files are reflinked when the filesystem supports it, else hardlinked (when
+// allowed), else copied; reflinks and copies are owner-writable. Symlinks are
+// recreated, not followed. Files already at dst are replaced, and extra files
+// there are kept.
+func linkTree(src, dst string, hardlinks bool) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			if cur, err := os.Lstat(target); err == nil && !cur.IsDir() {
+				_ = os.Remove(target)
+			}
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			_ = os.RemoveAll(target)
+			return os.Symlink(link, target)
+		case !info.Mode().IsRegular():
+			return nil
+		}
+		return linkFile(path, target, info, hardlinks)
+	})
+}
+
+func linkFile(src, dst string, info os.FileInfo, hardlinks bool) error {
+	if cur, err := os.Lstat(dst); err == nil {
+		if os.SameFile(cur, info) {
+			return nil
+		}
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+	}
+	if reflinkFile(src, dst, info.Mode().Perm()|0200) == nil {
+		return nil
+	}
+	if hardlinks && os.Link(src, dst) == nil {
+		return nil
+	}
+	return copyFile(src, dst, info.Mode().Perm()|0200)
+}
+
+// readOnlyTree clears the write bits of the regular files under dir, so an
+// in-place write through a hardlink fails instead of changing the cache entry.
+func readOnlyTree(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		return os.Chmod(path, info.Mode().Perm()&^0222)
+	})
+}
+
+// canHardlinkEntries: root ignores file permissions, so read-only entry files
+// only protect hardlinked restores for other users.
+func canHardlinkEntries() bool { return os.Geteuid() != 0 }
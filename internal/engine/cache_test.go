@@ -0,0 +1,237 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+	resourcemodel "bedrock/internal/resource/model"
+)
+
+func writeWorkspaceFile(t *testing.T, dir, rel, body string) {
+	t.Helper()
+	full := filepath.Join(dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildCache_keyFilesAndRestoreKeys(t *testing.T) {
+	t.Parallel()
+	cache := NewBuildCache(t.TempDir())
+	job := &model.BuildJob{ID: 7, CachePaths: "node_modules", CacheKey: "npm", CacheKeyFiles: "package-lock.json"}
+	ws := t.TempDir()
+	now := time.Now()
+
+	writeWorkspaceFile(t, ws, "package-lock.json", "v1")
+	plan1, err := cache.planCache(job, 1, nil, ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(plan1.key, "npm-") || !plan1.immutable || plan1.keyFiles != 1 {
+		t.Fatalf("plan=%+v", plan1)
+	}
+	if status, _, _ := cache.Restore(plan1, ws, now); status != CacheMiss {
+		t.Fatalf("empty cache status=%s", status)
+	}
+	writeWorkspaceFile(t, ws, "node_modules/a/index.js", "a1")
+	if saved, size, err := cache.Save(plan1, ws, now); !saved || size != 2 || err != nil {
+		t.Fatalf("save: saved=%v size=%d err=%v", saved, size, err)
+	}
+
+	// A new lockfile misses the exact key and falls back to the npm- prefix.
+	writeWorkspaceFile(t, ws, "package-lock.json", "v2")
+	plan2, _ := cache.planCache(job, 1, nil, ws)
+	if plan2.key == plan1.key {
+		t.Fatal("key did not change with the key file")
+	}
+	fresh := t.TempDir()
+	status, restored, err := cache.Restore(plan2, fresh, now)
+	if err != nil || status != CachePartial || restored != plan1.key {
+		t.Fatalf("restore: status=%s restored=%s err=%v", status, restored, err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(fresh, "node_modules", "a", "index.js")); string(b) != "a1" {
+		t.Fatalf("restored content=%q", b)
+	}
+
+	// Immutable entries are written once.
+	writeWorkspaceFile(t, ws, "node_modules/a/index.js", "changed")
+	if saved, _, _ := cache.Save(plan1, ws, now); saved {
+		t.Fatal("immutable entry overwritten")
+	}
+	status, _, _ = cache.Restore(plan1, t.TempDir(), now)
+	if status != CacheHit || !plan1.hit {
+		t.Fatalf("exact key status=%s", status)
+	}
+}
+
+func TestBuildCache_sharedScopeAndLegacyDir(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	cache := NewBuildCache(root)
+	cache.SetHardlinks(false)
+	now := time.Now()
+
+	// Cache written by the per-job layout before entries were keyed.
+	writeWorkspaceFile(t, root, "job-1/vendor/lib.go", "old")
+	jobA := &model.BuildJob{ID: 1, CachePaths: "vendor", CacheShared: true}
+	ws := t.TempDir()
+	planA, _ := cache.planCache(jobA, 5, nil, ws)
+	if planA.scope != "repo-5" || planA.immutable {
+		t.Fatalf("plan=%+v", planA)
+	}
+	status, restored, err := cache.Restore(planA, ws, now)
+	if err != nil || status != CachePartial || restored != "job-1" {
+		t.Fatalf("legacy restore: status=%s restored=%s err=%v", status, restored, err)
+	}
+	if saved, _, err := cache.Save(planA, ws, now); !saved || err != nil {
+		t.Fatalf("save: %v %v", saved, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "job-1")); !os.IsNotExist(err) {
+		t.Fatalf("legacy dir kept after save: %v", err)
+	}
+
+	// Another job of the same repository with the same paths shares the entry.
+	jobB := &model.BuildJob{ID: 2, CachePaths: "vendor", CacheShared: true}
+	planB, _ := cache.planCache(jobB, 5, nil, t.TempDir())
+	if planB.key != planA.key {
+		t.Fatalf("shared keys differ: %s vs %s", planB.key, planA.key)
+	}
+	other := t.TempDir()
+	if status, _, _ := cache.Restore(planB, other, now); status != CacheHit {
+		t.Fatalf("shared status=%s", status)
+	}
+	if b, _ := os.ReadFile(filepath.Join(other, "vendor", "lib.go")); string(b) != "old" {
+		t.Fatalf("shared content=%q", b)
+	}
+
+	// Unkeyed entries are replaced by every save.
+	writeWorkspaceFile(t, ws, "vendor/lib.go", "new")
+	if saved, _, _ := cache.Save(planA, ws, now); !saved {
+		t.Fatal("mutable entry not replaced")
+	}
+	other = t.TempDir()
+	_, _, _ = cache.Restore(planB, other, now)
+	if b, _ := os.ReadFile(filepath.Join(other, "vendor", "lib.go")); string(b) != "new" {
+		t.Fatalf("replaced content=%q", b)
+	}
+}
+
+func TestBuildCache_restoredFileChangesLeaveEntry(t *testing.T) {
+	t.Parallel()
+	cache := NewBuildCache(t.TempDir())
+	job := &model.BuildJob{ID: 3, CachePaths: "deps", CacheKeyFiles: "deps.lock"}
+	ws := t.TempDir()
+	now := time.Now()
+	writeWorkspaceFile(t, ws, "deps.lock", "v1")
+	writeWorkspaceFile(t, ws, "deps/lib.txt", "orig")
+	plan, _ := cache.planCache(job, 1, nil, ws)
+	if saved, _, err := cache.Save(plan, ws, now); !saved || err != nil {
+		t.Fatalf("save: %v %v", saved, err)
+	}
+	// The workspace keeps changing after the save.
+	writeWorkspaceFile(t, ws, "deps/lib.txt", "after save")
+
+	restored := t.TempDir()
+	lib := filepath.Join(restored, "deps", "lib.txt")
+	for i := 0; i < 2; i++ {
+		if status, _, err := cache.Restore(plan, restored, now); status != CacheHit || err != nil {
+			t.Fatalf("restore %d: status=%s err=%v", i, status, err)
+		}
+		if b, _ := os.ReadFile(lib); string(b) != "orig" {
+			t.Fatalf("restore %d content=%q", i, b)
+		}
+		// A build step rewrites the file in place; a hardlinked (read-only)
+		// file makes it replace the file instead.
+		if err := os.WriteFile(lib, []byte("changed"), 0644); err != nil {
+			_ = os.Remove(lib)
+			writeWorkspaceFile(t, restored, "deps/lib.txt", "changed")
+		}
+	}
+	entry := filepath.Join(cache.scopeDir(plan.scope), plan.key, cacheDataDir, "deps", "lib.txt")
+	if info, err := os.Stat(entry); err != nil || info.Mode().Perm()&0222 != 0 {
+		t.Fatalf("entry file not read-only: %v %v", info, err)
+	}
+}
+
+func TestBuildCache_evictLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	cache := NewBuildCache(root)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var plans []*cachePlan
+	for i, body := range []string{"aaaa", "bbbb", "cccc"} {
+		ws := t.TempDir()
+		writeWorkspaceFile(t, ws, "dep/f", body)
+		job := &model.BuildJob{ID: uint(i + 1), CachePaths: "dep"}
+		plan, _ := cache.planCache(job, 1, nil, ws)
+		if _, _, err := cache.Save(plan, ws, base.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		plans = append(plans, plan)
+	}
+	// Using job 1's entry makes job 2's the least recently used.
+	_, _, _ = cache.Restore(plans[0], t.TempDir(), base.Add(5*time.Hour))
+	stale := filepath.Join(root, cacheEntriesDir, "job-1", cacheTempPrefix+"crashed")
+	_ = os.MkdirAll(stale, 0755)
+	_ = os.Chtimes(stale, base, base)
+
+	removed, freed, err := cache.Evict(8, base.Add(48*time.Hour))
+	if err != nil || removed != 1 || freed != 4 {
+		t.Fatalf("evict: removed=%d freed=%d err=%v", removed, freed, err)
+	}
+	for i, want := range []bool{true, false, true} {
+		_, err := readCacheMeta(filepath.Join(root, cacheEntriesDir, plans[i].scope, plans[i].key))
+		if (err == nil) != want {
+			t.Fatalf("entry %d present=%v want %v", i+1, err == nil, want)
+		}
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("stale temp dir kept: %v", err)
+	}
+}
+
+func TestPipeline_recordsCacheStatus(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	repoDir := initLocalGitRepo(t)
+	tmp := t.TempDir()
+	store := newMemRunStore(
+		&model.BuildRun{ID: 1, BuildJobID: 10, BuildNumber: 1, Status: "queued", Stage: "pending", Branch: "main"},
+		&model.BuildRun{ID: 2, BuildJobID: 10, BuildNumber: 2, Status: "queued", Stage: "pending", Branch: "main"},
+	)
+	jobStore := &memJobStore{job: &model.BuildJob{
+		ID: 10, RepositoryID: 1, Branch: "main",
+		BuildScript:   "test -f deps/ok && echo cached || (mkdir -p deps && echo 1 > deps/ok)",
+		CachePaths:    "deps",
+		CacheKeyFiles: "README*",
+	}}
+	repoStore := &memRepoStore{repo: &resourcemodel.Repository{ID: 1, RepoURL: repoDir, AuthType: "none"}}
+	p := NewPipeline(store, jobStore, repoStore, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(),
+		filepath.Join(tmp, "ws"), filepath.Join(tmp, "a"), filepath.Join(tmp, "l"), filepath.Join(tmp, "c"))
+
+	p.Execute(context.Background(), 1)
+	first, _ := store.FindByID(1)
+	if first.Status != "success" || first.CacheStatus != CacheMiss || first.CacheKey == "" {
+		t.Fatalf("run 1: status=%s cache=%s key=%q err=%q", first.Status, first.CacheStatus, first.CacheKey, first.ErrorMessage)
+	}
+	// The workspace persists between runs; clear it so the hit comes from the cache.
+	_ = os.RemoveAll(filepath.Join(tmp, "ws", "repo-1", "job-10", "deps"))
+	p.Execute(context.Background(), 2)
+	second, _ := store.FindByID(2)
+	if second.CacheStatus != CacheHit || second.CacheRestoredKey != first.CacheKey {
+		t.Fatalf("run 2: cache=%s restored=%q want %q", second.CacheStatus, second.CacheRestoredKey, first.CacheKey)
+	}
+}
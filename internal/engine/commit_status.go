@@ -0,0 +1,363 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+)
+
+// Commit states reported to Git platforms; each platform maps them to its own.
+const (
+	CommitStatePending = "pending"
+	CommitStateRunning = "running"
+	CommitStateSuccess = "success"
+	CommitStateFailure = "failure"
+	CommitStateError   = "error"
+)
+
+// CommitStatus is one status update for a commit. Context names the check
+// (one per job / matrix cell); TargetURL links to the run page.
+type CommitStatus struct {
+	SHA         string
+	State       string
+	Context     string
+	Description string
+	TargetURL   string
+}
+
+// repoLocation is a repository's API address: BaseURL is scheme://host[:port]
+// (https for ssh remotes), Path is owner/repo without ".git".
+type repoLocation struct {
+	BaseURL string
+	Path    string
+}
+
+// parseRepoLocation reads the host and owner/repo path of an https or ssh remote.
+func parseRepoLocation(repoURL string) (repoLocation, error) {
+	raw := strings.TrimSpace(repoURL)
+	var host, path, scheme string
+	if strings.Contains(raw, "://") {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return repoLocation{}, err
+		}
+		scheme, host, path = u.Scheme, u.Host, u.Path
+		if scheme != "http" && scheme != "https" {
+			// ssh://git@host:2222/org/repo: the API is served over https without the ssh port.
+			scheme, host = "https", u.Hostname()
+		}
+	} else if scpLikeGitURL.MatchString(raw) {
+		hostPart, rest, _ := strings.Cut(raw, ":")
+		if i := strings.LastIndex(hostPart, "@"); i >= 0 {
+			hostPart = hostPart[i+1:]
+		}
+		scheme, host, path = "https", hostPart, rest
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if host == "" || !strings.Contains(path, "/") {
+		return repoLocation{}, fmt.Errorf("无法从仓库地址解析 owner/repo: %s", repoURL)
+	}
+	return repoLocation{BaseURL: scheme + "://" + host, Path: path}, nil
+}
+
+// commitStatusAPI posts statuses; implemented by the platforms that have one.
+// checkID carries a platform-side id between updates of the same status (Gitee
+// check runs); it is 0 on the first update.
+type commitStatusAPI interface {
+	postCommitStatus(ctx context.Context, c *http.Client, loc repoLocation, token string, st CommitStatus, checkID int64) (int64, error)
+}
+
+// GitHub: POST /repos/{owner}/{repo}/statuses/{sha}; GitHub Enterprise serves the API under /api/v3.
+func (gitHubPlatform) postCommitStatus(ctx context.Context, c *http.Client, loc repoLocation, token string, st CommitStatus, _ int64) (int64, error) {
+	api := loc.BaseURL + "/api/v3"
+	if strings.EqualFold(strings.TrimPrefix(loc.BaseURL, "https://"), "github.com") {
+		api = "https://api.github.com"
+	}
+	state := st.State
+	if state == CommitStateRunning {
+		state = "pending"
+	}
+	body := map[string]string{"state": state, "context": st.Context, "description": st.Description, "target_url": st.TargetURL}
+	header := http.Header{"Authorization": {"Bearer " + token}, "Accept": {"application/vnd.github+json"}}
+	_, err := statusRequest(ctx, c, http.MethodPost, api+"/repos/"+loc.Path+"/statuses/"+st.SHA, header, body)
+	return 0, err
+}
+
+// Gitea: POST /api/v1/repos/{owner}/{repo}/statuses/{sha}; same states as GitHub.
+func (giteaPlatform) postCommitStatus(ctx context.Context, c *http.Client, loc repoLocation, token string, st CommitStatus, _ int64) (int64, error) {
+	state := st.State
+	if state == CommitStateRunning {
+		state = "pending"
+	}
+	body := map[string]string{"state": state, "context": st.Context, "description": st.Description, "target_url": st.TargetURL}
+	header := http.Header{"Authorization": {"token " + token}}
+	_, err := statusRequest(ctx, c, http.MethodPost, loc.BaseURL+"/api/v1/repos/"+loc.Path+"/statuses/"+st.SHA, header, body)
+	return 0, err
+}
+
+// GitLab: POST /api/v4/projects/{url-encoded path}/statuses/{sha}.
+func (gitLabPlatform) postCommitStatus(ctx context.Context, c *http.Client, loc repoLocation, token string, st CommitStatus, _ int64) (int64, error) {
+	state := map[string]string{
+		CommitStatePending: "pending",
+		CommitStateRunning: "running",
+		CommitStateSuccess: "success",
+		CommitStateFailure: "failed",
+		CommitStateError:   "canceled",
+	}[st.State]
+	body := map[string]string{"state": state, "name": st.Context, "description": st.Description, "target_url": st.TargetURL}
+	header := http.Header{"PRIVATE-TOKEN": {token}}
+	endpoint := loc.BaseURL + "/api/v4/projects/" + url.PathEscape(loc.Path) + "/statuses/" + st.SHA
+	_, err := statusRequest(ctx, c, http.MethodPost, endpoint, header, body)
+	if err != nil && strings.Contains(err.Error(), "Cannot transition status") {
+		// Re-posting the current state (e.g. a recovered run) is not an error.
+		return 0, nil
+	}
+	return 0, err
+}
+
+// Gitee has no commit status API; a check run is created once and then updated
+// through PATCH /api/v5/repos/{owner}/{repo}/check-runs/{id}.
+func (giteePlatform) postCommitStatus(ctx context.Context, c *http.Client, loc repoLocation, token string, st CommitStatus, checkID int64) (int64, error) {
+	body := map[string]interface{}{
+		"access_token": token,
+		"name":         st.Context,
+		"head_sha":     st.SHA,
+		"details_url":  st.TargetURL,
+		"output":       map[string]string{"title": st.Context, "summary": st.Description},
+	}
+	switch st.State {
+	case CommitStatePending:
+		body["status"] = "queued"
+	case CommitStateRunning:
+		body["status"] = "in_progress"
+	default:
+		conclusion := map[string]string{
+			CommitStateSuccess: "success",
+			CommitStateFailure: "failure",
+			CommitStateError:   "cancelled",
+		}[st.State]
+		body["status"], body["conclusion"] = "completed", conclusion
+	}
+	endpoint := loc.BaseURL + "/api/v5/repos/" + loc.Path + "/check-runs"
+	method := http.MethodPost
+	if checkID > 0 {
+		endpoint += fmt.Sprintf("/%d", checkID)
+		method = http.MethodPatch
+	}
+	resp, err := statusRequest(ctx, c, method, endpoint, nil, body)
+	if err != nil {
+		return 0, err
+	}
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	_ = json.Unmarshal(resp, &created)
+	if created.ID == 0 {
+		created.ID = checkID
+	}
+	return created.ID, nil
+}
+
+// statusRequest sends a JSON body and returns the response body; non-2xx
+// responses become errors carrying a truncated body.
+func statusRequest(ctx context.Context, c *http.Client, method, endpoint string, header http.Header, body interface{}) ([]byte, error) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	out, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg := strings.TrimSpace(string(out))
+		if len(msg) > 300 {
+			msg = msg[:300]
+		}
+		return nil, fmt.Errorf("%s %s: HTTP %d: %s", method, req.URL.Path, resp.StatusCode, msg)
+	}
+	return out, nil
+}
+
+// CommitStatusReporter posts BuildRun progress to the repository's Git
+// platform. Report only queues the update; one worker sends them in order so a
+// late "running" can't overwrite "success". Runs without a commit, jobs with
+// ReportCommitStatus off and repositories without a token credential are skipped.
+type CommitStatusReporter struct {
+	runs      RunStore
+	jobs      JobStore
+	repos     RepoStore
+	secrets   SecretResolver
+	publicURL string
+	client    *http.Client
+	logger    *zap.Logger
+
+	mu       sync.Mutex
+	closed   bool
+	queue    chan commitStatusEvent
+	done     chan struct{}
+	checkIDs map[string]int64
+}
+
+type commitStatusEvent struct {
+	runID       uint
+	state       string
+	description string
+}
+
+// NewCommitStatusReporter starts the worker; publicURL (server.public_url)
+// prefixes run links and may be empty.
+func NewCommitStatusReporter(runs RunStore, jobs JobStore, repos RepoStore, secrets SecretResolver, publicURL string, logger *zap.Logger) *CommitStatusReporter {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	r := &CommitStatusReporter{
+		runs:      runs,
+		jobs:      jobs,
+		repos:     repos,
+		secrets:   secrets,
+		publicURL: strings.TrimRight(strings.TrimSpace(publicURL), "/"),
+		client:    &http.Client{Timeout: 15 * time.Second},
+		logger:    logger,
+		queue:     make(chan commitStatusEvent, 256),
+		done:      make(chan struct{}),
+		checkIDs:  map[string]int64{},
+	}
+	go r.loop()
+	return r
+}
+
+// Report queues a status for the run's commit. It never blocks the pipeline:
+// when the queue is full the update is dropped.
+func (r *CommitStatusReporter) Report(runID uint, state, description string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.queue <- commitStatusEvent{runID: runID, state: state, description: description}:
+	default:
+		r.logger.Warn("commit status queue full, update dropped", zap.Uint("run_id", runID), zap.String("state", state))
+	}
+}
+
+// Close sends the queued updates and stops the worker.
+func (r *CommitStatusReporter) Close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.mu.Unlock()
+	<-r.done
+}
+
+func (r *CommitStatusReporter) loop() {
+	defer close(r.done)
+	for ev := range r.queue {
+		if err := r.send(ev); err != nil {
+			r.logger.Warn("commit status report failed",
+				zap.Uint("run_id", ev.runID), zap.String("state", ev.state), zap.Error(err))
+		}
+	}
+}
+
+func (r *CommitStatusReporter) send(ev commitStatusEvent) error {
+	run, err := r.runs.FindByID(ev.runID)
+	if err != nil || (run.CommitHash == "" && run.PRHeadCommit == "") || IsMatrixParent(run) {
+		return nil
+	}
+	job, err := r.jobs.FindByID(run.BuildJobID)
+	if err != nil || !job.ReportCommitStatus {
+		return nil
+	}
+	repo, err := r.repos.FindByID(job.RepositoryID)
+	if err != nil || repo.AuthType != "credential" || repo.CredentialID == nil {
+		return nil
+	}
+	typ, _, secret, _, err := r.secrets.Resolve(*repo.CredentialID)
+	if err != nil {
+		return err
+	}
+	if NewGitAuth(typ, "", secret, "").Type == GitAuthSSH || secret == "" {
+		// SSH keys can clone but not call the platform API.
+		return nil
+	}
+	loc, err := parseRepoLocation(repo.RepoURL)
+	if err != nil {
+		return err
+	}
+	api, ok := ResolvePlatform(loc.BaseURL+"/"+loc.Path, repo.Platform).(commitStatusAPI)
+	if !ok {
+		return nil
+	}
+	DecodeMatrixCell(run)
+	sha := run.CommitHash
+	if run.PRHeadCommit != "" {
+		// Merge-ref builds: the status belongs on the PR head, not the merge commit.
+		sha = run.PRHeadCommit
+	}
+	st := CommitStatus{
+		SHA:         sha,
+		State:       ev.state,
+		Context:     commitStatusContext(job, run),
+		Description: truncateText(ev.description, 137), // GitHub allows 140 characters
+	}
+	if r.publicURL != "" {
+		st.TargetURL = fmt.Sprintf("%s/cicd/build-runs/%d", r.publicURL, run.ID)
+	}
+	key := fmt.Sprintf("%d/%s", run.ID, st.Context)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	id, err := api.postCommitStatus(ctx, r.client, loc, secret, st, r.checkIDs[key])
+	if err != nil {
+		return err
+	}
+	if id > 0 {
+		if len(r.checkIDs) >= 1024 {
+			// Ids only matter while a run is active; drop them all rather than track age.
+			r.checkIDs = map[string]int64{}
+		}
+		r.checkIDs[key] = id
+	}
+	return nil
+}
+
+// commitStatusContext is "bedrock/<job>" plus the matrix cell for child runs.
+func commitStatusContext(job *model.BuildJob, run *model.BuildRun) string {
+	name := "bedrock/" + job.Name
+	if len(run.MatrixCell) > 0 {
+		name += " (" + MatrixCellKey(run.MatrixCell) + ")"
+	}
+	return name
+}
@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+	resourcemodel "bedrock/internal/resource/model"
+)
+
+// fakeStatusAPI records requests sent to GitHub/GitLab/Gitea/Gitee status endpoints.
+type fakeStatusAPI struct {
+	mu   sync.Mutex
+	reqs []fakeStatusRequest
+}
+
+type fakeStatusRequest struct {
+	Method, Path, Auth string
+	Body               map[string]interface{}
+}
+
+func (f *fakeStatusAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		auth = r.Header.Get("Private-Token")
+	}
+	f.mu.Lock()
+	f.reqs = append(f.reqs, fakeStatusRequest{Method: r.Method, Path: r.URL.EscapedPath(), Auth: auth, Body: body})
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"id":42}`))
+}
+
+func (f *fakeStatusAPI) last(t *testing.T) fakeStatusRequest {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.reqs) == 0 {
+		t.Fatal("no request received")
+	}
+	return f.reqs[len(f.reqs)-1]
+}
+
+func TestParseRepoLocation(t *testing.T) {
+	t.Parallel()
+	cases := map[string]repoLocation{
+		"https://github.com/acme/app.git":        {BaseURL: "https://github.com", Path: "acme/app"},
+		"http://127.0.0.1:3000/acme/app":         {BaseURL: "http://127.0.0.1:3000", Path: "acme/app"},
+		"git@gitlab.com:group/sub/app.git":       {BaseURL: "https://gitlab.com", Path: "group/sub/app"},
+		"ssh://git@gitea.internal:2222/acme/app": {BaseURL: "https://gitea.internal", Path: "acme/app"},
+		"https://gitee.com/acme/app/":            {BaseURL: "https://gitee.com", Path: "acme/app"},
+	}
+	for raw, want := range cases {
+		got, err := parseRepoLocation(raw)
+		if err != nil || got != want {
+			t.Errorf("%s: got %+v err=%v want %+v", raw, got, err, want)
+		}
+	}
+	if _, err := parseRepoLocation("/srv/git/app"); err == nil {
+		t.Error("expected error for a local path")
+	}
+}
+
+func TestCommitStatusAPIs(t *testing.T) {
+	t.Parallel()
+	fake := &fakeStatusAPI{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	loc := repoLocation{BaseURL: srv.URL, Path: "acme/app"}
+	st := CommitStatus{SHA: "abc123", State: CommitStateRunning, Context: "bedrock/web", Description: "Building", TargetURL: "https://ci/cicd/build-runs/7"}
+	ctx := context.Background()
+
+	if _, err := (gitHubPlatform{}).postCommitStatus(ctx, srv.Client(), loc, "tok", st, 0); err != nil {
+		t.Fatal(err)
+	}
+	if r := fake.last(t); r.Path != "/api/v3/repos/acme/app/statuses/abc123" || r.Auth != "Bearer tok" ||
+		r.Body["state"] != "pending" || r.Body["context"] != "bedrock/web" || r.Body["target_url"] != st.TargetURL {
+		t.Fatalf("github request=%+v", r)
+	}
+
+	if _, err := (giteaPlatform{}).postCommitStatus(ctx, srv.Client(), loc, "tok", st, 0); err != nil {
+		t.Fatal(err)
+	}
+	if r := fake.last(t); r.Path != "/api/v1/repos/acme/app/statuses/abc123" || r.Auth != "token tok" || r.Body["state"] != "pending" {
+		t.Fatalf("gitea request=%+v", r)
+	}
+
+	st.State = CommitStateFailure
+	if _, err := (gitLabPlatform{}).postCommitStatus(ctx, srv.Client(), loc, "tok", st, 0); err != nil {
+		t.Fatal(err)
+	}
+	if r := fake.last(t); r.Path != "/api/v4/projects/acme%2Fapp/statuses/abc123" || r.Auth != "tok" ||
+		r.Body["state"] != "failed" || r.Body["name"] != "bedrock/web" {
+		t.Fatalf("gitlab request=%+v", r)
+	}
+
+	// Gitee creates a check run, then updates it by id.
+	st.State = CommitStateRunning
+	id, err := (giteePlatform{}).postCommitStatus(ctx, srv.Client(), loc, "tok", st, 0)
+	if err != nil || id != 42 {
+		t.Fatalf("gitee create: id=%d err=%v", id, err)
+	}
+	if r := fake.last(t); r.Method != http.MethodPost || r.Path != "/api/v5/repos/acme/app/check-runs" ||
+		r.Body["access_token"] != "tok" || r.Body["status"] != "in_progress" || r.Body["head_sha"] != "abc123" {
+		t.Fatalf("gitee create request=%+v", r)
+	}
+	st.State = CommitStateSuccess
+	if _, err := (giteePlatform{}).postCommitStatus(ctx, srv.Client(), loc, "tok", st, id); err != nil {
+		t.Fatal(err)
+	}
+	if r := fake.last(t); r.Method != http.MethodPatch || r.Path != "/api/v5/repos/acme/app/check-runs/42" ||
+		r.Body["status"] != "completed" || r.Body["conclusion"] != "success" {
+		t.Fatalf("gitee update request=%+v", r)
+	}
+}
+
+func TestCommitStatusReporter(t *testing.T) {
+	t.Parallel()
+	fake := &fakeStatusAPI{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	credID := uint(1)
+	runs := newMemRunStore(
+		&model.BuildRun{ID: 1, BuildJobID: 10, CommitHash: "abc123"},
+		&model.BuildRun{ID: 2, BuildJobID: 10},
+	)
+	jobs := &memJobStore{job: &model.BuildJob{ID: 10, RepositoryID: 3, Name: "web", ReportCommitStatus: true}}
+	repos := &memRepoStore{repo: &resourcemodel.Repository{
+		ID: 3, RepoURL: srv.URL + "/acme/app.git", AuthType: "credential", CredentialID: &credID, Platform: "gitea",
+	}}
+	r := NewCommitStatusReporter(runs, jobs, repos, mapSecrets{1: {"bot", "tok", ""}}, "https://ci.example.com/", zap.NewNop())
+	r.Report(1, CommitStatePending, "Queued")
+	r.Report(2, CommitStateRunning, "Building") // no commit yet: skipped
+	r.Report(1, CommitStateRunning, "Building")
+	r.Report(1, CommitStateSuccess, "Build succeeded")
+	r.Close()
+	r.Report(1, CommitStateFailure, "after close") // dropped, must not panic
+
+	if len(fake.reqs) != 3 {
+		t.Fatalf("requests=%+v", fake.reqs)
+	}
+	for i, want := range []string{"pending", "pending", "success"} {
+		got := fake.reqs[i]
+		if got.Body["state"] != want || got.Body["context"] != "bedrock/web" ||
+			got.Body["target_url"] != "https://ci.example.com/cicd/build-runs/1" || got.Auth != "token tok" {
+			t.Fatalf("request %d=%+v want state %s", i, got, want)
+		}
+	}
+
+	// Jobs can opt out.
+	jobs.job.ReportCommitStatus = false
+	r = NewCommitStatusReporter(runs, jobs, repos, mapSecrets{1: {"bot", "tok", ""}}, "", zap.NewNop())
+	r.Report(1, CommitStateFailure, "Build failed")
+	r.Close()
+	if len(fake.reqs) != 3 {
+		t.Fatalf("opted-out job still reported: %+v", fake.reqs[3:])
+	}
+}
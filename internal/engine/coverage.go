@@ -0,0 +1,299 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"bedrock/internal/cicd/model"
+)
+
+// collectCoverage ingests BuildJob.CoverageReportPaths after the build stage
+// and returns the merged summary (nil when nothing was collected).
+func (p *Pipeline) collectCoverage(run *model.BuildRun, job *model.BuildJob, workDir string, writeLine func(string)) *model.CoverageSummary {
+	patterns := parseCachePaths(job.CoverageReportPaths)
+	if len(patterns) == 0 {
+		return nil
+	}
+	writeLine("=== Stage: Coverage ===")
+	cov, warnings, err := CollectCoverage(workDir, patterns)
+	if err != nil {
+		writeLine("WARNING: 覆盖率报告路径无效: " + err.Error())
+		return nil
+	}
+	for _, w := range warnings {
+		writeLine("WARNING: 覆盖率报告解析失败: " + w)
+	}
+	if cov == nil {
+		writeLine("No coverage reports found")
+		return nil
+	}
+	cov.Threshold = job.CoverageThreshold
+	raw, _ := json.Marshal(cov)
+	pct := cov.Percent
+	_ = p.runs.UpdateFields(run.ID, map[string]interface{}{
+		"coverage_json":    string(raw),
+		"coverage_percent": &pct,
+	})
+	p.broadcastRunRefresh(run.ID)
+	writeLine(fmt.Sprintf("Coverage: %.2f%% (%d/%d lines, %d packages)",
+		cov.Percent, cov.LinesCovered, cov.LinesTotal, len(cov.Packages)))
+	return cov
+}
+
+// coverageSet merges line hits across reports: file -> line -> covered.
+type coverageSet struct {
+	lines map[string]map[int]bool
+	pkgs  map[string]string
+}
+
+func newCoverageSet() *coverageSet {
+	return &coverageSet{lines: map[string]map[int]bool{}, pkgs: map[string]string{}}
+}
+
+func (c *coverageSet) add(file, pkg string, line int, covered bool) {
+	m := c.lines[file]
+	if m == nil {
+		m = map[int]bool{}
+		c.lines[file] = m
+		if pkg == "" {
+			pkg = path.Dir(file)
+		}
+		c.pkgs[file] = pkg
+	}
+	m[line] = m[line] || covered
+}
+
+// CollectCoverage parses every coverage report matching patterns under workDir
+// and merges them; a line counts as covered when any report hit it.
+func CollectCoverage(workDir string, patterns []string) (*model.CoverageSummary, []string, error) {
+	files, err := globWorkspace(workDir, patterns)
+	if err != nil {
+		return nil, nil, err
+	}
+	set := newCoverageSet()
+	var warnings, parsed []string
+	for _, rel := range files {
+		full := filepath.Join(workDir, filepath.FromSlash(rel))
+		info, err := os.Stat(full)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", rel, err))
+			continue
+		}
+		if info.Size() > maxTestReportBytes {
+			warnings = append(warnings, fmt.Sprintf("%s: 文件过大（%d 字节），已跳过", rel, info.Size()))
+			continue
+		}
+		data, err := os.ReadFile(full)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", rel, err))
+			continue
+		}
+		if err := parseCoverage(data, workDir, set); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", rel, err))
+			continue
+		}
+		parsed = append(parsed, rel)
+	}
+	if len(parsed) == 0 {
+		return nil, warnings, nil
+	}
+	cov := summarizeCoverage(set)
+	cov.Files = parsed
+	return cov, warnings, nil
+}
+
+// ParseCoverage parses one report (Go coverprofile, Cobertura XML or LCOV).
+func ParseCoverage(data []byte) (*model.CoverageSummary, error) {
+	set := newCoverageSet()
+	if err := parseCoverage(data, "", set); err != nil {
+		return nil, err
+	}
+	return summarizeCoverage(set), nil
+}
+
+// DecodeCoverage fills BuildRun.Coverage from CoverageJSON.
+func DecodeCoverage(run *model.BuildRun) {
+	if run == nil || run.Coverage != nil || strings.TrimSpace(run.CoverageJSON) == "" {
+		return
+	}
+	var c model.CoverageSummary
+	if err := json.Unmarshal([]byte(run.CoverageJSON), &c); err == nil {
+		run.Coverage = &c
+	}
+}
+
+func parseCoverage(data []byte, workDir string, set *coverageSet) error {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) == 0:
+		return fmt.Errorf("空报告")
+	case bytes.HasPrefix(trimmed, []byte("mode:")):
+		return parseGoCoverProfile(trimmed, set)
+	case trimmed[0] == '<':
+		return parseCobertura(trimmed, workDir, set)
+	case bytes.HasPrefix(trimmed, []byte("TN:")) || bytes.HasPrefix(trimmed, []byte("SF:")):
+		return parseLCOV(trimmed, workDir, set)
+	default:
+		return fmt.Errorf("无法识别的覆盖率格式（支持 Go coverprofile、Cobertura XML、LCOV）")
+	}
+}
+
+// parseGoCoverProfile reads `go test -coverprofile` output:
+// "file.go:startLine.startCol,endLine.endCol numStmts count".
+func parseGoCoverProfile(data []byte, set *coverageSet) error {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		colon := strings.LastIndex(line, ":")
+		fields := strings.Fields(line[colon+1:])
+		if colon <= 0 || len(fields) != 3 {
+			return fmt.Errorf("无效的 coverprofile 行: %q", line)
+		}
+		file := line[:colon]
+		start, end, ok := parseCoverBlock(fields[0])
+		count, err := strconv.ParseInt(fields[2], 10, 64)
+		if !ok || err != nil {
+			return fmt.Errorf("无效的 coverprofile 行: %q", line)
+		}
+		for n := start; n <= end; n++ {
+			set.add(file, "", n, count > 0)
+		}
+	}
+	return sc.Err()
+}
+
+// parseCoverBlock parses "startLine.startCol,endLine.endCol".
+func parseCoverBlock(s string) (start, end int, ok bool) {
+	from, to, found := strings.Cut(s, ",")
+	if !found {
+		return 0, 0, false
+	}
+	startLine, _, _ := strings.Cut(from, ".")
+	endLine, _, _ := strings.Cut(to, ".")
+	a, err1 := strconv.Atoi(startLine)
+	b, err2 := strconv.Atoi(endLine)
+	if err1 != nil || err2 != nil || a <= 0 || b < a {
+		return 0, 0, false
+	}
+	return a, b, true
+}
+
+type coberturaReport struct {
+	XMLName  xml.Name `xml:"coverage"`
+	Packages []struct {
+		Name    string `xml:"name,attr"`
+		Classes []struct {
+			Filename string `xml:"filename,attr"`
+			Lines    []struct {
+				Number int    `xml:"number,attr"`
+				Hits   string `xml:"hits,attr"`
+			} `xml:"lines>line"`
+		} `xml:"classes>class"`
+	} `xml:"packages>package"`
+}
+
+func parseCobertura(data []byte, workDir string, set *coverageSet) error {
+	var rep coberturaReport
+	if err := xml.Unmarshal(data, &rep); err != nil {
+		return fmt.Errorf("Cobertura XML 解析失败: %w", err)
+	}
+	for _, pkg := range rep.Packages {
+		for _, cls := range pkg.Classes {
+			file := coverageFileName(workDir, cls.Filename)
+			for _, l := range cls.Lines {
+				hits, _ := strconv.ParseFloat(l.Hits, 64)
+				set.add(file, pkg.Name, l.Number, hits > 0)
+			}
+		}
+	}
+	return nil
+}
+
+// parseLCOV reads SF:/DA: records; other record types are ignored.
+func parseLCOV(data []byte, workDir string, set *coverageSet) error {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	file := ""
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			file = coverageFileName(workDir, strings.TrimPrefix(line, "SF:"))
+		case strings.HasPrefix(line, "DA:") && file != "":
+			parts := strings.Split(strings.TrimPrefix(line, "DA:"), ",")
+			if len(parts) < 2 {
+				return fmt.Errorf("无效的 LCOV 行: %q", line)
+			}
+			n, err1 := strconv.Atoi(parts[0])
+			hits, err2 := strconv.ParseInt(parts[1], 10, 64)
+			if err1 != nil || err2 != nil {
+				return fmt.Errorf("无效的 LCOV 行: %q", line)
+			}
+			set.add(file, "", n, hits > 0)
+		case line == "end_of_record":
+			file = ""
+		}
+	}
+	return sc.Err()
+}
+
+// coverageFileName turns absolute paths inside the workspace into repo-relative ones.
+func coverageFileName(workDir, name string) string {
+	name = strings.TrimSpace(name)
+	if workDir != "" && filepath.IsAbs(name) {
+		if rel, err := filepath.Rel(workDir, name); err == nil && !strings.HasPrefix(rel, "..") {
+			name = rel
+		}
+	}
+	return filepath.ToSlash(name)
+}
+
+func summarizeCoverage(set *coverageSet) *model.CoverageSummary {
+	cov := &model.CoverageSummary{}
+	byPkg := map[string]*model.PackageCoverage{}
+	for file, lines := range set.lines {
+		name := set.pkgs[file]
+		pc := byPkg[name]
+		if pc == nil {
+			pc = &model.PackageCoverage{Name: name}
+			byPkg[name] = pc
+		}
+		for _, covered := range lines {
+			pc.LinesTotal++
+			if covered {
+				pc.LinesCovered++
+			}
+		}
+	}
+	for _, pc := range byPkg {
+		pc.Percent = coveragePercent(pc.LinesCovered, pc.LinesTotal)
+		cov.LinesCovered += pc.LinesCovered
+		cov.LinesTotal += pc.LinesTotal
+		cov.Packages = append(cov.Packages, *pc)
+	}
+	sort.Slice(cov.Packages, func(i, j int) bool { return cov.Packages[i].Name < cov.Packages[j].Name })
+	cov.Percent = coveragePercent(cov.LinesCovered, cov.LinesTotal)
+	return cov
+}
+
+// coveragePercent rounds to two decimals so the threshold check matches what users see.
+func coveragePercent(covered, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(covered)*10000/float64(total)) / 100
+}
@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+	resourcemodel "bedrock/internal/resource/model"
+)
+
+func TestParseCoverage_formats(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name    string
+		report  string
+		covered int
+		total   int
+		pkgs    []string
+	}{
+		{
+			name: "coverprofile",
+			report: `mode: atomic
+example.com/m/a/a.go:3.10,5.2 2 1
+example.com/m/a/a.go:5.2,6.3 1 0
+example.com/m/b/b.go:1.1,2.5 1 0
+`,
+			// a.go lines 3-6 (line 5 shared by a hit block), b.go lines 1-2.
+			covered: 3, total: 6, pkgs: []string{"example.com/m/a", "example.com/m/b"},
+		},
+		{
+			name: "cobertura",
+			report: `<?xml version="1.0" ?>
+<coverage line-rate="0.5">
+  <packages>
+    <package name="app">
+      <classes>
+        <class name="A" filename="app/a.py">
+          <methods><method name="f"><lines><line number="1" hits="3"/></lines></method></methods>
+          <lines><line number="1" hits="3"/><line number="2" hits="0"/></lines>
+        </class>
+      </classes>
+    </package>
+  </packages>
+</coverage>`,
+			covered: 1, total: 2, pkgs: []string{"app"},
+		},
+		{
+			name: "lcov",
+			report: `TN:
+SF:src/x.js
+DA:1,1
+DA:2,0
+DA:3,5
+end_of_record
+SF:lib/y.js
+DA:1,0
+end_of_record
+`,
+			covered: 2, total: 4, pkgs: []string{"lib", "src"},
+		},
+	}
+	for _, tc := range cases {
+		cov, err := ParseCoverage([]byte(tc.report))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var pkgs []string
+		for _, p := range cov.Packages {
+			pkgs = append(pkgs, p.Name)
+		}
+		if cov.LinesCovered != tc.covered || cov.LinesTotal != tc.total || strings.Join(pkgs, ",") != strings.Join(tc.pkgs, ",") {
+			t.Fatalf("%s: got %d/%d pkgs=%v", tc.name, cov.LinesCovered, cov.LinesTotal, pkgs)
+		}
+	}
+	if _, err := ParseCoverage([]byte("hello")); err == nil {
+		t.Fatal("unknown format accepted")
+	}
+}
+
+func TestCollectCoverage_mergesReports(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	abs := filepath.Join(dir, "src", "x.js")
+	write := func(rel, body string) {
+		full := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Two shards of the same file: a line hit in either counts as covered.
+	write("cov/unit/lcov.info", "SF:"+abs+"\nDA:1,1\nDA:2,0\nend_of_record\n")
+	write("cov/e2e/lcov.info", "SF:src/x.js\nDA:1,0\nDA:2,4\nend_of_record\n")
+	write("cov/bad/lcov.info", "garbage")
+
+	cov, warnings, err := CollectCoverage(dir, []string{"cov/**/lcov.info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "cov/bad/lcov.info") {
+		t.Fatalf("warnings=%v", warnings)
+	}
+	if cov.LinesCovered != 2 || cov.LinesTotal != 2 || cov.Percent != 100 || len(cov.Files) != 2 {
+		t.Fatalf("cov=%+v", cov)
+	}
+	if len(cov.Packages) != 1 || cov.Packages[0].Name != "src" {
+		t.Fatalf("packages=%+v", cov.Packages)
+	}
+}
+
+func TestExecuteFailsRunBelowCoverageThreshold(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("sh script")
+	}
+	repoDir := initLocalGitRepo(t)
+	tmp := t.TempDir()
+	run := &model.BuildRun{ID: 1, BuildJobID: 10, BuildNumber: 1, Status: "queued", Stage: "pending", Branch: "main", TriggeredBy: 7}
+	store := newMemRunStore(run)
+	jobStore := &memJobStore{job: &model.BuildJob{
+		ID: 10, RepositoryID: 1, Branch: "main",
+		BuildScript:         `printf 'mode: set\nexample.com/m/a.go:1.1,4.2 1 1\nexample.com/m/a.go:5.1,6.2 1 0\n' > cover.out`,
+		CoverageReportPaths: "cover.out",
+		CoverageThreshold:   80,
+	}}
+	repoStore := &memRepoStore{repo: &resourcemodel.Repository{ID: 1, RepoURL: repoDir, AuthType: "none"}}
+	p := NewPipeline(store, jobStore, repoStore, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(),
+		filepath.Join(tmp, "ws"), filepath.Join(tmp, "a"), filepath.Join(tmp, "l"), filepath.Join(tmp, "c"))
+
+	p.Execute(context.Background(), 1)
+	got, _ := store.FindByID(1)
+	if got.Status != "failed" || !strings.Contains(got.ErrorMessage, "66.67%") {
+		t.Fatalf("status=%s error=%q", got.Status, got.ErrorMessage)
+	}
+	DecodeCoverage(got)
+	if got.CoveragePercent == nil || *got.CoveragePercent != 66.67 || got.Coverage == nil || got.Coverage.Threshold != 80 {
+		t.Fatalf("coverage=%v json=%s", got.CoveragePercent, got.CoverageJSON)
+	}
+}
@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"fmt"
+
+	"bedrock/internal/cicd/model"
+	"bedrock/internal/pkg"
+)
+
+// credentialEnv resolves the job's credential bindings to NAME=value pairs.
+// Values are only ever placed in the script environment, never in snapshots,
+// and are registered with redact so the build log masks them.
+func (p *Pipeline) credentialEnv(job *model.BuildJob, redact *pkg.Redactor) ([]string, error) {
+	if len(job.CredentialEnvs) == 0 {
+		return nil, nil
+	}
+	if p.secrets == nil {
+		return nil, fmt.Errorf("credential resolver not configured")
+	}
+	env := make([]string, 0, len(job.CredentialEnvs))
+	for _, b := range job.CredentialEnvs {
+		_, username, secret, passphrase, err := p.secrets.Resolve(b.CredentialID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", b.Name, err)
+		}
+		v := secret
+		switch b.Field {
+		case "username":
+			v = username
+		case "passphrase":
+			v = passphrase
+		}
+		redact.Add(v)
+		env = append(env, b.Name+"="+v)
+	}
+	return env, nil
+}
@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+	resourcemodel "bedrock/internal/resource/model"
+)
+
+type mapSecrets map[uint][3]string
+
+func (m mapSecrets) Resolve(id uint) (string, string, string, string, error) {
+	v, ok := m[id]
+	if !ok {
+		return "", "", "", "", errors.New("credential not found")
+	}
+	return "password", v[0], v[1], v[2], nil
+}
+
+func TestExecuteExportsCredentialEnvs(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	repoDir := initLocalGitRepo(t)
+	tmp := t.TempDir()
+	store := newMemRunStore(&model.BuildRun{ID: 1, BuildJobID: 10, BuildNumber: 1, Status: "queued", Stage: "pending", Branch: "main"})
+	jobStore := &memJobStore{job: &model.BuildJob{
+		ID: 10, RepositoryID: 1, Branch: "main", ArtifactFormat: "gzip",
+		BuildScript: `test "$DEPLOY_TOKEN" = "tok-123" && test "$DEPLOY_USER" = "bot" && echo creds-ok
+echo "leak=$DEPLOY_TOKEN"
+echo "leak-b64=dG9rLTEyMw=="`,
+		CredentialEnvs: []model.JobCredentialEnv{
+			{Name: "DEPLOY_TOKEN", CredentialID: 7, Field: "secret"},
+			{Name: "DEPLOY_USER", CredentialID: 7, Field: "username"},
+		},
+	}}
+	repoStore := &memRepoStore{repo: &resourcemodel.Repository{ID: 1, RepoURL: repoDir, AuthType: "none"}}
+	secrets := mapSecrets{7: {"bot", "tok-123", ""}}
+	p := NewPipeline(store, jobStore, repoStore, &memServerStore{}, secrets, nil, zap.NewNop(),
+		filepath.Join(tmp, "ws"), filepath.Join(tmp, "artifacts"), filepath.Join(tmp, "logs"), filepath.Join(tmp, "cache"))
+
+	p.Execute(context.Background(), 1)
+
+	got, _ := store.FindByID(1)
+	if got.Status != "success" {
+		t.Fatalf("status=%s (error=%q)", got.Status, got.ErrorMessage)
+	}
+	body, _ := os.ReadFile(got.LogPath)
+	if !strings.Contains(string(body), "creds-ok") {
+		t.Fatalf("credential env not exported:\n%s", body)
+	}
+	if strings.Contains(string(body), "tok-123") || strings.Contains(string(body), "dG9rLTEyMw") ||
+		!strings.Contains(string(body), "leak=***") {
+		t.Fatalf("credential value not redacted:\n%s", body)
+	}
+
+	// A dangling binding fails the run before the script executes.
+	jobStore.job.CredentialEnvs = []model.JobCredentialEnv{{Name: "GONE", CredentialID: 99, Field: "secret"}}
+	store.runs[2] = &model.BuildRun{ID: 2, BuildJobID: 10, BuildNumber: 2, Status: "queued", Stage: "pending", Branch: "main"}
+	p.Execute(context.Background(), 2)
+	got, _ = store.FindByID(2)
+	if got.Status != "failed" || !strings.Contains(got.ErrorMessage, "凭证变量解析失败") {
+		t.Fatalf("status=%s error=%q", got.Status, got.ErrorMessage)
+	}
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+)
+
+// DeployTargetDeployment kinds.
+const (
+	DeploymentDeploy   = "deploy"
+	DeploymentRedeploy = "redeploy"
+	DeploymentRollback = "rollback"
+)
+
+// Snapshot keys BuildRunService.Redeploy sets next to redeploy_target_ids.
+const (
+	snapshotRedeployKind   = "redeploy_kind"
+	snapshotRedeployBy     = "redeploy_by"
+	snapshotRedeployByName = "redeploy_by_name"
+)
+
+// deploymentOrigin is the kind and actor of the run's current distribution:
+// the run's trigger for its own build, whoever asked for a redeploy or
+// rollback otherwise.
+func deploymentOrigin(run *model.BuildRun) (kind string, by uint, byName string) {
+	if run.TriggerType != "redeploy" {
+		return DeploymentDeploy, run.TriggeredBy, ""
+	}
+	kind, by = DeploymentRedeploy, run.TriggeredBy
+	var snap map[string]interface{}
+	if strings.TrimSpace(run.SnapshotJSON) == "" || json.Unmarshal([]byte(run.SnapshotJSON), &snap) != nil {
+		return kind, by, ""
+	}
+	if k, _ := snap[snapshotRedeployKind].(string); k == DeploymentRollback {
+		kind = DeploymentRollback
+	}
+	if n, ok := snap[snapshotRedeployBy].(float64); ok && n > 0 {
+		by = uint(n)
+	}
+	byName, _ = snap[snapshotRedeployByName].(string)
+	return kind, by, byName
+}
+
+// recordDeployment makes a successful attempt the target's current deployment.
+func (p *Pipeline) recordDeployment(run *model.BuildRun, t *model.DeployTarget, attempt *model.BuildDeployAttempt) {
+	kind, by, byName := deploymentOrigin(run)
+	at := time.Now()
+	if attempt.FinishedAt != nil {
+		at = *attempt.FinishedAt
+	}
+	err := p.runs.RecordDeployment(&model.DeployTargetDeployment{
+		BuildJobID:     t.BuildJobID,
+		DeployTargetID: t.ID,
+		BuildRunID:     run.ID,
+		BuildNumber:    run.BuildNumber,
+		AttemptID:      attempt.ID,
+		CommitHash:     run.CommitHash,
+		ArtifactSHA256: attempt.ArtifactSHA256,
+		Kind:           kind,
+		DeployedBy:     by,
+		DeployedByName: byName,
+		DeployedAt:     at,
+	})
+	if err != nil && p.logger != nil {
+		p.logger.Warn("record deployment failed", zap.Uint("run_id", run.ID), zap.Uint("target_id", t.ID), zap.Error(err))
+	}
+}
@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+	resourcemodel "bedrock/internal/resource/model"
+)
+
+func TestPipeline_recordsTargetDeployments(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	repoDir := initLocalGitRepo(t)
+	tmp := t.TempDir()
+	store := newMemRunStore(&model.BuildRun{ID: 1, BuildJobID: 10, BuildNumber: 1, Status: "queued", Stage: "pending", Branch: "main", TriggeredBy: 3})
+	jobStore := &memJobStore{
+		job: &model.BuildJob{
+			ID: 10, RepositoryID: 1, Branch: "main",
+			BuildScript: "mkdir -p dist && echo bin > dist/app", OutputDir: "dist", MaxArtifacts: 5,
+		},
+		targets: []model.DeployTarget{
+			{ID: 1, BuildJobID: 10, Method: "local", RemotePath: filepath.Join(tmp, "a")},
+			{ID: 2, BuildJobID: 10, Method: "local", RemotePath: filepath.Join(tmp, "b")},
+		},
+	}
+	repoStore := &memRepoStore{repo: &resourcemodel.Repository{ID: 1, RepoURL: repoDir, AuthType: "none"}}
+	p := NewPipeline(store, jobStore, repoStore, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(),
+		filepath.Join(tmp, "ws"), filepath.Join(tmp, "a-store"), filepath.Join(tmp, "l"), filepath.Join(tmp, "c"))
+	p.SetArtifactStore(newMemArtifactStore(t))
+
+	p.Execute(context.Background(), 1)
+	if len(store.deployed) != 2 {
+		t.Fatalf("deployed=%+v", store.deployed)
+	}
+	for _, d := range store.deployed {
+		if !d.Current || d.Kind != DeploymentDeploy || d.DeployedBy != 3 || d.BuildJobID != 10 || d.AttemptID == 0 || d.ArtifactSHA256 == "" {
+			t.Fatalf("deployment=%+v", d)
+		}
+	}
+
+	// A rollback to target 2 is recorded with whoever asked for it.
+	_ = store.UpdateFields(1, map[string]interface{}{
+		"trigger_type":  "redeploy",
+		"snapshot_json": `{"redeploy_target_ids":[2],"redeploy_kind":"rollback","redeploy_by":7,"redeploy_by_name":"ops"}`,
+	})
+	p.Execute(context.Background(), 1)
+	if len(store.deployed) != 3 {
+		t.Fatalf("deployed=%+v", store.deployed)
+	}
+	if d := store.deployed[1]; d.DeployTargetID != 2 || d.Current {
+		t.Fatalf("replaced deployment=%+v", d)
+	}
+	if d := store.deployed[2]; d.DeployTargetID != 2 || !d.Current || d.Kind != DeploymentRollback || d.DeployedBy != 7 || d.DeployedByName != "ops" {
+		t.Fatalf("rollback deployment=%+v", d)
+	}
+}
@@ -1,8 +1,12 @@
 package engine
 
 import (
+	"io"
+	"time"
+
 	"bedrock/internal/cicd/model"
 	resourcemodel "bedrock/internal/resource/model"
+	storagemodel "bedrock/internal/storage/model"
 )
 
 // RunStore is the BuildRun persistence surface used by Pipeline/Scheduler.
@@ -12,10 +16,22 @@ type RunStore interface {
 	CreateAttempt(a *model.BuildDeployAttempt) error
 	UpdateAttempt(a *model.BuildDeployAttempt) error
 	NextBatchNo(runID uint) (int, error)
+	CreateApproval(a *model.BuildDeployApproval) error
+	LatestApproval(runID uint) (*model.BuildDeployApproval, error)
+	MarkApprovalApplied(id uint) error
 	ListByStatuses(statuses ...string) ([]model.BuildRun, error)
 	MarkRunningInterrupted() (int64, error)
 	HasNonTerminal(jobID uint) (bool, error)
 	ListArtifactsByJob(jobID uint) ([]model.BuildRun, error)
+	ListByParent(parentID uint) ([]model.BuildRun, error)
+	ReplaceTestResults(runID uint, suites []model.BuildTestSuite) error
+	// ListDeployedRunIDs returns runs whose artifact is the current
+	// deployment of one of the job's DeployTargets.
+	ListDeployedRunIDs(jobID uint) ([]uint, error)
+	// RecordDeployment makes d its target's current deployment.
+	RecordDeployment(d *model.DeployTargetDeployment) error
+	// LastBuiltCommit is the commit of the job's newest branch run ("" = none).
+	LastBuiltCommit(jobID uint, branch string) (string, error)
 }
 
 // JobStore loads BuildJob + DeployTargets.
@@ -23,7 +39,9 @@ type JobStore interface {
 	FindByID(id uint) (*model.BuildJob, error)
 	ListDeployTargets(jobID uint) ([]model.DeployTarget, error)
 	ListCronEnabled() ([]model.BuildJob, error)
+	ListPollEnabled() ([]model.BuildJob, error)
 	ListByRepositoryID(repositoryID uint) ([]model.BuildJob, error)
+	ListAll() ([]model.BuildJob, error)
 }
 
 // RepoStore loads Repository.
@@ -36,6 +54,16 @@ type ServerStore interface {
 	FindByID(id uint) (*resourcemodel.Server, error)
 }
 
+// ArtifactStore is the content-addressed store build artifacts are kept in
+// (storage.StorageService). Delete releases one reference; PurgeExpired
+// unlinks objects nothing references any more.
+type ArtifactStore interface {
+	Put(kind, contentType string, source io.Reader, declaredSize int64, createdBy uint) (*storagemodel.StorageObject, error)
+	LocalPath(id uint) (string, error)
+	Delete(id uint) error
+	PurgeExpired(now time.Time) (int, error)
+}
+
 // SecretResolver decrypts credentials for git/SSH/agent (never exposed via API).
 type SecretResolver interface {
 	Resolve(id uint) (typ, username, secret, passphrase string, err error)
@@ -52,6 +80,25 @@ type EnqueueParams struct {
 	TriggerType   string
 	CommitHash    string
 	CommitMessage string
+	// Params are raw trigger inputs, validated against BuildJob.Parameters.
+	Params map[string]string
+	// MatrixCell pins a single matrix cell (retry of one child) instead of fanning out.
+	MatrixCell map[string]string
+	// GitRef is checked out instead of Branch (pull request head/merge ref).
+	GitRef string
+	// PullRequest is set for runs building a pull/merge request.
+	PullRequest *PullRequestInfo
+	// Tag is set for runs building a pushed tag (GitRef defaults to refs/tags/<Tag>).
+	Tag string
+}
+
+// PullRequestInfo identifies the pull/merge request a run builds.
+type PullRequestInfo struct {
+	Number       int
+	SourceBranch string
+	TargetBranch string
+	Author       string
+	HeadCommit   string
 }
 
 // RunScheduler submits/cancels runs in the in-memory worker pool.
@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bedrock/internal/cicd/model"
+	"bedrock/internal/pkg"
+)
+
+// Distribution strategies (BuildJob.DistributeStrategy).
+const (
+	DistributeSerial   = "serial"
+	DistributeParallel = "parallel"
+	DistributeRolling  = "rolling"
+	DistributeCanary   = "canary"
+
+	// MaxDistributeConcurrency caps parallel deploys and rolling wave size.
+	MaxDistributeConcurrency = 50
+
+	// AttemptAborted marks targets a rolling or canary batch never reached.
+	AttemptAborted = "aborted"
+
+	canaryVerifyTimeout = 10 * time.Minute
+)
+
+// NormalizeDistributeStrategy maps empty/unknown input to serial.
+func NormalizeDistributeStrategy(s string) string {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case DistributeParallel:
+		return DistributeParallel
+	case DistributeRolling:
+		return DistributeRolling
+	case DistributeCanary:
+		return DistributeCanary
+	default:
+		return DistributeSerial
+	}
+}
+
+// ValidateDistributeStrategy checks a job's strategy settings.
+func ValidateDistributeStrategy(job *model.BuildJob) error {
+	switch job.DistributeStrategy {
+	case DistributeSerial, DistributeParallel, DistributeRolling, DistributeCanary:
+	default:
+		return fmt.Errorf("未知分发策略 %q（serial / parallel / rolling / canary）", job.DistributeStrategy)
+	}
+	if job.DistributeConcurrency < 1 || job.DistributeConcurrency > MaxDistributeConcurrency {
+		return fmt.Errorf("分发并发数须在 1 到 %d 之间", MaxDistributeConcurrency)
+	}
+	if job.DistributeMaxFailures < 0 {
+		return fmt.Errorf("失败阈值不能为负数")
+	}
+	return nil
+}
+
+// distributionWaves splits n targets (in SortOrder) into the strategy's waves
+// and returns how many targets of a wave deploy at once.
+func distributionWaves(job *model.BuildJob, n int) (waves [][]int, concurrency int) {
+	concurrency = min(max(job.DistributeConcurrency, 1), MaxDistributeConcurrency)
+	all := make([]int, n)
+	for i := range all {
+		all[i] = i
+	}
+	switch NormalizeDistributeStrategy(job.DistributeStrategy) {
+	case DistributeParallel:
+		return [][]int{all}, concurrency
+	case DistributeRolling:
+		for start := 0; start < n; start += concurrency {
+			waves = append(waves, all[start:min(start+concurrency, n)])
+		}
+		return waves, concurrency
+	case DistributeCanary:
+		if n <= 1 {
+			return [][]int{all}, 1
+		}
+		return [][]int{all[:1], all[1:]}, concurrency
+	default:
+		return [][]int{all}, 1
+	}
+}
+
+// describeStrategy is the log line announcing how a batch will deploy.
+func describeStrategy(job *model.BuildJob, waves [][]int, concurrency int) string {
+	switch NormalizeDistributeStrategy(job.DistributeStrategy) {
+	case DistributeParallel:
+		return fmt.Sprintf("Strategy: parallel, up to %d target(s) at once", concurrency)
+	case DistributeRolling:
+		return fmt.Sprintf("Strategy: rolling, %d wave(s) of up to %d target(s), stop after more than %d failure(s)",
+			len(waves), concurrency, job.DistributeMaxFailures)
+	case DistributeCanary:
+		return fmt.Sprintf("Strategy: canary, then up to %d target(s) at once", concurrency)
+	default:
+		return "Strategy: serial"
+	}
+}
+
+// deployWave deploys targets[idx] with up to concurrency at once and counts
+// the outcomes. Lines of concurrent targets are prefixed with the target id.
+// verify, when set, runs after each successful deploy and fails the attempt.
+func (p *Pipeline) deployWave(
+	ctx context.Context,
+	run *model.BuildRun,
+	batchNo, wave int,
+	targets []model.DeployTarget,
+	idx []int,
+	concurrency int,
+	sources *deploySources,
+	redact *pkg.Redactor,
+	writeLine func(string),
+	verify func(*model.DeployTarget) error,
+) (nOK, nFail int) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(concurrency, 1))
+	for _, i := range idx {
+		t := targets[i]
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			status, msg := stoppedAttemptStatus(ctx)
+			p.recordAttemptStopped(run, batchNo, wave, &t, status, msg)
+			continue
+		}
+		log := writeLine
+		if concurrency > 1 {
+			log = func(line string) { writeLine("[#" + strconv.FormatUint(uint64(t.ID), 10) + "] " + line) }
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := p.deployAttempt(ctx, run, batchNo, wave, &t, sources, redact, log, verify)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				nFail++
+			} else {
+				nOK++
+			}
+		}()
+	}
+	wg.Wait()
+	return nOK, nFail
+}
+
+// runCanaryVerify runs job.CanaryVerifyScript on the Bedrock host after the
+// canary target deployed; a non-zero exit fails the canary.
+func (p *Pipeline) runCanaryVerify(ctx context.Context, run *model.BuildRun, job *model.BuildJob, t *model.DeployTarget, writeLine func(string)) error {
+	writeLine("=== Verifying canary ===")
+	ctx, cancel := context.WithTimeout(ctx, canaryVerifyTimeout)
+	defer cancel()
+	dir, err := os.MkdirTemp("", "bedrock-verify-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	cmd, cleanup, err := newBuildScriptCommand(ctx, dir, job.BuildScriptType, job.CanaryVerifyScript)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	host := ""
+	if t.ServerID != nil {
+		if server, err := p.servers.FindByID(*t.ServerID); err == nil {
+			host = server.Host
+		}
+	}
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"BEDROCK_RUN_ID="+strconv.FormatUint(uint64(run.ID), 10),
+		"BEDROCK_BUILD_NUMBER="+strconv.Itoa(run.BuildNumber),
+		"BEDROCK_COMMIT="+run.CommitHash,
+		"BEDROCK_DEPLOY_TARGET_ID="+strconv.FormatUint(uint64(t.ID), 10),
+		"BEDROCK_DEPLOY_HOST="+host,
+		"BEDROCK_DEPLOY_PATH="+t.RemotePath,
+		"BEDROCK_DEPLOY_ENVIRONMENT="+t.Environment,
+	)
+	configureBuildCmdProc(cmd)
+	cmd.Cancel = func() error { return killBuildCmdProcess(cmd) }
+	stdout, _ := cmd.StdoutPipe()
+	stderr, _ := cmd.StderrPipe()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer func() { _ = killBuildCmdProcess(cmd) }()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scanLines(stdout, writeLine)
+	}()
+	scanLines(stderr, func(line string) { writeLine("stderr: " + line) })
+	wg.Wait()
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("金丝雀验证失败: %w", err)
+	}
+	writeLine("Canary verification passed")
+	return nil
+}
@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+	resourcemodel "bedrock/internal/resource/model"
+)
+
+func TestDistributionWaves(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		strategy    string
+		concurrency int
+		n           int
+		want        [][]int
+		wantConc    int
+	}{
+		{"", 0, 3, [][]int{{0, 1, 2}}, 1},
+		{DistributeSerial, 4, 3, [][]int{{0, 1, 2}}, 1},
+		{DistributeParallel, 4, 3, [][]int{{0, 1, 2}}, 4},
+		{DistributeRolling, 2, 5, [][]int{{0, 1}, {2, 3}, {4}}, 2},
+		{DistributeCanary, 3, 4, [][]int{{0}, {1, 2, 3}}, 3},
+		{DistributeCanary, 3, 1, [][]int{{0}}, 1},
+	}
+	for _, c := range cases {
+		job := &model.BuildJob{DistributeStrategy: c.strategy, DistributeConcurrency: c.concurrency}
+		got, conc := distributionWaves(job, c.n)
+		if !reflect.DeepEqual(got, c.want) || conc != c.wantConc {
+			t.Errorf("%s/%d/%d: got %v (%d) want %v (%d)", c.strategy, c.concurrency, c.n, got, conc, c.want, c.wantConc)
+		}
+	}
+}
+
+func TestPipeline_distributeStrategies(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	repoDir := initLocalGitRepo(t)
+	tmp := t.TempDir()
+	// A regular file as parent makes a local target fail.
+	blocker := filepath.Join(tmp, "blocker")
+	if err := os.WriteFile(blocker, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(runID uint, job model.BuildJob, paths ...string) *memRunStore {
+		store := newMemRunStore(&model.BuildRun{ID: runID, BuildJobID: 10, BuildNumber: int(runID), Status: "queued", Stage: "pending", Branch: "main"})
+		job.ID, job.RepositoryID, job.Branch = 10, 1, "main"
+		job.BuildScript, job.OutputDir, job.MaxArtifacts = "mkdir -p dist && echo bin > dist/app", "dist", 5
+		jobStore := &memJobStore{job: &job}
+		for i, path := range paths {
+			jobStore.targets = append(jobStore.targets, model.DeployTarget{
+				ID: uint(i + 1), BuildJobID: 10, Method: "local", RemotePath: path, SortOrder: i,
+			})
+		}
+		repoStore := &memRepoStore{repo: &resourcemodel.Repository{ID: 1, RepoURL: repoDir, AuthType: "none"}}
+		ws := filepath.Join(tmp, "ws", filepath.Base(paths[0]))
+		p := NewPipeline(store, jobStore, repoStore, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(),
+			ws, ws+"-a", ws+"-l", ws+"-c")
+		p.Execute(context.Background(), runID)
+		return store
+	}
+	statuses := func(store *memRunStore) map[uint][2]any {
+		out := map[uint][2]any{}
+		for _, a := range store.attempts {
+			out[*a.DeployTargetID] = [2]any{a.Status, a.Wave}
+		}
+		return out
+	}
+
+	// Parallel: every target deploys in one wave.
+	dir := filepath.Join(tmp, "par")
+	store := run(1, model.BuildJob{DistributeStrategy: DistributeParallel, DistributeConcurrency: 3},
+		dir+"1", dir+"2", dir+"3")
+	r, _ := store.FindByID(1)
+	if r.DistributionSummary != "all_success" || len(store.attempts) != 3 {
+		t.Fatalf("parallel: summary=%s attempts=%+v", r.DistributionSummary, store.attempts)
+	}
+	for _, a := range store.attempts {
+		if a.Status != "success" || a.Wave != 1 {
+			t.Fatalf("parallel attempt %+v", a)
+		}
+	}
+
+	// Rolling: the first wave exceeds the failure threshold, the rest is aborted.
+	dir = filepath.Join(tmp, "roll")
+	store = run(2, model.BuildJob{DistributeStrategy: DistributeRolling, DistributeConcurrency: 2},
+		dir+"1", filepath.Join(blocker, "x"), dir+"3", dir+"4")
+	r, _ = store.FindByID(2)
+	want := map[uint][2]any{1: {"success", 1}, 2: {"failed", 1}, 3: {AttemptAborted, 2}, 4: {AttemptAborted, 2}}
+	if r.DistributionSummary != AttemptAborted || !reflect.DeepEqual(statuses(store), want) {
+		t.Fatalf("rolling: summary=%s attempts=%v", r.DistributionSummary, statuses(store))
+	}
+	if _, err := os.Stat(dir + "3"); !os.IsNotExist(err) {
+		t.Fatalf("aborted target deployed: %v", err)
+	}
+
+	// Rolling within the threshold keeps going.
+	dir = filepath.Join(tmp, "tolerant")
+	store = run(3, model.BuildJob{DistributeStrategy: DistributeRolling, DistributeConcurrency: 1, DistributeMaxFailures: 1},
+		filepath.Join(blocker, "y"), dir+"2")
+	r, _ = store.FindByID(3)
+	if r.DistributionSummary != "partial" {
+		t.Fatalf("tolerant rolling: summary=%s attempts=%v", r.DistributionSummary, statuses(store))
+	}
+
+	// Canary: a failing verification aborts the other targets.
+	dir = filepath.Join(tmp, "canary")
+	store = run(4, model.BuildJob{DistributeStrategy: DistributeCanary, DistributeConcurrency: 2, CanaryVerifyScript: "test -f \"$BEDROCK_DEPLOY_PATH/missing\""},
+		dir+"1", dir+"2", dir+"3")
+	r, _ = store.FindByID(4)
+	want = map[uint][2]any{1: {"failed", 1}, 2: {AttemptAborted, 2}, 3: {AttemptAborted, 2}}
+	if r.DistributionSummary != AttemptAborted || !reflect.DeepEqual(statuses(store), want) {
+		t.Fatalf("canary: summary=%s attempts=%v", r.DistributionSummary, statuses(store))
+	}
+
+	// Canary: a passing verification rolls out the rest.
+	dir = filepath.Join(tmp, "canary-ok")
+	store = run(5, model.BuildJob{DistributeStrategy: DistributeCanary, DistributeConcurrency: 2, CanaryVerifyScript: "test -f \"$BEDROCK_DEPLOY_PATH/app\""},
+		dir+"1", dir+"2", dir+"3")
+	r, _ = store.FindByID(5)
+	want = map[uint][2]any{1: {"success", 1}, 2: {"success", 2}, 3: {"success", 2}}
+	if r.DistributionSummary != "all_success" || !reflect.DeepEqual(statuses(store), want) {
+		t.Fatalf("canary ok: summary=%s attempts=%v", r.DistributionSummary, statuses(store))
+	}
+}
@@ -7,43 +7,254 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
-func GitCloneOrPull(ctx context.Context, workDir, repoURL, authType, username, password, branch string, logFn func(string)) error {
-	authURL := buildAuthURL(repoURL, authType, username, password)
+// GitCheckoutOptions selects what GitCheckout materializes in the workspace.
+type GitCheckoutOptions struct {
+	Branch string
+	// Ref overrides Branch: refs/heads/<b>, refs/tags/<t>, any other full ref
+	// (e.g. refs/pull/1/head), a 40-char commit SHA, or a bare tag name.
+	Ref string
+	// Commit is checked out detached after the fetch (e.g. a retried run).
+	Commit string
+	// Shallow fetches depth 1; a shallow workspace is deepened when it is off.
+	Shallow bool
+	// Submodules are updated recursively with the repository's credentials.
+	Submodules bool
+	// LFS runs git lfs pull after checkout (requires git-lfs on the server).
+	LFS bool
+	// SparsePaths enables cone-mode sparse checkout of these directories.
+	SparsePaths []string
+}
 
-	if _, err := os.Stat(filepath.Join(workDir, ".git")); os.IsNotExist(err) {
-		logFn("Cloning repository...")
-		os.MkdirAll(filepath.Dir(workDir), 0755)
-		return runGit(ctx, "", logFn, "clone", "--branch", branch, authURL, workDir)
+var (
+	gitCommitPattern = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+	gitRefPattern    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/@+-]{0,299}$`)
+)
+
+// ValidateGitRef checks a checkout ref (see GitCheckoutOptions.Ref).
+func ValidateGitRef(ref string) error {
+	if !gitRefPattern.MatchString(ref) || strings.Contains(ref, "..") || strings.HasSuffix(ref, "/") || strings.HasSuffix(ref, ".lock") {
+		return fmt.Errorf("%q is not a valid git ref", ref)
+	}
+	return nil
+}
+
+// ValidateSparsePaths checks cone-mode sparse checkout directories.
+func ValidateSparsePaths(paths []string) error {
+	for _, p := range paths {
+		if err := validateRelPath(p); err != nil {
+			return fmt.Errorf("%q %w", p, err)
+		}
+		if strings.ContainsAny(p, "*?[!") {
+			return fmt.Errorf("%q: sparse checkout takes directories, not patterns", p)
+		}
 	}
+	return nil
+}
 
-	// Remove stale lock files that may remain from a previous crashed build
-	cleanGitLockFiles(workDir, logFn)
+// ParseSparsePaths splits a stored sparse path list (one per line or JSON array).
+func ParseSparsePaths(raw string) []string {
+	return parseCachePaths(raw)
+}
 
-	// 凭证或仓库地址变更后，工作区 .git/config 里仍可能缓存旧的 origin URL
-	if err := syncGitRemoteOrigin(ctx, workDir, authURL, logFn); err != nil {
+// GitCheckout brings workDir to opts' branch or ref: init (first time) or sync
+// the origin URL, fetch only that ref, force checkout, and clean untracked
+// files except dependency caches. Submodules, LFS and sparse checkout follow opts.
+func GitCheckout(ctx context.Context, workDir, repoURL string, auth GitAuth, opts GitCheckoutOptions, logFn func(string)) error {
+	env, cleanup, err := gitAuthEnv(repoURL, auth)
+	if err != nil {
 		return err
 	}
+	defer cleanup()
+	authURL := buildAuthURL(repoURL, auth.Type, auth.Username, auth.Secret)
 
-	logFn("Fetching updates...")
-	if err := runGit(ctx, workDir, logFn, "fetch", "origin"); err != nil {
+	if _, err := os.Stat(filepath.Join(workDir, ".git")); os.IsNotExist(err) {
+		logFn("Initializing repository...")
+		if err := os.MkdirAll(workDir, 0755); err != nil {
+			return err
+		}
+		if err := runGit(ctx, workDir, logFn, "init", "-q"); err != nil {
+			return err
+		}
+		if err := runGit(ctx, workDir, logFn, "remote", "add", "origin", authURL); err != nil {
+			return err
+		}
+	} else {
+		// Remove stale lock files that may remain from a previous crashed build
+		cleanGitLockFiles(workDir, logFn)
+
+		// 凭证或仓库地址变更后，工作区 .git/config 里仍可能缓存旧的 origin URL
+		if err := syncGitRemoteOrigin(ctx, workDir, authURL, logFn); err != nil {
+			return err
+		}
+	}
+	if err := applySparseCheckout(ctx, workDir, opts.SparsePaths, logFn); err != nil {
 		return err
 	}
-	logFn("Checking out branch: " + branch)
-	if err := runGit(ctx, workDir, logFn, "checkout", branch); err != nil {
-		// Branch might not exist locally
-		runGit(ctx, workDir, logFn, "checkout", "-b", branch, "origin/"+branch)
+
+	refspec, target, branch := resolveCheckoutRef(opts)
+	logFn("Fetching " + refspec + "...")
+	if err := runGitEnv(ctx, workDir, env, logFn, fetchArgs(workDir, opts.Shallow, refspec)...); err != nil {
+		if !gitCommitPattern.MatchString(refspec) {
+			return err
+		}
+		// Servers may refuse fetching an unadvertised SHA; fall back to all branches.
+		logFn("Fetching all branches to find " + refspec + "...")
+		if err := runGitEnv(ctx, workDir, env, logFn, fetchArgs(workDir, false)...); err != nil {
+			return err
+		}
 	}
-	if err := runGit(ctx, workDir, logFn, "reset", "--hard", "origin/"+branch); err != nil {
-		return err
+	if branch != "" {
+		logFn("Checking out branch: " + branch)
+		if err := runGit(ctx, workDir, logFn, "checkout", "--force", "-B", branch, target); err != nil {
+			return err
+		}
+	} else {
+		logFn("Checking out ref: " + strings.TrimSpace(opts.Ref))
+		if err := runGit(ctx, workDir, logFn, "checkout", "--force", "--detach", target); err != nil {
+			return err
+		}
+	}
+	if commit := strings.TrimSpace(opts.Commit); commit != "" {
+		if err := checkoutCommit(ctx, workDir, commit, env, logFn); err != nil {
+			return err
+		}
 	}
+
 	logFn("Cleaning workspace (preserving dependency caches)...")
 	runGit(ctx, workDir, logFn, "clean", "-fd",
 		"-e", "node_modules", "-e", "vendor", "-e", ".gradle",
 		"-e", "target", "-e", "__pycache__", "-e", ".venv",
 		"-e", "venv", "-e", ".tox")
+
+	if opts.Submodules {
+		if err := updateSubmodules(ctx, workDir, repoURL, auth, env, logFn); err != nil {
+			return err
+		}
+	}
+	if opts.LFS {
+		if err := pullLFS(ctx, workDir, opts.Submodules, env, logFn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveCheckoutRef maps opts to the fetch refspec, the checkout target and
+// the local branch to (re)create ("" = detached HEAD).
+func resolveCheckoutRef(opts GitCheckoutOptions) (refspec, target, branch string) {
+	ref := strings.TrimSpace(opts.Ref)
+	if ref == "" || strings.HasPrefix(ref, "refs/heads/") {
+		branch = strings.TrimPrefix(ref, "refs/heads/")
+		if branch == "" {
+			branch = strings.TrimSpace(opts.Branch)
+		}
+		if branch == "" {
+			branch = "main"
+		}
+		return "+refs/heads/" + branch + ":refs/remotes/origin/" + branch, "refs/remotes/origin/" + branch, branch
+	}
+	switch {
+	case gitCommitPattern.MatchString(ref):
+		return ref, ref, ""
+	case strings.HasPrefix(ref, "refs/tags/") || !strings.HasPrefix(ref, "refs/"):
+		tag := strings.TrimPrefix(ref, "refs/tags/")
+		return "+refs/tags/" + tag + ":refs/tags/" + tag, "refs/tags/" + tag, ""
+	default:
+		return "+" + ref + ":refs/bedrock/checkout", "refs/bedrock/checkout", ""
+	}
+}
+
+func fetchArgs(workDir string, shallow bool, refspecs ...string) []string {
+	args := []string{"fetch", "--force"}
+	if shallow {
+		args = append(args, "--depth", "1")
+	} else if isShallowRepo(workDir) {
+		args = append(args, "--unshallow")
+	}
+	return append(append(args, "origin"), refspecs...)
+}
+
+func isShallowRepo(workDir string) bool {
+	_, err := os.Stat(filepath.Join(workDir, ".git", "shallow"))
+	return err == nil
+}
+
+// checkoutCommit detaches at commit, fetching it first when a shallow or
+// single-ref fetch did not bring it in.
+func checkoutCommit(ctx context.Context, workDir, commit string, env []string, logFn func(string)) error {
+	if _, err := runGitOutput(ctx, workDir, "cat-file", "-e", commit+"^{commit}"); err != nil {
+		logFn("Fetching commit " + commit + "...")
+		if err := runGitEnv(ctx, workDir, env, logFn, "fetch", "--depth", "1", "origin", commit); err != nil {
+			if err := runGitEnv(ctx, workDir, env, logFn, fetchArgs(workDir, false)...); err != nil {
+				return err
+			}
+		}
+	}
+	logFn("Checking out commit: " + commit)
+	return runGit(ctx, workDir, logFn, "checkout", "--force", "--detach", commit)
+}
+
+func applySparseCheckout(ctx context.Context, workDir string, paths []string, logFn func(string)) error {
+	if len(paths) > 0 {
+		logFn("Sparse checkout: " + strings.Join(paths, ", "))
+		return runGit(ctx, workDir, logFn, append([]string{"sparse-checkout", "set", "--cone", "--"}, paths...)...)
+	}
+	if out, _ := runGitOutput(ctx, workDir, "config", "--bool", "core.sparseCheckout"); out == "true" {
+		logFn("Disabling sparse checkout")
+		return runGit(ctx, workDir, logFn, "sparse-checkout", "disable")
+	}
+	return nil
+}
+
+// updateSubmodules initializes submodules recursively. Submodules on the
+// repository's host reuse its credentials through a url.insteadOf rewrite
+// (relative URLs already resolve against the authenticated origin).
+func updateSubmodules(ctx context.Context, workDir, repoURL string, auth GitAuth, env []string, logFn func(string)) error {
+	if _, err := os.Stat(filepath.Join(workDir, ".gitmodules")); err != nil {
+		return nil
+	}
+	var cfg []string
+	if base, authBase := submoduleAuthRewrite(repoURL, auth.Type, auth.Username, auth.Secret); base != "" {
+		cfg = []string{"-c", "url." + authBase + ".insteadOf=" + base}
+	}
+	logFn("Updating submodules...")
+	if err := runGit(ctx, workDir, logFn, append(cfg, "submodule", "sync", "--recursive")...); err != nil {
+		return err
+	}
+	return runGitEnv(ctx, workDir, env, logFn, append(cfg, "submodule", "update", "--init", "--recursive", "--force")...)
+}
+
+func submoduleAuthRewrite(repoURL, authType, username, password string) (base, authBase string) {
+	u, err := url.Parse(repoURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", ""
+	}
+	base = u.Scheme + "://" + u.Host + "/"
+	authBase = buildAuthURL(base, authType, username, password)
+	if authBase == base {
+		return "", ""
+	}
+	return base, authBase
+}
+
+func pullLFS(ctx context.Context, workDir string, submodules bool, env []string, logFn func(string)) error {
+	if _, err := runGitOutput(ctx, workDir, "lfs", "version"); err != nil {
+		return fmt.Errorf("git lfs 未安装: %w", err)
+	}
+	logFn("Pulling LFS objects...")
+	if err := runGit(ctx, workDir, logFn, "lfs", "install", "--local"); err != nil {
+		return err
+	}
+	if err := runGitEnv(ctx, workDir, env, logFn, "lfs", "pull"); err != nil {
+		return err
+	}
+	if submodules {
+		return runGitEnv(ctx, workDir, env, logFn, "submodule", "foreach", "--recursive", "git lfs pull")
+	}
 	return nil
 }
 
@@ -71,10 +282,18 @@ func cleanGitLockFiles(workDir string, logFn func(string)) {
 }
 
 func runGit(ctx context.Context, dir string, logFn func(string), args ...string) error {
+	return runGitEnv(ctx, dir, nil, logFn, args...)
+}
+
+// runGitEnv is runGit with extra environment (e.g. GIT_SSH_COMMAND).
+func runGitEnv(ctx context.Context, dir string, env []string, logFn func(string), args ...string) error {
 	cmd := exec.CommandContext(ctx, "git", args...)
 	if dir != "" {
 		cmd.Dir = dir
 	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	output, err := cmd.CombinedOutput()
 	if len(output) > 0 {
 		for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
@@ -94,7 +313,7 @@ func runGitOutput(ctx context.Context, dir string, args ...string) (string, erro
 }
 
 func buildAuthURL(repoURL, authType, username, password string) string {
-	if authType == "none" || (username == "" && password == "") {
+	if authType == "none" || authType == GitAuthSSH || (username == "" && password == "") {
 		return repoURL
 	}
 	u, err := url.Parse(repoURL)
@@ -111,24 +330,91 @@ func buildAuthURL(repoURL, authType, username, password string) string {
 }
 
 // GitListBranches returns remote branch names via git ls-remote --heads.
-func GitListBranches(repoURL, authType, username, password string) ([]string, error) {
-	authURL := buildAuthURL(repoURL, authType, username, password)
-	cmd := exec.Command("git", "ls-remote", "--heads", authURL)
+func GitListBranches(repoURL string, auth GitAuth) ([]string, error) {
+	heads, err := GitListHeads(context.Background(), repoURL, auth)
+	if err != nil {
+		return nil, err
+	}
+	branches := make([]string, 0, len(heads))
+	for _, h := range heads {
+		branches = append(branches, h.Branch)
+	}
+	return branches, nil
+}
+
+// GitHead is a remote branch and the commit it points at.
+type GitHead struct {
+	Branch string
+	Commit string
+}
+
+// GitListHeads returns the remote branches with their head commits via
+// git ls-remote --heads.
+func GitListHeads(ctx context.Context, repoURL string, auth GitAuth) ([]GitHead, error) {
+	env, cleanup, err := gitAuthEnv(repoURL, auth)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	authURL := buildAuthURL(repoURL, auth.Type, auth.Username, auth.Secret)
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--heads", authURL)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return nil, fmt.Errorf("git ls-remote failed: %w", err)
 	}
-	var branches []string
+	var heads []GitHead
 	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
 		if line == "" {
 			continue
 		}
 		parts := strings.Fields(line)
 		if len(parts) >= 2 {
-			ref := parts[1]
-			branch := strings.TrimPrefix(ref, "refs/heads/")
-			branches = append(branches, branch)
+			branch := strings.TrimPrefix(parts[1], "refs/heads/")
+			heads = append(heads, GitHead{Branch: branch, Commit: parts[0]})
 		}
 	}
-	return branches, nil
+	return heads, nil
+}
+
+// GitChangedFiles lists the paths that differ between two commits (renames as
+// delete + add). It fetches just those commits, without blobs, into a
+// throwaway bare repository.
+func GitChangedFiles(ctx context.Context, repoURL string, auth GitAuth, before, after string) ([]string, error) {
+	env, cleanup, err := gitAuthEnv(repoURL, auth)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	dir, err := os.MkdirTemp("", "bedrock-diff-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	discard := func(string) {}
+	authURL := buildAuthURL(repoURL, auth.Type, auth.Username, auth.Secret)
+	if err := runGit(ctx, dir, discard, "init", "-q", "--bare"); err != nil {
+		return nil, fmt.Errorf("git init failed: %w", err)
+	}
+	if err := runGit(ctx, dir, discard, "remote", "add", "origin", authURL); err != nil {
+		return nil, fmt.Errorf("git remote add failed: %w", err)
+	}
+	if err := runGitEnv(ctx, dir, env, discard, "fetch", "-q", "--no-tags", "--depth=1", "--filter=blob:none", "origin", before, after); err != nil {
+		return nil, fmt.Errorf("git fetch failed: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, "git", "diff", "--name-only", "--no-renames", "-z", before, after)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff failed: %w", err)
+	}
+	var files []string
+	for _, f := range strings.Split(string(out), "\x00") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
 }
@@ -125,3 +125,22 @@ func DetectPlatform(repoURL string) GitPlatform {
 	}
 	return defaultPlatform
 }
+
+// PlatformNames lists the values accepted as a repository platform override.
+var PlatformNames = []string{"github", "gitlab", "gitea", "gitee"}
+
+// ResolvePlatform returns the platform named by override (github|gitlab|gitea|gitee),
+// falling back to DetectPlatform when it is empty. Self-hosted instances whose
+// hostname has no keyword (e.g. git.example.com running Gitea) need the override.
+func ResolvePlatform(repoURL, override string) GitPlatform {
+	name := strings.ToLower(strings.TrimSpace(override))
+	if name == "" || name == "auto" {
+		return DetectPlatform(repoURL)
+	}
+	for _, entry := range platformRegistry {
+		if entry.platform.Name() == name {
+			return entry.platform
+		}
+	}
+	return defaultPlatform
+}
@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Git auth modes resolved from a repository's credential.
+const (
+	GitAuthNone     = "none"
+	GitAuthPassword = "password"
+	GitAuthToken    = "token"
+	GitAuthSSH      = "ssh"
+)
+
+// GitAuth is a repository's resolved git credential. For GitAuthSSH, Secret
+// holds the private key and Passphrase its optional passphrase; KnownHosts
+// adds host key lines checked besides the system known_hosts files.
+type GitAuth struct {
+	Type       string
+	Username   string
+	Secret     string
+	Passphrase string
+	KnownHosts string
+}
+
+// NewGitAuth maps a Credential type (password|token|api_key|ssh_key) to a GitAuth.
+func NewGitAuth(credType, username, secret, passphrase string) GitAuth {
+	switch strings.ToLower(strings.TrimSpace(credType)) {
+	case "ssh_key":
+		return GitAuth{Type: GitAuthSSH, Username: username, Secret: secret, Passphrase: passphrase}
+	case "token", "api_key":
+		return GitAuth{Type: GitAuthToken, Username: username, Secret: secret}
+	default:
+		return GitAuth{Type: GitAuthPassword, Username: username, Secret: secret}
+	}
+}
+
+// ValidateKnownHosts checks known_hosts lines (comments and blank lines allowed).
+func ValidateKnownHosts(text string) error {
+	rest := []byte(text)
+	for len(strings.TrimSpace(string(rest))) > 0 {
+		var err error
+		_, _, _, _, rest, err = ssh.ParseKnownHosts(rest)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scpLikeGitURL matches git@host:org/repo.git (no scheme, colon before the path).
+var scpLikeGitURL = regexp.MustCompile(`^(?:[A-Za-z0-9._~-]+@)?[A-Za-z0-9.-]+:[^/\\]`)
+
+// IsSSHGitURL reports whether repoURL uses the ssh transport.
+func IsSSHGitURL(repoURL string) bool {
+	repoURL = strings.TrimSpace(repoURL)
+	if strings.Contains(repoURL, "://") {
+		u, err := url.Parse(repoURL)
+		return err == nil && (u.Scheme == "ssh" || u.Scheme == "git+ssh")
+	}
+	return scpLikeGitURL.MatchString(repoURL)
+}
+
+// gitAuthEnv returns the extra git environment for auth. For ssh keys it
+// writes the decrypted key (and KnownHosts) to a private temp dir, points
+// GIT_SSH_COMMAND at it with strict host key checking, and cleanup removes it.
+func gitAuthEnv(repoURL string, auth GitAuth) (env []string, cleanup func(), err error) {
+	cleanup = func() {}
+	if auth.Type != GitAuthSSH {
+		return nil, cleanup, nil
+	}
+	if !IsSSHGitURL(repoURL) {
+		return nil, cleanup, fmt.Errorf("SSH 密钥凭证需要 SSH 仓库地址（git@host:org/repo.git 或 ssh://）")
+	}
+	key, err := decryptSSHKey(auth.Secret, auth.Passphrase)
+	if err != nil {
+		return nil, cleanup, err
+	}
+	dir, err := os.MkdirTemp("", "bedrock-git-ssh-")
+	if err != nil {
+		return nil, cleanup, err
+	}
+	cleanup = func() { _ = os.RemoveAll(dir) }
+	keyFile := filepath.Join(dir, "id")
+	if err := os.WriteFile(keyFile, key, 0600); err != nil {
+		cleanup()
+		return nil, func() {}, err
+	}
+	cmd := "ssh -i " + sshCommandQuote(keyFile) +
+		" -o IdentitiesOnly=yes -o BatchMode=yes -o StrictHostKeyChecking=yes"
+	if hosts := strings.TrimSpace(auth.KnownHosts); hosts != "" {
+		hostsFile := filepath.Join(dir, "known_hosts")
+		if err := os.WriteFile(hostsFile, []byte(hosts+"\n"), 0600); err != nil {
+			cleanup()
+			return nil, func() {}, err
+		}
+		// Listing the user files too keeps ssh's defaults: the option replaces them.
+		cmd += " -o " + sshCommandQuote(`UserKnownHostsFile="`+hostsFile+`" ~/.ssh/known_hosts ~/.ssh/known_hosts2`)
+	}
+	return []string{"GIT_SSH_COMMAND=" + cmd, "GIT_TERMINAL_PROMPT=0"}, cleanup, nil
+}
+
+// decryptSSHKey returns key as an unencrypted OpenSSH private key so ssh
+// never prompts for the passphrase.
+func decryptSSHKey(key, passphrase string) ([]byte, error) {
+	var (
+		raw interface{}
+		err error
+	)
+	if passphrase != "" {
+		raw, err = ssh.ParseRawPrivateKeyWithPassphrase([]byte(key), []byte(passphrase))
+	} else {
+		raw, err = ssh.ParseRawPrivateKey([]byte(key))
+	}
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("SSH 私钥已加密，凭证缺少口令")
+		}
+		return nil, fmt.Errorf("SSH 私钥无效: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(raw, "")
+	if err != nil {
+		return nil, fmt.Errorf("SSH 私钥无效: %w", err)
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// sshCommandQuote quotes a path for GIT_SSH_COMMAND, which git runs via sh.
+func sshCommandQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
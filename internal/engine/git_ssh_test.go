@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestIsSSHGitURL(t *testing.T) {
+	for raw, want := range map[string]bool{
+		"git@gitea.internal:team/app.git":        true,
+		"gitea.internal:team/app.git":            true,
+		"ssh://git@gitea.internal:2222/team/app": true,
+		"git+ssh://git@host/team/app.git":        true,
+		"https://gitea.internal/team/app.git":    false,
+		"http://git@gitea.internal/team/app.git": false,
+		"/srv/git/app.git":                       false,
+		`C:\repos\app`:                           false,
+	} {
+		if got := IsSSHGitURL(raw); got != want {
+			t.Errorf("IsSSHGitURL(%q) = %v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestNewGitAuth(t *testing.T) {
+	for typ, want := range map[string]string{
+		"ssh_key": GitAuthSSH, "token": GitAuthToken, "api_key": GitAuthToken, "password": GitAuthPassword,
+	} {
+		if got := NewGitAuth(typ, "u", "s", "p").Type; got != want {
+			t.Errorf("NewGitAuth(%q).Type = %q, want %q", typ, got, want)
+		}
+	}
+	if got := buildAuthURL("ssh://git@host/app.git", GitAuthSSH, "git", "KEY"); got != "ssh://git@host/app.git" {
+		t.Errorf("ssh key embedded in URL: %q", got)
+	}
+}
+
+func TestGitAuthEnv_sshKey(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := string(pem.EncodeToMemory(block))
+	hostLine := "gitea.internal ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"
+	auth := GitAuth{Type: GitAuthSSH, Username: "git", Secret: encrypted, Passphrase: "s3cret", KnownHosts: hostLine}
+
+	env, cleanup, err := gitAuthEnv("git@gitea.internal:team/app.git", auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sshCmd string
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "GIT_SSH_COMMAND=") {
+			sshCmd = strings.TrimPrefix(kv, "GIT_SSH_COMMAND=")
+		}
+	}
+	if !strings.Contains(sshCmd, "StrictHostKeyChecking=yes") || !strings.Contains(sshCmd, `" ~/.ssh/known_hosts ~/.ssh/known_hosts2'`) {
+		t.Fatalf("GIT_SSH_COMMAND=%q", sshCmd)
+	}
+	keyFile := strings.Trim(strings.Fields(sshCmd)[2], "'")
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0077 != 0 && os.PathSeparator == '/' {
+		t.Fatalf("key file mode %v", info.Mode())
+	}
+	written, _ := os.ReadFile(keyFile)
+	if _, err := ssh.ParsePrivateKey(written); err != nil {
+		t.Fatalf("key file is not an unencrypted key: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(filepath.Dir(keyFile), "known_hosts")); strings.TrimSpace(string(b)) != hostLine {
+		t.Fatalf("known_hosts=%q", b)
+	}
+	cleanup()
+	if _, err := os.Stat(filepath.Dir(keyFile)); !os.IsNotExist(err) {
+		t.Fatalf("temp key dir kept: %v", err)
+	}
+
+	auth.Passphrase = ""
+	if _, _, err := gitAuthEnv("git@gitea.internal:team/app.git", auth); err == nil || !strings.Contains(err.Error(), "口令") {
+		t.Fatalf("missing passphrase err=%v", err)
+	}
+	if _, _, err := gitAuthEnv("https://gitea.internal/team/app.git", auth); err == nil {
+		t.Fatal("expected error for ssh key with an HTTPS URL")
+	}
+	if env, _, err := gitAuthEnv("https://gitea.internal/team/app.git", GitAuth{Type: GitAuthToken}); err != nil || env != nil {
+		t.Fatalf("token auth env=%v err=%v", env, err)
+	}
+}
+
+func TestValidateKnownHosts(t *testing.T) {
+	ok := "# internal gitea\ngitea.internal ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl\n\n"
+	if err := ValidateKnownHosts(ok); err != nil {
+		t.Fatalf("valid known_hosts: %v", err)
+	}
+	if err := ValidateKnownHosts(""); err != nil {
+		t.Fatalf("empty known_hosts: %v", err)
+	}
+	if err := ValidateKnownHosts("gitea.internal not-a-key"); err == nil {
+		t.Fatal("expected error for malformed line")
+	}
+}
@@ -0,0 +1,255 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"bedrock/internal/cicd/model"
+)
+
+// MaxMatrixCells caps the fan-out of a single trigger.
+const MaxMatrixCells = 64
+
+var envVarNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ExpandMatrix returns the cells of m in axis order (nil when m defines none).
+// Exclude entries drop every cell matching all of their keys; Include entries
+// are appended as extra cells unless an identical cell already exists.
+func ExpandMatrix(m *model.BuildMatrix) ([]map[string]string, error) {
+	if m == nil || (len(m.Axes) == 0 && len(m.Include) == 0) {
+		return nil, nil
+	}
+	seen := map[string]struct{}{}
+	for i, ax := range m.Axes {
+		name := strings.TrimSpace(ax.Name)
+		if !envVarNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("matrix.axes[%d]: name %q must be a valid env var name", i, ax.Name)
+		}
+		if _, dup := seen[name]; dup {
+			return nil, fmt.Errorf("matrix.axes[%d]: duplicate axis %q", i, name)
+		}
+		seen[name] = struct{}{}
+		if len(ax.Values) == 0 {
+			return nil, fmt.Errorf("matrix axis %q: values must not be empty", name)
+		}
+	}
+	for i, ex := range m.Exclude {
+		if len(ex) == 0 {
+			return nil, fmt.Errorf("matrix.exclude[%d] must not be empty", i)
+		}
+	}
+	for i, inc := range m.Include {
+		if len(inc) == 0 {
+			return nil, fmt.Errorf("matrix.include[%d] must not be empty", i)
+		}
+		for k := range inc {
+			if !envVarNamePattern.MatchString(strings.TrimSpace(k)) {
+				return nil, fmt.Errorf("matrix.include[%d]: key %q must be a valid env var name", i, k)
+			}
+		}
+	}
+
+	var cells []map[string]string
+	if len(m.Axes) > 0 {
+		cells = []map[string]string{{}}
+		for _, ax := range m.Axes {
+			name := strings.TrimSpace(ax.Name)
+			next := make([]map[string]string, 0, len(cells)*len(ax.Values))
+			for _, c := range cells {
+				for _, v := range ax.Values {
+					cell := make(map[string]string, len(c)+1)
+					for k, cv := range c {
+						cell[k] = cv
+					}
+					cell[name] = strings.TrimSpace(v)
+					next = append(next, cell)
+				}
+			}
+			cells = next
+			if len(cells) > MaxMatrixCells*4 {
+				return nil, fmt.Errorf("matrix too large (max %d cells)", MaxMatrixCells)
+			}
+		}
+	}
+
+	out := make([]map[string]string, 0, len(cells)+len(m.Include))
+	keys := map[string]struct{}{}
+	for _, c := range cells {
+		if matrixExcluded(c, m.Exclude) {
+			continue
+		}
+		keys[MatrixCellKey(c)] = struct{}{}
+		out = append(out, c)
+	}
+	for _, inc := range m.Include {
+		cell := make(map[string]string, len(inc))
+		for k, v := range inc {
+			cell[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+		key := MatrixCellKey(cell)
+		if _, dup := keys[key]; dup {
+			continue
+		}
+		keys[key] = struct{}{}
+		out = append(out, cell)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("matrix has no cells after exclude")
+	}
+	if len(out) > MaxMatrixCells {
+		return nil, fmt.Errorf("matrix has %d cells (max %d)", len(out), MaxMatrixCells)
+	}
+	return out, nil
+}
+
+func matrixExcluded(cell map[string]string, excludes []map[string]string) bool {
+	for _, ex := range excludes {
+		match := true
+		for k, v := range ex {
+			if cell[strings.TrimSpace(k)] != strings.TrimSpace(v) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// MatrixCellKey is a stable "k=v,k=v" label (sorted by key).
+func MatrixCellKey(cell map[string]string) string {
+	names := make([]string, 0, len(cell))
+	for k := range cell {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, k := range names {
+		parts = append(parts, k+"="+cell[k])
+	}
+	return strings.Join(parts, ",")
+}
+
+// MatrixEnv exports a cell to the build script as NAME=value and MATRIX_NAME=value.
+func MatrixEnv(cell map[string]string) []string {
+	names := make([]string, 0, len(cell))
+	for k := range cell {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	env := make([]string, 0, len(names)*2)
+	for _, k := range names {
+		env = append(env, k+"="+cell[k], "MATRIX_"+strings.ToUpper(k)+"="+cell[k])
+	}
+	return env
+}
+
+// jobDirName names the per-job workspace/cache directory; matrix cells get
+// their own so concurrent children of one job never share a checkout.
+func jobDirName(jobID uint, cell map[string]string) string {
+	name := fmt.Sprintf("job-%d", jobID)
+	if len(cell) == 0 {
+		return name
+	}
+	return name + "-" + stageFileNameUnsafe.ReplaceAllString(MatrixCellKey(cell), "_")
+}
+
+// IsMatrixParent reports whether run only aggregates child runs (never executed itself).
+func IsMatrixParent(run *model.BuildRun) bool {
+	return run != nil && run.MatrixSummary != "" && run.MatrixSummary != "none"
+}
+
+// DecodeJobMatrix fills BuildJob.Matrix from MatrixJSON.
+func DecodeJobMatrix(job *model.BuildJob) {
+	if job == nil || job.Matrix != nil || strings.TrimSpace(job.MatrixJSON) == "" {
+		return
+	}
+	var m model.BuildMatrix
+	if err := json.Unmarshal([]byte(job.MatrixJSON), &m); err == nil {
+		job.Matrix = &m
+	}
+}
+
+// DecodeMatrixCell fills BuildRun.MatrixCell from MatrixCellJSON.
+func DecodeMatrixCell(run *model.BuildRun) {
+	if run == nil || len(run.MatrixCell) > 0 || strings.TrimSpace(run.MatrixCellJSON) == "" {
+		return
+	}
+	_ = json.Unmarshal([]byte(run.MatrixCellJSON), &run.MatrixCell)
+}
+
+// RollupMatrix derives the parent's matrix_summary and status from its children,
+// mirroring distribution_summary: running while any child is queued/running,
+// then all_success | partial | all_failed | cancelled.
+func RollupMatrix(children []model.BuildRun) (summary, status string) {
+	if len(children) == 0 {
+		return "all_failed", "failed"
+	}
+	var ok, cancelled int
+	for _, c := range children {
+		switch c.Status {
+		case "queued", "running":
+			return "running", "running"
+		case "success":
+			ok++
+		case "cancelled":
+			cancelled++
+		}
+	}
+	switch {
+	case ok == len(children):
+		return "all_success", "success"
+	case cancelled == len(children):
+		return "cancelled", "cancelled"
+	case ok > 0:
+		return "partial", "failed"
+	default:
+		return "all_failed", "failed"
+	}
+}
+
+// MatrixRollupFields is the UpdateFields payload for a parent after a child changed.
+func MatrixRollupFields(parent *model.BuildRun, children []model.BuildRun) map[string]interface{} {
+	summary, status := RollupMatrix(children)
+	fields := map[string]interface{}{"matrix_summary": summary}
+	if status == "running" {
+		return fields
+	}
+	finished := time.Now()
+	fields["status"] = status
+	fields["stage"] = "idle"
+	fields["finished_at"] = finished
+	if parent.StartedAt != nil {
+		fields["duration_ms"] = finished.Sub(*parent.StartedAt).Milliseconds()
+	}
+	if status == "failed" {
+		fields["error_message"] = fmt.Sprintf("matrix %s", summary)
+	} else {
+		fields["error_message"] = ""
+	}
+	return fields
+}
+
+// rollupMatrixParent refreshes the parent of a finished child run.
+func (p *Pipeline) rollupMatrixParent(runID uint) {
+	run, err := p.runs.FindByID(runID)
+	if err != nil || run.ParentRunID == nil {
+		return
+	}
+	parent, err := p.runs.FindByID(*run.ParentRunID)
+	if err != nil {
+		return
+	}
+	children, err := p.runs.ListByParent(parent.ID)
+	if err != nil {
+		return
+	}
+	_ = p.runs.UpdateFields(parent.ID, MatrixRollupFields(parent, children))
+	p.broadcastRunRefresh(parent.ID)
+}
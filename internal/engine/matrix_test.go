@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+	resourcemodel "bedrock/internal/resource/model"
+)
+
+func TestExpandMatrix(t *testing.T) {
+	t.Parallel()
+	cells, err := ExpandMatrix(&model.BuildMatrix{
+		Axes: []model.MatrixAxis{
+			{Name: "GOOS", Values: []string{"linux", "windows"}},
+			{Name: "GOARCH", Values: []string{"amd64", "arm64"}},
+		},
+		Exclude: []map[string]string{{"GOOS": "windows", "GOARCH": "arm64"}},
+		Include: []map[string]string{
+			{"GOOS": "linux", "GOARCH": "amd64"}, // duplicate: ignored
+			{"GOOS": "js", "GOARCH": "wasm"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, c := range cells {
+		keys = append(keys, MatrixCellKey(c))
+	}
+	want := []string{
+		"GOARCH=amd64,GOOS=linux",
+		"GOARCH=arm64,GOOS=linux",
+		"GOARCH=amd64,GOOS=windows",
+		"GOARCH=wasm,GOOS=js",
+	}
+	if strings.Join(keys, ";") != strings.Join(want, ";") {
+		t.Fatalf("cells=%v want %v", keys, want)
+	}
+
+	if cells, err := ExpandMatrix(nil); err != nil || cells != nil {
+		t.Fatalf("nil matrix: cells=%v err=%v", cells, err)
+	}
+	bad := []*model.BuildMatrix{
+		{Axes: []model.MatrixAxis{{Name: "1X", Values: []string{"a"}}}},
+		{Axes: []model.MatrixAxis{{Name: "A", Values: []string{"a"}}, {Name: "A", Values: []string{"b"}}}},
+		{Axes: []model.MatrixAxis{{Name: "A"}}},
+		{Axes: []model.MatrixAxis{{Name: "A", Values: []string{"a"}}}, Exclude: []map[string]string{{"A": "a"}}},
+	}
+	for i, m := range bad {
+		if _, err := ExpandMatrix(m); err == nil {
+			t.Fatalf("bad[%d]: expected error", i)
+		}
+	}
+}
+
+func TestRollupMatrix(t *testing.T) {
+	t.Parallel()
+	runs := func(statuses ...string) []model.BuildRun {
+		out := make([]model.BuildRun, len(statuses))
+		for i, s := range statuses {
+			out[i].Status = s
+		}
+		return out
+	}
+	cases := []struct {
+		in             []model.BuildRun
+		summary, state string
+	}{
+		{runs("success", "running"), "running", "running"},
+		{runs("success", "success"), "all_success", "success"},
+		{runs("success", "failed"), "partial", "failed"},
+		{runs("failed", "interrupted"), "all_failed", "failed"},
+		{runs("cancelled", "cancelled"), "cancelled", "cancelled"},
+	}
+	for i, tc := range cases {
+		summary, status := RollupMatrix(tc.in)
+		if summary != tc.summary || status != tc.state {
+			t.Fatalf("case %d: got %s/%s want %s/%s", i, summary, status, tc.summary, tc.state)
+		}
+	}
+}
+
+func TestExecuteMatrixChildExportsCellAndRollsUp(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	repoDir := initLocalGitRepo(t)
+	tmp := t.TempDir()
+	parentID := uint(1)
+	parent := &model.BuildRun{ID: parentID, BuildJobID: 10, BuildNumber: 1, Status: "running", Stage: "building", MatrixSummary: "running"}
+	child := &model.BuildRun{
+		ID: 2, BuildJobID: 10, BuildNumber: 2, Status: "queued", Stage: "pending", Branch: "main",
+		ParentRunID: &parentID, MatrixCellJSON: `{"GOOS":"linux","NODE":"20"}`,
+	}
+	store := newMemRunStore(parent, child)
+	jobStore := &memJobStore{job: &model.BuildJob{
+		ID: 10, RepositoryID: 1, Branch: "main", ArtifactFormat: "gzip",
+		BuildScript: `echo "cell=$GOOS/$MATRIX_NODE"`,
+	}}
+	repoStore := &memRepoStore{repo: &resourcemodel.Repository{ID: 1, RepoURL: repoDir, AuthType: "none"}}
+	p := NewPipeline(store, jobStore, repoStore, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(),
+		filepath.Join(tmp, "ws"), filepath.Join(tmp, "artifacts"), filepath.Join(tmp, "logs"), filepath.Join(tmp, "cache"))
+
+	p.Execute(context.Background(), parentID) // parent aggregates only: no-op
+	if got, _ := store.FindByID(parentID); got.LogPath != "" || got.Status != "running" {
+		t.Fatalf("parent executed: %+v", got)
+	}
+
+	p.Execute(context.Background(), 2)
+
+	got, _ := store.FindByID(2)
+	if got.Status != "success" {
+		t.Fatalf("child status=%s (error=%q)", got.Status, got.ErrorMessage)
+	}
+	body, _ := os.ReadFile(got.LogPath)
+	if !strings.Contains(string(body), "cell=linux/20") {
+		t.Fatalf("matrix env not exported:\n%s", body)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "ws", "repo-1", "job-10-GOOS_linux_NODE_20")); err != nil {
+		t.Fatalf("expected per-cell workspace: %v", err)
+	}
+	gotParent, _ := store.FindByID(parentID)
+	if gotParent.Status != "success" || gotParent.MatrixSummary != "all_success" {
+		t.Fatalf("parent status=%s summary=%s", gotParent.Status, gotParent.MatrixSummary)
+	}
+}
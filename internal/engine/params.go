@@ -0,0 +1,222 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"bedrock/internal/cicd/model"
+	"bedrock/internal/pkg"
+)
+
+// Build parameter types.
+const (
+	ParamString  = "string"
+	ParamChoice  = "choice"
+	ParamBoolean = "boolean"
+	ParamSecret  = "secret"
+)
+
+// MaskedValue replaces secret values in snapshots and API responses.
+const MaskedValue = "***"
+
+// ValidateParameterDefs normalizes job parameter definitions in place.
+func ValidateParameterDefs(defs []model.BuildParameter) error {
+	seen := map[string]struct{}{}
+	for i := range defs {
+		d := &defs[i]
+		d.Name = strings.TrimSpace(d.Name)
+		d.Type = strings.ToLower(strings.TrimSpace(d.Type))
+		if d.Type == "" {
+			d.Type = ParamString
+		}
+		if !envVarNamePattern.MatchString(d.Name) {
+			return fmt.Errorf("parameters[%d]: name %q must be a valid env var name", i, d.Name)
+		}
+		if _, dup := seen[d.Name]; dup {
+			return fmt.Errorf("parameters[%d]: duplicate name %q", i, d.Name)
+		}
+		seen[d.Name] = struct{}{}
+		switch d.Type {
+		case ParamString:
+			if d.Pattern != "" {
+				if _, err := regexp.Compile(d.Pattern); err != nil {
+					return fmt.Errorf("parameter %s: invalid pattern: %w", d.Name, err)
+				}
+			}
+		case ParamChoice:
+			if len(d.Choices) == 0 {
+				return fmt.Errorf("parameter %s: choices must not be empty", d.Name)
+			}
+			if d.Default == "" {
+				d.Default = d.Choices[0]
+			}
+		case ParamBoolean:
+			if d.Default == "" {
+				d.Default = "false"
+			}
+		case ParamSecret:
+			if d.Default != "" {
+				return fmt.Errorf("parameter %s: secret parameters cannot have a default", d.Name)
+			}
+		default:
+			return fmt.Errorf("parameter %s: type %q invalid (string|choice|boolean|secret)", d.Name, d.Type)
+		}
+		if d.Default != "" {
+			if _, err := checkParamValue(*d, d.Default); err != nil {
+				return fmt.Errorf("parameter %s: default %w", d.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ResolveParams applies defaults and validates supplied values against defs.
+// Unknown names are rejected.
+func ResolveParams(defs []model.BuildParameter, in map[string]string) (map[string]string, error) {
+	known := make(map[string]model.BuildParameter, len(defs))
+	for _, d := range defs {
+		known[d.Name] = d
+	}
+	for name := range in {
+		if _, ok := known[name]; !ok {
+			return nil, fmt.Errorf("未定义的参数: %s", name)
+		}
+	}
+	out := make(map[string]string, len(defs))
+	for _, d := range defs {
+		v, ok := in[d.Name]
+		if !ok || (v == "" && d.Type != ParamString) {
+			v = d.Default
+		}
+		if v == "" {
+			if d.Required {
+				return nil, fmt.Errorf("参数 %s 必填", d.Name)
+			}
+			out[d.Name] = ""
+			continue
+		}
+		norm, err := checkParamValue(d, v)
+		if err != nil {
+			return nil, fmt.Errorf("参数 %s %s", d.Name, err.Error())
+		}
+		out[d.Name] = norm
+	}
+	return out, nil
+}
+
+func checkParamValue(d model.BuildParameter, v string) (string, error) {
+	switch d.Type {
+	case ParamChoice:
+		for _, c := range d.Choices {
+			if c == v {
+				return v, nil
+			}
+		}
+		return "", fmt.Errorf("must be one of %s", strings.Join(d.Choices, ", "))
+	case ParamBoolean:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return "", fmt.Errorf("must be true or false")
+		}
+		return strconv.FormatBool(b), nil
+	case ParamString:
+		if d.Pattern != "" {
+			re, err := regexp.Compile(d.Pattern)
+			if err != nil || !re.MatchString(v) {
+				return "", fmt.Errorf("does not match %s", d.Pattern)
+			}
+		}
+	}
+	return v, nil
+}
+
+// MaskParams returns values with secret parameters replaced by MaskedValue.
+func MaskParams(defs []model.BuildParameter, values map[string]string) map[string]string {
+	secret := map[string]bool{}
+	for _, d := range defs {
+		if d.Type == ParamSecret {
+			secret[d.Name] = true
+		}
+	}
+	out := make(map[string]string, len(values))
+	for k, v := range values {
+		if secret[k] && v != "" {
+			v = MaskedValue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// ParamsEnv exports resolved parameters as NAME=value (sorted).
+func ParamsEnv(values map[string]string) []string {
+	names := make([]string, 0, len(values))
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	env := make([]string, 0, len(names))
+	for _, k := range names {
+		env = append(env, k+"="+values[k])
+	}
+	return env
+}
+
+// EncryptRunParams seals resolved values (including secrets) for BuildRun.ParamsCipher.
+func EncryptRunParams(values map[string]string) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return pkg.Encrypt(string(b))
+}
+
+// DecryptRunParams opens BuildRun.ParamsCipher.
+func DecryptRunParams(cipherText string) (map[string]string, error) {
+	cipherText = strings.TrimSpace(cipherText)
+	if cipherText == "" {
+		return map[string]string{}, nil
+	}
+	plain, err := pkg.Decrypt(cipherText)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]string
+	if err := json.Unmarshal([]byte(plain), &values); err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = map[string]string{}
+	}
+	return values, nil
+}
+
+// ValidateEnvVarName rejects names that cannot be exported to a build script.
+func ValidateEnvVarName(name string) error {
+	if !envVarNamePattern.MatchString(name) {
+		return fmt.Errorf("%q must match [A-Za-z_][A-Za-z0-9_]*", name)
+	}
+	return nil
+}
+
+// DecodeJobParameters fills BuildJob.Parameters from ParametersJSON.
+func DecodeJobParameters(job *model.BuildJob) {
+	if job == nil || job.Parameters != nil {
+		return
+	}
+	job.Parameters = []model.BuildParameter{}
+	if strings.TrimSpace(job.ParametersJSON) == "" {
+		return
+	}
+	var defs []model.BuildParameter
+	if err := json.Unmarshal([]byte(job.ParametersJSON), &defs); err == nil && defs != nil {
+		job.Parameters = defs
+	}
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"testing"
+
+	"bedrock/internal/cicd/model"
+)
+
+func TestValidateParameterDefs(t *testing.T) {
+	t.Parallel()
+	defs := []model.BuildParameter{
+		{Name: "ENV", Type: "choice", Choices: []string{"staging", "prod"}},
+		{Name: "DRY_RUN", Type: "boolean"},
+		{Name: "VERSION", Pattern: `^v\d+`},
+		{Name: "TOKEN", Type: "secret", Required: true},
+	}
+	if err := ValidateParameterDefs(defs); err != nil {
+		t.Fatal(err)
+	}
+	if defs[0].Default != "staging" || defs[1].Default != "false" || defs[2].Type != ParamString {
+		t.Fatalf("defaults not normalized: %+v", defs)
+	}
+	bad := [][]model.BuildParameter{
+		{{Name: "bad-name"}},
+		{{Name: "A"}, {Name: "A"}},
+		{{Name: "A", Type: "number"}},
+		{{Name: "A", Type: "choice"}},
+		{{Name: "A", Type: "choice", Choices: []string{"x"}, Default: "y"}},
+		{{Name: "A", Type: "secret", Default: "s3cr3t"}},
+		{{Name: "A", Pattern: "("}},
+	}
+	for i, b := range bad {
+		if err := ValidateParameterDefs(b); err == nil {
+			t.Fatalf("bad[%d]: expected error", i)
+		}
+	}
+}
+
+func TestResolveAndMaskParams(t *testing.T) {
+	t.Parallel()
+	defs := []model.BuildParameter{
+		{Name: "ENV", Type: "choice", Choices: []string{"staging", "prod"}, Default: "staging"},
+		{Name: "DRY_RUN", Type: "boolean", Default: "false"},
+		{Name: "VERSION", Type: "string", Pattern: `^v\d+`},
+		{Name: "TOKEN", Type: "secret", Required: true},
+	}
+	got, err := ResolveParams(defs, map[string]string{"DRY_RUN": "1", "VERSION": "v2", "TOKEN": "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["ENV"] != "staging" || got["DRY_RUN"] != "true" || got["TOKEN"] != "abc" {
+		t.Fatalf("resolved=%v", got)
+	}
+	masked := MaskParams(defs, got)
+	if masked["TOKEN"] != MaskedValue || masked["VERSION"] != "v2" {
+		t.Fatalf("masked=%v", masked)
+	}
+
+	for i, in := range []map[string]string{
+		{"VERSION": "v1"}, // missing required secret
+		{"TOKEN": "x", "ENV": "dev"},
+		{"TOKEN": "x", "DRY_RUN": "maybe"},
+		{"TOKEN": "x", "VERSION": "1.0"},
+		{"TOKEN": "x", "UNKNOWN": "1"},
+	} {
+		if _, err := ResolveParams(defs, in); err == nil {
+			t.Fatalf("case %d: expected error for %v", i, in)
+		}
+	}
+}
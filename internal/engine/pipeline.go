@@ -4,17 +4,18 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
 	"go.uber.org/zap"
 
 	"bedrock/internal/cicd/model"
+	"bedrock/internal/pkg"
 	resourcemodel "bedrock/internal/resource/model"
 	"bedrock/internal/ws"
 )
@@ -31,6 +32,8 @@ type TerminalNotifier interface {
 }
 
 // Pipeline executes BuildRun: clone → build → archive → success → distribute.
+// The build phase runs either BuildJob.BuildScript or the stages of an in-repo
+// pipeline file (.bedrock.yml, see PipelineSpec).
 // Distribution failure never sets status=failed (DESIGN §5.2).
 // Sync AI Agent stage is intentionally absent (P4 async AgentRun only).
 type Pipeline struct {
@@ -44,9 +47,12 @@ type Pipeline struct {
 	workspace string
 	artifact  string
 	logDir    string
-	cacheDir  string
+	cache     *BuildCache
 	agentHook AgentEventHook
 	notifier  TerminalNotifier
+	store     ArtifactStore
+	statuses  *CommitStatusReporter
+	approvals ApprovalNotifier
 }
 
 // SetAgentEventHook wires P4 async AgentRun creation from build events.
@@ -59,6 +65,17 @@ func (p *Pipeline) SetTerminalNotifier(n TerminalNotifier) {
 	p.notifier = n
 }
 
+// SetArtifactStore moves archives into the content store; without one they
+// stay as files under the artifact directory.
+func (p *Pipeline) SetArtifactStore(s ArtifactStore) {
+	p.store = s
+}
+
+// SetCommitStatusReporter posts run progress back to the Git platform.
+func (p *Pipeline) SetCommitStatusReporter(r *CommitStatusReporter) {
+	p.statuses = r
+}
+
 func NewPipeline(
 	runs RunStore,
 	jobs JobStore,
@@ -80,10 +97,15 @@ func NewPipeline(
 		workspace: workspaceDir,
 		artifact:  artifactDir,
 		logDir:    logDir,
-		cacheDir:  cacheDir,
+		cache:     NewBuildCache(cacheDir),
 	}
 }
 
+// BuildCache is the cache under cache_dir, shared with the CacheEvictor.
+func (p *Pipeline) BuildCache() *BuildCache {
+	return p.cache
+}
+
 func (p *Pipeline) Execute(ctx context.Context, runID uint) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -105,7 +127,7 @@ func (p *Pipeline) Execute(ctx context.Context, runID uint) {
 		}
 		return
 	}
-	if run.Status == "cancelled" || run.Status == "interrupted" {
+	if run.Status == "cancelled" || run.Status == "interrupted" || IsMatrixParent(run) {
 		return
 	}
 	job, err := p.jobs.FindByID(run.BuildJobID)
@@ -119,9 +141,14 @@ func (p *Pipeline) Execute(ctx context.Context, runID uint) {
 		return
 	}
 	decodeJobEnvNames(job)
+	DecodeMatrixCell(run)
+	ctx, cancelTimeout := withTimeout(ctx, TimeoutJob, job.TimeoutSeconds)
+	defer cancelTimeout()
 
 	now := time.Now()
-	redeployOnly := run.TriggerType == "redeploy"
+	// A successful run is submitted again only to distribute: a redeploy, or
+	// a batch whose deployment approval was decided.
+	redeployOnly := run.TriggerType == "redeploy" || run.Status == "success"
 	if !redeployOnly || run.Status != "success" {
 		run.StartedAt = &now
 	}
@@ -142,18 +169,16 @@ func (p *Pipeline) Execute(ctx context.Context, runID uint) {
 	logDir := filepath.Join(p.logDir, fmt.Sprintf("job-%d", job.ID))
 	_ = os.MkdirAll(logDir, 0755)
 	logPath := filepath.Join(logDir, fmt.Sprintf("run-%03d.log", run.BuildNumber))
-	var logFile *os.File
 	if redeployOnly && run.LogPath != "" {
 		logPath = run.LogPath
-		logFile, err = os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
-	} else {
-		logFile, err = os.Create(logPath)
 	}
+	DecodeLogStages(run)
+	out, err := openRunLog(logPath, redeployOnly, run.LogStages)
 	if err != nil {
 		p.failRun(run, "无法创建日志文件: "+err.Error())
 		return
 	}
-	defer logFile.Close()
+	defer out.Close()
 	run.LogPath = logPath
 	_ = p.runs.UpdateFields(run.ID, map[string]interface{}{
 		"log_path":   logPath,
@@ -164,103 +189,109 @@ func (p *Pipeline) Execute(ctx context.Context, runID uint) {
 	p.broadcastRunRefresh(run.ID)
 
 	channel := fmt.Sprintf("build-run:%d", run.ID)
-	var logMu sync.Mutex
-	writeLine := func(line string) {
-		logMu.Lock()
-		defer logMu.Unlock()
-		_, _ = logFile.WriteString(line + "\n")
-		if p.hub != nil {
-			p.hub.BroadcastToChannel(channel, []byte(line))
-		}
+	// Every secret resolved for this run is registered here and masked before
+	// a line reaches the log file or the hub.
+	redact := pkg.NewRedactor()
+	out.redact = redact
+	if p.hub != nil {
+		out.broadcast = func(line string) { p.hub.BroadcastToChannel(channel, []byte(line)) }
 	}
+	out.onStage = func(stages []model.LogStage) {
+		raw, _ := json.Marshal(stages)
+		_ = p.runs.UpdateFields(run.ID, map[string]interface{}{"log_stages_json": string(raw)})
+	}
+	writeLine := out.Line
 
 	if redeployOnly {
-		p.executeRedeployOnly(ctx, run, job, writeLine)
+		if approval := p.decidedApproval(run.ID); approval != nil {
+			p.resumeApproval(ctx, run, job, approval, redact, writeLine)
+			return
+		}
+		p.executeRedeployOnly(ctx, run, job, redact, writeLine)
 		return
 	}
 
 	writeLine("=== Stage: Cloning ===")
 	writeLine("NOTE: Build scripts run as the same OS user as Bedrock (no sandbox isolation).")
-	workDir := filepath.Join(p.workspace, fmt.Sprintf("repo-%d", repo.ID), fmt.Sprintf("job-%d", job.ID))
+	if len(run.MatrixCell) > 0 {
+		writeLine("Matrix: " + MatrixCellKey(run.MatrixCell))
+	}
+	workDir := filepath.Join(p.workspace, fmt.Sprintf("repo-%d", repo.ID), jobDirName(job.ID, run.MatrixCell))
 
-	authType, username, password, err := p.resolveRepoGitAuth(repo)
+	auth, err := p.resolveRepoGitAuth(repo)
 	if err != nil {
 		p.failRun(run, "仓库凭证错误: "+err.Error())
 		writeLine("ERROR: " + err.Error())
 		return
 	}
+	redact.Add(auth.Secret, auth.Passphrase)
 
 	branch := job.Branch
 	if run.Branch != "" {
 		branch = run.Branch
 	}
 
-	err = GitCloneOrPull(ctx, workDir, repo.RepoURL, authType, username, password, branch, writeLine)
+	cloneCtx, cancelClone := withTimeout(ctx, TimeoutClone, job.CloneTimeoutSeconds)
+	defer cancelClone()
+	ref := job.GitRef
+	if run.GitRef != "" {
+		ref = run.GitRef
+	}
+	err = GitCheckout(cloneCtx, workDir, repo.RepoURL, auth, GitCheckoutOptions{
+		Branch:      branch,
+		Ref:         ref,
+		Commit:      run.CommitHash,
+		Shallow:     job.ShallowClone,
+		Submodules:  job.GitSubmodules,
+		LFS:         job.GitLFS,
+		SparsePaths: ParseSparsePaths(job.SparseCheckoutPaths),
+	}, writeLine)
 	if err != nil {
-		if ctx.Err() != nil {
-			p.cancelRun(run)
+		if cloneCtx.Err() != nil {
+			p.stopRun(run, cloneCtx, writeLine)
 			return
 		}
-		p.failRun(run, "Git操作失败: "+err.Error())
+		p.failRun(run, "Git操作失败: "+redact.Redact(err.Error()))
 		writeLine("ERROR: " + err.Error())
 		return
 	}
 
-	if run.CommitHash != "" {
-		writeLine("Checking out commit: " + run.CommitHash)
-		if err := runGit(ctx, workDir, writeLine, "checkout", run.CommitHash); err != nil {
-			if ctx.Err() != nil {
-				p.cancelRun(run)
-				return
-			}
-			p.failRun(run, "Checkout commit 失败: "+err.Error())
-			writeLine("ERROR: " + err.Error())
-			return
-		}
-	} else {
+	if run.CommitHash == "" {
 		// Capture HEAD for snapshot enrichment
-		if out, err := runGitOutput(ctx, workDir, "rev-parse", "HEAD"); err == nil {
+		if out, err := runGitOutput(cloneCtx, workDir, "rev-parse", "HEAD"); err == nil {
 			hash := strings.TrimSpace(out)
 			run.CommitHash = hash
 			_ = p.runs.UpdateFields(run.ID, map[string]interface{}{"commit_hash": hash})
 			p.broadcastRunRefresh(run.ID)
 		}
 	}
+	// Reported once the commit is known; runs triggered by commit already showed "queued".
+	p.statuses.Report(run.ID, CommitStateRunning, "Building")
+
+	spec, specFile, specDigest, err := LoadPipelineSpec(workDir, job.PipelineFile)
+	if err != nil {
+		p.failRun(run, "流水线文件无效: "+err.Error())
+		writeLine("ERROR: " + err.Error())
+		return
+	}
+	if spec != nil {
+		writeLine(fmt.Sprintf("Pipeline file: %s (%d stages)", specFile, len(spec.Stages)))
+		p.recordPipelineSnapshot(run, spec, specFile, specDigest)
+	}
 
-	cachePaths := parseCachePaths(job.CachePaths)
-	if len(cachePaths) > 0 && p.cacheDir != "" {
+	var cache *cachePlan
+	if len(parseCachePaths(job.CachePaths)) > 0 && p.cache.root != "" {
 		writeLine("=== Stage: Restoring Cache ===")
-		jobCacheDir := filepath.Join(p.cacheDir, fmt.Sprintf("job-%d", job.ID))
-		restored := 0
-		for _, cp := range cachePaths {
-			src := filepath.Join(jobCacheDir, cp)
-			dst := filepath.Join(workDir, cp)
-			if _, err := os.Stat(src); err == nil {
-				_ = os.MkdirAll(filepath.Dir(dst), 0755)
-				if err := copyDir(src, dst); err != nil {
-					writeLine(fmt.Sprintf("WARNING: 恢复缓存 %s 失败: %s", cp, err.Error()))
-				} else {
-					restored++
-					writeLine(fmt.Sprintf("Restored cache: %s", cp))
-				}
-			}
-		}
-		if restored == 0 {
-			writeLine("No cache found (first build or cache cleared)")
-		}
+		cache = p.restoreCache(run, job, repo.ID, workDir, writeLine)
 	}
 
+	cancelClone()
 	if ctx.Err() != nil {
-		p.cancelRun(run)
+		p.stopRun(run, ctx, writeLine)
 		return
 	}
 	p.setRunning(run, "building")
-	writeLine("=== Stage: Building ===")
 
-	buildDir := workDir
-	if strings.TrimSpace(job.WorkDir) != "" {
-		buildDir = filepath.Join(workDir, job.WorkDir)
-	}
 	envVars := os.Environ()
 	for _, name := range job.EnvVarNames {
 		name = strings.TrimSpace(name)
@@ -269,66 +300,79 @@ func (p *Pipeline) Execute(ctx context.Context, runID uint) {
 		}
 		if v, ok := os.LookupEnv(name); ok {
 			envVars = append(envVars, name+"="+v)
+			redact.Add(v)
 		}
 	}
-
-	cmd, cleanupScript, err := newBuildScriptCommand(ctx, buildDir, job.BuildScriptType, job.BuildScript)
+	params, err := DecryptRunParams(run.ParamsCipher)
 	if err != nil {
-		p.failRun(run, "构建脚本配置无效: "+err.Error())
+		p.failRun(run, "构建参数解密失败: "+err.Error())
 		writeLine("ERROR: " + err.Error())
 		return
 	}
-	defer cleanupScript()
-	cmd.Dir = buildDir
-	cmd.Env = envVars
-	configureBuildCmdProc(cmd)
-	cmd.Cancel = func() error { return killBuildCmdProcess(cmd) }
-
-	stdout, _ := cmd.StdoutPipe()
-	stderr, _ := cmd.StderrPipe()
-	if err := cmd.Start(); err != nil {
-		p.failRun(run, "启动构建脚本失败: "+err.Error())
+	DecodeJobParameters(job)
+	for _, d := range job.Parameters {
+		if d.Type == ParamSecret {
+			redact.Add(params[d.Name])
+		}
+	}
+	envVars = append(envVars, ParamsEnv(params)...)
+	credEnv, err := p.credentialEnv(job, redact)
+	if err != nil {
+		p.failRun(run, "凭证变量解析失败: "+err.Error())
 		writeLine("ERROR: " + err.Error())
 		return
 	}
-	defer func() { _ = killBuildCmdProcess(cmd) }()
+	envVars = append(envVars, credEnv...)
+	envVars = append(envVars, MatrixEnv(run.MatrixCell)...)
+	envVars = append(envVars, ReleaseEnv(run.Tag, run.ReleaseVersion)...)
 
-	var scanWg sync.WaitGroup
-	scanWg.Add(1)
-	go func() {
-		defer scanWg.Done()
-		scanLines(stdout, writeLine)
-	}()
-	scanLines(stderr, writeLine)
-	scanWg.Wait()
-
-	if err := cmd.Wait(); err != nil {
-		if ctx.Err() != nil {
-			p.cancelRun(run)
+	buildCtx, cancelBuild := withTimeout(ctx, TimeoutBuild, job.BuildTimeoutSeconds)
+	defer cancelBuild()
+	if spec != nil {
+		err = p.runPipelineSpec(buildCtx, run, job, spec, workDir, branch, envVars, out)
+	} else {
+		writeLine("=== Stage: Building ===")
+		buildDir := workDir
+		if strings.TrimSpace(job.WorkDir) != "" {
+			buildDir = filepath.Join(workDir, job.WorkDir)
+		}
+		err = p.runScript(buildCtx, buildDir, job.BuildScriptType, job.BuildScript, envVars, out)
+	}
+	if err != nil {
+		if buildCtx.Err() != nil {
+			p.stopRun(run, buildCtx, writeLine)
 			return
 		}
-		p.failRun(run, "构建失败: "+err.Error())
-		writeLine("ERROR: Build failed with " + err.Error())
+		// Failing tests usually fail the script; their reports matter most here.
+		p.collectTestReports(run, job, workDir, writeLine)
+		p.collectCoverage(run, job, workDir, writeLine)
+		var cfgErr *scriptConfigError
+		var startErr *scriptStartError
+		switch {
+		case errors.As(err, &cfgErr):
+			p.failRun(run, "构建脚本配置无效: "+err.Error())
+			writeLine("ERROR: " + err.Error())
+		case errors.As(err, &startErr):
+			p.failRun(run, "启动构建脚本失败: "+err.Error())
+			writeLine("ERROR: " + err.Error())
+		default:
+			p.failRun(run, "构建失败: "+err.Error())
+			writeLine("ERROR: Build failed with " + err.Error())
+		}
 		return
 	}
 	writeLine("=== Build completed successfully ===")
+	p.collectTestReports(run, job, workDir, writeLine)
+	if cov := p.collectCoverage(run, job, workDir, writeLine); cov != nil && cov.Percent < job.CoverageThreshold {
+		msg := fmt.Sprintf("覆盖率 %.2f%% 低于阈值 %.2f%%", cov.Percent, job.CoverageThreshold)
+		p.failRun(run, msg)
+		writeLine("ERROR: " + msg)
+		return
+	}
 
-	if len(cachePaths) > 0 && p.cacheDir != "" {
+	if cache != nil {
 		writeLine("=== Stage: Saving Cache ===")
-		jobCacheDir := filepath.Join(p.cacheDir, fmt.Sprintf("job-%d", job.ID))
-		for _, cp := range cachePaths {
-			src := filepath.Join(workDir, cp)
-			dst := filepath.Join(jobCacheDir, cp)
-			if info, err := os.Stat(src); err == nil && info.IsDir() {
-				_ = os.MkdirAll(filepath.Dir(dst), 0755)
-				_ = os.RemoveAll(dst)
-				if err := copyDir(src, dst); err != nil {
-					writeLine(fmt.Sprintf("WARNING: 保存缓存 %s 失败: %s", cp, err.Error()))
-				} else {
-					writeLine(fmt.Sprintf("Saved cache: %s", cp))
-				}
-			}
-		}
+		p.saveCache(cache, workDir, writeLine)
 	}
 
 	p.setRunning(run, "archiving")
@@ -340,55 +384,61 @@ func (p *Pipeline) Execute(ctx context.Context, runID uint) {
 		_ = os.MkdirAll(artifactDir, 0755)
 		artifactFormat := NormalizeArtifactFormat(job.ArtifactFormat)
 		artifactPath := filepath.Join(artifactDir, artifactArchiveName(run.BuildNumber, artifactFormat))
-		if err := CreateArtifactArchive(artifactPath, sourceDir, artifactFormat); err != nil {
+		var art *model.RunArtifact
+		err := CreateArtifactArchive(artifactPath, sourceDir, artifactFormat)
+		if err == nil {
+			art, err = p.storeArtifact(artifactPath, artifactFormat, run.TriggeredBy)
+		}
+		if err != nil {
+			_ = os.Remove(artifactPath)
 			writeLine("WARNING: 打包构建产物失败: " + err.Error())
 		} else {
-			run.ArtifactPath = artifactPath
-			_ = p.runs.UpdateFields(run.ID, map[string]interface{}{"artifact_path": artifactPath})
+			run.ArtifactPath, run.ArtifactSHA256 = art.Path, art.SHA256
+			run.ArtifactName = filepath.Base(artifactPath)
+			fields := map[string]interface{}{
+				"artifact_path":   art.Path,
+				"artifact_sha256": art.SHA256,
+				"artifact_name":   run.ArtifactName,
+			}
+			if art.ObjectID != 0 {
+				id := art.ObjectID
+				run.ArtifactObjectID = &id
+				fields["artifact_object_id"] = &id
+			}
+			_ = p.runs.UpdateFields(run.ID, fields)
 			p.broadcastRunRefresh(run.ID)
-			writeLine("Artifact saved: " + artifactPath)
+			writeLine(fmt.Sprintf("Artifact saved: %s (%d bytes, sha256 %s)", run.ArtifactName, art.Size, art.SHA256))
 		}
-		p.cleanupArtifacts(job)
 	} else {
 		writeLine("=== Stage: Archiving (no output_dir; skip archive file) ===")
 	}
+	p.collectNamedArtifacts(run, job, workDir, writeLine)
+	if strings.TrimSpace(job.OutputDir) != "" || len(job.ArtifactDefs) > 0 {
+		p.cleanupArtifacts(job)
+	}
 
 	targets, _ := p.jobs.ListDeployTargets(job.ID)
 	hasDist := len(targets) > 0
+	if hasDist && run.PRNumber > 0 && !job.PullRequestDistribute {
+		writeLine(fmt.Sprintf("=== Pull request #%d: distribution skipped ===", run.PRNumber))
+		hasDist = false
+	}
 	p.markArtifactSuccess(run, writeLine, hasDist)
 	if ctx.Err() != nil {
-		p.cancelRun(run)
+		p.stopRun(run, ctx, writeLine)
 		return
 	}
 	// Agent sync stage intentionally omitted — P4 creates AgentRun asynchronously.
 	if hasDist {
 		p.setStageKeepSuccess(run, "distributing")
-		p.runDistributions(ctx, run, job, sourceDir, writeLine, nil)
+		p.runDistributions(ctx, run, job, sourceDir, redact, writeLine, nil)
 	} else {
 		p.setStageKeepSuccess(run, "idle")
 	}
 }
 
-func (p *Pipeline) resolveRepoGitAuth(repo *resourcemodel.Repository) (authType, username, password string, err error) {
-	switch strings.ToLower(strings.TrimSpace(repo.AuthType)) {
-	case "", "none":
-		return "none", "", "", nil
-	case "credential":
-		if repo.CredentialID == nil || *repo.CredentialID == 0 {
-			return "", "", "", fmt.Errorf("repository credential is empty")
-		}
-		typ, user, secret, _, err := p.secrets.Resolve(*repo.CredentialID)
-		if err != nil {
-			return "", "", "", err
-		}
-		authType = "password"
-		if strings.EqualFold(typ, "token") || strings.EqualFold(typ, "api_key") {
-			authType = "token"
-		}
-		return authType, user, secret, nil
-	default:
-		return "none", "", "", nil
-	}
+func (p *Pipeline) resolveRepoGitAuth(repo *resourcemodel.Repository) (GitAuth, error) {
+	return repoGitAuth(repo, p.secrets)
 }
 
 func (p *Pipeline) broadcastRunRefresh(runID uint) {
@@ -434,7 +484,9 @@ func (p *Pipeline) failRun(run *model.BuildRun, errMsg string) {
 	}
 	_ = p.runs.UpdateFields(run.ID, fields)
 	p.broadcastRunRefresh(run.ID)
+	p.statuses.Report(run.ID, CommitStateFailure, "Build failed: "+errMsg)
 	p.notifyTerminal(run, "failed", errMsg)
+	p.rollupMatrixParent(run.ID)
 }
 
 func (p *Pipeline) cancelRun(run *model.BuildRun) {
@@ -458,7 +510,9 @@ func (p *Pipeline) cancelRun(run *model.BuildRun) {
 	}
 	_ = p.runs.UpdateFields(run.ID, fields)
 	p.broadcastRunRefresh(run.ID)
+	p.statuses.Report(run.ID, CommitStateError, "Build cancelled")
 	p.notifyTerminal(run, "cancelled", "")
+	p.rollupMatrixParent(run.ID)
 }
 
 func (p *Pipeline) markArtifactSuccess(run *model.BuildRun, writeLine func(string), hasDist bool) {
@@ -477,17 +531,32 @@ func (p *Pipeline) markArtifactSuccess(run *model.BuildRun, writeLine func(strin
 	}
 	run.Stage = stage
 	run.DistributionSummary = summary
-	_ = p.runs.UpdateFields(run.ID, map[string]interface{}{
+	fields := map[string]interface{}{
 		"finished_at":          run.FinishedAt,
 		"duration_ms":          run.DurationMs,
 		"stage":                stage,
 		"status":               "success",
 		"error_message":        "",
 		"distribution_summary": summary,
-	})
+	}
+	// A successful tag run is a release; retention must keep its artifacts.
+	if run.ReleaseVersion != "" {
+		run.ArtifactPinned = true
+		fields["artifact_pinned"] = true
+	}
+	_ = p.runs.UpdateFields(run.ID, fields)
 	p.broadcastRunRefresh(run.ID)
 	writeLine(fmt.Sprintf("=== Build phase succeeded in %dms (artifact ready) ===", run.DurationMs))
+	if run.ReleaseVersion != "" {
+		writeLine(fmt.Sprintf("Release %s recorded (tag %s)", run.ReleaseVersion, run.Tag))
+	}
+	if hasDist {
+		p.statuses.Report(run.ID, CommitStateSuccess, "Build succeeded, distributing")
+	} else {
+		p.statuses.Report(run.ID, CommitStateSuccess, "Build succeeded")
+	}
 	p.notifyTerminal(run, "success", "")
+	p.rollupMatrixParent(run.ID)
 	if p.agentHook != nil && run.ArtifactPath != "" {
 		// Default event: artifact_ready (archive succeeded with a usable artifact path).
 		job, err := p.jobs.FindByID(run.BuildJobID)
@@ -520,21 +589,17 @@ func (p *Pipeline) notifyTerminal(run *model.BuildRun, status, message string) {
 	p.hub.BroadcastToChannel(fmt.Sprintf("notifications:%d", run.TriggeredBy), payload)
 }
 
+// cleanupArtifacts applies the job's retention policy right after archiving;
+// ArtifactSweeper covers the same rules periodically.
 func (p *Pipeline) cleanupArtifacts(job *model.BuildJob) {
-	builds, _ := p.runs.ListArtifactsByJob(job.ID)
-	maxArtifacts := job.MaxArtifacts
-	if maxArtifacts <= 0 {
-		maxArtifacts = 5
-	}
-	if len(builds) <= maxArtifacts {
-		return
-	}
-	for _, b := range builds[maxArtifacts:] {
-		if b.ArtifactPath != "" {
-			_ = os.Remove(b.ArtifactPath)
-			_ = p.runs.UpdateFields(b.ID, map[string]interface{}{"artifact_path": ""})
+	plan, err := ApplyArtifactRetention(p.runs, p.store, job, time.Now(), false)
+	if err != nil {
+		if p.logger != nil {
+			p.logger.Warn("artifact retention failed", zap.Uint("job_id", job.ID), zap.Error(err))
 		}
+		return
 	}
+	logRetention(p.logger, plan)
 }
 
 func scanLines(r io.Reader, fn func(string)) {
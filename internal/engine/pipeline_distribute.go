@@ -7,18 +7,23 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"bedrock/internal/cicd/model"
 	"bedrock/internal/deployer"
+	"bedrock/internal/pkg"
 	resourcemodel "bedrock/internal/resource/model"
 )
 
+// runDistributions deploys to the job's targets (filterIDs limits a redeploy)
+// as a new batch, or parks the run when one of them needs approval.
 func (p *Pipeline) runDistributions(
 	ctx context.Context,
 	run *model.BuildRun,
 	job *model.BuildJob,
 	sourceDir string,
+	redact *pkg.Redactor,
 	writeLine func(string),
 	filterIDs []uint,
 ) {
@@ -33,6 +38,29 @@ func (p *Pipeline) runDistributions(
 		return
 	}
 	targets = filterDeployTargets(targets, filterIDs)
+	if p.requestApproval(run, job, targets, writeLine) {
+		// Deciding the approval submits the run again; no slot is held meanwhile.
+		return
+	}
+	batchNo, err := p.runs.NextBatchNo(run.ID)
+	if err != nil || batchNo < 1 {
+		batchNo = 1
+	}
+	p.distribute(ctx, run, job, sourceDir, redact, writeLine, targets, batchNo)
+}
+
+// distribute deploys the run's artifacts to targets as attempts of batchNo
+// and records the distribution summary.
+func (p *Pipeline) distribute(
+	ctx context.Context,
+	run *model.BuildRun,
+	job *model.BuildJob,
+	sourceDir string,
+	redact *pkg.Redactor,
+	writeLine func(string),
+	targets []model.DeployTarget,
+	batchNo int,
+) {
 	if len(targets) == 0 {
 		writeLine("=== No deploy targets ===")
 		_ = p.runs.UpdateFields(run.ID, map[string]interface{}{
@@ -42,66 +70,71 @@ func (p *Pipeline) runDistributions(
 		p.broadcastRunRefresh(run.ID)
 		return
 	}
-
-	batchNo, err := p.runs.NextBatchNo(run.ID)
-	if err != nil || batchNo < 1 {
-		batchNo = 1
-	}
+	ctx, cancel := withTimeout(ctx, TimeoutDistribute, job.DistributeTimeoutSeconds)
+	defer cancel()
+	DecodeRunArtifacts(run)
+	sources := &deploySources{p: p, run: run, job: job, buildDir: sourceDir, cache: map[string]*deploySource{}, log: writeLine}
+	defer sources.close()
 
 	p.setStageKeepSuccess(run, "distributing")
 	_ = p.runs.UpdateFields(run.ID, map[string]interface{}{"distribution_summary": "running"})
 	p.broadcastRunRefresh(run.ID)
 	writeLine(fmt.Sprintf("=== Stage: Distributing (batch %d) ===", batchNo))
+	strategy := NormalizeDistributeStrategy(job.DistributeStrategy)
+	waves, concurrency := distributionWaves(job, len(targets))
+	if strategy != DistributeSerial {
+		writeLine(describeStrategy(job, waves, concurrency))
+	}
 
-	var nOK, nFail int
-	for i := range targets {
-		if ctx.Err() != nil {
-			for j := i; j < len(targets); j++ {
-				p.recordAttemptCancelled(run, batchNo, &targets[j])
+	var nOK, nFail, nAborted int
+	abort := ""
+	for w, wave := range waves {
+		waveNo := w + 1
+		if abort != "" || ctx.Err() != nil {
+			status, msg := AttemptAborted, abort
+			if abort == "" {
+				status, msg = stoppedAttemptStatus(ctx)
 			}
-			writeLine("ERROR: cancelled")
-			break
-		}
-		t := targets[i]
-		snap, _ := json.Marshal(t)
-		attempt := &model.BuildDeployAttempt{
-			BuildRunID:         run.ID,
-			BatchNo:            batchNo,
-			DeployTargetID:     &t.ID,
-			TargetSnapshotJSON: string(snap),
-			Status:             "running",
-			StartedAt:          ptrTime(time.Now()),
-		}
-		_ = p.runs.CreateAttempt(attempt)
-		p.broadcastRunRefresh(run.ID)
-		writeLine(fmt.Sprintf("--- Target #%d (%s → %s) ---", t.ID, t.Method, t.RemotePath))
-		err := p.deployOneTarget(ctx, &t, sourceDir, NormalizeArtifactFormat(job.ArtifactFormat), writeLine)
-		fin := time.Now()
-		attempt.FinishedAt = &fin
-		if err != nil {
-			if ctx.Err() != nil {
-				attempt.Status = "cancelled"
-				attempt.ErrorMessage = "cancelled"
-			} else {
-				attempt.Status = "failed"
-				attempt.ErrorMessage = err.Error()
+			for _, i := range wave {
+				p.recordAttemptStopped(run, batchNo, waveNo, &targets[i], status, msg)
+			}
+			if abort != "" {
+				nAborted += len(wave)
 			}
-			_ = p.runs.UpdateAttempt(attempt)
-			p.broadcastRunRefresh(run.ID)
-			writeLine("ERROR: " + err.Error())
-			nFail++
 			continue
 		}
-		attempt.Status = "success"
-		attempt.ErrorMessage = ""
-		_ = p.runs.UpdateAttempt(attempt)
-		p.broadcastRunRefresh(run.ID)
-		nOK++
+		if len(waves) > 1 {
+			writeLine(fmt.Sprintf("--- Wave %d/%d (%d target(s)) ---", waveNo, len(waves), len(wave)))
+		}
+		var verify func(*model.DeployTarget) error
+		canary := strategy == DistributeCanary && waveNo == 1 && len(waves) > 1
+		if canary && strings.TrimSpace(job.CanaryVerifyScript) != "" {
+			verify = func(t *model.DeployTarget) error { return p.runCanaryVerify(ctx, run, job, t, writeLine) }
+		}
+		ok, failed := p.deployWave(ctx, run, batchNo, waveNo, targets, wave, concurrency, sources, redact, writeLine, verify)
+		nOK += ok
+		nFail += failed
+		switch {
+		case ctx.Err() != nil || waveNo == len(waves):
+		case canary && failed > 0:
+			abort = "金丝雀目标分发或验证失败，已中止其余目标"
+		case strategy == DistributeRolling && nFail > job.DistributeMaxFailures:
+			abort = fmt.Sprintf("失败目标数 %d 超过阈值 %d，已中止滚动分发", nFail, job.DistributeMaxFailures)
+		}
+		if abort != "" {
+			writeLine("ERROR: " + abort)
+		}
+	}
+	if ctx.Err() != nil {
+		_, msg := stoppedAttemptStatus(ctx)
+		writeLine("ERROR: " + msg)
 	}
 
 	summary := "all_success"
 	if ctx.Err() != nil {
-		summary = "cancelled"
+		summary, _ = stoppedAttemptStatus(ctx)
+	} else if nAborted > 0 {
+		summary = AttemptAborted
 	} else if nFail > 0 && nOK > 0 {
 		summary = "partial"
 	} else if nFail > 0 && nOK == 0 {
@@ -116,6 +149,11 @@ func (p *Pipeline) runDistributions(
 	})
 	p.broadcastRunRefresh(run.ID)
 	writeLine(fmt.Sprintf("=== Distribution phase finished (%s) ===", summary))
+	if summary == "all_success" {
+		p.statuses.Report(run.ID, CommitStateSuccess, "Build succeeded, distribution finished")
+	} else {
+		p.statuses.Report(run.ID, CommitStateFailure, "Build succeeded, distribution "+summary)
+	}
 	if p.agentHook != nil {
 		job, err := p.jobs.FindByID(run.BuildJobID)
 		if err == nil {
@@ -125,16 +163,87 @@ func (p *Pipeline) runDistributions(
 	}
 }
 
-func (p *Pipeline) recordAttemptCancelled(run *model.BuildRun, batchNo int, t *model.DeployTarget) {
+// stoppedAttemptStatus is the attempt status/message once ctx ended:
+// timed_out when a time limit fired, cancelled otherwise.
+func stoppedAttemptStatus(ctx context.Context) (status, msg string) {
+	if te := timeoutCause(ctx); te != nil {
+		return RunStatusTimedOut, te.Error()
+	}
+	return "cancelled", "cancelled"
+}
+
+// deployAttempt deploys one target as an attempt of batchNo/wave.
+func (p *Pipeline) deployAttempt(
+	ctx context.Context,
+	run *model.BuildRun,
+	batchNo, wave int,
+	t *model.DeployTarget,
+	sources *deploySources,
+	redact *pkg.Redactor,
+	writeLine func(string),
+	verify func(*model.DeployTarget) error,
+) error {
+	snap, _ := json.Marshal(t)
+	label := t.ArtifactName
+	if label == "" {
+		label = "main"
+	}
+	writeLine(fmt.Sprintf("--- Target #%d (%s → %s, artifact %s) ---", t.ID, t.Method, t.RemotePath, label))
+	src := sources.resolve(t.ArtifactName)
+	id := t.ID
+	attempt := &model.BuildDeployAttempt{
+		BuildRunID:         run.ID,
+		BatchNo:            batchNo,
+		Wave:               wave,
+		DeployTargetID:     &id,
+		TargetSnapshotJSON: string(snap),
+		Status:             "running",
+		StartedAt:          ptrTime(time.Now()),
+		ArtifactSHA256:     src.sha256,
+	}
+	_ = p.runs.CreateAttempt(attempt)
+	p.broadcastRunRefresh(run.ID)
+	err := src.err
+	if err == nil {
+		err = p.deployOneTarget(ctx, t, src.dir, src.format, redact, writeLine)
+	}
+	if err == nil && verify != nil {
+		err = verify(t)
+	}
+	fin := time.Now()
+	attempt.FinishedAt = &fin
+	if err != nil {
+		if ctx.Err() != nil {
+			attempt.Status, attempt.ErrorMessage = stoppedAttemptStatus(ctx)
+		} else {
+			attempt.Status = "failed"
+			attempt.ErrorMessage = redact.Redact(err.Error())
+		}
+		_ = p.runs.UpdateAttempt(attempt)
+		p.broadcastRunRefresh(run.ID)
+		writeLine("ERROR: " + err.Error())
+		return err
+	}
+	attempt.Status = "success"
+	attempt.ErrorMessage = ""
+	_ = p.runs.UpdateAttempt(attempt)
+	p.recordDeployment(run, t, attempt)
+	p.broadcastRunRefresh(run.ID)
+	return nil
+}
+
+// recordAttemptStopped records a target the batch did not deploy.
+func (p *Pipeline) recordAttemptStopped(run *model.BuildRun, batchNo, wave int, t *model.DeployTarget, status, msg string) {
 	snap, _ := json.Marshal(t)
 	id := t.ID
 	_ = p.runs.CreateAttempt(&model.BuildDeployAttempt{
 		BuildRunID:         run.ID,
 		BatchNo:            batchNo,
+		Wave:               wave,
 		DeployTargetID:     &id,
 		TargetSnapshotJSON: string(snap),
-		Status:             "cancelled",
-		ErrorMessage:       "cancelled",
+		Status:             status,
+		ErrorMessage:       msg,
 		FinishedAt:         ptrTime(time.Now()),
 	})
 	p.broadcastRunRefresh(run.ID)
@@ -161,6 +270,7 @@ func (p *Pipeline) deployOneTarget(
 	ctx context.Context,
 	t *model.DeployTarget,
 	sourceDir, artifactFormat string,
+	redact *pkg.Redactor,
 	writeLine func(string),
 ) error {
 	method := strings.TrimSpace(strings.ToLower(t.Method))
@@ -201,6 +311,7 @@ func (p *Pipeline) deployOneTarget(
 		if err != nil {
 			return err
 		}
+		redact.Add(password, privateKey, agentToken)
 		username := server.Username
 		if username == "" {
 			// Prefer credential username when server username empty
@@ -268,49 +379,116 @@ func (p *Pipeline) resolveServerSecrets(server *resourcemodel.Server) (password,
 	return password, privateKey, agentToken, nil
 }
 
-func (p *Pipeline) executeRedeployOnly(ctx context.Context, run *model.BuildRun, job *model.BuildJob, writeLine func(string)) {
-	artifactPath := strings.TrimSpace(run.ArtifactPath)
-	if artifactPath == "" {
+func (p *Pipeline) executeRedeployOnly(ctx context.Context, run *model.BuildRun, job *model.BuildJob, redact *pkg.Redactor, writeLine func(string)) {
+	DecodeRunArtifacts(run)
+	if strings.TrimSpace(run.ArtifactPath) == "" && run.ArtifactObjectID == nil && len(run.Artifacts) == 0 {
 		writeLine("ERROR: no artifact_path")
 		_ = p.runs.UpdateFields(run.ID, map[string]interface{}{"distribution_summary": "all_failed", "stage": "idle"})
 		p.broadcastRunRefresh(run.ID)
 		return
 	}
-	if !filepath.IsAbs(artifactPath) {
-		artifactPath = filepath.Join(p.artifact, artifactPath)
-	}
-	if _, err := os.Stat(artifactPath); err != nil {
-		writeLine("ERROR: " + err.Error())
-		_ = p.runs.UpdateFields(run.ID, map[string]interface{}{"distribution_summary": "all_failed", "stage": "idle"})
-		p.broadcastRunRefresh(run.ID)
+	writeLine("=== Redeploy: using existing artifacts ===")
+
+	filter := parseTargetFilterFromSnapshot(run.SnapshotJSON)
+	if ctx.Err() != nil {
+		p.stopRun(run, ctx, writeLine)
 		return
 	}
-	writeLine("=== Redeploy: using existing artifact ===")
-	writeLine("Artifact: " + artifactPath)
+	// Empty source dir: each artifact a target needs is extracted from the store.
+	p.runDistributions(ctx, run, job, "", redact, writeLine, filter)
+}
 
-	tmpDir, err := os.MkdirTemp("", "bedrock-redeploy-*")
-	if err != nil {
-		writeLine("ERROR: mkdir temp: " + err.Error())
-		_ = p.runs.UpdateFields(run.ID, map[string]interface{}{"distribution_summary": "all_failed", "stage": "idle"})
-		p.broadcastRunRefresh(run.ID)
-		return
+// deploySource is what one DeployTarget ships.
+type deploySource struct {
+	dir    string
+	format string
+	sha256 string
+	err    error
+}
+
+// deploySources resolves each target's source directory: buildDir for targets
+// without ArtifactName (the OutputDir just built), otherwise a run artifact
+// extracted on first use after checking its digest. An empty buildDir (redeploy)
+// extracts the run's main archive too, so only artifacts in use are unpacked.
+type deploySources struct {
+	p        *Pipeline
+	run      *model.BuildRun
+	job      *model.BuildJob
+	buildDir string
+	tmp      string
+	cache    map[string]*deploySource
+	log      func(string)
+	mu       sync.Mutex
+}
+
+func (s *deploySources) resolve(name string) *deploySource {
+	// Parallel targets share one extraction per artifact.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if src, ok := s.cache[name]; ok {
+		return src
 	}
-	defer os.RemoveAll(tmpDir)
+	src := s.load(name)
+	s.cache[name] = src
+	return src
+}
 
-	format := NormalizeArtifactFormat(job.ArtifactFormat)
-	if err := extractArtifactArchive(artifactPath, tmpDir, format); err != nil {
-		writeLine("ERROR: " + err.Error())
-		_ = p.runs.UpdateFields(run.ID, map[string]interface{}{"distribution_summary": "all_failed", "stage": "idle"})
-		p.broadcastRunRefresh(run.ID)
-		return
+func (s *deploySources) load(name string) *deploySource {
+	run := s.run
+	if name == "" {
+		format := NormalizeArtifactFormat(s.job.ArtifactFormat)
+		if strings.HasSuffix(run.ArtifactName, ".zip") {
+			format = "zip"
+		} else if strings.HasSuffix(run.ArtifactName, ".tar.gz") {
+			format = "gzip"
+		}
+		if s.buildDir != "" {
+			return &deploySource{dir: s.buildDir, format: format, sha256: run.ArtifactSHA256}
+		}
+		path := strings.TrimSpace(run.ArtifactPath)
+		if path == "" && run.ArtifactObjectID == nil {
+			return &deploySource{err: fmt.Errorf("no artifact_path")}
+		}
+		if path != "" && !filepath.IsAbs(path) {
+			path = filepath.Join(s.p.artifact, path)
+		}
+		return s.extract("main", runObjectID(run), path, format, run.ArtifactSHA256)
+	}
+	for _, a := range run.Artifacts {
+		if a.Name == name {
+			return s.extract(name, a.ObjectID, a.Path, NormalizeArtifactFormat(a.Format), a.SHA256)
+		}
 	}
+	return &deploySource{err: fmt.Errorf("构建未产出制品 %q", name)}
+}
 
-	filter := parseTargetFilterFromSnapshot(run.SnapshotJSON)
-	if ctx.Err() != nil {
-		p.cancelRun(run)
-		return
+func (s *deploySources) extract(label string, objectID uint, path, format, sha string) *deploySource {
+	file, err := ArtifactFile(s.p.store, objectID, path)
+	if err == nil && sha != "" {
+		// The store is content-addressed, but a file edited on disk must not ship.
+		var digest string
+		if digest, err = fileSHA256(file); err == nil && digest != sha {
+			err = fmt.Errorf("制品校验失败: sha256 %s，构建时为 %s", digest, sha)
+		}
+	}
+	if err == nil && s.tmp == "" {
+		s.tmp, err = os.MkdirTemp("", "bedrock-deploy-*")
+	}
+	if err != nil {
+		return &deploySource{err: err}
+	}
+	dir := filepath.Join(s.tmp, stageFileNameUnsafe.ReplaceAllString(label, "_"))
+	if err := extractArtifactArchive(file, dir, format); err != nil {
+		return &deploySource{err: err}
+	}
+	s.log(fmt.Sprintf("Artifact %s: %s (sha256 %s, verified)", label, filepath.Base(file), sha))
+	return &deploySource{dir: dir, format: format, sha256: sha}
+}
+
+func (s *deploySources) close() {
+	if s.tmp != "" {
+		_ = os.RemoveAll(s.tmp)
 	}
-	p.runDistributions(ctx, run, job, tmpDir, writeLine, filter)
 }
 
 // parseTargetFilterFromSnapshot reads optional redeploy_target_ids from snapshot_json.
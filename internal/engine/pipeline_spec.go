@@ -0,0 +1,257 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPipelineFiles are probed (in order) at the repository root when
+// BuildJob.PipelineFile is empty.
+var DefaultPipelineFiles = []string{".bedrock.yml", ".bedrock.yaml"}
+
+// PipelineSpec is the in-repo pipeline definition (.bedrock.yml).
+// When present it replaces BuildJob.BuildScript; clone, cache, archive
+// (output_dir) and distribution still follow the job configuration.
+type PipelineSpec struct {
+	Env    map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+	Stages []StageSpec       `yaml:"stages" json:"stages"`
+}
+
+// StageSpec is one named section of the run log.
+type StageSpec struct {
+	Name      string            `yaml:"name" json:"name"`
+	When      *WhenSpec         `yaml:"when,omitempty" json:"when,omitempty"`
+	Env       map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+	Steps     []StepSpec        `yaml:"steps" json:"steps"`
+	Artifacts *StageArtifact    `yaml:"artifacts,omitempty" json:"artifacts,omitempty"`
+}
+
+// StepSpec runs one script inside a stage.
+type StepSpec struct {
+	Name    string            `yaml:"name" json:"name"`
+	Shell   string            `yaml:"shell,omitempty" json:"shell,omitempty"`
+	Script  string            `yaml:"script" json:"script"`
+	WorkDir string            `yaml:"working_dir,omitempty" json:"working_dir,omitempty"`
+	Env     map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+	When    *WhenSpec         `yaml:"when,omitempty" json:"when,omitempty"`
+}
+
+// StageArtifact archives a path once the stage succeeds (kept per stage on the run).
+type StageArtifact struct {
+	Path   string `yaml:"path" json:"path"`
+	Format string `yaml:"format,omitempty" json:"format,omitempty"`
+}
+
+// WhenSpec gates a stage/step. Empty lists match everything.
+// Status: on_success (default) | on_failure | always — relative to earlier
+// stages (for a step: earlier steps of the same stage).
+type WhenSpec struct {
+	Branches []string `yaml:"branches,omitempty" json:"branches,omitempty"`
+	Triggers []string `yaml:"triggers,omitempty" json:"triggers,omitempty"`
+	Status   string   `yaml:"status,omitempty" json:"status,omitempty"`
+}
+
+// WhenContext is the run state a WhenSpec is evaluated against.
+type WhenContext struct {
+	Branch  string
+	Trigger string
+	Failed  bool
+}
+
+// Match reports whether the gated stage/step should run.
+func (w *WhenSpec) Match(c WhenContext) bool {
+	status := "on_success"
+	if w != nil && strings.TrimSpace(w.Status) != "" {
+		status = strings.ToLower(strings.TrimSpace(w.Status))
+	}
+	switch status {
+	case "always":
+	case "on_failure":
+		if !c.Failed {
+			return false
+		}
+	default:
+		if c.Failed {
+			return false
+		}
+	}
+	if w == nil {
+		return true
+	}
+	if len(w.Branches) > 0 && !matchAnyGlob(w.Branches, c.Branch) {
+		return false
+	}
+	if len(w.Triggers) > 0 {
+		ok := false
+		for _, t := range w.Triggers {
+			if strings.EqualFold(strings.TrimSpace(t), c.Trigger) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAnyGlob(patterns []string, value string) bool {
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == value {
+			return true
+		}
+		if ok, err := path.Match(p, value); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadPipelineSpec reads the pipeline file from a checked-out workspace.
+// It returns (nil, "", "", nil) when no file exists and the job did not pin one.
+func LoadPipelineSpec(workDir, pinned string) (spec *PipelineSpec, file, digest string, err error) {
+	candidates := DefaultPipelineFiles
+	if p := strings.TrimSpace(pinned); p != "" {
+		candidates = []string{p}
+	}
+	for _, name := range candidates {
+		full, err := resolveInWorkspace(workDir, name)
+		if err != nil {
+			return nil, "", "", err
+		}
+		data, err := os.ReadFile(full)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, "", "", err
+		}
+		spec, err := ParsePipelineSpec(data)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("%s: %w", name, err)
+		}
+		sum := sha256.Sum256(data)
+		return spec, filepath.ToSlash(name), hex.EncodeToString(sum[:]), nil
+	}
+	if strings.TrimSpace(pinned) != "" {
+		return nil, "", "", fmt.Errorf("流水线文件不存在: %s", pinned)
+	}
+	return nil, "", "", nil
+}
+
+// ParsePipelineSpec decodes and validates a pipeline YAML document.
+func ParsePipelineSpec(data []byte) (*PipelineSpec, error) {
+	var spec PipelineSpec
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("invalid pipeline yaml: %w", err)
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// Validate checks names, steps and relative paths.
+func (s *PipelineSpec) Validate() error {
+	if len(s.Stages) == 0 {
+		return fmt.Errorf("stages must not be empty")
+	}
+	seen := map[string]struct{}{}
+	for i, st := range s.Stages {
+		name := strings.TrimSpace(st.Name)
+		if name == "" {
+			return fmt.Errorf("stages[%d]: name is required", i)
+		}
+		if _, dup := seen[name]; dup {
+			return fmt.Errorf("stages[%d]: duplicate stage name %q", i, name)
+		}
+		seen[name] = struct{}{}
+		if len(st.Steps) == 0 {
+			return fmt.Errorf("stage %q: steps must not be empty", name)
+		}
+		if err := validateWhen(st.When); err != nil {
+			return fmt.Errorf("stage %q: %w", name, err)
+		}
+		for j, step := range st.Steps {
+			if strings.TrimSpace(step.Script) == "" {
+				return fmt.Errorf("stage %q step %d: script is required", name, j+1)
+			}
+			if err := validateRelPath(step.WorkDir); err != nil {
+				return fmt.Errorf("stage %q step %d: working_dir %w", name, j+1, err)
+			}
+			if err := validateWhen(step.When); err != nil {
+				return fmt.Errorf("stage %q step %d: %w", name, j+1, err)
+			}
+		}
+		if st.Artifacts != nil {
+			if strings.TrimSpace(st.Artifacts.Path) == "" {
+				return fmt.Errorf("stage %q: artifacts.path is required", name)
+			}
+			if err := validateRelPath(st.Artifacts.Path); err != nil {
+				return fmt.Errorf("stage %q: artifacts.path %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateWhen(w *WhenSpec) error {
+	if w == nil {
+		return nil
+	}
+	switch strings.ToLower(strings.TrimSpace(w.Status)) {
+	case "", "on_success", "on_failure", "always":
+		return nil
+	default:
+		return fmt.Errorf("when.status %q invalid (on_success|on_failure|always)", w.Status)
+	}
+}
+
+func validateRelPath(p string) error {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return nil
+	}
+	if filepath.IsAbs(p) || strings.HasPrefix(p, "/") {
+		return fmt.Errorf("must be relative")
+	}
+	clean := filepath.Clean(p)
+	if clean == ".." || strings.HasPrefix(clean, ".."+string(os.PathSeparator)) {
+		return fmt.Errorf("must stay inside the workspace")
+	}
+	return nil
+}
+
+// resolveInWorkspace joins rel onto workDir, rejecting paths that escape it.
+func resolveInWorkspace(workDir, rel string) (string, error) {
+	if err := validateRelPath(rel); err != nil {
+		return "", fmt.Errorf("%q %w", rel, err)
+	}
+	return filepath.Join(workDir, filepath.Clean(rel)), nil
+}
+
+// mergeEnv overlays key=value pairs onto base (later maps win).
+func mergeEnv(base []string, layers ...map[string]string) []string {
+	out := append([]string(nil), base...)
+	for _, layer := range layers {
+		for k, v := range layer {
+			k = strings.TrimSpace(k)
+			if k == "" {
+				continue
+			}
+			out = append(out, k+"="+v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,197 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+	resourcemodel "bedrock/internal/resource/model"
+)
+
+func TestParsePipelineSpecValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name string
+		yaml string
+		ok   bool
+	}{
+		{"valid", "stages:\n  - name: build\n    steps:\n      - script: echo hi\n", true},
+		{"no stages", "env:\n  A: b\n", false},
+		{"missing name", "stages:\n  - steps:\n      - script: echo\n", false},
+		{"duplicate", "stages:\n  - name: a\n    steps: [{script: x}]\n  - name: a\n    steps: [{script: y}]\n", false},
+		{"empty script", "stages:\n  - name: a\n    steps: [{name: s}]\n", false},
+		{"bad status", "stages:\n  - name: a\n    when: {status: sometimes}\n    steps: [{script: x}]\n", false},
+		{"escape workdir", "stages:\n  - name: a\n    steps: [{script: x, working_dir: ../up}]\n", false},
+		{"abs artifact", "stages:\n  - name: a\n    steps: [{script: x}]\n    artifacts: {path: /etc}\n", false},
+		{"unknown field", "stages:\n  - name: a\n    steps: [{script: x}]\n    image: alpine\n", false},
+	}
+	for _, tc := range cases {
+		_, err := ParsePipelineSpec([]byte(tc.yaml))
+		if (err == nil) != tc.ok {
+			t.Fatalf("%s: err=%v want ok=%v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestWhenSpecMatch(t *testing.T) {
+	t.Parallel()
+	var nilWhen *WhenSpec
+	if !nilWhen.Match(WhenContext{Branch: "main"}) {
+		t.Fatal("nil when should match on success")
+	}
+	if nilWhen.Match(WhenContext{Failed: true}) {
+		t.Fatal("nil when should not run after failure")
+	}
+	w := &WhenSpec{Branches: []string{"release/*"}, Triggers: []string{"webhook"}}
+	if !w.Match(WhenContext{Branch: "release/1.0", Trigger: "webhook"}) {
+		t.Fatal("expected glob+trigger match")
+	}
+	if w.Match(WhenContext{Branch: "main", Trigger: "webhook"}) {
+		t.Fatal("branch should not match")
+	}
+	if w.Match(WhenContext{Branch: "release/1.0", Trigger: "manual"}) {
+		t.Fatal("trigger should not match")
+	}
+	if !(&WhenSpec{Status: "on_failure"}).Match(WhenContext{Failed: true}) {
+		t.Fatal("on_failure should run after failure")
+	}
+	if !(&WhenSpec{Status: "always"}).Match(WhenContext{}) {
+		t.Fatal("always should run")
+	}
+}
+
+func commitRepoFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"add", "."}, {"commit", "-m", "add " + name}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+}
+
+func TestExecutePipelineFileStages(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	repoDir := initLocalGitRepo(t)
+	commitRepoFile(t, repoDir, ".bedrock.yml", `env:
+  GREETING: hello
+stages:
+  - name: compile
+    steps:
+      - name: make
+        script: mkdir -p dist && echo "$GREETING" > dist/app.txt
+    artifacts:
+      path: dist
+  - name: release-only
+    when:
+      branches: ["release/*"]
+    steps:
+      - script: echo should-not-run
+  - name: test
+    steps:
+      - script: echo tests-ok
+`)
+	tmp := t.TempDir()
+	logDir := filepath.Join(tmp, "logs")
+	run := &model.BuildRun{ID: 1, BuildJobID: 10, BuildNumber: 3, Status: "queued", Stage: "pending", Branch: "main"}
+	store := newMemRunStore(run)
+	jobStore := &memJobStore{job: &model.BuildJob{
+		ID: 10, RepositoryID: 1, Branch: "main",
+		BuildScript:    "echo legacy-script",
+		OutputDir:      "dist",
+		ArtifactFormat: "gzip",
+		MaxArtifacts:   5,
+	}}
+	repoStore := &memRepoStore{repo: &resourcemodel.Repository{ID: 1, RepoURL: repoDir, AuthType: "none"}}
+	p := NewPipeline(store, jobStore, repoStore, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(),
+		filepath.Join(tmp, "ws"), filepath.Join(tmp, "artifacts"), logDir, filepath.Join(tmp, "cache"))
+
+	p.Execute(context.Background(), 1)
+
+	got, _ := store.FindByID(1)
+	if got.Status != "success" {
+		t.Fatalf("status=%s want success (error=%q)", got.Status, got.ErrorMessage)
+	}
+	logBody, err := os.ReadFile(got.LogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(logBody)
+	for _, want := range []string{"=== Stage: compile ===", "=== Stage: release-only (skipped) ===", "=== Stage: test ===", "tests-ok"} {
+		if !strings.Contains(log, want) {
+			t.Fatalf("log missing %q:\n%s", want, log)
+		}
+	}
+	if strings.Contains(log, "should-not-run") || strings.Contains(log, "legacy-script") {
+		t.Fatalf("unexpected script output in log:\n%s", log)
+	}
+	DecodeRunArtifacts(got)
+	if len(got.Artifacts) != 1 || got.Artifacts[0].Stage != "compile" {
+		t.Fatalf("artifacts=%+v want one compile artifact", got.Artifacts)
+	}
+	if _, err := os.Stat(got.Artifacts[0].Path); err != nil {
+		t.Fatalf("stage artifact missing: %v", err)
+	}
+	var snap map[string]interface{}
+	if err := json.Unmarshal([]byte(got.SnapshotJSON), &snap); err != nil {
+		t.Fatal(err)
+	}
+	if snap["pipeline_file"] != ".bedrock.yml" || snap["pipeline_sha256"] == "" {
+		t.Fatalf("snapshot missing pipeline: %v", snap)
+	}
+}
+
+func TestExecutePipelineFileFailureRunsAlwaysStage(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	repoDir := initLocalGitRepo(t)
+	commitRepoFile(t, repoDir, ".bedrock.yml", `stages:
+  - name: build
+    steps:
+      - script: exit 3
+  - name: package
+    steps:
+      - script: echo packaged
+  - name: cleanup
+    when: {status: always}
+    steps:
+      - script: echo cleaned
+`)
+	tmp := t.TempDir()
+	run := &model.BuildRun{ID: 1, BuildJobID: 10, BuildNumber: 1, Status: "queued", Stage: "pending", Branch: "main"}
+	store := newMemRunStore(run)
+	jobStore := &memJobStore{job: &model.BuildJob{ID: 10, RepositoryID: 1, Branch: "main", ArtifactFormat: "gzip"}}
+	repoStore := &memRepoStore{repo: &resourcemodel.Repository{ID: 1, RepoURL: repoDir, AuthType: "none"}}
+	p := NewPipeline(store, jobStore, repoStore, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(),
+		filepath.Join(tmp, "ws"), filepath.Join(tmp, "artifacts"), filepath.Join(tmp, "logs"), filepath.Join(tmp, "cache"))
+
+	p.Execute(context.Background(), 1)
+
+	got, _ := store.FindByID(1)
+	if got.Status != "failed" {
+		t.Fatalf("status=%s want failed", got.Status)
+	}
+	logBody, _ := os.ReadFile(got.LogPath)
+	log := string(logBody)
+	if !strings.Contains(log, "=== Stage: package (skipped) ===") || !strings.Contains(log, "cleaned") {
+		t.Fatalf("unexpected log:\n%s", log)
+	}
+}
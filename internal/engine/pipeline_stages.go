@@ -0,0 +1,220 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"bedrock/internal/cicd/model"
+)
+
+// runScript starts one build script in its own process group and streams
+// stdout/stderr into out (each line tagged with its stream) until it exits.
+func (p *Pipeline) runScript(
+	ctx context.Context,
+	dir, scriptType, script string,
+	env []string,
+	out *runLog,
+) error {
+	cmd, cleanupScript, err := newBuildScriptCommand(ctx, dir, scriptType, script)
+	if err != nil {
+		return &scriptConfigError{err: err}
+	}
+	defer cleanupScript()
+	cmd.Dir = dir
+	cmd.Env = env
+	configureBuildCmdProc(cmd)
+	cmd.Cancel = func() error { return killBuildCmdProcess(cmd) }
+
+	stdout, _ := cmd.StdoutPipe()
+	stderr, _ := cmd.StderrPipe()
+	if err := cmd.Start(); err != nil {
+		return &scriptStartError{err: err}
+	}
+	defer func() { _ = killBuildCmdProcess(cmd) }()
+
+	var scanWg sync.WaitGroup
+	scanWg.Add(1)
+	go func() {
+		defer scanWg.Done()
+		scanLines(stdout, out.Stdout)
+	}()
+	scanLines(stderr, out.Stderr)
+	scanWg.Wait()
+	return cmd.Wait()
+}
+
+// scriptConfigError: the script could not be turned into a command (bad type, missing shell).
+type scriptConfigError struct{ err error }
+
+func (e *scriptConfigError) Error() string { return e.err.Error() }
+
+// scriptStartError: the command exists but the process failed to start.
+type scriptStartError struct{ err error }
+
+func (e *scriptStartError) Error() string { return e.err.Error() }
+
+// runPipelineSpec executes .bedrock.yml stages in order. Each stage writes its
+// own "=== Stage: <name> ===" section header so log viewers can split the run.
+// After a failure only stages/steps with when.status on_failure|always run;
+// the first failure is returned.
+func (p *Pipeline) runPipelineSpec(
+	ctx context.Context,
+	run *model.BuildRun,
+	job *model.BuildJob,
+	spec *PipelineSpec,
+	workDir, branch string,
+	baseEnv []string,
+	out *runLog,
+) error {
+	var firstErr error
+	var artifacts []model.RunArtifact
+	for _, st := range spec.Stages {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		when := WhenContext{Branch: branch, Trigger: run.TriggerType, Failed: firstErr != nil}
+		if !st.When.Match(when) {
+			out.Line(fmt.Sprintf("=== Stage: %s (skipped) ===", st.Name))
+			continue
+		}
+		out.Line(fmt.Sprintf("=== Stage: %s ===", st.Name))
+		stageErr := p.runStageSteps(ctx, job, st, workDir, when, mergeEnv(baseEnv, spec.Env, st.Env), out)
+		if stageErr != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			out.Line(fmt.Sprintf("ERROR: stage %s failed: %s", st.Name, stageErr.Error()))
+			if firstErr == nil {
+				firstErr = fmt.Errorf("stage %s: %w", st.Name, stageErr)
+			}
+			continue
+		}
+		if st.Artifacts != nil && firstErr == nil {
+			art, err := p.archiveStageArtifact(run, job, st, workDir)
+			if err != nil {
+				out.Line(fmt.Sprintf("WARNING: 打包阶段 %s 产物失败: %s", st.Name, err.Error()))
+			} else {
+				artifacts = append(artifacts, *art)
+				out.Line(fmt.Sprintf("Stage artifact saved: %s (sha256 %s)", art.FileName, art.SHA256))
+			}
+		}
+	}
+	if len(artifacts) > 0 {
+		run.Artifacts = artifacts
+		raw, _ := json.Marshal(artifacts)
+		run.ArtifactsJSON = string(raw)
+		_ = p.runs.UpdateFields(run.ID, map[string]interface{}{"artifacts_json": run.ArtifactsJSON})
+		p.broadcastRunRefresh(run.ID)
+	}
+	return firstErr
+}
+
+func (p *Pipeline) runStageSteps(
+	ctx context.Context,
+	job *model.BuildJob,
+	st StageSpec,
+	workDir string,
+	when WhenContext,
+	env []string,
+	out *runLog,
+) error {
+	var stageErr error
+	for i, step := range st.Steps {
+		// The stage gate already handled earlier stages; steps only look at their siblings.
+		stepWhen := when
+		stepWhen.Failed = stageErr != nil
+		label := strings.TrimSpace(step.Name)
+		if label == "" {
+			label = fmt.Sprintf("step %d", i+1)
+		}
+		if !step.When.Match(stepWhen) {
+			out.Line(fmt.Sprintf("--- Step: %s (skipped) ---", label))
+			continue
+		}
+		out.Line(fmt.Sprintf("--- Step: %s ---", label))
+		dir := workDir
+		rel := step.WorkDir
+		if strings.TrimSpace(rel) == "" {
+			rel = job.WorkDir
+		}
+		if strings.TrimSpace(rel) != "" {
+			resolved, err := resolveInWorkspace(workDir, rel)
+			if err != nil {
+				return err
+			}
+			dir = resolved
+		}
+		shell := step.Shell
+		if strings.TrimSpace(shell) == "" {
+			shell = job.BuildScriptType
+		}
+		if err := p.runScript(ctx, dir, shell, step.Script, mergeEnv(env, step.Env), out); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if stageErr == nil {
+				stageErr = fmt.Errorf("%s: %w", label, err)
+			}
+		}
+	}
+	return stageErr
+}
+
+var stageFileNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func (p *Pipeline) archiveStageArtifact(run *model.BuildRun, job *model.BuildJob, st StageSpec, workDir string) (*model.RunArtifact, error) {
+	src, err := resolveInWorkspace(workDir, st.Artifacts.Path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(src); err != nil {
+		return nil, err
+	}
+	format := job.ArtifactFormat
+	if strings.TrimSpace(st.Artifacts.Format) != "" {
+		format = st.Artifacts.Format
+	}
+	art, err := p.archiveNamedArtifact(run, job, st.Name, src, format)
+	if err != nil {
+		return nil, err
+	}
+	art.Stage = st.Name
+	return art, nil
+}
+
+// recordPipelineSnapshot merges the resolved pipeline file into snapshot_json.
+func (p *Pipeline) recordPipelineSnapshot(run *model.BuildRun, spec *PipelineSpec, file, digest string) {
+	snap := map[string]interface{}{}
+	if strings.TrimSpace(run.SnapshotJSON) != "" {
+		_ = json.Unmarshal([]byte(run.SnapshotJSON), &snap)
+	}
+	snap["pipeline_file"] = file
+	snap["pipeline_sha256"] = digest
+	snap["pipeline"] = spec
+	raw, err := json.Marshal(snap)
+	if err != nil {
+		return
+	}
+	run.SnapshotJSON = string(raw)
+	_ = p.runs.UpdateFields(run.ID, map[string]interface{}{"snapshot_json": run.SnapshotJSON})
+}
+
+// DecodeRunArtifacts fills BuildRun.Artifacts from ArtifactsJSON.
+func DecodeRunArtifacts(run *model.BuildRun) {
+	if run == nil {
+		return
+	}
+	run.Artifacts = []model.RunArtifact{}
+	if strings.TrimSpace(run.ArtifactsJSON) == "" {
+		return
+	}
+	var items []model.RunArtifact
+	if err := json.Unmarshal([]byte(run.ArtifactsJSON), &items); err == nil && items != nil {
+		run.Artifacts = items
+	}
+}
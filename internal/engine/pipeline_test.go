@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
@@ -17,10 +18,13 @@ import (
 )
 
 type memRunStore struct {
-	mu       sync.Mutex
-	runs     map[uint]*model.BuildRun
-	attempts []model.BuildDeployAttempt
-	nextID   uint
+	mu        sync.Mutex
+	runs      map[uint]*model.BuildRun
+	attempts  []model.BuildDeployAttempt
+	approvals []model.BuildDeployApproval
+	deployed  []model.DeployTargetDeployment
+	suites    map[uint][]model.BuildTestSuite
+	nextID    uint
 }
 
 func newMemRunStore(runs ...*model.BuildRun) *memRunStore {
@@ -69,12 +73,42 @@ func applyRunFields(r *model.BuildRun, fields map[string]interface{}) {
 			r.LogPath = v.(string)
 		case "artifact_path":
 			r.ArtifactPath = v.(string)
+		case "artifacts_json":
+			r.ArtifactsJSON = v.(string)
+		case "artifact_object_id":
+			r.ArtifactObjectID = v.(*uint)
+		case "artifact_sha256":
+			r.ArtifactSHA256 = v.(string)
+		case "artifact_name":
+			r.ArtifactName = v.(string)
+		case "cache_status":
+			r.CacheStatus = v.(string)
+		case "cache_key":
+			r.CacheKey = v.(string)
+		case "cache_restored_key":
+			r.CacheRestoredKey = v.(string)
 		case "commit_hash":
 			r.CommitHash = v.(string)
 		case "trigger_type":
 			r.TriggerType = v.(string)
 		case "snapshot_json":
 			r.SnapshotJSON = v.(string)
+		case "matrix_summary":
+			r.MatrixSummary = v.(string)
+		case "test_summary_json":
+			r.TestSummaryJSON = v.(string)
+		case "coverage_json":
+			r.CoverageJSON = v.(string)
+		case "coverage_percent":
+			r.CoveragePercent = v.(*float64)
+		case "artifact_pinned":
+			r.ArtifactPinned = v.(bool)
+		case "artifact_removed_reason":
+			r.ArtifactRemovedReason = v.(string)
+		case "artifact_removed_at":
+			if t, ok := v.(time.Time); ok {
+				r.ArtifactRemovedAt = &t
+			}
 		case "duration_ms":
 			r.DurationMs = v.(int64)
 		case "finished_at":
@@ -114,6 +148,38 @@ func (m *memRunStore) UpdateAttempt(a *model.BuildDeployAttempt) error {
 	return os.ErrNotExist
 }
 
+func (m *memRunStore) CreateApproval(a *model.BuildDeployApproval) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a.ID = uint(len(m.approvals) + 1)
+	m.approvals = append(m.approvals, *a)
+	return nil
+}
+
+func (m *memRunStore) LatestApproval(runID uint) (*model.BuildDeployApproval, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.approvals) - 1; i >= 0; i-- {
+		if m.approvals[i].BuildRunID == runID {
+			cp := m.approvals[i]
+			return &cp, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func (m *memRunStore) MarkApprovalApplied(id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.approvals {
+		if m.approvals[i].ID == id {
+			now := time.Now()
+			m.approvals[i].AppliedAt = &now
+		}
+	}
+	return nil
+}
+
 func (m *memRunStore) NextBatchNo(runID uint) (int, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -168,7 +234,87 @@ func (m *memRunStore) HasNonTerminal(jobID uint) (bool, error) {
 }
 
 func (m *memRunStore) ListArtifactsByJob(jobID uint) ([]model.BuildRun, error) {
-	return nil, nil
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []model.BuildRun
+	for _, r := range m.runs {
+		if r.BuildJobID == jobID && (r.ArtifactPath != "" || r.ArtifactsJSON != "") {
+			out = append(out, *r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID > out[j].ID })
+	return out, nil
+}
+
+func (m *memRunStore) RecordDeployment(d *model.DeployTargetDeployment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.deployed {
+		if m.deployed[i].DeployTargetID == d.DeployTargetID {
+			m.deployed[i].Current = false
+		}
+	}
+	d.ID = uint(len(m.deployed) + 1)
+	d.Current = true
+	m.deployed = append(m.deployed, *d)
+	return nil
+}
+
+func (m *memRunStore) ListDeployedRunIDs(jobID uint) ([]uint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	latest := map[uint]model.BuildDeployAttempt{}
+	for _, a := range m.attempts {
+		if a.DeployTargetID == nil || a.Status != "success" {
+			continue
+		}
+		if prev, ok := latest[*a.DeployTargetID]; !ok || a.ID > prev.ID {
+			latest[*a.DeployTargetID] = a
+		}
+	}
+	var ids []uint
+	for _, a := range latest {
+		ids = append(ids, a.BuildRunID)
+	}
+	return ids, nil
+}
+
+func (m *memRunStore) LastBuiltCommit(jobID uint, branch string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var last *model.BuildRun
+	for _, r := range m.runs {
+		if r.BuildJobID == jobID && r.Branch == branch && r.CommitHash != "" && r.ParentRunID == nil &&
+			r.PRNumber == 0 && r.Tag == "" && (last == nil || r.ID > last.ID) {
+			last = r
+		}
+	}
+	if last == nil {
+		return "", nil
+	}
+	return last.CommitHash, nil
+}
+
+func (m *memRunStore) ListByParent(parentID uint) ([]model.BuildRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []model.BuildRun
+	for _, r := range m.runs {
+		if r.ParentRunID != nil && *r.ParentRunID == parentID {
+			out = append(out, *r)
+		}
+	}
+	return out, nil
+}
+
+func (m *memRunStore) ReplaceTestResults(runID uint, suites []model.BuildTestSuite) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.suites == nil {
+		m.suites = map[uint][]model.BuildTestSuite{}
+	}
+	m.suites[runID] = suites
+	return nil
 }
 
 type memJobStore struct {
@@ -187,6 +333,14 @@ func (m *memJobStore) ListDeployTargets(jobID uint) ([]model.DeployTarget, error
 	return append([]model.DeployTarget(nil), m.targets...), nil
 }
 func (m *memJobStore) ListCronEnabled() ([]model.BuildJob, error) { return nil, nil }
+func (m *memJobStore) ListPollEnabled() ([]model.BuildJob, error) { return nil, nil }
+func (m *memJobStore) ListAll() ([]model.BuildJob, error) {
+	if m.job == nil {
+		return nil, nil
+	}
+	return []model.BuildJob{*m.job}, nil
+}
+
 func (m *memJobStore) ListByRepositoryID(uint) ([]model.BuildJob, error) {
 	return nil, nil
 }
@@ -231,7 +385,7 @@ func TestDistributionFailureKeepsSuccess(t *testing.T) {
 		},
 	}
 	p := NewPipeline(store, jobStore, &memRepoStore{}, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(), tmp, tmp, tmp, tmp)
-	p.runDistributions(context.Background(), run, jobStore.job, src, func(string) {}, nil)
+	p.runDistributions(context.Background(), run, jobStore.job, src, nil, func(string) {}, nil)
 
 	got, _ := store.FindByID(1)
 	if got.Status != "success" {
@@ -265,7 +419,7 @@ func TestDistributionAllFailedKeepsSuccess(t *testing.T) {
 		},
 	}
 	p := NewPipeline(store, jobStore, &memRepoStore{}, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(), tmp, tmp, tmp, tmp)
-	p.runDistributions(context.Background(), run, jobStore.job, src, func(string) {}, nil)
+	p.runDistributions(context.Background(), run, jobStore.job, src, nil, func(string) {}, nil)
 
 	got, _ := store.FindByID(1)
 	if got.Status != "success" {
@@ -361,6 +515,89 @@ func TestArchiveMarksSuccessAndArtifactDownloadable(t *testing.T) {
 	}
 }
 
+// TestPullRequestRunChecksOutRefAndSkipsDistribution builds a PR ref that is not a
+// branch and leaves deploy targets untouched unless the job opts in.
+func TestPullRequestRunChecksOutRefAndSkipsDistribution(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repoDir := initLocalGitRepo(t)
+	gitIn(t, repoDir, "update-ref", "refs/pull/1/head", "HEAD")
+	tmp := t.TempDir()
+	dest := filepath.Join(tmp, "deployed")
+
+	run := &model.BuildRun{
+		ID: 1, BuildJobID: 10, BuildNumber: 1, Status: "queued", Stage: "pending",
+		Branch: "feature", GitRef: "refs/pull/1/head", PRNumber: 1, PRTargetBranch: "main",
+	}
+	store := newMemRunStore(run)
+	jobStore := &memJobStore{
+		job: &model.BuildJob{
+			ID: 10, RepositoryID: 1, Branch: "main",
+			BuildScript: "mkdir -p dist && echo pr > dist/app.txt",
+			OutputDir:   "dist", ArtifactFormat: "gzip", MaxArtifacts: 5,
+		},
+		targets: []model.DeployTarget{{ID: 1, BuildJobID: 10, Method: "local", RemotePath: dest}},
+	}
+	repoStore := &memRepoStore{repo: &resourcemodel.Repository{ID: 1, RepoURL: repoDir, AuthType: "none"}}
+	p := NewPipeline(store, jobStore, repoStore, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(),
+		filepath.Join(tmp, "ws"), filepath.Join(tmp, "artifacts"), filepath.Join(tmp, "logs"), filepath.Join(tmp, "cache"))
+
+	p.Execute(context.Background(), 1)
+
+	got, _ := store.FindByID(1)
+	if got.Status != "success" {
+		t.Fatalf("status=%s want success (error=%q)", got.Status, got.ErrorMessage)
+	}
+	if got.DistributionSummary != "none" || got.Stage != "idle" {
+		t.Fatalf("summary=%s stage=%s want none/idle", got.DistributionSummary, got.Stage)
+	}
+	if len(store.attempts) != 0 {
+		t.Fatalf("attempts=%d want 0", len(store.attempts))
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Fatalf("PR build was distributed: %v", err)
+	}
+}
+
+func TestTagRunExportsVersionAndPinsRelease(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repoDir := initLocalGitRepo(t)
+	gitIn(t, repoDir, "tag", "v1.4.0-rc.2")
+	tmp := t.TempDir()
+
+	run := &model.BuildRun{
+		ID: 1, BuildJobID: 10, BuildNumber: 1, Status: "queued", Stage: "pending",
+		GitRef: "refs/tags/v1.4.0-rc.2", Tag: "v1.4.0-rc.2", ReleaseVersion: "1.4.0-rc.2",
+	}
+	store := newMemRunStore(run)
+	jobStore := &memJobStore{job: &model.BuildJob{
+		ID: 10, RepositoryID: 1, Branch: "main",
+		BuildScript: `test "$GIT_TAG" = v1.4.0-rc.2 && test "$RELEASE_VERSION_MINOR" = 4 && ` +
+			`test "$RELEASE_VERSION_PRERELEASE" = rc.2 && mkdir -p dist && echo "$RELEASE_VERSION" > dist/version`,
+		OutputDir: "dist", ArtifactFormat: "gzip", MaxArtifacts: 5,
+	}}
+	repoStore := &memRepoStore{repo: &resourcemodel.Repository{ID: 1, RepoURL: repoDir, AuthType: "none"}}
+	p := NewPipeline(store, jobStore, repoStore, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(),
+		filepath.Join(tmp, "ws"), filepath.Join(tmp, "artifacts"), filepath.Join(tmp, "logs"), filepath.Join(tmp, "cache"))
+
+	p.Execute(context.Background(), 1)
+
+	got, _ := store.FindByID(1)
+	if got.Status != "success" {
+		t.Fatalf("status=%s want success (error=%q)", got.Status, got.ErrorMessage)
+	}
+	if !got.ArtifactPinned {
+		t.Fatal("release artifacts not pinned")
+	}
+}
+
 func TestMarkArtifactSuccess_WithDistributeStage(t *testing.T) {
 	t.Parallel()
 	tmp := t.TempDir()
@@ -425,11 +662,11 @@ func TestRedeployAppendsAttempts(t *testing.T) {
 	}
 	p := NewPipeline(store, jobStore, &memRepoStore{}, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(), tmp, tmp, tmp, tmp)
 
-	p.runDistributions(context.Background(), run, jobStore.job, src, func(string) {}, nil)
+	p.runDistributions(context.Background(), run, jobStore.job, src, nil, func(string) {}, nil)
 	if len(store.attempts) != 1 || store.attempts[0].BatchNo != 1 {
 		t.Fatalf("batch1 attempts=%v", store.attempts)
 	}
-	p.runDistributions(context.Background(), run, jobStore.job, src, func(string) {}, nil)
+	p.runDistributions(context.Background(), run, jobStore.job, src, nil, func(string) {}, nil)
 	if len(store.attempts) != 2 {
 		t.Fatalf("after redeploy attempts=%d want 2", len(store.attempts))
 	}
@@ -0,0 +1,296 @@
+package engine
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+	resourcemodel "bedrock/internal/resource/model"
+)
+
+// Polling trigger bounds. A repository is polled at most once per
+// MinPollInterval however many jobs poll it.
+const (
+	MinPollInterval = 60 * time.Second
+	MaxPollInterval = 24 * time.Hour
+
+	pollTick       = 15 * time.Second
+	pollTimeout    = 60 * time.Second
+	pollMaxBackoff = 30 * time.Minute
+)
+
+// BranchCache keeps a repository's branch list (RepositoryService.StoreBranches);
+// every successful poll refreshes it.
+type BranchCache interface {
+	StoreBranches(repositoryID uint, branches []string) error
+}
+
+// HeadLister lists a repository's branch heads (GitListHeads; tests stub it).
+type HeadLister func(ctx context.Context, repoURL string, auth GitAuth) ([]GitHead, error)
+
+// repoPoll is the schedule of one polled repository.
+type repoPoll struct {
+	next     time.Time
+	failures int
+}
+
+// PollScheduler implements BuildJob.TriggerPoll: it runs git ls-remote once
+// per repository for all its polling jobs and enqueues a "poll" run for each
+// matching branch whose head differs from the job's last built commit.
+//
+// The first poll after a repository is due is spread over its interval
+// (jitter) and later polls add up to a tenth of it; failures back off
+// exponentially up to pollMaxBackoff. A branch the job has never built is
+// only remembered on the job's first poll of the process (no build storm
+// when polling is switched on); afterwards new branches build.
+type PollScheduler struct {
+	jobs     JobStore
+	repos    RepoStore
+	runs     RunStore
+	enqueuer RunEnqueuer
+	secrets  SecretResolver
+	logger   *zap.Logger
+	clock    Clock
+	branches BranchCache
+	list     HeadLister
+
+	mu    sync.Mutex
+	state map[uint]*repoPoll
+	// seen holds the head each job last handled per branch (built or baseline).
+	seen map[uint]map[string]string
+	stop chan struct{}
+	once sync.Once
+}
+
+func NewPollScheduler(
+	jobs JobStore,
+	repos RepoStore,
+	runs RunStore,
+	enqueuer RunEnqueuer,
+	secrets SecretResolver,
+	logger *zap.Logger,
+) *PollScheduler {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &PollScheduler{
+		jobs:     jobs,
+		repos:    repos,
+		runs:     runs,
+		enqueuer: enqueuer,
+		secrets:  secrets,
+		logger:   logger,
+		clock:    realClock{},
+		list:     GitListHeads,
+		state:    make(map[uint]*repoPoll),
+		seen:     make(map[uint]map[string]string),
+		stop:     make(chan struct{}),
+	}
+}
+
+// SetClock injects a clock (tests).
+func (ps *PollScheduler) SetClock(c Clock) {
+	if c != nil {
+		ps.clock = c
+	}
+}
+
+// SetBranchCache refreshes the repositories' branch cache from each poll.
+func (ps *PollScheduler) SetBranchCache(c BranchCache) { ps.branches = c }
+
+// SetHeadLister replaces git ls-remote (tests).
+func (ps *PollScheduler) SetHeadLister(fn HeadLister) {
+	if fn != nil {
+		ps.list = fn
+	}
+}
+
+func (ps *PollScheduler) Start() {
+	go func() {
+		t := time.NewTicker(pollTick)
+		defer t.Stop()
+		for {
+			ps.poll(false)
+			select {
+			case <-ps.stop:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+func (ps *PollScheduler) Stop() { ps.once.Do(func() { close(ps.stop) }) }
+
+// PollNow is for tests: poll every repository immediately, due or not.
+func (ps *PollScheduler) PollNow() {
+	ps.poll(true)
+}
+
+func (ps *PollScheduler) poll(force bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	jobs, err := ps.jobs.ListPollEnabled()
+	if err != nil {
+		ps.logger.Error("load poll jobs failed", zap.Error(err))
+		return
+	}
+	byRepo := make(map[uint][]model.BuildJob)
+	polling := make(map[uint]bool, len(jobs))
+	for _, job := range jobs {
+		byRepo[job.RepositoryID] = append(byRepo[job.RepositoryID], job)
+		polling[job.ID] = true
+	}
+	for jobID := range ps.seen {
+		if !polling[jobID] {
+			delete(ps.seen, jobID)
+		}
+	}
+	for repoID := range ps.state {
+		if byRepo[repoID] == nil {
+			delete(ps.state, repoID)
+		}
+	}
+
+	now := ps.clock.Now()
+	for repoID, repoJobs := range byRepo {
+		interval := pollInterval(repoJobs)
+		st := ps.state[repoID]
+		if st == nil {
+			st = &repoPoll{next: now.Add(jitter(interval))}
+			ps.state[repoID] = st
+		}
+		if !force && now.Before(st.next) {
+			continue
+		}
+		delay := interval
+		if err := ps.pollRepository(repoID, repoJobs); err != nil {
+			st.failures++
+			delay = backoff(interval, st.failures)
+			ps.logger.Warn("repository poll failed",
+				zap.Uint("repository_id", repoID), zap.Int("failures", st.failures),
+				zap.Duration("retry_in", delay), zap.Error(err))
+		} else {
+			st.failures = 0
+		}
+		st.next = now.Add(delay + jitter(delay/10))
+	}
+}
+
+// pollRepository lists the repository's heads once and checks every job.
+func (ps *PollScheduler) pollRepository(repoID uint, jobs []model.BuildJob) error {
+	repo, err := ps.repos.FindByID(repoID)
+	if err != nil {
+		return err
+	}
+	auth, err := repoGitAuth(repo, ps.secrets)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pollTimeout)
+	defer cancel()
+	heads, err := ps.list(ctx, repo.RepoURL, auth)
+	if err != nil {
+		return err
+	}
+	if ps.branches != nil {
+		names := make([]string, 0, len(heads))
+		for _, h := range heads {
+			names = append(names, h.Branch)
+		}
+		if err := ps.branches.StoreBranches(repoID, names); err != nil {
+			ps.logger.Warn("branch cache refresh failed", zap.Uint("repository_id", repoID), zap.Error(err))
+		}
+	}
+	for i := range jobs {
+		ps.pollJob(ctx, &jobs[i], repo, auth, heads)
+	}
+	return nil
+}
+
+func (ps *PollScheduler) pollJob(ctx context.Context, job *model.BuildJob, repo *resourcemodel.Repository, auth GitAuth, heads []GitHead) {
+	seen, known := ps.seen[job.ID]
+	if !known {
+		seen = make(map[string]string)
+		ps.seen[job.ID] = seen
+	}
+	patterns := ParseFilterPatterns(job.BranchPatterns)
+	includes := ParseFilterPatterns(job.PathIncludes)
+	excludes := ParseFilterPatterns(job.PathExcludes)
+	for _, h := range heads {
+		matched := h.Branch == job.Branch
+		if len(patterns) > 0 {
+			matched = MatchBranch(patterns, h.Branch)
+		}
+		if !matched {
+			continue
+		}
+		if seen[h.Branch] == h.Commit {
+			continue
+		}
+		last, err := ps.runs.LastBuiltCommit(job.ID, h.Branch)
+		if err != nil {
+			ps.logger.Warn("poll: last built commit lookup failed", zap.Uint("job_id", job.ID), zap.Error(err))
+			continue
+		}
+		if last == h.Commit || (last == "" && !known) {
+			seen[h.Branch] = h.Commit
+			continue
+		}
+		if last != "" && (len(includes) > 0 || len(excludes) > 0) {
+			// Fail open like webhooks: an unknown diff builds.
+			files, err := GitChangedFiles(ctx, repo.RepoURL, auth, last, h.Commit)
+			if err == nil && !MatchChangedPaths(includes, excludes, files) {
+				seen[h.Branch] = h.Commit
+				continue
+			}
+		}
+		run, err := ps.enqueuer.EnqueueInternal(job.ID, 0, EnqueueParams{
+			Branch:      h.Branch,
+			TriggerType: "poll",
+			CommitHash:  h.Commit,
+		})
+		if err != nil {
+			// Not remembered: the next poll retries.
+			ps.logger.Error("poll enqueue failed", zap.Uint("job_id", job.ID), zap.String("branch", h.Branch), zap.Error(err))
+			continue
+		}
+		seen[h.Branch] = h.Commit
+		ps.logger.Info("poll triggered", zap.Uint("job_id", job.ID), zap.Uint("run_id", run.ID),
+			zap.String("branch", h.Branch), zap.String("commit", h.Commit))
+	}
+}
+
+// pollInterval is the smallest interval of a repository's polling jobs,
+// clamped to [MinPollInterval, MaxPollInterval].
+func pollInterval(jobs []model.BuildJob) time.Duration {
+	interval := MaxPollInterval
+	for _, job := range jobs {
+		d := time.Duration(job.PollIntervalSeconds) * time.Second
+		if d > 0 && d < interval {
+			interval = d
+		}
+	}
+	return max(interval, MinPollInterval)
+}
+
+// backoff doubles interval per consecutive failure, up to pollMaxBackoff
+// (never below interval itself).
+func backoff(interval time.Duration, failures int) time.Duration {
+	d := interval
+	for i := 0; i < failures && d < pollMaxBackoff; i++ {
+		d *= 2
+	}
+	return max(min(d, pollMaxBackoff), interval)
+}
+
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d)
+}
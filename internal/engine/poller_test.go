@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+	resourcemodel "bedrock/internal/resource/model"
+)
+
+type pollJobStore struct {
+	memJobStore
+	jobs []model.BuildJob
+}
+
+func (m *pollJobStore) ListPollEnabled() ([]model.BuildJob, error) { return m.jobs, nil }
+
+type stepClock struct{ now time.Time }
+
+func (c *stepClock) Now() time.Time { return c.now }
+
+type branchCacheRecorder map[uint][]string
+
+func (r branchCacheRecorder) StoreBranches(id uint, branches []string) error {
+	r[id] = branches
+	return nil
+}
+
+func TestPollSchedulerSharesRepositoryPoll(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	src := t.TempDir()
+	gitIn(t, src, "init", "-q", "-b", "main")
+	built := commitFile(t, src, "main.go", "package main")
+	gitIn(t, src, "branch", "feature/old")
+
+	jobs := &pollJobStore{jobs: []model.BuildJob{
+		{ID: 1, RepositoryID: 7, Enabled: true, Branch: "main", TriggerPoll: true, PollIntervalSeconds: 300},
+		{ID: 2, RepositoryID: 7, Enabled: true, BranchPatterns: "feature/**", TriggerPoll: true, PollIntervalSeconds: 120},
+	}}
+	runs := newMemRunStore(&model.BuildRun{ID: 1, BuildJobID: 1, Branch: "main", CommitHash: built, Status: "success"})
+	runs.nextID = 2
+	var enqueued []EnqueueParams
+	enq := &stubEnqueuer{fn: func(jobID, _ uint, in EnqueueParams) (*model.BuildRun, error) {
+		enqueued = append(enqueued, in)
+		run := &model.BuildRun{ID: runs.nextID, BuildJobID: jobID, Branch: in.Branch, CommitHash: in.CommitHash,
+			TriggerType: in.TriggerType, Status: "queued"}
+		runs.runs[run.ID] = run
+		runs.nextID++
+		return run, nil
+	}}
+	repos := &memRepoStore{repo: &resourcemodel.Repository{ID: 7, RepoURL: src}}
+	ps := NewPollScheduler(jobs, repos, runs, enq, nopSecrets{}, zap.NewNop())
+	lists := 0
+	ps.SetHeadLister(func(ctx context.Context, repoURL string, auth GitAuth) ([]GitHead, error) {
+		lists++
+		return GitListHeads(ctx, repoURL, auth)
+	})
+	cache := branchCacheRecorder{}
+	ps.SetBranchCache(cache)
+
+	// main is already built; feature/old was never built and becomes a baseline.
+	ps.PollNow()
+	if lists != 1 || len(enqueued) != 0 {
+		t.Fatalf("first poll: lists=%d enqueued=%+v", lists, enqueued)
+	}
+	if len(cache[7]) != 2 {
+		t.Fatalf("branch cache=%v", cache[7])
+	}
+
+	head := commitFile(t, src, "api.go", "package main")
+	gitIn(t, src, "branch", "feature/new")
+	ps.PollNow()
+	if lists != 2 || len(enqueued) != 2 {
+		t.Fatalf("second poll: lists=%d enqueued=%+v", lists, enqueued)
+	}
+	for _, in := range enqueued {
+		if in.TriggerType != "poll" || in.CommitHash != head || (in.Branch != "main" && in.Branch != "feature/new") {
+			t.Fatalf("enqueued %+v", in)
+		}
+	}
+
+	ps.PollNow()
+	if lists != 3 || len(enqueued) != 2 {
+		t.Fatalf("unchanged heads built again: %+v", enqueued)
+	}
+}
+
+func TestPollSchedulerSchedule(t *testing.T) {
+	t.Parallel()
+	jobs := &pollJobStore{jobs: []model.BuildJob{
+		{ID: 1, RepositoryID: 7, Enabled: true, Branch: "main", TriggerPoll: true, PollIntervalSeconds: 10},
+	}}
+	repos := &memRepoStore{repo: &resourcemodel.Repository{ID: 7, RepoURL: "https://example.com/x.git"}}
+	ps := NewPollScheduler(jobs, repos, newMemRunStore(), &stubEnqueuer{}, nopSecrets{}, zap.NewNop())
+	clock := &stepClock{now: time.Unix(1_700_000_000, 0)}
+	ps.SetClock(clock)
+	lists := 0
+	ps.SetHeadLister(func(context.Context, string, GitAuth) ([]GitHead, error) {
+		lists++
+		return nil, nil
+	})
+
+	ps.poll(false)
+	if lists != 0 {
+		t.Fatalf("polled before the jittered start: %d", lists)
+	}
+	// The 10s interval is raised to MinPollInterval.
+	clock.now = clock.now.Add(MinPollInterval)
+	ps.poll(false)
+	clock.now = clock.now.Add(MinPollInterval / 2)
+	ps.poll(false)
+	if lists != 1 {
+		t.Fatalf("lists=%d want 1", lists)
+	}
+
+	if got := backoff(MinPollInterval, 1); got != 2*MinPollInterval {
+		t.Fatalf("backoff(1)=%v", got)
+	}
+	if got := backoff(MinPollInterval, 20); got != pollMaxBackoff {
+		t.Fatalf("backoff(20)=%v", got)
+	}
+}
@@ -0,0 +1,226 @@
+package engine
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Tag patterns (BuildJob.TagPatterns, one per line) select the tags a job
+// builds on push:
+//
+//	v*.*.*                 glob (path.Match; * does not cross "/")
+//	semver                 any semantic version, with or without a leading v
+//	semver:>=1.2.0 <2.0.0  semantic versions satisfying every comparison
+//
+// A semver constraint never matches pre-releases unless one of its
+// comparisons names a pre-release (semver:>=2.0.0-rc.1).
+
+var semverPattern = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+[0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*)?$`)
+
+// Semver is a parsed semantic version (build metadata is dropped).
+type Semver struct {
+	Major, Minor, Patch int
+	Prerelease          string
+}
+
+// ParseSemver parses "1.2.3", "v1.2.3-rc.1" or "1.2.3+build.5".
+func ParseSemver(s string) (Semver, bool) {
+	m := semverPattern.FindStringSubmatch(s)
+	if m == nil {
+		return Semver{}, false
+	}
+	var v Semver
+	var err error
+	if v.Major, err = strconv.Atoi(m[1]); err != nil {
+		return Semver{}, false
+	}
+	if v.Minor, err = strconv.Atoi(m[2]); err != nil {
+		return Semver{}, false
+	}
+	if v.Patch, err = strconv.Atoi(m[3]); err != nil {
+		return Semver{}, false
+	}
+	v.Prerelease = m[4]
+	return v, true
+}
+
+func (v Semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// Compare orders versions by semver precedence (-1, 0, 1).
+func (v Semver) Compare(o Semver) int {
+	if c := cmp.Or(cmp.Compare(v.Major, o.Major), cmp.Compare(v.Minor, o.Minor), cmp.Compare(v.Patch, o.Patch)); c != 0 {
+		return c
+	}
+	switch {
+	case v.Prerelease == o.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case o.Prerelease == "":
+		return -1
+	}
+	a, b := strings.Split(v.Prerelease, "."), strings.Split(o.Prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := comparePrereleaseIdent(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func comparePrereleaseIdent(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return cmp.Compare(na, nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// ReleaseVersion is the version label of a tag build: the semantic version
+// without its leading v, or the tag name itself when it is not one.
+func ReleaseVersion(tag string) string {
+	if v, ok := ParseSemver(tag); ok {
+		return v.String()
+	}
+	return tag
+}
+
+// ReleaseEnv exports a tag run's tag and version to the build script:
+// GIT_TAG, RELEASE_VERSION and, for semantic versions, RELEASE_VERSION_MAJOR /
+// _MINOR / _PATCH / _PRERELEASE.
+func ReleaseEnv(tag, version string) []string {
+	if tag == "" {
+		return nil
+	}
+	env := []string{"GIT_TAG=" + tag, "RELEASE_VERSION=" + version}
+	if v, ok := ParseSemver(version); ok {
+		env = append(env,
+			"RELEASE_VERSION_MAJOR="+strconv.Itoa(v.Major),
+			"RELEASE_VERSION_MINOR="+strconv.Itoa(v.Minor),
+			"RELEASE_VERSION_PATCH="+strconv.Itoa(v.Patch),
+			"RELEASE_VERSION_PRERELEASE="+v.Prerelease,
+		)
+	}
+	return env
+}
+
+// ParseTagPatterns splits a stored tag pattern list (one per line or JSON array).
+func ParseTagPatterns(raw string) []string {
+	return parseCachePaths(raw)
+}
+
+// ValidateTagPatterns checks glob syntax and semver constraints.
+func ValidateTagPatterns(patterns []string) error {
+	for _, p := range patterns {
+		if c, ok := semverConstraintOf(p); ok {
+			if _, err := parseSemverConstraint(c); err != nil {
+				return fmt.Errorf("%q: %w", p, err)
+			}
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("%q: bad glob pattern", p)
+		}
+	}
+	return nil
+}
+
+// MatchTag reports whether tag matches one of the patterns.
+func MatchTag(patterns []string, tag string) bool {
+	for _, p := range patterns {
+		if c, ok := semverConstraintOf(p); ok {
+			v, isSemver := ParseSemver(tag)
+			if !isSemver {
+				continue
+			}
+			cmps, err := parseSemverConstraint(c)
+			if err == nil && satisfies(v, cmps) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(p, tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// semverConstraintOf splits "semver" / "semver:<constraint>" patterns.
+func semverConstraintOf(pattern string) (string, bool) {
+	if pattern == "semver" {
+		return "", true
+	}
+	if c, ok := strings.CutPrefix(pattern, "semver:"); ok {
+		return strings.TrimSpace(c), true
+	}
+	return "", false
+}
+
+type semverComparison struct {
+	op string
+	v  Semver
+}
+
+func parseSemverConstraint(c string) ([]semverComparison, error) {
+	var out []semverComparison
+	for _, field := range strings.Fields(c) {
+		op := "="
+		for _, candidate := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(field, candidate) {
+				op, field = candidate, field[len(candidate):]
+				break
+			}
+		}
+		v, ok := ParseSemver(field)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a semantic version", field)
+		}
+		out = append(out, semverComparison{op: op, v: v})
+	}
+	return out, nil
+}
+
+func satisfies(v Semver, cmps []semverComparison) bool {
+	allowPre := v.Prerelease == ""
+	for _, c := range cmps {
+		d := v.Compare(c.v)
+		var ok bool
+		switch c.op {
+		case ">=":
+			ok = d >= 0
+		case "<=":
+			ok = d <= 0
+		case ">":
+			ok = d > 0
+		case "<":
+			ok = d < 0
+		default:
+			ok = d == 0
+		}
+		if !ok {
+			return false
+		}
+		if c.v.Prerelease != "" {
+			allowPre = true
+		}
+	}
+	// Bare "semver" accepts pre-releases; constraints only when they name one.
+	return allowPre || len(cmps) == 0
+}
@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseSemverAndCompare(t *testing.T) {
+	t.Parallel()
+	v, ok := ParseSemver("v1.2.3-rc.1+build.7")
+	if !ok || v != (Semver{Major: 1, Minor: 2, Patch: 3, Prerelease: "rc.1"}) || v.String() != "1.2.3-rc.1" {
+		t.Fatalf("parsed %+v ok=%v", v, ok)
+	}
+	for _, bad := range []string{"1.2", "v01.2.3", "1.2.3-", "release-1"} {
+		if _, ok := ParseSemver(bad); ok {
+			t.Errorf("%s parsed as semver", bad)
+		}
+	}
+	// Ascending precedence per semver.org §11.
+	order := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.10.0"}
+	for i := 1; i < len(order); i++ {
+		a, _ := ParseSemver(order[i-1])
+		b, _ := ParseSemver(order[i])
+		if a.Compare(b) != -1 || b.Compare(a) != 1 {
+			t.Errorf("%s should sort before %s", order[i-1], order[i])
+		}
+	}
+}
+
+func TestMatchTag(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		patterns []string
+		tag      string
+		want     bool
+	}{
+		{[]string{"v*.*.*"}, "v1.2.3", true},
+		{[]string{"v*.*.*"}, "v1.2", false},
+		{[]string{"release/*"}, "release/2024-10", true},
+		{[]string{"semver"}, "2.0.0-rc.1", true},
+		{[]string{"semver"}, "nightly", false},
+		{[]string{"semver:>=1.2.0 <2.0.0"}, "v1.9.9", true},
+		{[]string{"semver:>=1.2.0 <2.0.0"}, "v2.0.0", false},
+		{[]string{"semver:>=1.2.0 <2.0.0"}, "v1.5.0-beta.1", false},
+		{[]string{"semver:>=1.5.0-beta.0"}, "v1.5.0-beta.1", true},
+		{[]string{"nightly-*", "semver:1.0.0"}, "v1.0.0", true},
+	}
+	for _, c := range cases {
+		if got := MatchTag(c.patterns, c.tag); got != c.want {
+			t.Errorf("MatchTag(%q, %q)=%v want %v", c.patterns, c.tag, got, c.want)
+		}
+	}
+	if err := ValidateTagPatterns([]string{"v[0-9"}); err == nil {
+		t.Error("expected bad glob error")
+	}
+	if err := ValidateTagPatterns([]string{"semver:>=one"}); err == nil {
+		t.Error("expected bad constraint error")
+	}
+}
+
+func TestReleaseEnv(t *testing.T) {
+	t.Parallel()
+	if got := ReleaseVersion("v3.1.0"); got != "3.1.0" {
+		t.Fatalf("ReleaseVersion=%s", got)
+	}
+	if got := ReleaseVersion("nightly-42"); got != "nightly-42" {
+		t.Fatalf("ReleaseVersion=%s", got)
+	}
+	env := ReleaseEnv("v3.1.0", "3.1.0")
+	want := []string{"GIT_TAG=v3.1.0", "RELEASE_VERSION=3.1.0", "RELEASE_VERSION_MAJOR=3",
+		"RELEASE_VERSION_MINOR=1", "RELEASE_VERSION_PATCH=0", "RELEASE_VERSION_PRERELEASE="}
+	if !slices.Equal(env, want) {
+		t.Fatalf("env=%q", env)
+	}
+	if env := ReleaseEnv("", ""); env != nil {
+		t.Fatalf("non-tag run env=%q", env)
+	}
+}
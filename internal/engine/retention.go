@@ -0,0 +1,240 @@
+package engine
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+)
+
+// Reasons recorded on BuildRun.ArtifactRemovedReason.
+const (
+	RetentionCount = "count"
+	RetentionAge   = "age"
+	RetentionSize  = "size"
+)
+
+// defaultMaxArtifacts applies when BuildJob.MaxArtifacts is unset.
+const defaultMaxArtifacts = 5
+
+// ArtifactRemoval is one run whose artifacts a retention pass removes.
+type ArtifactRemoval struct {
+	RunID       uint      `json:"run_id"`
+	BuildNumber int       `json:"build_number"`
+	Reason      string    `json:"reason"`
+	Bytes       int64     `json:"bytes"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// RetentionPlan is the outcome of applying a job's retention policy.
+type RetentionPlan struct {
+	BuildJobID uint              `json:"build_job_id"`
+	DryRun     bool              `json:"dry_run"`
+	Remove     []ArtifactRemoval `json:"remove"`
+	// Protected counts pinned, currently deployed and in-use runs.
+	Protected  int   `json:"protected"`
+	Kept       int   `json:"kept"`
+	KeptBytes  int64 `json:"kept_bytes"`
+	FreedBytes int64 `json:"freed_bytes"`
+}
+
+// ApplyArtifactRetention evaluates job's policy over its runs with artifacts
+// (newest first) and, unless dryRun, deletes the files and records the reason.
+//
+// Pinned runs, runs whose artifact is the current deployment of any
+// DeployTarget, and runs still building, distributing or waiting for
+// deployment approval are never removed; their size counts toward the total.
+// Only in-use runs (usually the build that triggered this pass) take one of
+// the MaxArtifacts slots.
+// Stored artifacts release their store reference; the bytes go once no other
+// run shares them.
+func ApplyArtifactRetention(runs RunStore, store ArtifactStore, job *model.BuildJob, now time.Time, dryRun bool) (*RetentionPlan, error) {
+	items, err := runs.ListArtifactsByJob(job.ID)
+	if err != nil {
+		return nil, err
+	}
+	deployedIDs, err := runs.ListDeployedRunIDs(job.ID)
+	if err != nil {
+		return nil, err
+	}
+	deployed := make(map[uint]bool, len(deployedIDs))
+	for _, id := range deployedIDs {
+		deployed[id] = true
+	}
+
+	plan := &RetentionPlan{BuildJobID: job.ID, DryRun: dryRun, Remove: []ArtifactRemoval{}}
+	sizes := make([]int64, len(items))
+	protected := make([]bool, len(items))
+	kept := 0
+	for i := range items {
+		DecodeRunArtifacts(&items[i])
+		sizes[i] = runArtifactBytes(&items[i])
+		inUse := artifactInUse(&items[i])
+		if items[i].ArtifactPinned || deployed[items[i].ID] || inUse {
+			protected[i] = true
+			plan.Protected++
+			plan.KeptBytes += sizes[i]
+			if inUse {
+				kept++
+			}
+		}
+	}
+	maxCount := job.MaxArtifacts
+	if maxCount <= 0 {
+		maxCount = defaultMaxArtifacts
+	}
+	maxAge := time.Duration(job.ArtifactMaxAgeDays) * 24 * time.Hour
+	maxBytes := int64(job.ArtifactMaxTotalMB) << 20
+
+	for i := range items {
+		if protected[i] {
+			continue
+		}
+		r := &items[i]
+		reason := ""
+		switch {
+		case kept >= maxCount:
+			reason = RetentionCount
+		case maxAge > 0 && now.Sub(r.CreatedAt) > maxAge:
+			reason = RetentionAge
+		case maxBytes > 0 && plan.KeptBytes+sizes[i] > maxBytes:
+			reason = RetentionSize
+		}
+		if reason == "" {
+			kept++
+			plan.Kept++
+			plan.KeptBytes += sizes[i]
+			continue
+		}
+		plan.Remove = append(plan.Remove, ArtifactRemoval{
+			RunID: r.ID, BuildNumber: r.BuildNumber, Reason: reason, Bytes: sizes[i], CreatedAt: r.CreatedAt,
+		})
+		plan.FreedBytes += sizes[i]
+		if !dryRun {
+			removeRunArtifacts(runs, store, r, reason, now)
+		}
+	}
+	plan.Kept += plan.Protected
+	return plan, nil
+}
+
+// artifactInUse reports runs that may still read or write their artifacts,
+// including runs parked at the approval gate (deployed once approved).
+func artifactInUse(r *model.BuildRun) bool {
+	return r.Status == "queued" || r.Status == "running" || r.DistributionSummary == "running" ||
+		r.Stage == StageWaitingApproval
+}
+
+func runArtifactBytes(r *model.BuildRun) int64 {
+	var n int64
+	if r.ArtifactPath != "" {
+		if info, err := os.Stat(r.ArtifactPath); err == nil {
+			n += info.Size()
+		}
+	}
+	for _, a := range r.Artifacts {
+		if info, err := os.Stat(a.Path); err == nil {
+			n += info.Size()
+		}
+	}
+	return n
+}
+
+func removeRunArtifacts(runs RunStore, store ArtifactStore, r *model.BuildRun, reason string, now time.Time) {
+	if r.ArtifactPath != "" || r.ArtifactObjectID != nil {
+		releaseArtifact(store, runObjectID(r), r.ArtifactPath)
+	}
+	for _, a := range r.Artifacts {
+		releaseArtifact(store, a.ObjectID, a.Path)
+	}
+	_ = runs.UpdateFields(r.ID, map[string]interface{}{
+		"artifact_path":           "",
+		"artifacts_json":          "",
+		"artifact_object_id":      (*uint)(nil),
+		"artifact_removed_at":     now,
+		"artifact_removed_reason": reason,
+	})
+}
+
+// ArtifactSweeper applies every job's retention policy periodically, so
+// age/size limits take effect even for jobs that no longer build.
+type ArtifactSweeper struct {
+	runs   RunStore
+	jobs   JobStore
+	store  ArtifactStore
+	every  time.Duration
+	logger *zap.Logger
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func NewArtifactSweeper(runs RunStore, jobs JobStore, store ArtifactStore, every time.Duration, logger *zap.Logger) *ArtifactSweeper {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &ArtifactSweeper{runs: runs, jobs: jobs, store: store, every: every, logger: logger, stop: make(chan struct{})}
+}
+
+func (s *ArtifactSweeper) Start() {
+	if s.every <= 0 {
+		return
+	}
+	go func() {
+		t := time.NewTicker(s.every)
+		defer t.Stop()
+		for {
+			s.SweepAll(time.Now())
+			select {
+			case <-s.stop:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+func (s *ArtifactSweeper) Stop() { s.once.Do(func() { close(s.stop) }) }
+
+// SweepAll applies retention to every job; one failing job does not stop the
+// pass. Afterwards, store objects no run references any more are purged.
+func (s *ArtifactSweeper) SweepAll(now time.Time) {
+	jobs, err := s.jobs.ListAll()
+	if err != nil {
+		s.logger.Warn("artifact sweep: list jobs failed", zap.Error(err))
+		return
+	}
+	for i := range jobs {
+		plan, err := ApplyArtifactRetention(s.runs, s.store, &jobs[i], now, false)
+		if err != nil {
+			s.logger.Warn("artifact sweep failed", zap.Uint("job_id", jobs[i].ID), zap.Error(err))
+			continue
+		}
+		logRetention(s.logger, plan)
+	}
+	if s.store == nil {
+		return
+	}
+	if n, err := s.store.PurgeExpired(now); err != nil {
+		s.logger.Warn("artifact sweep: purge storage failed", zap.Error(err))
+	} else if n > 0 {
+		s.logger.Info("purged released storage objects", zap.Int("count", n))
+	}
+}
+
+func logRetention(logger *zap.Logger, plan *RetentionPlan) {
+	if logger == nil {
+		return
+	}
+	for _, r := range plan.Remove {
+		logger.Info("artifact removed by retention",
+			zap.Uint("job_id", plan.BuildJobID),
+			zap.Uint("run_id", r.RunID),
+			zap.Int("build_number", r.BuildNumber),
+			zap.String("reason", r.Reason),
+			zap.Int64("bytes", r.Bytes),
+		)
+	}
+}
@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+)
+
+func writeArtifact(t *testing.T, dir string, id uint, size int) string {
+	t.Helper()
+	p := filepath.Join(dir, fmt.Sprintf("run-%d.tar.gz", id))
+	if err := os.WriteFile(p, make([]byte, size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestApplyArtifactRetention_countSkipsPinnedAndDeployed(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	now := time.Now()
+	var runs []*model.BuildRun
+	for id := uint(1); id <= 6; id++ {
+		runs = append(runs, &model.BuildRun{
+			ID: id, BuildJobID: 10, BuildNumber: int(id), Status: "success",
+			ArtifactPath: writeArtifact(t, dir, id, 10),
+			CreatedAt:    now.Add(-time.Duration(7-id) * time.Hour),
+		})
+	}
+	runs[4].ArtifactPinned = true // #5
+	store := newMemRunStore(runs...)
+	target := uint(1)
+	store.attempts = []model.BuildDeployAttempt{
+		{ID: 1, BuildRunID: 1, DeployTargetID: &target, Status: "success"},
+		{ID: 2, BuildRunID: 2, DeployTargetID: &target, Status: "success"},
+		{ID: 3, BuildRunID: 3, DeployTargetID: &target, Status: "failed"},
+	}
+	job := &model.BuildJob{ID: 10, MaxArtifacts: 2}
+
+	plan, err := ApplyArtifactRetention(store, nil, job, now, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Protected: #5 pinned, #2 currently deployed. Kept by count: #6, #4.
+	if len(plan.Remove) != 2 || plan.Remove[0].RunID != 3 || plan.Remove[1].RunID != 1 {
+		t.Fatalf("remove=%+v", plan.Remove)
+	}
+	if plan.Protected != 2 || plan.Kept != 4 || plan.FreedBytes != 20 || plan.KeptBytes != 40 {
+		t.Fatalf("plan=%+v", plan)
+	}
+	if r, _ := store.FindByID(3); r.ArtifactPath == "" || r.ArtifactRemovedAt != nil {
+		t.Fatal("dry run must not touch runs")
+	}
+	if _, err := os.Stat(runs[2].ArtifactPath); err != nil {
+		t.Fatal("dry run must not delete files")
+	}
+
+	if _, err := ApplyArtifactRetention(store, nil, job, now, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []uint{1, 3} {
+		r, _ := store.FindByID(id)
+		if r.ArtifactPath != "" || r.ArtifactRemovedAt == nil || r.ArtifactRemovedReason != RetentionCount {
+			t.Fatalf("run %d: path=%q removed=%v reason=%q", id, r.ArtifactPath, r.ArtifactRemovedAt, r.ArtifactRemovedReason)
+		}
+		if _, err := os.Stat(runs[id-1].ArtifactPath); !os.IsNotExist(err) {
+			t.Fatalf("run %d artifact still on disk", id)
+		}
+	}
+	for _, id := range []uint{2, 4, 5, 6} {
+		if r, _ := store.FindByID(id); r.ArtifactPath == "" {
+			t.Fatalf("run %d artifact removed", id)
+		}
+	}
+}
+
+func TestApplyArtifactRetention_keepsRunWaitingForApproval(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	now := time.Now()
+	parked := &model.BuildRun{
+		ID: 1, BuildJobID: 10, BuildNumber: 1, Status: "success",
+		Stage: StageWaitingApproval, DistributionSummary: StageWaitingApproval,
+		ArtifactPath: writeArtifact(t, dir, 1, 100), CreatedAt: now.Add(-48 * time.Hour),
+	}
+	newer := &model.BuildRun{
+		ID: 2, BuildJobID: 10, BuildNumber: 2, Status: "success",
+		ArtifactPath: writeArtifact(t, dir, 2, 10), CreatedAt: now,
+	}
+	store := newMemRunStore(parked, newer)
+	// Count, age and size would each remove the parked run.
+	job := &model.BuildJob{ID: 10, MaxArtifacts: 1, ArtifactMaxAgeDays: 1, ArtifactMaxTotalMB: 1}
+
+	plan, err := ApplyArtifactRetention(store, nil, job, now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Remove) != 1 || plan.Remove[0].RunID != 2 || plan.Protected != 1 {
+		t.Fatalf("plan=%+v", plan)
+	}
+	if _, err := os.Stat(parked.ArtifactPath); err != nil {
+		t.Fatalf("parked artifact removed: %v", err)
+	}
+}
+
+func TestArtifactSweeper_ageAndSizeLimits(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	now := time.Now()
+	const kb = 1 << 10
+	ages := map[uint]time.Duration{1: 10 * 24 * time.Hour, 2: 48 * time.Hour, 3: 24 * time.Hour, 4: time.Hour, 5: 0}
+	var runs []*model.BuildRun
+	for id := uint(1); id <= 5; id++ {
+		runs = append(runs, &model.BuildRun{
+			ID: id, BuildJobID: 10, BuildNumber: int(id), Status: "success",
+			ArtifactPath: writeArtifact(t, dir, id, 400*kb),
+			CreatedAt:    now.Add(-ages[id]),
+		})
+	}
+	// Still building: protected, but its size counts toward the total.
+	runs[4].Status = "running"
+	store := newMemRunStore(runs...)
+	jobs := &memJobStore{job: &model.BuildJob{ID: 10, MaxArtifacts: 10, ArtifactMaxAgeDays: 7, ArtifactMaxTotalMB: 1}}
+
+	NewArtifactSweeper(store, jobs, nil, 0, zap.NewNop()).SweepAll(now)
+
+	want := map[uint]string{1: RetentionAge, 2: RetentionSize, 3: RetentionSize, 4: "", 5: ""}
+	for id, reason := range want {
+		r, _ := store.FindByID(id)
+		if r.ArtifactRemovedReason != reason {
+			t.Fatalf("run %d reason=%q want %q", id, r.ArtifactRemovedReason, reason)
+		}
+		if (reason == "") != (r.ArtifactPath != "") {
+			t.Fatalf("run %d artifact_path=%q", id, r.ArtifactPath)
+		}
+	}
+}
@@ -0,0 +1,620 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bedrock/internal/cicd/model"
+	"bedrock/internal/pkg"
+)
+
+// Log streams. Lines emitted by the pipeline itself (stage markers, git,
+// distribution) are "system"; script output keeps its own stream.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+	StreamSystem = "system"
+)
+
+// LogLine is one stored build log line. Files hold one JSON object per line
+// (NDJSON); N is assigned when reading and never stored.
+type LogLine struct {
+	N      int       `json:"n,omitempty"`
+	Time   time.Time `json:"ts"`
+	Stream string    `json:"stream"`
+	Stage  string    `json:"stage,omitempty"`
+	Text   string    `json:"text"`
+}
+
+const stageMarkerPrefix = "=== Stage: "
+
+// runLog writes a run's structured log file and mirrors plain text to the hub.
+// A line "=== Stage: <name> ===" starts a new stage segment; its position is
+// appended to the stage index and reported through onStage.
+type runLog struct {
+	mu        sync.Mutex
+	f         *os.File
+	offset    int64
+	lines     int
+	stage     string
+	stages    []model.LogStage
+	redact    *pkg.Redactor
+	broadcast func(string)
+	onStage   func([]model.LogStage)
+}
+
+// openRunLog creates path, or appends to it (redeploy) continuing the line
+// count and stage index already recorded for the run.
+func openRunLog(path string, appendTo bool, stages []model.LogStage) (*runLog, error) {
+	if !appendTo {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		return &runLog{f: f}, nil
+	}
+	if err := decompressLogInPlace(path); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := &runLog{f: f, stages: append([]model.LogStage(nil), stages...)}
+	if _, err := f.Seek(0, io.SeekStart); err == nil {
+		br := bufio.NewReader(f)
+		for {
+			raw, err := br.ReadBytes('\n')
+			if len(raw) > 0 {
+				l.offset += int64(len(raw))
+				l.lines++
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	if n := len(l.stages); n > 0 {
+		l.stage = l.stages[n-1].Name
+	}
+	return l, nil
+}
+
+func (l *runLog) Close() error { return l.f.Close() }
+
+// Line writes a pipeline (system) line; it satisfies the func(string) loggers
+// passed to git, deployers and distribution.
+func (l *runLog) Line(text string) { l.write(StreamSystem, text) }
+
+func (l *runLog) Stdout(text string) { l.write(StreamStdout, text) }
+
+func (l *runLog) Stderr(text string) { l.write(StreamStderr, text) }
+
+func (l *runLog) write(stream, text string) {
+	text = l.redact.Redact(text)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var stages []model.LogStage
+	if stream == StreamSystem {
+		if name, ok := parseStageMarker(text); ok {
+			l.stage = name
+			l.stages = append(l.stages, model.LogStage{Name: name, Line: l.lines + 1, Offset: l.offset})
+			stages = append([]model.LogStage(nil), l.stages...)
+		}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(LogLine{Time: time.Now().UTC(), Stream: stream, Stage: l.stage, Text: text})
+	n, _ := l.f.Write(buf.Bytes())
+	l.offset += int64(n)
+	l.lines++
+	if l.broadcast != nil {
+		l.broadcast(text)
+	}
+	if stages != nil && l.onStage != nil {
+		l.onStage(stages)
+	}
+}
+
+func parseStageMarker(text string) (string, bool) {
+	if !strings.HasPrefix(text, stageMarkerPrefix) || !strings.HasSuffix(text, " ===") {
+		return "", false
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(text, stageMarkerPrefix), " ===")
+	name = strings.TrimSuffix(name, " (skipped)")
+	return strings.TrimSpace(name), name != ""
+}
+
+// ParseLogLine decodes one stored line. Lines from logs written before the
+// structured format come back as system text without a timestamp.
+func ParseLogLine(raw []byte) LogLine {
+	raw = bytes.TrimRight(raw, "\r\n")
+	if bytes.HasPrefix(raw, []byte(`{"ts":`)) {
+		var l LogLine
+		if err := json.Unmarshal(raw, &l); err == nil {
+			return l
+		}
+	}
+	return LogLine{Stream: StreamSystem, Text: string(raw)}
+}
+
+// DecodeLogStages fills BuildRun.LogStages from LogStagesJSON.
+func DecodeLogStages(run *model.BuildRun) {
+	if run == nil || len(run.LogStages) > 0 || strings.TrimSpace(run.LogStagesJSON) == "" {
+		return
+	}
+	_ = json.Unmarshal([]byte(run.LogStagesJSON), &run.LogStages)
+}
+
+// ---- Reading ----
+
+// ErrLogNotFound: neither the log nor its compressed form exists.
+var ErrLogNotFound = errors.New("log file not found")
+
+// openLogAt opens path (or path.gz) positioned at offset, plus its
+// uncompressed size.
+func openLogAt(path string, offset int64) (*bufio.Reader, io.Closer, int64, error) {
+	if f, err := os.Open(path); err == nil {
+		st, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, nil, 0, err
+		}
+		if offset > 0 {
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				f.Close()
+				return nil, nil, 0, err
+			}
+		}
+		return bufio.NewReaderSize(f, 64*1024), f, st.Size(), nil
+	} else if !os.IsNotExist(err) {
+		return nil, nil, 0, err
+	}
+	f, err := os.Open(path + ".gz")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, 0, ErrLogNotFound
+		}
+		return nil, nil, 0, err
+	}
+	size, err := gzipLogSize(path)
+	if err != nil {
+		f.Close()
+		return nil, nil, 0, err
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, 0, err
+	}
+	br := bufio.NewReaderSize(zr, 64*1024)
+	if offset > 0 {
+		if _, err := br.Discard(int(offset)); err != nil && err != io.EOF {
+			f.Close()
+			return nil, nil, 0, err
+		}
+	}
+	return br, f, size, nil
+}
+
+// gzipSizeSuffix names the file next to "<path>.gz" holding the log's
+// uncompressed size (the gzip ISIZE trailer wraps at 4 GiB).
+const gzipSizeSuffix = ".gz.size"
+
+// gzipLogSize returns the uncompressed size of path.gz. Logs compressed
+// before the size was recorded are measured once and recorded then.
+func gzipLogSize(path string) (int64, error) {
+	if b, err := os.ReadFile(path + gzipSizeSuffix); err == nil {
+		if n, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64); err == nil {
+			return n, nil
+		}
+	}
+	f, err := os.Open(path + ".gz")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(io.Discard, zr)
+	if err != nil {
+		return 0, err
+	}
+	_ = writeGzipSize(path, n)
+	return n, nil
+}
+
+func writeGzipSize(path string, n int64) error {
+	return os.WriteFile(path+gzipSizeSuffix, []byte(strconv.FormatInt(n, 10)), 0644)
+}
+
+// LogRange is a line-aligned byte range of the stored log.
+type LogRange struct {
+	Data   []byte
+	Offset int64 // start after alignment
+	Next   int64 // offset to request next; == Size at end of file
+	Size   int64
+}
+
+// ReadLogRange returns whole lines from offset up to about limit bytes (at
+// least one line). An offset inside a line advances to the next line start.
+// tail > 0 reads the last tail bytes instead.
+func ReadLogRange(path string, offset, limit, tail int64) (*LogRange, error) {
+	if limit <= 0 {
+		limit = 1 << 20
+	}
+	_, c, size, err := openLogAt(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	c.Close()
+	if tail > 0 {
+		offset = size - tail
+		limit = tail
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= size {
+		return &LogRange{Offset: size, Next: size, Size: size}, nil
+	}
+	start := offset
+	if start > 0 {
+		start--
+	}
+	br, c, _, err := openLogAt(path, start)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if offset > 0 {
+		prev, err := br.ReadByte()
+		if err != nil {
+			return &LogRange{Offset: size, Next: size, Size: size}, nil
+		}
+		if prev != '\n' {
+			skipped, _ := br.ReadBytes('\n')
+			offset += int64(len(skipped))
+		}
+	}
+	out := &LogRange{Offset: offset, Size: size}
+	var buf bytes.Buffer
+	for int64(buf.Len()) < limit {
+		raw, err := br.ReadBytes('\n')
+		buf.Write(raw)
+		if err != nil {
+			break
+		}
+	}
+	out.Data = buf.Bytes()
+	out.Next = offset + int64(len(out.Data))
+	return out, nil
+}
+
+// Text renders the range as plain text lines.
+func (r *LogRange) Text() []byte { return RenderLogText(r.Data) }
+
+// RenderLogText converts stored NDJSON to plain text lines.
+func RenderLogText(data []byte) []byte {
+	var out bytes.Buffer
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		var raw []byte
+		if i < 0 {
+			raw, data = data, nil
+		} else {
+			raw, data = data[:i], data[i+1:]
+		}
+		out.WriteString(ParseLogLine(raw).Text)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// logSegment is a byte span [Offset, End) whose first line is number Line; End < 0 means EOF.
+type logSegment struct {
+	Offset int64
+	End    int64
+	Line   int
+}
+
+// stageSegments returns the spans of every stage named stage (a stage can
+// repeat, e.g. distribution batches on redeploy), or of the whole log when
+// stage is empty.
+func stageSegments(stages []model.LogStage, stage string) []logSegment {
+	if stage == "" {
+		return []logSegment{{Offset: 0, End: -1, Line: 1}}
+	}
+	var segs []logSegment
+	for i, s := range stages {
+		if s.Name != stage {
+			continue
+		}
+		end := int64(-1)
+		if i+1 < len(stages) {
+			end = stages[i+1].Offset
+		}
+		segs = append(segs, logSegment{Offset: s.Offset, End: end, Line: s.Line})
+	}
+	return segs
+}
+
+// ScanLogStages rebuilds the stage index of a log that has none (logs written
+// before the index existed).
+func ScanLogStages(path string) ([]model.LogStage, error) {
+	br, c, _, err := openLogAt(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	var stages []model.LogStage
+	var offset int64
+	for n := 1; ; n++ {
+		raw, err := br.ReadBytes('\n')
+		if len(raw) > 0 {
+			if l := ParseLogLine(raw); l.Stream == StreamSystem {
+				if name, ok := parseStageMarker(l.Text); ok {
+					stages = append(stages, model.LogStage{Name: name, Line: n, Offset: offset})
+				}
+			}
+			offset += int64(len(raw))
+		}
+		if err != nil {
+			break
+		}
+	}
+	return stages, nil
+}
+
+// eachLogLine calls fn for every line in segs (numbered) until fn returns false.
+func eachLogLine(path string, segs []logSegment, fn func(LogLine) bool) error {
+	for _, seg := range segs {
+		br, c, _, err := openLogAt(path, seg.Offset)
+		if err != nil {
+			return err
+		}
+		pos, n := seg.Offset, seg.Line
+		cont := true
+		for cont && (seg.End < 0 || pos < seg.End) {
+			raw, err := br.ReadBytes('\n')
+			if len(raw) > 0 {
+				pos += int64(len(raw))
+				l := ParseLogLine(raw)
+				l.N = n
+				n++
+				cont = fn(l)
+			}
+			if err != nil {
+				break
+			}
+		}
+		c.Close()
+		if !cont {
+			break
+		}
+	}
+	return nil
+}
+
+// LogPage is a page of numbered log lines.
+type LogPage struct {
+	Lines   []LogLine `json:"lines"`
+	Next    int       `json:"next"` // line number to request next (0 when done)
+	HasMore bool      `json:"has_more"`
+}
+
+// ReadLogLines returns up to limit lines starting at line number from
+// (1-based), optionally restricted to one stage.
+func ReadLogLines(path string, stages []model.LogStage, from, limit int, stage string) (*LogPage, error) {
+	if from < 1 {
+		from = 1
+	}
+	if limit <= 0 {
+		limit = 1000
+	} else if limit > 5000 {
+		limit = 5000
+	}
+	segs := stageSegments(stages, stage)
+	if stage == "" {
+		// Seek to the closest stage start at or before from.
+		for _, s := range stages {
+			if s.Line <= from {
+				segs[0].Offset, segs[0].Line = s.Offset, s.Line
+			}
+		}
+	}
+	page := &LogPage{Lines: []LogLine{}}
+	err := eachLogLine(path, segs, func(l LogLine) bool {
+		if l.N < from {
+			return true
+		}
+		if len(page.Lines) == limit {
+			page.HasMore = true
+			page.Next = l.N
+			return false
+		}
+		page.Lines = append(page.Lines, l)
+		return true
+	})
+	return page, err
+}
+
+// SearchLog returns lines whose text matches re (at most limit).
+func SearchLog(path string, stages []model.LogStage, re *regexp.Regexp, stage string, limit int) (*LogPage, error) {
+	if limit <= 0 {
+		limit = 200
+	} else if limit > 1000 {
+		limit = 1000
+	}
+	page := &LogPage{Lines: []LogLine{}}
+	err := eachLogLine(path, stageSegments(stages, stage), func(l LogLine) bool {
+		if !re.MatchString(l.Text) {
+			return true
+		}
+		if len(page.Lines) == limit {
+			page.HasMore = true
+			page.Next = l.N
+			return false
+		}
+		page.Lines = append(page.Lines, l)
+		return true
+	})
+	return page, err
+}
+
+// ---- Compression ----
+
+// CompressOldLogs gzips build logs (job-*/run-*.log) not modified within
+// olderThan. Readers fall back to "<path>.gz" transparently.
+func CompressOldLogs(logDir string, olderThan time.Duration, now time.Time) (int, error) {
+	if olderThan <= 0 || logDir == "" {
+		return 0, nil
+	}
+	cutoff := now.Add(-olderThan)
+	n := 0
+	err := filepath.WalkDir(logDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != logDir && !strings.HasPrefix(d.Name(), "job-") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Dir(path) == filepath.Clean(logDir) || !strings.HasSuffix(d.Name(), ".log") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+		if err := gzipFile(path, info.ModTime()); err != nil {
+			return fmt.Errorf("compress %s: %w", path, err)
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+func gzipFile(path string, mtime time.Time) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := path + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	size, err := io.Copy(zw, src)
+	if err != nil {
+		zw.Close()
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// Recorded first: a reader that finds path.gz also finds its size.
+	if err := writeGzipSize(path, size); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	_ = os.Chtimes(path+".gz", mtime, mtime)
+	return os.Remove(path)
+}
+
+// decompressLogInPlace restores path from path.gz so a redeploy can append.
+func decompressLogInPlace(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	src, err := os.Open(path + ".gz")
+	if err != nil {
+		return nil
+	}
+	defer src.Close()
+	zr, err := gzip.NewReader(src)
+	if err != nil {
+		return err
+	}
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, zr); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	_ = os.Remove(path + gzipSizeSuffix)
+	return os.Remove(path + ".gz")
+}
+
+// LogCompactor periodically compresses old build logs.
+type LogCompactor struct {
+	dir   string
+	after time.Duration
+	every time.Duration
+	onErr func(error)
+	stop  chan struct{}
+	once  sync.Once
+}
+
+func NewLogCompactor(dir string, after time.Duration, onErr func(error)) *LogCompactor {
+	return &LogCompactor{dir: dir, after: after, every: time.Hour, onErr: onErr, stop: make(chan struct{})}
+}
+
+func (c *LogCompactor) Start() {
+	if c.after <= 0 {
+		return
+	}
+	go func() {
+		t := time.NewTicker(c.every)
+		defer t.Stop()
+		for {
+			if _, err := CompressOldLogs(c.dir, c.after, time.Now()); err != nil && c.onErr != nil {
+				c.onErr(err)
+			}
+			select {
+			case <-c.stop:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+func (c *LogCompactor) Stop() { c.once.Do(func() { close(c.stop) }) }
@@ -0,0 +1,189 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"bedrock/internal/cicd/model"
+	"bedrock/internal/pkg"
+)
+
+func writeTestRunLog(t *testing.T, path string) []model.LogStage {
+	t.Helper()
+	l, err := openRunLog(path, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var indexed []model.LogStage
+	l.onStage = func(s []model.LogStage) { indexed = s }
+	l.redact = pkg.NewRedactor("hunter22")
+	l.Line("=== Stage: Cloning ===")
+	l.Line("cloned")
+	l.Line("=== Stage: Build ===")
+	l.Stdout("compiling pass=hunter22")
+	l.Stderr("warning: unused")
+	l.Line("=== Stage: Test (skipped) ===")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return indexed
+}
+
+func TestRunLog_stageIndexAndLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run-1.log")
+	stages := writeTestRunLog(t, path)
+	if len(stages) != 3 || stages[1].Name != "Build" || stages[1].Line != 3 || stages[2].Name != "Test" {
+		t.Fatalf("stages = %+v", stages)
+	}
+	scanned, err := ScanLogStages(path)
+	if err != nil || len(scanned) != 3 || scanned[1] != stages[1] {
+		t.Fatalf("scanned = %+v, %v (want %+v)", scanned, err, stages)
+	}
+
+	page, err := ReadLogLines(path, stages, 0, 0, "Build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Lines) != 3 || page.Lines[1].N != 4 || page.Lines[1].Stream != StreamStdout ||
+		page.Lines[1].Stage != "Build" || page.Lines[1].Text != "compiling pass=***" ||
+		page.Lines[2].Stream != StreamStderr || page.Lines[1].Time.IsZero() {
+		t.Fatalf("build stage = %+v", page.Lines)
+	}
+
+	page, err = ReadLogLines(path, stages, 2, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Lines) != 2 || page.Lines[0].Text != "cloned" || !page.HasMore || page.Next != 4 {
+		t.Fatalf("page = %+v", page)
+	}
+
+	found, err := SearchLog(path, stages, regexp.MustCompile(`warn|clon`), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found.Lines) != 2 || found.Lines[0].N != 2 || found.Lines[1].N != 5 {
+		t.Fatalf("search = %+v", found.Lines)
+	}
+}
+
+func TestRunLog_appendContinuesIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run-1.log")
+	stages := writeTestRunLog(t, path)
+	l, err := openRunLog(path, true, stages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var indexed []model.LogStage
+	l.onStage = func(s []model.LogStage) { indexed = s }
+	l.Line("=== Stage: Distributing (batch 2) ===")
+	l.Close()
+	if len(indexed) != 4 || indexed[3].Line != 7 {
+		t.Fatalf("indexed = %+v", indexed)
+	}
+	page, _ := ReadLogLines(path, indexed, 0, 0, "Distributing (batch 2)")
+	if len(page.Lines) != 1 || page.Lines[0].N != 7 {
+		t.Fatalf("page = %+v", page.Lines)
+	}
+}
+
+func TestReadLogRange_lineAlignedAndTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run-1.log")
+	writeTestRunLog(t, path)
+
+	first, err := ReadLogRange(path, 0, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(first.Text()); got != "=== Stage: Cloning ===\n" {
+		t.Fatalf("first = %q", got)
+	}
+	// An offset inside a line skips to the next line start.
+	mid, err := ReadLogRange(path, first.Next-3, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mid.Offset != first.Next || string(mid.Text()) != "cloned\n" {
+		t.Fatalf("mid = %+v %q", mid, mid.Text())
+	}
+	tail, err := ReadLogRange(path, 0, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tail.Next != tail.Size || len(tail.Data) != 0 {
+		// the last line is longer than 10 bytes, so alignment consumes it
+		t.Fatalf("tail = %+v", tail)
+	}
+	all, _ := ReadLogRange(path, 0, 0, 0)
+	if strings.Count(string(all.Text()), "\n") != 6 || all.Next != all.Size {
+		t.Fatalf("all = %q", all.Text())
+	}
+}
+
+func TestCompressOldLogs_readsGzipTransparently(t *testing.T) {
+	dir := t.TempDir()
+	jobDir := filepath.Join(dir, "job-1")
+	if err := os.MkdirAll(jobDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(jobDir, "run-1.log")
+	stages := writeTestRunLog(t, path)
+	plain, _ := os.Stat(path)
+	agentLog := filepath.Join(dir, "agent-1.log")
+	_ = os.WriteFile(agentLog, []byte("x\n"), 0o644)
+
+	n, err := CompressOldLogs(dir, time.Hour, time.Now().Add(2*time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("compressed %d, %v", n, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("plain log should be removed")
+	}
+	if _, err := os.Stat(agentLog); err != nil {
+		t.Fatal("non-build logs are left alone")
+	}
+	rng, err := ReadLogRange(path, 0, 0, 0)
+	if err != nil || rng.Size != plain.Size() || rng.Next != rng.Size {
+		t.Fatalf("range = %+v, %v", rng, err)
+	}
+	// Logs compressed without a recorded size are measured, then recorded.
+	_ = os.Remove(path + gzipSizeSuffix)
+	if rng, err := ReadLogRange(path, 0, 0, 0); err != nil || rng.Size != plain.Size() {
+		t.Fatalf("unrecorded range = %+v, %v", rng, err)
+	}
+	if b, _ := os.ReadFile(path + gzipSizeSuffix); string(b) != strconv.FormatInt(plain.Size(), 10) {
+		t.Fatalf("recorded size = %q", b)
+	}
+	page, err := ReadLogLines(path, stages, 0, 0, "Build")
+	if err != nil || len(page.Lines) != 3 {
+		t.Fatalf("page = %+v, %v", page, err)
+	}
+	// Redeploy appends to a restored plain file.
+	l, err := openRunLog(path, true, stages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Line("more")
+	l.Close()
+	if _, err := os.Stat(path + ".gz"); !os.IsNotExist(err) {
+		t.Fatal("gz should be replaced by the restored log")
+	}
+	if _, err := os.Stat(path + gzipSizeSuffix); !os.IsNotExist(err) {
+		t.Fatal("size file should be removed with the gz")
+	}
+	if _, err := ReadLogRange(filepath.Join(jobDir, "missing.log"), 0, 0, 0); err != ErrLogNotFound {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestParseLogLine_legacyText(t *testing.T) {
+	l := ParseLogLine([]byte("plain old line\n"))
+	if l.Stream != StreamSystem || l.Text != "plain old line" || !l.Time.IsZero() {
+		t.Fatalf("got %+v", l)
+	}
+}
@@ -116,6 +116,7 @@ func (s *Scheduler) Submit(runID uint) error {
 	if s.closed.Load() {
 		return fmt.Errorf("scheduler is shut down")
 	}
+	s.pipeline.statuses.Report(runID, CommitStatePending, "Queued")
 	select {
 	case s.jobs <- runID:
 		return nil
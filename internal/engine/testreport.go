@@ -0,0 +1,423 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"bedrock/internal/cicd/model"
+)
+
+// Report limits: files above maxTestReportBytes are skipped; long failure
+// output is truncated so one noisy test cannot bloat the database.
+const (
+	maxTestReportBytes = 32 << 20
+	maxTestMessageLen  = 1024
+	maxTestDetailsLen  = 16 << 10
+)
+
+// collectTestReports ingests BuildJob.TestReportPaths after the build stage.
+// Report problems are logged as warnings and never change the run status.
+func (p *Pipeline) collectTestReports(run *model.BuildRun, job *model.BuildJob, workDir string, writeLine func(string)) {
+	patterns := parseCachePaths(job.TestReportPaths)
+	if len(patterns) == 0 {
+		return
+	}
+	writeLine("=== Stage: Test Reports ===")
+	suites, warnings, err := CollectTestReports(workDir, patterns)
+	if err != nil {
+		writeLine("WARNING: 测试报告路径无效: " + err.Error())
+		return
+	}
+	for _, w := range warnings {
+		writeLine("WARNING: 测试报告解析失败: " + w)
+	}
+	if len(suites) == 0 {
+		writeLine("No test reports found")
+		return
+	}
+	if err := p.runs.ReplaceTestResults(run.ID, suites); err != nil {
+		writeLine("WARNING: 保存测试结果失败: " + err.Error())
+		return
+	}
+	summary := SummarizeTests(suites)
+	raw, _ := json.Marshal(summary)
+	_ = p.runs.UpdateFields(run.ID, map[string]interface{}{"test_summary_json": string(raw)})
+	p.broadcastRunRefresh(run.ID)
+	writeLine(fmt.Sprintf("Tests: %d total, %d passed, %d failed, %d errors, %d skipped",
+		summary.Total, summary.Passed, summary.Failed, summary.Errors, summary.Skipped))
+}
+
+// ValidateReportPaths checks report globs such as BuildJob.TestReportPaths
+// (JSON array or one per line): every pattern must stay inside the workspace.
+func ValidateReportPaths(raw string) error {
+	for _, pat := range parseCachePaths(raw) {
+		if err := validateRelPath(pat); err != nil {
+			return fmt.Errorf("%q %w", pat, err)
+		}
+		if _, err := path.Match(strings.ReplaceAll(pat, "**", "*"), ""); err != nil {
+			return fmt.Errorf("%q: %w", pat, err)
+		}
+	}
+	return nil
+}
+
+// CollectTestReports parses every report matching patterns under workDir.
+// Unreadable or malformed files are reported in warnings and skipped.
+func CollectTestReports(workDir string, patterns []string) (suites []model.BuildTestSuite, warnings []string, err error) {
+	files, err := globWorkspace(workDir, patterns)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, rel := range files {
+		full := filepath.Join(workDir, filepath.FromSlash(rel))
+		info, err := os.Stat(full)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", rel, err))
+			continue
+		}
+		if info.Size() > maxTestReportBytes {
+			warnings = append(warnings, fmt.Sprintf("%s: 文件过大（%d 字节），已跳过", rel, info.Size()))
+			continue
+		}
+		data, err := os.ReadFile(full)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", rel, err))
+			continue
+		}
+		parsed, err := ParseTestReport(data)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", rel, err))
+			continue
+		}
+		for i := range parsed {
+			parsed[i].File = rel
+		}
+		suites = append(suites, parsed...)
+	}
+	return suites, warnings, nil
+}
+
+// ParseTestReport detects the format (JUnit/xUnit XML or `go test -json`
+// NDJSON) and returns suites with per-suite counts derived from their cases.
+func ParseTestReport(data []byte) ([]model.BuildTestSuite, error) {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) == 0:
+		return nil, fmt.Errorf("空报告")
+	case trimmed[0] == '<':
+		return parseJUnit(trimmed)
+	case trimmed[0] == '{':
+		return parseTest2JSON(trimmed)
+	default:
+		return nil, fmt.Errorf("无法识别的报告格式（支持 JUnit XML、go test -json）")
+	}
+}
+
+// SummarizeTests totals suites for BuildRun.TestSummary.
+func SummarizeTests(suites []model.BuildTestSuite) model.TestSummary {
+	var s model.TestSummary
+	for _, suite := range suites {
+		s.Total += suite.Tests
+		s.Failed += suite.Failures
+		s.Errors += suite.Errors
+		s.Skipped += suite.Skipped
+		s.DurationMs += suite.DurationMs
+	}
+	s.Passed = s.Total - s.Failed - s.Errors - s.Skipped
+	return s
+}
+
+// DecodeTestSummary fills BuildRun.TestSummary from TestSummaryJSON.
+func DecodeTestSummary(run *model.BuildRun) {
+	if run == nil || run.TestSummary != nil || strings.TrimSpace(run.TestSummaryJSON) == "" {
+		return
+	}
+	var s model.TestSummary
+	if err := json.Unmarshal([]byte(run.TestSummaryJSON), &s); err == nil {
+		run.TestSummary = &s
+	}
+}
+
+// ---- JUnit / xUnit XML ----
+
+type junitSuite struct {
+	Name   string       `xml:"name,attr"`
+	Time   string       `xml:"time,attr"`
+	Cases  []junitCase  `xml:"testcase"`
+	Suites []junitSuite `xml:"testsuite"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitOutcome `xml:"failure"`
+	Error     *junitOutcome `xml:"error"`
+	Skipped   *junitOutcome `xml:"skipped"`
+}
+
+type junitOutcome struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func parseJUnit(data []byte) ([]model.BuildTestSuite, error) {
+	// The root is either <testsuites> or a single <testsuite>.
+	var root struct {
+		XMLName xml.Name
+		junitSuite
+	}
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("解析 JUnit XML 失败: %w", err)
+	}
+	var out []model.BuildTestSuite
+	switch root.XMLName.Local {
+	case "testsuites":
+		for _, s := range root.Suites {
+			out = appendJUnitSuite(out, s, "")
+		}
+	case "testsuite":
+		out = appendJUnitSuite(out, root.junitSuite, "")
+	default:
+		return nil, fmt.Errorf("不是 JUnit 报告（根元素 <%s>）", root.XMLName.Local)
+	}
+	return out, nil
+}
+
+// appendJUnitSuite flattens nested suites (as written by some xUnit tools)
+// into "parent.child" names.
+func appendJUnitSuite(out []model.BuildTestSuite, s junitSuite, prefix string) []model.BuildTestSuite {
+	name := s.Name
+	if prefix != "" {
+		name = prefix + "." + name
+	}
+	if len(s.Cases) > 0 || len(s.Suites) == 0 {
+		suite := model.BuildTestSuite{Name: name, DurationMs: parseSeconds(s.Time)}
+		for _, c := range s.Cases {
+			tc := model.BuildTestCase{
+				SuiteName:  name,
+				ClassName:  c.ClassName,
+				Name:       c.Name,
+				Status:     model.TestPassed,
+				DurationMs: parseSeconds(c.Time),
+			}
+			switch {
+			case c.Failure != nil:
+				tc.Status = model.TestFailed
+				tc.Message, tc.Details = outcomeText(c.Failure)
+			case c.Error != nil:
+				tc.Status = model.TestError
+				tc.Message, tc.Details = outcomeText(c.Error)
+			case c.Skipped != nil:
+				tc.Status = model.TestSkipped
+				tc.Message, _ = outcomeText(c.Skipped)
+			}
+			suite.Cases = append(suite.Cases, tc)
+		}
+		out = append(out, countSuite(suite))
+	}
+	for _, child := range s.Suites {
+		out = appendJUnitSuite(out, child, name)
+	}
+	return out
+}
+
+func outcomeText(o *junitOutcome) (message, details string) {
+	message = strings.TrimSpace(o.Message)
+	details = strings.TrimSpace(o.Text)
+	if message == "" {
+		message = strings.TrimSpace(o.Type)
+	}
+	if message == "" {
+		message, _, _ = strings.Cut(details, "\n")
+	}
+	return truncateText(message, maxTestMessageLen), truncateText(details, maxTestDetailsLen)
+}
+
+// ---- go test -json (test2json) ----
+
+type test2jsonEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Output  string
+	Elapsed float64
+}
+
+func parseTest2JSON(data []byte) ([]model.BuildTestSuite, error) {
+	type caseState struct {
+		tc     model.BuildTestCase
+		output strings.Builder
+	}
+	suites := map[string]*model.BuildTestSuite{}
+	cases := map[string]map[string]*caseState{}
+	caseOrder := map[string][]string{}
+	pkgOutput := map[string]*strings.Builder{}
+	pkgFailed := map[string]bool{}
+	var order []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), 4<<20)
+	parsed := 0
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 || line[0] != '{' {
+			continue // interleaved non-JSON output (e.g. go vet)
+		}
+		var ev test2jsonEvent
+		if err := json.Unmarshal(line, &ev); err != nil {
+			continue
+		}
+		parsed++
+		if ev.Package == "" {
+			continue
+		}
+		suite := suites[ev.Package]
+		if suite == nil {
+			suite = &model.BuildTestSuite{Name: ev.Package}
+			suites[ev.Package] = suite
+			cases[ev.Package] = map[string]*caseState{}
+			pkgOutput[ev.Package] = &strings.Builder{}
+			order = append(order, ev.Package)
+		}
+		if ev.Test == "" {
+			switch ev.Action {
+			case "output":
+				if pkgOutput[ev.Package].Len() < maxTestDetailsLen {
+					pkgOutput[ev.Package].WriteString(ev.Output)
+				}
+			case "fail":
+				pkgFailed[ev.Package] = true
+				suite.DurationMs = int64(ev.Elapsed * 1000)
+			case "pass", "skip":
+				suite.DurationMs = int64(ev.Elapsed * 1000)
+			}
+			continue
+		}
+		st := cases[ev.Package][ev.Test]
+		if st == nil {
+			st = &caseState{tc: model.BuildTestCase{SuiteName: ev.Package, ClassName: ev.Package, Name: ev.Test}}
+			cases[ev.Package][ev.Test] = st
+			caseOrder[ev.Package] = append(caseOrder[ev.Package], ev.Test)
+		}
+		switch ev.Action {
+		case "output":
+			if st.output.Len() < maxTestDetailsLen {
+				st.output.WriteString(ev.Output)
+			}
+		case "pass":
+			st.tc.Status = model.TestPassed
+			st.tc.DurationMs = int64(ev.Elapsed * 1000)
+		case "fail":
+			st.tc.Status = model.TestFailed
+			st.tc.DurationMs = int64(ev.Elapsed * 1000)
+		case "skip":
+			st.tc.Status = model.TestSkipped
+			st.tc.DurationMs = int64(ev.Elapsed * 1000)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("读取 go test -json 输出失败: %w", err)
+	}
+	if parsed == 0 {
+		return nil, fmt.Errorf("未找到 go test -json 事件")
+	}
+	out := make([]model.BuildTestSuite, 0, len(order))
+	for _, pkgName := range order {
+		suite := suites[pkgName]
+		for _, name := range caseOrder[pkgName] {
+			st := cases[pkgName][name]
+			tc := st.tc
+			if tc.Status == "" {
+				// No terminal event: the test binary crashed or was killed mid-test.
+				tc.Status = model.TestError
+				tc.Message = "测试未结束（进程崩溃或被终止）"
+			}
+			if tc.Status != model.TestPassed {
+				details := strings.TrimSpace(st.output.String())
+				tc.Details = truncateText(details, maxTestDetailsLen)
+				if tc.Message == "" {
+					tc.Message = truncateText(lastMeaningfulLine(details), maxTestMessageLen)
+				}
+			}
+			suite.Cases = append(suite.Cases, tc)
+		}
+		if pkgFailed[pkgName] && len(suite.Cases) == 0 {
+			// Package failed without running tests (build error, TestMain exit).
+			details := strings.TrimSpace(pkgOutput[pkgName].String())
+			suite.Cases = append(suite.Cases, model.BuildTestCase{
+				SuiteName: pkgName,
+				ClassName: pkgName,
+				Name:      "[package]",
+				Status:    model.TestError,
+				Message:   truncateText(lastMeaningfulLine(details), maxTestMessageLen),
+				Details:   truncateText(details, maxTestDetailsLen),
+			})
+		}
+		out = append(out, countSuite(*suite))
+	}
+	return out, nil
+}
+
+// lastMeaningfulLine skips the trailing "--- FAIL: TestX" summary go test prints.
+func lastMeaningfulLine(output string) string {
+	lines := strings.Split(output, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		l := strings.TrimSpace(lines[i])
+		if l == "" || strings.HasPrefix(l, "--- ") || strings.HasPrefix(l, "=== ") {
+			continue
+		}
+		return l
+	}
+	return ""
+}
+
+// ---- helpers ----
+
+func countSuite(s model.BuildTestSuite) model.BuildTestSuite {
+	s.Tests, s.Failures, s.Errors, s.Skipped = len(s.Cases), 0, 0, 0
+	var sum int64
+	for _, c := range s.Cases {
+		sum += c.DurationMs
+		switch c.Status {
+		case model.TestFailed:
+			s.Failures++
+		case model.TestError:
+			s.Errors++
+		case model.TestSkipped:
+			s.Skipped++
+		}
+	}
+	if s.DurationMs == 0 {
+		s.DurationMs = sum
+	}
+	return s
+}
+
+func parseSeconds(v string) int64 {
+	f, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(v), ",", ""), 64)
+	if err != nil || f < 0 {
+		return 0
+	}
+	return int64(f * 1000)
+}
+
+func truncateText(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "…"
+}
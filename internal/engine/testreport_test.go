@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+	resourcemodel "bedrock/internal/resource/model"
+)
+
+func TestParseTestReport_junit(t *testing.T) {
+	t.Parallel()
+	suites, err := ParseTestReport([]byte(`<?xml version="1.0"?>
+<testsuites>
+  <testsuite name="outer" time="1.5">
+    <testcase classname="pkg.A" name="ok" time="0.25"/>
+    <testcase classname="pkg.A" name="bad" time="1"><failure message="expected 1" type="Assert">stack line 1
+stack line 2</failure></testcase>
+    <testsuite name="inner">
+      <testcase classname="pkg.B" name="skip"><skipped message="flaky"/></testcase>
+      <testcase classname="pkg.B" name="boom"><error>panic: nil map</error></testcase>
+    </testsuite>
+  </testsuite>
+</testsuites>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suites) != 2 {
+		t.Fatalf("suites=%d", len(suites))
+	}
+	outer := suites[0]
+	if outer.Tests != 2 || outer.Failures != 1 || outer.DurationMs != 1500 {
+		t.Fatalf("outer=%+v", outer)
+	}
+	bad := outer.Cases[1]
+	if bad.Status != model.TestFailed || bad.Message != "expected 1" || bad.DurationMs != 1000 || bad.Details == "" {
+		t.Fatalf("bad=%+v", bad)
+	}
+	inner := suites[1]
+	if inner.Tests != 2 || inner.Skipped != 1 || inner.Errors != 1 {
+		t.Fatalf("inner=%+v", inner)
+	}
+	if inner.Cases[0].Message != "flaky" || inner.Cases[1].Message != "panic: nil map" {
+		t.Fatalf("cases=%+v", inner.Cases)
+	}
+	sum := SummarizeTests(suites)
+	want := model.TestSummary{Total: 4, Passed: 1, Failed: 1, Errors: 1, Skipped: 1, DurationMs: sum.DurationMs}
+	if sum != want {
+		t.Fatalf("summary=%+v", sum)
+	}
+}
+
+func TestParseTestReport_test2json(t *testing.T) {
+	t.Parallel()
+	report := `{"Action":"run","Package":"example/a","Test":"TestOK"}
+{"Action":"pass","Package":"example/a","Test":"TestOK","Elapsed":0.01}
+{"Action":"run","Package":"example/a","Test":"TestBad"}
+{"Action":"output","Package":"example/a","Test":"TestBad","Output":"=== RUN   TestBad\n"}
+{"Action":"output","Package":"example/a","Test":"TestBad","Output":"    a_test.go:9: got 2, want 3\n"}
+{"Action":"output","Package":"example/a","Test":"TestBad","Output":"--- FAIL: TestBad (0.00s)\n"}
+{"Action":"fail","Package":"example/a","Test":"TestBad","Elapsed":0}
+{"Action":"run","Package":"example/a","Test":"TestHang"}
+{"Action":"output","Package":"example/a","Test":"TestHang","Output":"panic: test timed out\n"}
+{"Action":"fail","Package":"example/a","Elapsed":2.5}
+{"Action":"output","Package":"example/b","Output":"b.go:3:1: syntax error\n"}
+{"Action":"fail","Package":"example/b","Elapsed":0}
+`
+	suites, err := ParseTestReport([]byte(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suites) != 2 {
+		t.Fatalf("suites=%+v", suites)
+	}
+	a := suites[0]
+	var names, statuses []string
+	for _, c := range a.Cases {
+		names = append(names, c.Name)
+		statuses = append(statuses, c.Status)
+	}
+	if !reflect.DeepEqual(names, []string{"TestOK", "TestBad", "TestHang"}) ||
+		!reflect.DeepEqual(statuses, []string{model.TestPassed, model.TestFailed, model.TestError}) {
+		t.Fatalf("names=%v statuses=%v", names, statuses)
+	}
+	if a.Cases[1].Message != "a_test.go:9: got 2, want 3" {
+		t.Fatalf("message=%q", a.Cases[1].Message)
+	}
+	if a.DurationMs != 2500 || a.Failures != 1 || a.Errors != 1 {
+		t.Fatalf("suite=%+v", a)
+	}
+	b := suites[1]
+	if len(b.Cases) != 1 || b.Cases[0].Status != model.TestError || b.Cases[0].Message != "b.go:3:1: syntax error" {
+		t.Fatalf("build failure=%+v", b.Cases)
+	}
+}
+
+func TestGlobWorkspace(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	for _, f := range []string{
+		"TEST-root.xml",
+		"svc/target/surefire-reports/TEST-a.xml",
+		"svc/target/other.xml",
+		".git/TEST-hidden.xml",
+	} {
+		full := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte("<testsuite/>"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := globWorkspace(dir, []string{"**/TEST-*.xml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{"TEST-root.xml", "svc/target/surefire-reports/TEST-a.xml"}) {
+		t.Fatalf("got %v", got)
+	}
+	if _, err := globWorkspace(dir, []string{"../*.xml"}); err == nil {
+		t.Fatal("pattern escaping the workspace accepted")
+	}
+}
+
+func TestExecuteCollectsTestReportsOnFailedBuild(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("sh script")
+	}
+	repoDir := initLocalGitRepo(t)
+	tmp := t.TempDir()
+	run := &model.BuildRun{ID: 1, BuildJobID: 10, BuildNumber: 1, Status: "queued", Stage: "pending", Branch: "main", TriggeredBy: 7}
+	store := newMemRunStore(run)
+	jobStore := &memJobStore{job: &model.BuildJob{
+		ID: 10, RepositoryID: 1, Branch: "main",
+		BuildScript:     `mkdir -p out && printf '<testsuite name="s"><testcase name="t"><failure message="m"/></testcase></testsuite>' > out/TEST-s.xml && exit 1`,
+		TestReportPaths: "out/TEST-*.xml",
+	}}
+	repoStore := &memRepoStore{repo: &resourcemodel.Repository{ID: 1, RepoURL: repoDir, AuthType: "none"}}
+	p := NewPipeline(store, jobStore, repoStore, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(),
+		filepath.Join(tmp, "ws"), filepath.Join(tmp, "a"), filepath.Join(tmp, "l"), filepath.Join(tmp, "c"))
+
+	p.Execute(context.Background(), 1)
+	got, _ := store.FindByID(1)
+	if got.Status != "failed" {
+		t.Fatalf("status=%s", got.Status)
+	}
+	DecodeTestSummary(got)
+	if got.TestSummary == nil || got.TestSummary.Total != 1 || got.TestSummary.Failed != 1 {
+		t.Fatalf("summary=%q", got.TestSummaryJSON)
+	}
+	if suites := store.suites[1]; len(suites) != 1 || suites[0].File != "out/TEST-s.xml" || suites[0].Cases[0].Message != "m" {
+		t.Fatalf("suites=%+v", suites)
+	}
+}
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"bedrock/internal/cicd/model"
+)
+
+// RunStatusTimedOut is the terminal status of a run stopped by a job or stage time limit.
+const RunStatusTimedOut = "timed_out"
+
+// Timeout scopes on BuildJob: the whole run, or one phase of it.
+const (
+	TimeoutJob        = "job"
+	TimeoutClone      = "clone"
+	TimeoutBuild      = "build"
+	TimeoutDistribute = "distribute"
+)
+
+// TimeoutError is the context cause when a time limit fires; it tells a
+// timeout apart from a manual Cancel (plain context.Canceled).
+type TimeoutError struct {
+	Scope string
+	Limit time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	label := map[string]string{
+		TimeoutJob:        "构建整体",
+		TimeoutClone:      "克隆阶段",
+		TimeoutBuild:      "构建阶段",
+		TimeoutDistribute: "分发阶段",
+	}[e.Scope]
+	if label == "" {
+		label = e.Scope
+	}
+	return fmt.Sprintf("%s超时（限制 %s）", label, e.Limit)
+}
+
+// withTimeout derives a context that expires after seconds (0 = no limit)
+// with a *TimeoutError cause. Expiry cancels the context, so running scripts
+// are killed through cmd.Cancel (killBuildCmdProcess, whole process group).
+func withTimeout(ctx context.Context, scope string, seconds int) (context.Context, context.CancelFunc) {
+	if seconds <= 0 {
+		return context.WithCancel(ctx)
+	}
+	d := time.Duration(seconds) * time.Second
+	return context.WithTimeoutCause(ctx, d, &TimeoutError{Scope: scope, Limit: d})
+}
+
+// timeoutCause returns the limit that stopped ctx, or nil for a manual cancel.
+func timeoutCause(ctx context.Context) *TimeoutError {
+	var te *TimeoutError
+	if errors.As(context.Cause(ctx), &te) {
+		return te
+	}
+	return nil
+}
+
+// stopRun finishes a run whose context ended: timed_out when a limit fired,
+// cancelled otherwise.
+func (p *Pipeline) stopRun(run *model.BuildRun, ctx context.Context, writeLine func(string)) {
+	te := timeoutCause(ctx)
+	if te == nil {
+		p.cancelRun(run)
+		return
+	}
+	writeLine("ERROR: " + te.Error())
+	p.timeoutRun(run, te.Error())
+}
+
+func (p *Pipeline) timeoutRun(run *model.BuildRun, errMsg string) {
+	latest, err := p.runs.FindByID(run.ID)
+	if err == nil && latest.Status == "success" {
+		// Build already succeeded; only the distribution phase ran out of time.
+		_ = p.runs.UpdateFields(run.ID, map[string]interface{}{
+			"stage":                "idle",
+			"distribution_summary": RunStatusTimedOut,
+		})
+		p.broadcastRunRefresh(run.ID)
+		return
+	}
+	finished := time.Now()
+	fields := map[string]interface{}{
+		"status":        RunStatusTimedOut,
+		"error_message": errMsg,
+		"finished_at":   finished,
+		"stage":         run.Stage,
+	}
+	if run.StartedAt != nil {
+		fields["duration_ms"] = finished.Sub(*run.StartedAt).Milliseconds()
+	}
+	_ = p.runs.UpdateFields(run.ID, fields)
+	p.broadcastRunRefresh(run.ID)
+	p.statuses.Report(run.ID, CommitStateError, "Build timed out")
+	p.notifyTerminal(run, RunStatusTimedOut, errMsg)
+	p.rollupMatrixParent(run.ID)
+}
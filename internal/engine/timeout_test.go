@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"bedrock/internal/cicd/model"
+	resourcemodel "bedrock/internal/resource/model"
+)
+
+func TestBuildTimeoutKillsScriptAndFreesCron(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("sh script")
+	}
+	repoDir := initLocalGitRepo(t)
+	tmp := t.TempDir()
+	run := &model.BuildRun{ID: 1, BuildJobID: 10, BuildNumber: 1, Status: "queued", Stage: "pending", Branch: "main", TriggeredBy: 7}
+	store := newMemRunStore(run)
+	jobStore := &memJobStore{job: &model.BuildJob{
+		ID: 10, RepositoryID: 1, Branch: "main",
+		// The background child keeps stdout open: only a process-group kill ends the run.
+		BuildScript:         "sleep 30 & sleep 30",
+		BuildTimeoutSeconds: 1,
+	}}
+	repoStore := &memRepoStore{repo: &resourcemodel.Repository{ID: 1, RepoURL: repoDir, AuthType: "none"}}
+	p := NewPipeline(store, jobStore, repoStore, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(),
+		filepath.Join(tmp, "ws"), filepath.Join(tmp, "a"), filepath.Join(tmp, "l"), filepath.Join(tmp, "c"))
+	notes := &recordingNotifier{}
+	p.SetTerminalNotifier(notes)
+
+	start := time.Now()
+	p.Execute(context.Background(), 1)
+	if d := time.Since(start); d > 15*time.Second {
+		t.Fatalf("timed-out build took %s", d)
+	}
+	got, _ := store.FindByID(1)
+	if got.Status != RunStatusTimedOut || got.Stage != "building" {
+		t.Fatalf("status=%s stage=%s", got.Status, got.Stage)
+	}
+	if !strings.Contains(got.ErrorMessage, "构建阶段超时") || got.FinishedAt == nil {
+		t.Fatalf("error=%q finished=%v", got.ErrorMessage, got.FinishedAt)
+	}
+	if len(notes.statuses) != 1 || notes.statuses[0] != RunStatusTimedOut {
+		t.Fatalf("notifications=%v", notes.statuses)
+	}
+
+	var enqueued int
+	enq := &stubEnqueuer{fn: func(jobID, _ uint, _ EnqueueParams) (*model.BuildRun, error) {
+		enqueued++
+		return &model.BuildRun{ID: 2, BuildJobID: jobID, Status: "queued"}, nil
+	}}
+	NewCronScheduler(jobStore, store, enq, nil, zap.NewNop()).TriggerNow(10)
+	if enqueued != 1 {
+		t.Fatal("cron must fire again once the stuck run timed out")
+	}
+}
+
+func TestStopRun_manualCancelIsNotTimeout(t *testing.T) {
+	t.Parallel()
+	tmp := t.TempDir()
+	run := &model.BuildRun{ID: 1, BuildJobID: 10, Status: "running", Stage: "building"}
+	store := newMemRunStore(run)
+	p := NewPipeline(store, &memJobStore{}, &memRepoStore{}, &memServerStore{}, nopSecrets{}, nil, zap.NewNop(), tmp, tmp, tmp, tmp)
+
+	ctx, cancel := withTimeout(context.Background(), TimeoutJob, 60)
+	cancel()
+	if timeoutCause(ctx) != nil {
+		t.Fatal("manual cancel reported as timeout")
+	}
+	p.stopRun(run, ctx, func(string) {})
+	if got, _ := store.FindByID(1); got.Status != "cancelled" {
+		t.Fatalf("status=%s want cancelled", got.Status)
+	}
+
+	// A job limit surfaces through a stage context derived from it.
+	jobCtx, cancelJob := withTimeout(context.Background(), TimeoutJob, 1)
+	defer cancelJob()
+	stageCtx, cancelStage := withTimeout(jobCtx, TimeoutBuild, 0)
+	defer cancelStage()
+	<-stageCtx.Done()
+	if te := timeoutCause(stageCtx); te == nil || te.Scope != TimeoutJob || te.Limit != time.Second {
+		t.Fatalf("cause=%v", context.Cause(stageCtx))
+	}
+}
+
+type recordingNotifier struct{ statuses []string }
+
+func (r *recordingNotifier) NotifyBuildRun(_ uint, _ uint, _ int, status, _ string) {
+	r.statuses = append(r.statuses, status)
+}
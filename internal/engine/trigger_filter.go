@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	resourcemodel "bedrock/internal/resource/model"
+)
+
+// Webhook trigger filters. BuildJob.BranchPatterns and PathIncludes /
+// PathExcludes hold one glob per line; "*" matches within a path segment and
+// a "**" segment matches any number of segments (release/*, feature/**,
+// services/api/**).
+
+// ParseFilterPatterns splits a stored pattern list (one per line or JSON array).
+func ParseFilterPatterns(raw string) []string {
+	return parseCachePaths(raw)
+}
+
+// ValidateFilterPatterns checks the glob syntax of every segment.
+func ValidateFilterPatterns(patterns []string) error {
+	for _, p := range patterns {
+		for _, seg := range strings.Split(p, "/") {
+			if _, err := path.Match(seg, ""); err != nil {
+				return fmt.Errorf("%q: bad glob pattern", p)
+			}
+		}
+	}
+	return nil
+}
+
+// MatchBranch reports whether branch matches one of the patterns.
+func MatchBranch(patterns []string, branch string) bool {
+	for _, p := range patterns {
+		if matchGlobPath(p, branch) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchChangedPaths reports whether any changed file is included (no
+// includes = every file) and not excluded.
+func MatchChangedPaths(includes, excludes, files []string) bool {
+	for _, f := range files {
+		if len(includes) > 0 && !MatchBranch(includes, f) {
+			continue
+		}
+		if MatchBranch(excludes, f) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// changedFilesTimeout bounds the fetch behind a webhook's path filter.
+const changedFilesTimeout = 60 * time.Second
+
+// GitDiffResolver lists a repository's changed files between two commits
+// for webhook path filters whose push payload does not list them.
+type GitDiffResolver struct {
+	repos   RepoStore
+	secrets SecretResolver
+}
+
+func NewGitDiffResolver(repos RepoStore, secrets SecretResolver) *GitDiffResolver {
+	return &GitDiffResolver{repos: repos, secrets: secrets}
+}
+
+func (r *GitDiffResolver) ChangedFiles(repositoryID uint, before, after string) ([]string, error) {
+	repo, err := r.repos.FindByID(repositoryID)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := repoGitAuth(repo, r.secrets)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), changedFilesTimeout)
+	defer cancel()
+	return GitChangedFiles(ctx, repo.RepoURL, auth, before, after)
+}
+
+// repoGitAuth resolves the credential a repository is cloned with.
+func repoGitAuth(repo *resourcemodel.Repository, secrets SecretResolver) (GitAuth, error) {
+	switch strings.ToLower(strings.TrimSpace(repo.AuthType)) {
+	case "", "none":
+		return GitAuth{Type: GitAuthNone}, nil
+	case "credential":
+		if repo.CredentialID == nil || *repo.CredentialID == 0 {
+			return GitAuth{}, fmt.Errorf("repository credential is empty")
+		}
+		typ, user, secret, passphrase, err := secrets.Resolve(*repo.CredentialID)
+		if err != nil {
+			return GitAuth{}, err
+		}
+		auth := NewGitAuth(typ, user, secret, passphrase)
+		auth.KnownHosts = repo.SSHKnownHosts
+		return auth, nil
+	default:
+		return GitAuth{Type: GitAuthNone}, nil
+	}
+}
@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"os/exec"
+	"slices"
+	"testing"
+
+	resourcemodel "bedrock/internal/resource/model"
+)
+
+func TestMatchBranchAndChangedPaths(t *testing.T) {
+	t.Parallel()
+	patterns := []string{"main", "release/*", "feature/**"}
+	for branch, want := range map[string]bool{
+		"main": true, "release/1.2": true, "release/1.2/hotfix": false,
+		"feature/a": true, "feature/team/a": true, "develop": false,
+	} {
+		if got := MatchBranch(patterns, branch); got != want {
+			t.Errorf("MatchBranch(%s)=%v want %v", branch, got, want)
+		}
+	}
+
+	includes := []string{"services/api/**", "go.mod"}
+	excludes := []string{"**/*.md"}
+	cases := []struct {
+		files []string
+		want  bool
+	}{
+		{[]string{"README.md"}, false},
+		{[]string{"services/web/main.go"}, false},
+		{[]string{"services/api/README.md"}, false},
+		{[]string{"services/api/README.md", "services/api/cmd/main.go"}, true},
+		{[]string{"go.mod"}, true},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if got := MatchChangedPaths(includes, excludes, c.files); got != c.want {
+			t.Errorf("MatchChangedPaths(%q)=%v want %v", c.files, got, c.want)
+		}
+	}
+	if !MatchChangedPaths(nil, excludes, []string{"main.go"}) || MatchChangedPaths(nil, excludes, []string{"docs/a.md"}) {
+		t.Error("exclude-only filter")
+	}
+	if err := ValidateFilterPatterns([]string{"services/[a-/**"}); err == nil {
+		t.Error("expected bad glob error")
+	}
+}
+
+func TestGitDiffResolver(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	src := t.TempDir()
+	gitIn(t, src, "init", "-q", "-b", "main")
+	before := commitFile(t, src, "services/api/main.go", "package main")
+	commitFile(t, src, "README.md", "docs")
+	gitIn(t, src, "mv", "services/api/main.go", "services/api/app.go")
+	gitIn(t, src, "commit", "-q", "-m", "rename")
+	after := gitIn(t, src, "rev-parse", "HEAD")
+
+	r := NewGitDiffResolver(&memRepoStore{repo: &resourcemodel.Repository{ID: 1, RepoURL: src, AuthType: "none"}}, nopSecrets{})
+	files, err := r.ChangedFiles(1, before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"README.md", "services/api/app.go", "services/api/main.go"}
+	if !slices.Equal(files, want) {
+		t.Fatalf("files=%q want %q", files, want)
+	}
+	if _, err := r.ChangedFiles(1, before, "0123456789012345678901234567890123456789"); err == nil {
+		t.Fatal("expected error for an unknown commit")
+	}
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxGlobMatches bounds report discovery so a broad pattern cannot flood a run.
+const maxGlobMatches = 500
+
+// globWorkspace returns files under workDir (slash-separated, relative) that
+// match any pattern. Patterns are relative to the repository root and support
+// "**" for any number of directories, e.g. "**/TEST-*.xml". .git is skipped.
+func globWorkspace(workDir string, patterns []string) ([]string, error) {
+	var clean []string
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if err := validateRelPath(p); err != nil {
+			return nil, fmt.Errorf("%q %w", p, err)
+		}
+		clean = append(clean, path.Clean(filepath.ToSlash(p)))
+	}
+	if len(clean) == 0 {
+		return nil, nil
+	}
+	var out []string
+	err := filepath.WalkDir(workDir, func(full string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(workDir, full)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		for _, p := range clean {
+			if matchGlobPath(p, rel) {
+				out = append(out, rel)
+				break
+			}
+		}
+		if len(out) >= maxGlobMatches {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	sort.Strings(out)
+	return out, err
+}
+
+// matchGlobPath matches a slash path against pattern segment by segment;
+// a "**" segment matches zero or more path segments.
+func matchGlobPath(pattern, name string) bool {
+	return matchGlobSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchGlobSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			rest := pat[1:]
+			for i := 0; i <= len(name); i++ {
+				if matchGlobSegments(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pat[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RedactMask replaces secret values in logs.
+const RedactMask = "***"
+
+// minRedactLen skips values too short to redact without mangling unrelated output.
+const minRedactLen = 4
+
+// Redactor masks every registered secret, plus its base64 and URL-encoded
+// forms, in log lines. It is safe for concurrent use (stdout/stderr scanners
+// share one per run).
+type Redactor struct {
+	mu     sync.RWMutex
+	seen   map[string]struct{}
+	values []string // longest first so overlapping secrets mask fully
+}
+
+func NewRedactor(secrets ...string) *Redactor {
+	r := &Redactor{seen: map[string]struct{}{}}
+	r.Add(secrets...)
+	return r
+}
+
+// Add registers secrets. Multi-line values (private keys) also register each
+// line, since logs are written line by line.
+func (r *Redactor) Add(secrets ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changed := false
+	for _, s := range secrets {
+		for _, v := range redactForms(s) {
+			if len(v) < minRedactLen {
+				continue
+			}
+			if _, ok := r.seen[v]; ok {
+				continue
+			}
+			r.seen[v] = struct{}{}
+			r.values = append(r.values, v)
+			changed = true
+		}
+	}
+	if changed {
+		sort.SliceStable(r.values, func(i, j int) bool { return len(r.values[i]) > len(r.values[j]) })
+	}
+}
+
+// Redact returns text with every registered secret replaced by RedactMask.
+func (r *Redactor) Redact(text string) string {
+	if r == nil || text == "" {
+		return text
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, v := range r.values {
+		if strings.Contains(text, v) {
+			text = strings.ReplaceAll(text, v, RedactMask)
+		}
+	}
+	return text
+}
+
+func redactForms(secret string) []string {
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		return nil
+	}
+	forms := []string{secret}
+	if strings.Contains(secret, "\n") {
+		for _, line := range strings.Split(secret, "\n") {
+			forms = append(forms, strings.TrimSpace(line))
+		}
+	}
+	forms = append(forms,
+		base64.StdEncoding.EncodeToString([]byte(secret)),
+		base64.RawStdEncoding.EncodeToString([]byte(secret)),
+		base64.URLEncoding.EncodeToString([]byte(secret)),
+		base64.RawURLEncoding.EncodeToString([]byte(secret)),
+		url.QueryEscape(secret),
+		url.PathEscape(secret),
+		// userinfo escaping as used in authenticated clone URLs
+		strings.TrimPrefix(url.UserPassword("", secret).String(), ":"),
+	)
+	return forms
+}
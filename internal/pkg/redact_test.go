@@ -0,0 +1,43 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedactor_masksEncodedForms(t *testing.T) {
+	const secret = "p@ss/w0rd+tok"
+	r := NewRedactor(secret, "", "ab")
+	lines := []string{
+		"echo " + secret,
+		"Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(secret)),
+		"curl https://x/?t=" + url.QueryEscape(secret),
+		"clone https://" + url.UserPassword("oauth2", secret).String() + "@git.example.com/r.git",
+	}
+	for _, line := range lines {
+		got := r.Redact(line)
+		if strings.Contains(got, "w0rd") || !strings.Contains(got, RedactMask) {
+			t.Fatalf("not redacted: %q -> %q", line, got)
+		}
+	}
+	if got := r.Redact("grab the cab"); got != "grab the cab" {
+		t.Fatalf("short values must not be masked: %q", got)
+	}
+}
+
+func TestRedactor_multilineKeyAndLateAdd(t *testing.T) {
+	var nilR *Redactor
+	if nilR.Redact("x") != "x" {
+		t.Fatal("nil redactor must pass through")
+	}
+	r := NewRedactor()
+	if r.Redact("token-value-123") != "token-value-123" {
+		t.Fatal("nothing registered yet")
+	}
+	r.Add("token-value-123", "-----BEGIN KEY-----\nMIIEowIBAAKCAQEA\n-----END KEY-----")
+	if got := r.Redact("leak token-value-123 MIIEowIBAAKCAQEA"); got != "leak *** ***" {
+		t.Fatalf("got %q", got)
+	}
+}
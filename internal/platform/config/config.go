@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"path/filepath"
 	"strings"
 	"time"
@@ -12,6 +13,9 @@ import (
 type ServerConfig struct {
 	Port int    `mapstructure:"port"`
 	Host string `mapstructure:"host"`
+	// PublicURL is the externally reachable base URL (e.g. https://ci.example.com),
+	// used for links sent to Git platforms. Empty = no links.
+	PublicURL string `mapstructure:"public_url"`
 }
 
 // DatabaseConfig supports sqlite | postgres | mysql.
@@ -42,6 +46,17 @@ type BuildConfig struct {
 	ArtifactDir   string `mapstructure:"artifact_dir"`
 	LogDir        string `mapstructure:"log_dir"`
 	CacheDir      string `mapstructure:"cache_dir"`
+	// LogCompressAfter gzips run logs older than this duration; "0" disables.
+	LogCompressAfter string `mapstructure:"log_compress_after"`
+	// ArtifactSweepInterval is how often job artifact retention runs; "0" disables.
+	ArtifactSweepInterval string `mapstructure:"artifact_sweep_interval"`
+	// CacheMaxBytes caps the build cache; least recently used entries go first. 0 = no limit.
+	CacheMaxBytes int64 `mapstructure:"cache_max_bytes"`
+	// CacheSweepInterval is how often the build cache is trimmed; "0" disables.
+	CacheSweepInterval string `mapstructure:"cache_sweep_interval"`
+	// CacheHardlinks lets cache restores hardlink files where reflinks are
+	// unsupported; false copies them instead.
+	CacheHardlinks bool `mapstructure:"cache_hardlinks"`
 }
 
 // StorageConfig controls the content-addressed upload store. Limits are bytes.
@@ -49,6 +64,7 @@ type StorageConfig struct {
 	Root               string `mapstructure:"root"`
 	AttachmentMaxBytes int64  `mapstructure:"attachment_max_bytes"`
 	DocImportMaxBytes  int64  `mapstructure:"doc_import_max_bytes"`
+	ArtifactMaxBytes   int64  `mapstructure:"artifact_max_bytes"`
 }
 
 type EncryptionConfig struct {
@@ -99,9 +115,15 @@ func Load(configPath string) (*Config, error) {
 	v.SetDefault("jwt.access_ttl", "2h")
 	v.SetDefault("jwt.refresh_ttl", "168h")
 	v.SetDefault("build.max_concurrent", 3)
+	v.SetDefault("build.log_compress_after", "168h")
+	v.SetDefault("build.artifact_sweep_interval", "1h")
+	v.SetDefault("build.cache_max_bytes", 10*1024*1024*1024)
+	v.SetDefault("build.cache_sweep_interval", "30m")
+	v.SetDefault("build.cache_hardlinks", true)
 	v.SetDefault("storage.root", "./data/storage")
 	v.SetDefault("storage.attachment_max_bytes", 20*1024*1024)
 	v.SetDefault("storage.doc_import_max_bytes", 100*1024*1024)
+	v.SetDefault("storage.artifact_max_bytes", 2*1024*1024*1024)
 
 	if err := v.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
@@ -182,11 +204,35 @@ func (c *Config) Validate() error {
 	default:
 		return fmt.Errorf("unsupported database.driver %q (want sqlite|postgres|mysql)", c.Database.Driver)
 	}
+	if c.Server.PublicURL != "" {
+		u, err := url.Parse(c.Server.PublicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid server.public_url %q (want http(s)://host[/path])", c.Server.PublicURL)
+		}
+	}
 	if c.Database.ConnMaxLifetime != "" {
 		if _, err := time.ParseDuration(c.Database.ConnMaxLifetime); err != nil {
 			return fmt.Errorf("invalid database.conn_max_lifetime: %w", err)
 		}
 	}
+	if c.Build.LogCompressAfter != "" {
+		if _, err := time.ParseDuration(c.Build.LogCompressAfter); err != nil {
+			return fmt.Errorf("invalid build.log_compress_after: %w", err)
+		}
+	}
+	if c.Build.ArtifactSweepInterval != "" {
+		if _, err := time.ParseDuration(c.Build.ArtifactSweepInterval); err != nil {
+			return fmt.Errorf("invalid build.artifact_sweep_interval: %w", err)
+		}
+	}
+	if c.Build.CacheMaxBytes < 0 {
+		return fmt.Errorf("build.cache_max_bytes must not be negative")
+	}
+	if c.Build.CacheSweepInterval != "" {
+		if _, err := time.ParseDuration(c.Build.CacheSweepInterval); err != nil {
+			return fmt.Errorf("invalid build.cache_sweep_interval: %w", err)
+		}
+	}
 	if c.Storage.Root == "" {
 		return fmt.Errorf("storage.root is required")
 	}
@@ -196,6 +242,9 @@ func (c *Config) Validate() error {
 	if c.Storage.DocImportMaxBytes <= 0 {
 		return fmt.Errorf("storage.doc_import_max_bytes must be greater than zero")
 	}
+	if c.Storage.ArtifactMaxBytes <= 0 {
+		return fmt.Errorf("storage.artifact_max_bytes must be greater than zero")
+	}
 	return nil
 }
 
@@ -210,6 +259,33 @@ func (c *DatabaseConfig) ConnMaxLifetimeDuration() time.Duration {
 	return d
 }
 
+// LogCompressAfterDuration returns 0 when compression is disabled.
+func (c *BuildConfig) LogCompressAfterDuration() time.Duration {
+	d, err := time.ParseDuration(c.LogCompressAfter)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// ArtifactSweepIntervalDuration returns 0 when the sweeper is disabled.
+func (c *BuildConfig) ArtifactSweepIntervalDuration() time.Duration {
+	d, err := time.ParseDuration(c.ArtifactSweepInterval)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// CacheSweepIntervalDuration returns 0 when cache eviction is disabled.
+func (c *BuildConfig) CacheSweepIntervalDuration() time.Duration {
+	d, err := time.ParseDuration(c.CacheSweepInterval)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
 func resolvePath(baseDir, targetPath string) string {
 	if targetPath == "" || filepath.IsAbs(targetPath) {
 		return targetPath
@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000027_pipeline_as_code", upPipelineAsCode)
+}
+
+func upPipelineAsCode(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	job := &buildJobPipelineFileMigrationModel{}
+	if !db.Migrator().HasColumn(job, "pipeline_file") {
+		if err := db.Migrator().AddColumn(job, "PipelineFile"); err != nil {
+			return err
+		}
+	}
+	run := &buildRunArtifactsMigrationModel{}
+	if !db.Migrator().HasColumn(run, "artifacts_json") {
+		if err := db.Migrator().AddColumn(run, "ArtifactsJSON"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buildJobPipelineFileMigrationModel struct {
+	ID           uint   `gorm:"primaryKey"`
+	PipelineFile string `gorm:"size:300"`
+}
+
+func (buildJobPipelineFileMigrationModel) TableName() string { return "build_jobs" }
+
+type buildRunArtifactsMigrationModel struct {
+	ID            uint   `gorm:"primaryKey"`
+	ArtifactsJSON string `gorm:"type:text"`
+}
+
+func (buildRunArtifactsMigrationModel) TableName() string { return "build_runs" }
@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000028_build_matrix", upBuildMatrix)
+}
+
+func upBuildMatrix(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	job := &buildJobMatrixMigrationModel{}
+	if !db.Migrator().HasColumn(job, "matrix_json") {
+		if err := db.Migrator().AddColumn(job, "MatrixJSON"); err != nil {
+			return err
+		}
+	}
+	run := &buildRunMatrixMigrationModel{}
+	for _, col := range []struct{ column, field string }{
+		{"parent_run_id", "ParentRunID"},
+		{"matrix_cell_json", "MatrixCellJSON"},
+		{"matrix_summary", "MatrixSummary"},
+	} {
+		if db.Migrator().HasColumn(run, col.column) {
+			continue
+		}
+		if err := db.Migrator().AddColumn(run, col.field); err != nil {
+			return err
+		}
+	}
+	if !db.Migrator().HasIndex(run, "idx_build_runs_parent_run_id") {
+		if err := db.Migrator().CreateIndex(run, "idx_build_runs_parent_run_id"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buildJobMatrixMigrationModel struct {
+	ID         uint   `gorm:"primaryKey"`
+	MatrixJSON string `gorm:"type:text"`
+}
+
+func (buildJobMatrixMigrationModel) TableName() string { return "build_jobs" }
+
+type buildRunMatrixMigrationModel struct {
+	ID             uint   `gorm:"primaryKey"`
+	ParentRunID    *uint  `gorm:"index:idx_build_runs_parent_run_id"`
+	MatrixCellJSON string `gorm:"type:text"`
+	MatrixSummary  string `gorm:"size:30;default:none"`
+}
+
+func (buildRunMatrixMigrationModel) TableName() string { return "build_runs" }
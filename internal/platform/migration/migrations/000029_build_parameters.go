@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000029_build_parameters", upBuildParameters)
+}
+
+func upBuildParameters(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	job := &buildJobParametersMigrationModel{}
+	if !db.Migrator().HasColumn(job, "parameters_json") {
+		if err := db.Migrator().AddColumn(job, "ParametersJSON"); err != nil {
+			return err
+		}
+	}
+	run := &buildRunParamsMigrationModel{}
+	if !db.Migrator().HasColumn(run, "params_cipher") {
+		if err := db.Migrator().AddColumn(run, "ParamsCipher"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buildJobParametersMigrationModel struct {
+	ID             uint   `gorm:"primaryKey"`
+	ParametersJSON string `gorm:"type:text"`
+}
+
+func (buildJobParametersMigrationModel) TableName() string { return "build_jobs" }
+
+type buildRunParamsMigrationModel struct {
+	ID           uint   `gorm:"primaryKey"`
+	ParamsCipher string `gorm:"type:text"`
+}
+
+func (buildRunParamsMigrationModel) TableName() string { return "build_runs" }
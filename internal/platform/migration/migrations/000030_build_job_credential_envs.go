@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000030_build_job_credential_envs", upBuildJobCredentialEnvs)
+}
+
+func upBuildJobCredentialEnvs(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+	if db.Migrator().HasTable(&buildJobCredentialEnvMigrationModel{}) {
+		return nil
+	}
+	return db.Migrator().CreateTable(&buildJobCredentialEnvMigrationModel{})
+}
+
+// buildJobCredentialEnvMigrationModel binds a Credential to a BuildJob as an env var.
+type buildJobCredentialEnvMigrationModel struct {
+	ID           uint   `gorm:"primaryKey"`
+	BuildJobID   uint   `gorm:"index;not null"`
+	Name         string `gorm:"size:100;not null"`
+	CredentialID uint   `gorm:"index;not null"`
+	Field        string `gorm:"size:20;not null;default:secret"`
+	CreatedAt    time.Time
+}
+
+func (buildJobCredentialEnvMigrationModel) TableName() string { return "build_job_credential_envs" }
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000031_build_run_log_stages", upBuildRunLogStages)
+}
+
+func upBuildRunLogStages(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	run := &buildRunLogStagesMigrationModel{}
+	if !db.Migrator().HasColumn(run, "log_stages_json") {
+		if err := db.Migrator().AddColumn(run, "LogStagesJSON"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buildRunLogStagesMigrationModel struct {
+	ID            uint   `gorm:"primaryKey"`
+	LogStagesJSON string `gorm:"type:text"`
+}
+
+func (buildRunLogStagesMigrationModel) TableName() string { return "build_runs" }
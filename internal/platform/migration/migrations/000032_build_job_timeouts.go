@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000032_build_job_timeouts", upBuildJobTimeouts)
+}
+
+func upBuildJobTimeouts(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	job := &buildJobTimeoutsMigrationModel{}
+	for _, field := range []string{"TimeoutSeconds", "CloneTimeoutSeconds", "BuildTimeoutSeconds", "DistributeTimeoutSeconds"} {
+		if db.Migrator().HasColumn(job, field) {
+			continue
+		}
+		if err := db.Migrator().AddColumn(job, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buildJobTimeoutsMigrationModel struct {
+	ID                       uint `gorm:"primaryKey"`
+	TimeoutSeconds           int  `gorm:"not null;default:0"`
+	CloneTimeoutSeconds      int  `gorm:"not null;default:0"`
+	BuildTimeoutSeconds      int  `gorm:"not null;default:0"`
+	DistributeTimeoutSeconds int  `gorm:"not null;default:0"`
+}
+
+func (buildJobTimeoutsMigrationModel) TableName() string { return "build_jobs" }
@@ -0,0 +1,85 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000033_build_test_reports", upBuildTestReports)
+}
+
+func upBuildTestReports(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	job := &buildJobTestReportsMigrationModel{}
+	if !db.Migrator().HasColumn(job, "test_report_paths") {
+		if err := db.Migrator().AddColumn(job, "TestReportPaths"); err != nil {
+			return err
+		}
+	}
+	run := &buildRunTestSummaryMigrationModel{}
+	if !db.Migrator().HasColumn(run, "test_summary_json") {
+		if err := db.Migrator().AddColumn(run, "TestSummaryJSON"); err != nil {
+			return err
+		}
+	}
+	for _, table := range []interface{}{&buildTestSuiteMigrationModel{}, &buildTestCaseMigrationModel{}} {
+		if db.Migrator().HasTable(table) {
+			continue
+		}
+		if err := db.Migrator().CreateTable(table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buildJobTestReportsMigrationModel struct {
+	ID              uint   `gorm:"primaryKey"`
+	TestReportPaths string `gorm:"type:text"`
+}
+
+func (buildJobTestReportsMigrationModel) TableName() string { return "build_jobs" }
+
+type buildRunTestSummaryMigrationModel struct {
+	ID              uint   `gorm:"primaryKey"`
+	TestSummaryJSON string `gorm:"type:text"`
+}
+
+func (buildRunTestSummaryMigrationModel) TableName() string { return "build_runs" }
+
+type buildTestSuiteMigrationModel struct {
+	ID         uint   `gorm:"primaryKey"`
+	BuildRunID uint   `gorm:"index;not null"`
+	Name       string `gorm:"size:300"`
+	File       string `gorm:"size:500"`
+	Tests      int
+	Failures   int
+	Errors     int
+	Skipped    int
+	DurationMs int64
+	CreatedAt  time.Time
+}
+
+func (buildTestSuiteMigrationModel) TableName() string { return "build_test_suites" }
+
+type buildTestCaseMigrationModel struct {
+	ID         uint   `gorm:"primaryKey"`
+	BuildRunID uint   `gorm:"index;not null"`
+	SuiteID    uint   `gorm:"index;not null"`
+	SuiteName  string `gorm:"size:300"`
+	ClassName  string `gorm:"size:300"`
+	Name       string `gorm:"size:500"`
+	Status     string `gorm:"size:20;not null"`
+	DurationMs int64
+	Message    string `gorm:"type:text"`
+	Details    string `gorm:"type:text"`
+}
+
+func (buildTestCaseMigrationModel) TableName() string { return "build_test_cases" }
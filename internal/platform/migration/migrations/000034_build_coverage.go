@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000034_build_coverage", upBuildCoverage)
+}
+
+func upBuildCoverage(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	job := &buildJobCoverageMigrationModel{}
+	if !db.Migrator().HasColumn(job, "coverage_report_paths") {
+		if err := db.Migrator().AddColumn(job, "CoverageReportPaths"); err != nil {
+			return err
+		}
+	}
+	if !db.Migrator().HasColumn(job, "coverage_threshold") {
+		if err := db.Migrator().AddColumn(job, "CoverageThreshold"); err != nil {
+			return err
+		}
+	}
+	run := &buildRunCoverageMigrationModel{}
+	if !db.Migrator().HasColumn(run, "coverage_json") {
+		if err := db.Migrator().AddColumn(run, "CoverageJSON"); err != nil {
+			return err
+		}
+	}
+	if !db.Migrator().HasColumn(run, "coverage_percent") {
+		if err := db.Migrator().AddColumn(run, "CoveragePercent"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buildJobCoverageMigrationModel struct {
+	ID                  uint    `gorm:"primaryKey"`
+	CoverageReportPaths string  `gorm:"type:text"`
+	CoverageThreshold   float64 `gorm:"not null;default:0"`
+}
+
+func (buildJobCoverageMigrationModel) TableName() string { return "build_jobs" }
+
+type buildRunCoverageMigrationModel struct {
+	ID              uint   `gorm:"primaryKey"`
+	CoverageJSON    string `gorm:"type:text"`
+	CoveragePercent *float64
+}
+
+func (buildRunCoverageMigrationModel) TableName() string { return "build_runs" }
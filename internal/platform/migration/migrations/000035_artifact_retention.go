@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000035_artifact_retention", upArtifactRetention)
+}
+
+func upArtifactRetention(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	job := &buildJobRetentionMigrationModel{}
+	if !db.Migrator().HasColumn(job, "artifact_max_age_days") {
+		if err := db.Migrator().AddColumn(job, "ArtifactMaxAgeDays"); err != nil {
+			return err
+		}
+	}
+	if !db.Migrator().HasColumn(job, "artifact_max_total_mb") {
+		if err := db.Migrator().AddColumn(job, "ArtifactMaxTotalMB"); err != nil {
+			return err
+		}
+	}
+	run := &buildRunRetentionMigrationModel{}
+	if !db.Migrator().HasColumn(run, "artifact_pinned") {
+		if err := db.Migrator().AddColumn(run, "ArtifactPinned"); err != nil {
+			return err
+		}
+	}
+	if !db.Migrator().HasColumn(run, "artifact_removed_at") {
+		if err := db.Migrator().AddColumn(run, "ArtifactRemovedAt"); err != nil {
+			return err
+		}
+	}
+	if !db.Migrator().HasColumn(run, "artifact_removed_reason") {
+		if err := db.Migrator().AddColumn(run, "ArtifactRemovedReason"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buildJobRetentionMigrationModel struct {
+	ID                 uint `gorm:"primaryKey"`
+	ArtifactMaxAgeDays int  `gorm:"not null;default:0"`
+	ArtifactMaxTotalMB int  `gorm:"not null;default:0"`
+}
+
+func (buildJobRetentionMigrationModel) TableName() string { return "build_jobs" }
+
+type buildRunRetentionMigrationModel struct {
+	ID                    uint `gorm:"primaryKey"`
+	ArtifactPinned        bool `gorm:"not null;default:false"`
+	ArtifactRemovedAt     *time.Time
+	ArtifactRemovedReason string `gorm:"size:20"`
+}
+
+func (buildRunRetentionMigrationModel) TableName() string { return "build_runs" }
@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000036_artifact_storage", upArtifactStorage)
+}
+
+func upArtifactStorage(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	run := &buildRunArtifactStorageMigrationModel{}
+	if !db.Migrator().HasColumn(run, "artifact_object_id") {
+		if err := db.Migrator().AddColumn(run, "ArtifactObjectID"); err != nil {
+			return err
+		}
+	}
+	if !db.Migrator().HasColumn(run, "artifact_sha256") {
+		if err := db.Migrator().AddColumn(run, "ArtifactSHA256"); err != nil {
+			return err
+		}
+	}
+	if !db.Migrator().HasColumn(run, "artifact_name") {
+		if err := db.Migrator().AddColumn(run, "ArtifactName"); err != nil {
+			return err
+		}
+	}
+	attempt := &buildDeployAttemptArtifactMigrationModel{}
+	if !db.Migrator().HasColumn(attempt, "artifact_sha256") {
+		if err := db.Migrator().AddColumn(attempt, "ArtifactSHA256"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buildRunArtifactStorageMigrationModel struct {
+	ID               uint `gorm:"primaryKey"`
+	ArtifactObjectID *uint
+	ArtifactSHA256   string `gorm:"size:64"`
+	ArtifactName     string `gorm:"size:200"`
+}
+
+func (buildRunArtifactStorageMigrationModel) TableName() string { return "build_runs" }
+
+type buildDeployAttemptArtifactMigrationModel struct {
+	ID             uint   `gorm:"primaryKey"`
+	ArtifactSHA256 string `gorm:"size:64"`
+}
+
+func (buildDeployAttemptArtifactMigrationModel) TableName() string { return "build_deploy_attempts" }
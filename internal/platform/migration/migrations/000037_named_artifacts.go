@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000037_named_artifacts", upNamedArtifacts)
+}
+
+func upNamedArtifacts(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	job := &buildJobArtifactDefsMigrationModel{}
+	if !db.Migrator().HasColumn(job, "artifact_defs_json") {
+		if err := db.Migrator().AddColumn(job, "ArtifactDefsJSON"); err != nil {
+			return err
+		}
+	}
+	target := &deployTargetArtifactMigrationModel{}
+	if !db.Migrator().HasColumn(target, "artifact_name") {
+		if err := db.Migrator().AddColumn(target, "ArtifactName"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buildJobArtifactDefsMigrationModel struct {
+	ID               uint   `gorm:"primaryKey"`
+	ArtifactDefsJSON string `gorm:"type:text"`
+}
+
+func (buildJobArtifactDefsMigrationModel) TableName() string { return "build_jobs" }
+
+type deployTargetArtifactMigrationModel struct {
+	ID           uint   `gorm:"primaryKey"`
+	ArtifactName string `gorm:"size:100"`
+}
+
+func (deployTargetArtifactMigrationModel) TableName() string { return "deploy_targets" }
@@ -0,0 +1,57 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000038_build_cache_keys", upBuildCacheKeys)
+}
+
+func upBuildCacheKeys(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	job := &buildJobCacheKeyMigrationModel{}
+	for _, field := range []string{"CacheKey", "CacheKeyFiles", "CacheRestoreKeys", "CacheShared"} {
+		if db.Migrator().HasColumn(job, field) {
+			continue
+		}
+		if err := db.Migrator().AddColumn(job, field); err != nil {
+			return err
+		}
+	}
+	run := &buildRunCacheMigrationModel{}
+	for _, field := range []string{"CacheStatus", "CacheKey", "CacheRestoredKey"} {
+		if db.Migrator().HasColumn(run, field) {
+			continue
+		}
+		if err := db.Migrator().AddColumn(run, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buildJobCacheKeyMigrationModel struct {
+	ID               uint   `gorm:"primaryKey"`
+	CacheKey         string `gorm:"size:100"`
+	CacheKeyFiles    string `gorm:"type:text"`
+	CacheRestoreKeys string `gorm:"type:text"`
+	CacheShared      bool   `gorm:"not null;default:false"`
+}
+
+func (buildJobCacheKeyMigrationModel) TableName() string { return "build_jobs" }
+
+type buildRunCacheMigrationModel struct {
+	ID               uint   `gorm:"primaryKey"`
+	CacheStatus      string `gorm:"size:20"`
+	CacheKey         string `gorm:"size:200"`
+	CacheRestoredKey string `gorm:"size:200"`
+}
+
+func (buildRunCacheMigrationModel) TableName() string { return "build_runs" }
@@ -0,0 +1,59 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000039_git_checkout_options", upGitCheckoutOptions)
+}
+
+func upGitCheckoutOptions(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	job := &buildJobGitCheckoutMigrationModel{}
+	for _, field := range []string{"GitRef", "GitSubmodules", "GitLFS", "SparseCheckoutPaths"} {
+		if db.Migrator().HasColumn(job, field) {
+			continue
+		}
+		if err := db.Migrator().AddColumn(job, field); err != nil {
+			return err
+		}
+	}
+	binding := &aiAgentRepoBindingCheckoutMigrationModel{}
+	for _, field := range []string{"Ref", "Shallow", "Submodules", "LFS", "SparsePaths"} {
+		if db.Migrator().HasColumn(binding, field) {
+			continue
+		}
+		if err := db.Migrator().AddColumn(binding, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buildJobGitCheckoutMigrationModel struct {
+	ID                  uint   `gorm:"primaryKey"`
+	GitRef              string `gorm:"size:300"`
+	GitSubmodules       bool   `gorm:"not null;default:false"`
+	GitLFS              bool   `gorm:"not null;default:false"`
+	SparseCheckoutPaths string `gorm:"type:text"`
+}
+
+func (buildJobGitCheckoutMigrationModel) TableName() string { return "build_jobs" }
+
+type aiAgentRepoBindingCheckoutMigrationModel struct {
+	ID          uint   `gorm:"primaryKey"`
+	Ref         string `gorm:"size:300"`
+	Shallow     bool   `gorm:"not null;default:false"`
+	Submodules  bool   `gorm:"not null;default:false"`
+	LFS         bool   `gorm:"not null;default:false"`
+	SparsePaths string `gorm:"type:text"`
+}
+
+func (aiAgentRepoBindingCheckoutMigrationModel) TableName() string { return "ai_agent_repo_bindings" }
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000040_repository_ssh_known_hosts", upRepositorySSHKnownHosts)
+}
+
+func upRepositorySSHKnownHosts(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	repo := &repositorySSHKnownHostsMigrationModel{}
+	if !db.Migrator().HasColumn(repo, "SSHKnownHosts") {
+		if err := db.Migrator().AddColumn(repo, "SSHKnownHosts"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type repositorySSHKnownHostsMigrationModel struct {
+	ID            uint   `gorm:"primaryKey"`
+	SSHKnownHosts string `gorm:"type:text"`
+}
+
+func (repositorySSHKnownHostsMigrationModel) TableName() string { return "repositories" }
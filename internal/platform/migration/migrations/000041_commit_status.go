@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000041_commit_status", upCommitStatus)
+}
+
+func upCommitStatus(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	job := &buildJobCommitStatusMigrationModel{}
+	if !db.Migrator().HasColumn(job, "ReportCommitStatus") {
+		if err := db.Migrator().AddColumn(job, "ReportCommitStatus"); err != nil {
+			return err
+		}
+	}
+	repo := &repositoryPlatformMigrationModel{}
+	if !db.Migrator().HasColumn(repo, "Platform") {
+		if err := db.Migrator().AddColumn(repo, "Platform"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buildJobCommitStatusMigrationModel struct {
+	ID                 uint `gorm:"primaryKey"`
+	ReportCommitStatus bool `gorm:"not null;default:true"`
+}
+
+func (buildJobCommitStatusMigrationModel) TableName() string { return "build_jobs" }
+
+type repositoryPlatformMigrationModel struct {
+	ID       uint   `gorm:"primaryKey"`
+	Platform string `gorm:"size:20"`
+}
+
+func (repositoryPlatformMigrationModel) TableName() string { return "repositories" }
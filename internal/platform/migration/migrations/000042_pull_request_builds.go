@@ -0,0 +1,59 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000042_pull_request_builds", upPullRequestBuilds)
+}
+
+func upPullRequestBuilds(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	job := &buildJobPullRequestMigrationModel{}
+	for _, field := range []string{"TriggerPullRequest", "PullRequestRef", "PullRequestDistribute"} {
+		if db.Migrator().HasColumn(job, field) {
+			continue
+		}
+		if err := db.Migrator().AddColumn(job, field); err != nil {
+			return err
+		}
+	}
+	run := &buildRunPullRequestMigrationModel{}
+	for _, field := range []string{"GitRef", "PRNumber", "PRSourceBranch", "PRTargetBranch", "PRAuthor", "PRHeadCommit"} {
+		if db.Migrator().HasColumn(run, field) {
+			continue
+		}
+		if err := db.Migrator().AddColumn(run, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buildJobPullRequestMigrationModel struct {
+	ID                    uint   `gorm:"primaryKey"`
+	TriggerPullRequest    bool   `gorm:"not null;default:false"`
+	PullRequestRef        string `gorm:"size:10;default:head"`
+	PullRequestDistribute bool   `gorm:"not null;default:false"`
+}
+
+func (buildJobPullRequestMigrationModel) TableName() string { return "build_jobs" }
+
+type buildRunPullRequestMigrationModel struct {
+	ID             uint   `gorm:"primaryKey"`
+	GitRef         string `gorm:"size:300"`
+	PRNumber       int    `gorm:"not null;default:0"`
+	PRSourceBranch string `gorm:"size:200"`
+	PRTargetBranch string `gorm:"size:200"`
+	PRAuthor       string `gorm:"size:100"`
+	PRHeadCommit   string `gorm:"size:64"`
+}
+
+func (buildRunPullRequestMigrationModel) TableName() string { return "build_runs" }
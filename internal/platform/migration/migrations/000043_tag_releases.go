@@ -0,0 +1,59 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000043_tag_releases", upTagReleases)
+}
+
+func upTagReleases(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	job := &buildJobTagMigrationModel{}
+	for _, field := range []string{"TriggerTag", "TagPatterns"} {
+		if db.Migrator().HasColumn(job, field) {
+			continue
+		}
+		if err := db.Migrator().AddColumn(job, field); err != nil {
+			return err
+		}
+	}
+	run := &buildRunReleaseMigrationModel{}
+	for _, field := range []string{"Tag", "ReleaseVersion"} {
+		if db.Migrator().HasColumn(run, field) {
+			continue
+		}
+		if err := db.Migrator().AddColumn(run, field); err != nil {
+			return err
+		}
+	}
+	if !db.Migrator().HasIndex(run, "idx_build_runs_release_version") {
+		if err := db.Migrator().CreateIndex(run, "idx_build_runs_release_version"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buildJobTagMigrationModel struct {
+	ID          uint   `gorm:"primaryKey"`
+	TriggerTag  bool   `gorm:"not null;default:false"`
+	TagPatterns string `gorm:"type:text"`
+}
+
+func (buildJobTagMigrationModel) TableName() string { return "build_jobs" }
+
+type buildRunReleaseMigrationModel struct {
+	ID             uint   `gorm:"primaryKey"`
+	Tag            string `gorm:"size:200"`
+	ReleaseVersion string `gorm:"size:200;index:idx_build_runs_release_version"`
+}
+
+func (buildRunReleaseMigrationModel) TableName() string { return "build_runs" }
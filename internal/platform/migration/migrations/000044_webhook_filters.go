@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000044_webhook_filters", upWebhookFilters)
+}
+
+func upWebhookFilters(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	job := &buildJobWebhookFilterMigrationModel{}
+	for _, field := range []string{"BranchPatterns", "PathIncludes", "PathExcludes"} {
+		if db.Migrator().HasColumn(job, field) {
+			continue
+		}
+		if err := db.Migrator().AddColumn(job, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buildJobWebhookFilterMigrationModel struct {
+	ID             uint   `gorm:"primaryKey"`
+	BranchPatterns string `gorm:"type:text"`
+	PathIncludes   string `gorm:"type:text"`
+	PathExcludes   string `gorm:"type:text"`
+}
+
+func (buildJobWebhookFilterMigrationModel) TableName() string { return "build_jobs" }
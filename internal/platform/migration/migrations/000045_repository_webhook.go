@@ -0,0 +1,61 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000045_repository_webhook", upRepositoryWebhook)
+}
+
+// upRepositoryWebhook adds the repository-level webhook secret and scopes
+// delivery dedup by repository: repository deliveries use build_job_id = 0.
+func upRepositoryWebhook(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	repo := &repositoryWebhookMigrationModel{}
+	if !db.Migrator().HasColumn(repo, "WebhookSecret") {
+		if err := db.Migrator().AddColumn(repo, "WebhookSecret"); err != nil {
+			return err
+		}
+	}
+
+	delivery := &webhookDeliveryScopeMigrationModel{}
+	if !db.Migrator().HasColumn(delivery, "RepositoryID") {
+		if err := db.Migrator().AddColumn(delivery, "RepositoryID"); err != nil {
+			return err
+		}
+	}
+	if !db.Migrator().HasIndex(delivery, "idx_wh_delivery_scope") {
+		if err := db.Migrator().CreateIndex(delivery, "idx_wh_delivery_scope"); err != nil {
+			return err
+		}
+	}
+	if db.Migrator().HasIndex(delivery, "idx_wh_delivery") {
+		if err := db.Migrator().DropIndex(delivery, "idx_wh_delivery"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type repositoryWebhookMigrationModel struct {
+	ID            uint   `gorm:"primaryKey"`
+	WebhookSecret string `gorm:"size:64"`
+}
+
+func (repositoryWebhookMigrationModel) TableName() string { return "repositories" }
+
+type webhookDeliveryScopeMigrationModel struct {
+	ID           uint   `gorm:"primaryKey"`
+	BuildJobID   uint   `gorm:"uniqueIndex:idx_wh_delivery_scope;not null"`
+	RepositoryID uint   `gorm:"uniqueIndex:idx_wh_delivery_scope;not null;default:0"`
+	DeliveryKey  string `gorm:"size:200;uniqueIndex:idx_wh_delivery_scope;not null"`
+}
+
+func (webhookDeliveryScopeMigrationModel) TableName() string { return "webhook_deliveries" }
@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000046_webhook_delivery_records", upWebhookDeliveryRecords)
+}
+
+func upWebhookDeliveryRecords(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+	m := &webhookDeliveryRecordMigrationModel{}
+	if db.Migrator().HasTable(m) {
+		return nil
+	}
+	return db.Migrator().CreateTable(m)
+}
+
+type webhookDeliveryRecordMigrationModel struct {
+	ID            uint      `gorm:"primaryKey"`
+	BuildJobID    uint      `gorm:"index;not null;default:0"`
+	RepositoryID  uint      `gorm:"index;not null;default:0"`
+	DeliveryKey   string    `gorm:"size:200"`
+	Platform      string    `gorm:"size:20"`
+	Event         string    `gorm:"size:100"`
+	Ref           string    `gorm:"size:300"`
+	CommitHash    string    `gorm:"size:64"`
+	Outcome       string    `gorm:"size:30;index"`
+	Message       string    `gorm:"size:500"`
+	RunIDsJSON    string    `gorm:"type:text"`
+	JobsJSON      string    `gorm:"type:text"`
+	HeadersJSON   string    `gorm:"type:text"`
+	Body          string    `gorm:"type:text"`
+	BodySize      int       `gorm:""`
+	BodyTruncated bool      `gorm:"not null;default:false"`
+	ReplayOf      *uint     `gorm:"index"`
+	CreatedAt     time.Time `gorm:""`
+}
+
+func (webhookDeliveryRecordMigrationModel) TableName() string { return "webhook_delivery_records" }
@@ -0,0 +1,37 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000047_scm_polling", upSCMPolling)
+}
+
+// upSCMPolling adds the polling trigger of build jobs.
+func upSCMPolling(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	job := &buildJobPollMigrationModel{}
+	for _, field := range []string{"TriggerPoll", "PollIntervalSeconds"} {
+		if !db.Migrator().HasColumn(job, field) {
+			if err := db.Migrator().AddColumn(job, field); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type buildJobPollMigrationModel struct {
+	ID                  uint `gorm:"primaryKey"`
+	TriggerPoll         bool `gorm:"not null;default:false"`
+	PollIntervalSeconds int  `gorm:"not null;default:300"`
+}
+
+func (buildJobPollMigrationModel) TableName() string { return "build_jobs" }
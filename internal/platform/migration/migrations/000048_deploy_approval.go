@@ -0,0 +1,78 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000048_deploy_approval", upDeployApproval)
+}
+
+// upDeployApproval adds the approval gate before distribution: target and job
+// settings plus one approval row per gated batch.
+func upDeployApproval(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	target := &deployTargetApprovalMigrationModel{}
+	for _, field := range []string{"Environment", "RequiresApproval"} {
+		if !db.Migrator().HasColumn(target, field) {
+			if err := db.Migrator().AddColumn(target, field); err != nil {
+				return err
+			}
+		}
+	}
+	job := &buildJobApprovalMigrationModel{}
+	for _, field := range []string{"ApprovalEnvironments", "DeployApproverIDsJSON"} {
+		if !db.Migrator().HasColumn(job, field) {
+			if err := db.Migrator().AddColumn(job, field); err != nil {
+				return err
+			}
+		}
+	}
+	approval := &buildDeployApprovalMigrationModel{}
+	if !db.Migrator().HasTable(approval) {
+		if err := db.Migrator().CreateTable(approval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type deployTargetApprovalMigrationModel struct {
+	ID               uint   `gorm:"primaryKey"`
+	Environment      string `gorm:"size:50"`
+	RequiresApproval bool   `gorm:"not null;default:false"`
+}
+
+func (deployTargetApprovalMigrationModel) TableName() string { return "deploy_targets" }
+
+type buildJobApprovalMigrationModel struct {
+	ID                    uint   `gorm:"primaryKey"`
+	ApprovalEnvironments  string `gorm:"type:text"`
+	DeployApproverIDsJSON string `gorm:"type:text"`
+}
+
+func (buildJobApprovalMigrationModel) TableName() string { return "build_jobs" }
+
+type buildDeployApprovalMigrationModel struct {
+	ID            uint       `gorm:"primaryKey"`
+	BuildRunID    uint       `gorm:"index;not null"`
+	BatchNo       int        `gorm:"not null"`
+	Status        string     `gorm:"size:20;not null;default:pending"`
+	Environments  string     `gorm:"size:300"`
+	TargetsJSON   string     `gorm:"type:text"`
+	RejectAction  string     `gorm:"size:10"`
+	DecidedBy     *uint      `gorm:""`
+	DecidedByName string     `gorm:"size:100"`
+	Comment       string     `gorm:"size:500"`
+	DecidedAt     *time.Time `gorm:""`
+	CreatedAt     time.Time  `gorm:""`
+}
+
+func (buildDeployApprovalMigrationModel) TableName() string { return "build_deploy_approvals" }
@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000049_distribute_strategy", upDistributeStrategy)
+}
+
+// upDistributeStrategy adds per-job distribution strategies and the wave of
+// each deploy attempt.
+func upDistributeStrategy(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	job := &buildJobStrategyMigrationModel{}
+	for _, field := range []string{"DistributeStrategy", "DistributeConcurrency", "DistributeMaxFailures", "CanaryVerifyScript"} {
+		if !db.Migrator().HasColumn(job, field) {
+			if err := db.Migrator().AddColumn(job, field); err != nil {
+				return err
+			}
+		}
+	}
+	attempt := &deployAttemptWaveMigrationModel{}
+	if !db.Migrator().HasColumn(attempt, "Wave") {
+		if err := db.Migrator().AddColumn(attempt, "Wave"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buildJobStrategyMigrationModel struct {
+	ID                    uint   `gorm:"primaryKey"`
+	DistributeStrategy    string `gorm:"size:20;not null;default:serial"`
+	DistributeConcurrency int    `gorm:"not null;default:1"`
+	DistributeMaxFailures int    `gorm:"not null;default:0"`
+	CanaryVerifyScript    string `gorm:"type:text"`
+}
+
+func (buildJobStrategyMigrationModel) TableName() string { return "build_jobs" }
+
+type deployAttemptWaveMigrationModel struct {
+	ID   uint `gorm:"primaryKey"`
+	Wave int  `gorm:"not null;default:1"`
+}
+
+func (deployAttemptWaveMigrationModel) TableName() string { return "build_deploy_attempts" }
@@ -0,0 +1,89 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000050_target_deployments", upTargetDeployments)
+}
+
+// upTargetDeployments adds the per-target deployment record and seeds each
+// existing target's current deployment from its latest successful attempt.
+func upTargetDeployments(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	deployment := &deployTargetDeploymentMigrationModel{}
+	if db.Migrator().HasTable(deployment) {
+		return nil
+	}
+	if err := db.Migrator().CreateTable(deployment); err != nil {
+		return err
+	}
+	latest := db.Table("build_deploy_attempts").
+		Select("MAX(build_deploy_attempts.id)").
+		Joins("JOIN deploy_targets ON deploy_targets.id = build_deploy_attempts.deploy_target_id").
+		Where("build_deploy_attempts.status = ?", "success").
+		Group("build_deploy_attempts.deploy_target_id")
+	var rows []struct {
+		AttemptID      uint
+		BuildJobID     uint
+		DeployTargetID uint
+		BuildRunID     uint
+		BuildNumber    int
+		CommitHash     string
+		ArtifactSHA256 string
+		TriggeredBy    uint
+		FinishedAt     *time.Time
+	}
+	err := db.Table("build_deploy_attempts").
+		Select("build_deploy_attempts.id AS attempt_id, deploy_targets.build_job_id, build_deploy_attempts.deploy_target_id, "+
+			"build_deploy_attempts.build_run_id, build_runs.build_number, build_runs.commit_hash, "+
+			"build_deploy_attempts.artifact_sha256, build_runs.triggered_by, build_deploy_attempts.finished_at").
+		Joins("JOIN deploy_targets ON deploy_targets.id = build_deploy_attempts.deploy_target_id").
+		Joins("JOIN build_runs ON build_runs.id = build_deploy_attempts.build_run_id").
+		Where("build_deploy_attempts.id IN (?)", latest).
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		at := time.Now()
+		if r.FinishedAt != nil {
+			at = *r.FinishedAt
+		}
+		if err := db.Create(&deployTargetDeploymentMigrationModel{
+			BuildJobID: r.BuildJobID, DeployTargetID: r.DeployTargetID, BuildRunID: r.BuildRunID,
+			BuildNumber: r.BuildNumber, AttemptID: r.AttemptID, CommitHash: r.CommitHash,
+			ArtifactSHA256: r.ArtifactSHA256, Kind: "deploy", Current: true,
+			DeployedBy: r.TriggeredBy, DeployedAt: at,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type deployTargetDeploymentMigrationModel struct {
+	ID             uint      `gorm:"primaryKey"`
+	BuildJobID     uint      `gorm:"index;not null"`
+	DeployTargetID uint      `gorm:"index;not null"`
+	BuildRunID     uint      `gorm:"index;not null"`
+	BuildNumber    int       `gorm:""`
+	AttemptID      uint      `gorm:""`
+	CommitHash     string    `gorm:"size:64"`
+	ArtifactSHA256 string    `gorm:"size:64"`
+	Kind           string    `gorm:"size:20;not null;default:deploy"`
+	Current        bool      `gorm:"index;not null;default:false"`
+	DeployedBy     uint      `gorm:""`
+	DeployedByName string    `gorm:"size:100"`
+	DeployedAt     time.Time `gorm:""`
+}
+
+func (deployTargetDeploymentMigrationModel) TableName() string { return "deploy_target_deployments" }
@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000051_deploy_approval_applied", upDeployApprovalApplied)
+}
+
+// upDeployApprovalApplied records when the pipeline acted on an approval
+// decision. Existing decisions count as applied unless their run is still
+// queued for distribution.
+func upDeployApprovalApplied(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	approval := &deployApprovalAppliedMigrationModel{}
+	if db.Migrator().HasColumn(approval, "AppliedAt") {
+		return nil
+	}
+	if err := db.Migrator().AddColumn(approval, "AppliedAt"); err != nil {
+		return err
+	}
+	distributing := db.Table("build_runs").Select("id").Where("stage = ?", "distributing")
+	return db.Model(approval).
+		Where("status <> ? AND build_run_id NOT IN (?)", "pending", distributing).
+		Update("applied_at", time.Now()).Error
+}
+
+type deployApprovalAppliedMigrationModel struct {
+	ID         uint       `gorm:"primaryKey"`
+	BuildRunID uint       `gorm:"index;not null"`
+	Status     string     `gorm:"size:20"`
+	AppliedAt  *time.Time `gorm:""`
+}
+
+func (deployApprovalAppliedMigrationModel) TableName() string { return "build_deploy_approvals" }
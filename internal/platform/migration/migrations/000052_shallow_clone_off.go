@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"bedrock/internal/platform/migration"
+)
+
+func init() {
+	migration.Register("000052_shallow_clone_off", upShallowCloneOff)
+}
+
+// upShallowCloneOff turns shallow_clone off for existing jobs. The flag used to
+// be ignored (every clone fetched full history), so honouring its old default
+// would silently switch them to depth-1 fetches.
+func upShallowCloneOff(ctx context.Context, db *gorm.DB, driver migration.Driver) error {
+	_ = ctx
+	_ = driver
+
+	return db.Session(&gorm.Session{AllowGlobalUpdate: true}).
+		Model(&buildJobShallowMigrationModel{}).
+		Update("shallow_clone", false).Error
+}
+
+type buildJobShallowMigrationModel struct {
+	ID           uint `gorm:"primaryKey"`
+	ShallowClone bool `gorm:"not null;default:true"`
+}
+
+func (buildJobShallowMigrationModel) TableName() string { return "build_jobs" }
@@ -85,7 +85,7 @@ func EnsureRBACResources(db *gorm.DB) error {
 		{
 			Code: "cicd", Name: "CI/CD", RoutePrefix: "/cicd", SortKey: 30,
 			Menus: []seedMenu{
-				{Code: "cicd_build_jobs", Title: "构建任务", Route: "/cicd/build-jobs", SortKey: 10, Actions: append(append([]string{}, standardCRUD...), "execute")},
+				{Code: "cicd_build_jobs", Title: "构建任务", Route: "/cicd/build-jobs", SortKey: 10, Actions: append(append([]string{}, standardCRUD...), "execute", "approve")},
 				{Code: "cicd_build_runs", Title: "构建记录", Route: "/cicd/build-runs", SortKey: 20, Actions: []string{"view"}},
 			},
 		},
@@ -252,7 +252,7 @@ func actionTitle(code string) string {
 	titles := map[string]string{
 		"view": "查看", "create": "创建", "update": "更新", "delete": "删除",
 		"execute": "执行", "use": "使用", "view_all": "查看全部", "manage_all": "管理全部",
-		"download": "下载",
+		"download": "下载", "approve": "审批",
 	}
 	if t, ok := titles[code]; ok {
 		return t
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 
@@ -32,6 +33,8 @@ func (h *RepositoryHandler) RegisterRoutes(rg *gin.RouterGroup, authMW gin.Handl
 	g.GET("/:id/branches", rbacmw.RequirePermission(h.perm, "resource_repositories:view"), h.Branches)
 	g.POST("/:id/sync-branches", rbacmw.RequirePermission(h.perm, "resource_repositories:update"), h.SyncBranches)
 	g.POST("/:id/test", rbacmw.RequirePermission(h.perm, "resource_repositories:view"), h.Test)
+	g.GET("/:id/webhook-secret", rbacmw.RequirePermission(h.perm, "resource_repositories:view"), h.GetWebhookSecret)
+	g.POST("/:id/webhook-secret/rotate", rbacmw.RequirePermission(h.perm, "resource_repositories:update"), h.RotateWebhookSecret)
 }
 
 func (h *RepositoryHandler) canUseCredential(c *gin.Context) bool {
@@ -168,3 +171,37 @@ func (h *RepositoryHandler) Test(c *gin.Context) {
 	}
 	pkg.Success(c, result)
 }
+
+func (h *RepositoryHandler) GetWebhookSecret(c *gin.Context) {
+	id, err := parseID(c)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效 ID")
+		return
+	}
+	repo, err := h.svc.GetWebhookSecret(id)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	pkg.Success(c, gin.H{
+		"webhook_secret": repo.WebhookSecret,
+		"webhook_url":    "/api/v1/webhook/repos/" + strconv.FormatUint(uint64(repo.ID), 10) + "/" + repo.WebhookSecret,
+	})
+}
+
+func (h *RepositoryHandler) RotateWebhookSecret(c *gin.Context) {
+	id, err := parseID(c)
+	if err != nil {
+		pkg.Error(c, http.StatusBadRequest, "无效 ID")
+		return
+	}
+	repo, err := h.svc.RotateWebhookSecret(id)
+	if err != nil {
+		writeServiceError(c, err)
+		return
+	}
+	pkg.Success(c, gin.H{
+		"webhook_secret": repo.WebhookSecret,
+		"webhook_url":    "/api/v1/webhook/repos/" + strconv.FormatUint(uint64(repo.ID), 10) + "/" + repo.WebhookSecret,
+	})
+}
@@ -30,12 +30,19 @@ type Repository struct {
 	RepoURL          string     `json:"repo_url" gorm:"size:500;not null"`
 	AuthType         string     `json:"auth_type" gorm:"size:20;not null;default:none"`
 	CredentialID     *uint      `json:"credential_id" gorm:"index"`
+	SSHKnownHosts    string     `json:"ssh_known_hosts" gorm:"type:text"`
+	Platform         string     `json:"platform" gorm:"size:20"` // "" = detect from URL | github | gitlab | gitea | gitee
 	BranchesJSON     string     `json:"-" gorm:"type:text"`
 	Branches         []string   `json:"branches" gorm:"-"`
 	BranchesSyncedAt *time.Time `json:"branches_synced_at"`
 	CreatedBy        uint       `json:"created_by" gorm:"index"`
 	CreatedAt        time.Time  `json:"created_at"`
 	UpdatedAt        time.Time  `json:"updated_at"`
+
+	// WebhookSecret authenticates the repository webhook, which fans a push
+	// out to every build job of the repository. Only the webhook-secret
+	// endpoint returns it.
+	WebhookSecret string `json:"-" gorm:"size:64"`
 }
 
 func (Repository) TableName() string { return "repositories" }
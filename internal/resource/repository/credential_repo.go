@@ -60,3 +60,10 @@ func (r *CredentialRepository) CountByServerRefs(id uint) (int64, error) {
 		Count(&n).Error
 	return n, err
 }
+
+// CountByBuildJobRefs counts BuildJob env bindings (cicd build_job_credential_envs).
+func (r *CredentialRepository) CountByBuildJobRefs(id uint) (int64, error) {
+	var n int64
+	err := r.db.Table("build_job_credential_envs").Where("credential_id = ?", id).Count(&n).Error
+	return n, err
+}
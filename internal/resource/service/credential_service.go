@@ -128,8 +128,12 @@ func (s *CredentialService) Delete(id uint) error {
 	if err != nil {
 		return err
 	}
-	if n1+n2 > 0 {
-		return NewConflict("该凭证仍被仓库或服务器引用，无法删除")
+	n3, err := s.repo.CountByBuildJobRefs(id)
+	if err != nil {
+		return err
+	}
+	if n1+n2+n3 > 0 {
+		return NewConflict("该凭证仍被仓库、服务器或构建任务引用，无法删除")
 	}
 	return s.repo.Delete(id)
 }
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"strings"
 	"time"
@@ -12,13 +14,13 @@ import (
 
 // GitLister abstracts git ls-remote for tests.
 type GitLister interface {
-	ListBranches(repoURL, authType, username, password string) ([]string, error)
+	ListBranches(repoURL string, auth engine.GitAuth) ([]string, error)
 }
 
 type defaultGitLister struct{}
 
-func (defaultGitLister) ListBranches(repoURL, authType, username, password string) ([]string, error) {
-	return engine.GitListBranches(repoURL, authType, username, password)
+func (defaultGitLister) ListBranches(repoURL string, auth engine.GitAuth) ([]string, error) {
+	return engine.GitListBranches(repoURL, auth)
 }
 
 type RepositoryService struct {
@@ -44,6 +46,10 @@ type CreateRepositoryInput struct {
 	RepoURL      string `json:"repo_url"`
 	AuthType     string `json:"auth_type"`
 	CredentialID *uint  `json:"credential_id"`
+	// SSHKnownHosts adds known_hosts lines for ssh_key clones.
+	SSHKnownHosts string `json:"ssh_known_hosts"`
+	// Platform overrides URL detection for commit statuses: github|gitlab|gitea|gitee.
+	Platform string `json:"platform"`
 }
 
 type UpdateRepositoryInput struct {
@@ -54,6 +60,8 @@ type UpdateRepositoryInput struct {
 	AuthType        *string `json:"auth_type"`
 	CredentialID    *uint   `json:"credential_id"`
 	ClearCredential bool    `json:"clear_credential"`
+	SSHKnownHosts   *string `json:"ssh_known_hosts"`
+	Platform        *string `json:"platform"`
 }
 
 func (s *RepositoryService) Create(createdBy uint, in CreateRepositoryInput, canUseCredential bool) (*model.Repository, error) {
@@ -84,7 +92,21 @@ func (s *RepositoryService) Create(createdBy uint, in CreateRepositoryInput, can
 		AuthType:     authType,
 		CredentialID: in.CredentialID,
 		CreatedBy:    createdBy,
+
+		SSHKnownHosts: strings.TrimSpace(in.SSHKnownHosts),
+		Platform:      normalizeRepoPlatform(in.Platform),
+	}
+	if err := validateRepoPlatform(repo.Platform); err != nil {
+		return nil, err
+	}
+	if err := s.validateSSHAuth(repo); err != nil {
+		return nil, err
+	}
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return nil, err
 	}
+	repo.WebhookSecret = secret
 	if err := s.repo.Create(repo); err != nil {
 		return nil, err
 	}
@@ -137,6 +159,18 @@ func (s *RepositoryService) Update(id uint, in UpdateRepositoryInput, canUseCred
 	if existing.Name == "" || existing.RepoURL == "" {
 		return nil, errorsNew("名称与仓库 URL 不能为空")
 	}
+	if in.SSHKnownHosts != nil {
+		existing.SSHKnownHosts = strings.TrimSpace(*in.SSHKnownHosts)
+	}
+	if in.Platform != nil {
+		existing.Platform = normalizeRepoPlatform(*in.Platform)
+		if err := validateRepoPlatform(existing.Platform); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.validateSSHAuth(existing); err != nil {
+		return nil, err
+	}
 	if err := s.repo.Update(existing); err != nil {
 		return nil, err
 	}
@@ -184,6 +218,36 @@ func (s *RepositoryService) List(page, pageSize int, keyword string) ([]model.Re
 	return items, total, nil
 }
 
+// GetWebhookSecret returns the repository with its webhook secret,
+// generating one for repositories created before repository webhooks existed.
+func (s *RepositoryService) GetWebhookSecret(id uint) (*model.Repository, error) {
+	repo, err := s.repo.FindByID(id)
+	if err != nil {
+		return nil, NewNotFound("仓库不存在")
+	}
+	if repo.WebhookSecret != "" {
+		return repo, nil
+	}
+	return s.RotateWebhookSecret(id)
+}
+
+// RotateWebhookSecret replaces the repository webhook secret; the old URL stops working.
+func (s *RepositoryService) RotateWebhookSecret(id uint) (*model.Repository, error) {
+	repo, err := s.repo.FindByID(id)
+	if err != nil {
+		return nil, NewNotFound("仓库不存在")
+	}
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	repo.WebhookSecret = secret
+	if err := s.repo.Update(repo); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
 // CachedBranches returns previously synced branch names (may be empty).
 func (s *RepositoryService) CachedBranches(id uint) (items []string, syncedAt *time.Time, err error) {
 	repo, err := s.repo.FindByID(id)
@@ -211,6 +275,16 @@ func (s *RepositoryService) SyncBranches(id uint) (*model.Repository, error) {
 	return repo, nil
 }
 
+// StoreBranches writes an already fetched branch list to the cache (the
+// SCM poller's ls-remote implements engine.BranchCache through it).
+func (s *RepositoryService) StoreBranches(id uint, branches []string) error {
+	repo, err := s.repo.FindByID(id)
+	if err != nil {
+		return NewNotFound("仓库不存在")
+	}
+	return s.writeBranchCache(repo, branches)
+}
+
 // BranchSyncResult is one item in a batch sync-branches response.
 type BranchSyncResult struct {
 	ID          uint       `json:"id"`
@@ -250,21 +324,35 @@ func (s *RepositoryService) TestFetch(id uint) (map[string]interface{}, error) {
 }
 
 func (s *RepositoryService) fetchRemoteBranches(repo *model.Repository) ([]string, error) {
-	authType := "none"
-	username, password := "", ""
+	auth := engine.GitAuth{Type: engine.GitAuthNone}
 	if repo.AuthType == "credential" && repo.CredentialID != nil {
-		cred, secret, _, err := s.creds.GetDecrypted(*repo.CredentialID)
+		cred, secret, passphrase, err := s.creds.GetDecrypted(*repo.CredentialID)
 		if err != nil {
 			return nil, err
 		}
-		username = cred.Username
-		password = secret
-		authType = "password"
-		if cred.Type == "ssh_key" {
-			authType = "ssh"
-		}
+		auth = engine.NewGitAuth(cred.Type, cred.Username, secret, passphrase)
+		auth.KnownHosts = repo.SSHKnownHosts
 	}
-	return s.git.ListBranches(repo.RepoURL, authType, username, password)
+	return s.git.ListBranches(repo.RepoURL, auth)
+}
+
+// validateSSHAuth checks known_hosts lines and that an ssh_key credential is
+// bound to an SSH URL (keys can't authenticate HTTPS clones).
+func (s *RepositoryService) validateSSHAuth(repo *model.Repository) error {
+	if err := engine.ValidateKnownHosts(repo.SSHKnownHosts); err != nil {
+		return errorsNew("known_hosts 无效: " + err.Error())
+	}
+	if repo.AuthType != "credential" || repo.CredentialID == nil {
+		return nil
+	}
+	cred, err := s.creds.Get(*repo.CredentialID)
+	if err != nil {
+		return errorsNew("凭证不存在")
+	}
+	if cred.Type == "ssh_key" && !engine.IsSSHGitURL(repo.RepoURL) {
+		return errorsNew("SSH 密钥凭证需要 SSH 仓库地址（git@host:org/repo.git 或 ssh://）")
+	}
+	return nil
 }
 
 func (s *RepositoryService) writeBranchCache(repo *model.Repository, branches []string) error {
@@ -307,6 +395,34 @@ func normalizeRepoAuth(t string) string {
 	}
 }
 
+func normalizeRepoPlatform(p string) string {
+	p = strings.ToLower(strings.TrimSpace(p))
+	if p == "auto" {
+		return ""
+	}
+	return p
+}
+
+func validateRepoPlatform(p string) error {
+	if p == "" {
+		return nil
+	}
+	for _, name := range engine.PlatformNames {
+		if p == name {
+			return nil
+		}
+	}
+	return errorsNew("platform 仅支持 github | gitlab | gitea | gitee（留空自动识别）")
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 func credentialIDEqual(a, b *uint) bool {
 	if a == nil && b == nil {
 		return true
@@ -7,6 +7,8 @@ import (
 
 	"bedrock/internal/ai/model"
 	airepo "bedrock/internal/ai/repository"
+	"bedrock/internal/engine"
+	"bedrock/internal/pkg"
 	"bedrock/internal/platform/config"
 	"bedrock/internal/platform/db"
 	"bedrock/internal/platform/migration"
@@ -20,10 +22,12 @@ type stubGitLister struct {
 	branches []string
 	err      error
 	calls    int
+	auth     engine.GitAuth
 }
 
-func (s *stubGitLister) ListBranches(repoURL, authType, username, password string) ([]string, error) {
+func (s *stubGitLister) ListBranches(repoURL string, auth engine.GitAuth) ([]string, error) {
 	s.calls++
+	s.auth = auth
 	if s.err != nil {
 		return nil, s.err
 	}
@@ -161,3 +165,88 @@ func TestRepositoryBranchCacheSyncAndRead(t *testing.T) {
 		t.Fatalf("test should refresh remote, calls=%d", git.calls)
 	}
 }
+
+func TestRepositorySSHKeyCredential(t *testing.T) {
+	if err := pkg.InitEncryption("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	gdb, err := db.Open(&config.DatabaseConfig{
+		Driver: "sqlite",
+		Path:   filepath.Join(t.TempDir(), "repo-ssh.sqlite"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migration.Up(context.Background(), gdb, migration.Driver("sqlite")); err != nil {
+		t.Fatalf("migration: %v", err)
+	}
+	credSvc := service.NewCredentialService(resourcerepo.NewCredentialRepository(gdb))
+	repoSvc := service.NewRepositoryService(resourcerepo.NewRepositoryRepository(gdb), credSvc)
+	git := &stubGitLister{branches: []string{"main"}}
+	repoSvc.SetGitLister(git)
+
+	cred, err := credSvc.Create(1, service.CreateCredentialInput{
+		Name: "deploy-key", Type: "ssh_key", Username: "git", Secret: "KEY", Passphrase: "pw",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := service.CreateRepositoryInput{
+		Name: "ssh", RepoURL: "https://gitea.internal/team/app.git", AuthType: "credential", CredentialID: &cred.ID,
+	}
+	if _, err := repoSvc.Create(1, in, true); err == nil {
+		t.Fatal("ssh_key credential accepted for an HTTPS URL")
+	}
+	in.RepoURL = "git@gitea.internal:team/app.git"
+	in.SSHKnownHosts = "not a host key"
+	if _, err := repoSvc.Create(1, in, true); err == nil {
+		t.Fatal("malformed known_hosts accepted")
+	}
+	in.SSHKnownHosts = "gitea.internal ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"
+	repo, err := repoSvc.Create(1, in, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repoSvc.SyncBranches(repo.ID); err != nil {
+		t.Fatal(err)
+	}
+	if git.auth.Type != engine.GitAuthSSH || git.auth.Secret != "KEY" || git.auth.Passphrase != "pw" || git.auth.KnownHosts != in.SSHKnownHosts {
+		t.Fatalf("auth=%+v", git.auth)
+	}
+}
+
+func TestRepositoryPlatformOverride(t *testing.T) {
+	gdb, err := db.Open(&config.DatabaseConfig{
+		Driver: "sqlite",
+		Path:   filepath.Join(t.TempDir(), "repo-platform.sqlite"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migration.Up(context.Background(), gdb, migration.Driver("sqlite")); err != nil {
+		t.Fatalf("migration: %v", err)
+	}
+	credSvc := service.NewCredentialService(resourcerepo.NewCredentialRepository(gdb))
+	repoSvc := service.NewRepositoryService(resourcerepo.NewRepositoryRepository(gdb), credSvc)
+
+	in := service.CreateRepositoryInput{Name: "app", RepoURL: "https://git.example.com/team/app.git", Platform: "bitbucket"}
+	if _, err := repoSvc.Create(1, in, false); err == nil {
+		t.Fatal("unknown platform accepted")
+	}
+	in.Platform = " Gitea "
+	repo, err := repoSvc.Create(1, in, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.Platform != "gitea" {
+		t.Fatalf("platform=%q want gitea", repo.Platform)
+	}
+	auto := "auto"
+	repo, err = repoSvc.Update(repo.ID, service.UpdateRepositoryInput{Platform: &auto}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.Platform != "" {
+		t.Fatalf("platform=%q want auto-detect", repo.Platform)
+	}
+}
@@ -87,6 +87,15 @@ func (r *StorageRepository) Delete(id uint) error {
 	return r.db.Delete(&model.StorageObject{}, id).Error
 }
 
+// ListPurgeable returns released objects whose purge window ended before now.
+func (r *StorageRepository) ListPurgeable(now time.Time) ([]model.StorageObject, error) {
+	var objects []model.StorageObject
+	err := r.db.Unscoped().
+		Where("deleted_at IS NOT NULL AND ref_count = 0 AND purge_after IS NOT NULL AND purge_after <= ?", now).
+		Find(&objects).Error
+	return objects, err
+}
+
 func (r *StorageRepository) Purge(id uint) error {
 	return r.db.Unscoped().Delete(&model.StorageObject{}, id).Error
 }
@@ -20,6 +20,7 @@ const (
 	DefaultAttachmentMaxBytes int64 = 20 * 1024 * 1024
 	DefaultDocImportMaxBytes  int64 = 100 * 1024 * 1024
 	DefaultSkillZIPMaxBytes   int64 = 50 * 1024 * 1024
+	DefaultArtifactMaxBytes   int64 = 2 * 1024 * 1024 * 1024
 )
 
 var (
@@ -31,6 +32,7 @@ type Limits struct {
 	AttachmentMaxBytes int64
 	DocImportMaxBytes  int64
 	SkillZIPMaxBytes   int64
+	ArtifactMaxBytes   int64
 }
 
 func (l Limits) normalized() Limits {
@@ -43,6 +45,9 @@ func (l Limits) normalized() Limits {
 	if l.SkillZIPMaxBytes <= 0 {
 		l.SkillZIPMaxBytes = DefaultSkillZIPMaxBytes
 	}
+	if l.ArtifactMaxBytes <= 0 {
+		l.ArtifactMaxBytes = DefaultArtifactMaxBytes
+	}
 	return l
 }
 
@@ -76,6 +81,8 @@ func (s *StorageService) MaxBytes(kind string) int64 {
 		return s.limits.DocImportMaxBytes
 	case model.KindSkillZIP:
 		return s.limits.SkillZIPMaxBytes
+	case model.KindArtifact:
+		return s.limits.ArtifactMaxBytes
 	default:
 		return s.limits.DocImportMaxBytes
 	}
@@ -208,6 +215,50 @@ func (s *StorageService) Delete(id uint) error {
 	return err
 }
 
+// LocalPath resolves a live object to its file below the storage root, for
+// callers that need a real path (e.g. extracting a build artifact).
+func (s *StorageService) LocalPath(id uint) (string, error) {
+	object, err := s.repo.FindByID(id)
+	if err != nil {
+		return "", err
+	}
+	if object.RefCount <= 0 {
+		return "", ErrUnavailable
+	}
+	path, err := s.absolutePath(object.Path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", ErrUnavailable
+	}
+	return path, nil
+}
+
+// PurgeExpired unlinks objects whose last reference was released before now
+// and whose purge window has passed, then drops their rows.
+func (s *StorageService) PurgeExpired(now time.Time) (int, error) {
+	objects, err := s.repo.ListPurgeable(now)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, object := range objects {
+		path, err := s.absolutePath(object.Path)
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err := s.repo.Purge(object.ID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
 func (s *StorageService) absolutePath(relative string) (string, error) {
 	clean := filepath.Clean(filepath.FromSlash(relative))
 	if clean == "." || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(os.PathSeparator)) {
@@ -64,6 +64,28 @@ func (s *NotificationService) NotifyBuildRun(userID uint, buildRunID uint, build
 	})
 }
 
+// NotifyDeployApproval implements engine.ApprovalNotifier.
+func (s *NotificationService) NotifyDeployApproval(userIDs []uint, buildRunID uint, buildNumber int, jobName, environments string) {
+	runID := buildRunID
+	title := fmt.Sprintf("构建 #%d 等待部署审批", buildNumber)
+	msg := fmt.Sprintf("%s 构建 #%d 待审批后分发", jobName, buildNumber)
+	if environments != "" {
+		msg += "（环境: " + environments + "）"
+	}
+	for _, userID := range userIDs {
+		if userID == 0 {
+			continue
+		}
+		_, _ = s.Push(PushInput{
+			UserID:     userID,
+			Type:       "build_run_approval",
+			Title:      title,
+			Message:    msg,
+			BuildRunID: &runID,
+		})
+	}
+}
+
 // NotifyAgentRun pushes an AgentRun terminal notification (and keeps ai-run log channel separate).
 func (s *NotificationService) NotifyAgentRun(userID uint, agentRunID, agentID uint, status string) {
 	if userID == 0 {
@@ -117,6 +139,8 @@ func statusLabel(status string) string {
 		return "已取消"
 	case "interrupted":
 		return "已中断"
+	case "timed_out":
+		return "已超时"
 	default:
 		return status
 	}
@@ -1,5 +1,17 @@
 import { getAccessToken, http } from "./http";
-import type { BuildJob, BuildRun, PageResult } from "./types";
+import type {
+  ArtifactRetentionPlan,
+  BuildJob,
+  BuildLogPage,
+  BuildRun,
+  BuildTestComparison,
+  BuildTestResults,
+  BuildTestStatus,
+  PageResult,
+  TargetDeployment,
+  WebhookDeliveryRecord,
+  WebhookResult,
+} from "./types";
 
 export type ListQuery = Record<string, string | number | boolean | undefined | null>;
 
@@ -89,14 +101,33 @@ export async function redeployBuildRun(
   return data;
 }
 
+/** Approve or reject the run's pending deployment approval (cicd_build_jobs:approve). */
+export async function decideBuildRunApproval(
+  id: number,
+  body: { approve: boolean; reject_action?: "skip" | "cancel"; comment?: string },
+): Promise<BuildRun> {
+  const { body: data } = await http.post<BuildRun>(`/build-runs/${id}/approval`, body);
+  return data;
+}
+
 /** Artifact download URL (Bearer via browser navigation with token query is not used; open with fetch blob). */
-export function buildRunArtifactURL(id: number): string {
-  return `/api/v1/build-runs/${id}/artifact`;
+export function buildRunArtifactURL(id: number, name?: string): string {
+  const base = `/api/v1/build-runs/${id}/artifact`;
+  return name ? `${base}?name=${encodeURIComponent(name)}` : base;
 }
 
-export async function getBuildRunLog(id: number): Promise<string> {
+/** Plain-text log. `tail` reads the last N bytes; offset/limit page forward by byte. */
+export async function getBuildRunLog(
+  id: number,
+  opts?: { offset?: number; limit?: number; tail?: number },
+): Promise<string> {
   const token = getAccessToken();
-  const res = await fetch(`/api/v1/build-runs/${id}/log`, {
+  const q = new URLSearchParams();
+  for (const [k, v] of Object.entries(opts ?? {})) {
+    if (v) q.set(k, String(v));
+  }
+  const qs = q.toString();
+  const res = await fetch(`/api/v1/build-runs/${id}/log${qs ? `?${qs}` : ""}`, {
     headers: token ? { Authorization: `Bearer ${token}` } : {},
   });
   if (!res.ok) {
@@ -105,6 +136,117 @@ export async function getBuildRunLog(id: number): Promise<string> {
   return res.text();
 }
 
+export async function getBuildRunLogLines(
+  id: number,
+  params?: { from?: number; limit?: number; stage?: string },
+): Promise<BuildLogPage> {
+  const { body } = await http.get<BuildLogPage>(`/build-runs/${id}/log/lines`, {
+    query: toQuery(params),
+  });
+  return body;
+}
+
+export async function searchBuildRunLog(
+  id: number,
+  params: { q: string; stage?: string; limit?: number },
+): Promise<BuildLogPage> {
+  const { body } = await http.get<BuildLogPage>(`/build-runs/${id}/log/search`, {
+    query: toQuery(params),
+  });
+  return body;
+}
+
+export async function getBuildRunTests(
+  id: number,
+  params?: { status?: BuildTestStatus },
+): Promise<BuildTestResults> {
+  const { body } = await http.get<BuildTestResults>(`/build-runs/${id}/tests`, {
+    query: toQuery(params),
+  });
+  return body;
+}
+
+export async function compareBuildRunTests(
+  id: number,
+  params?: { base?: number },
+): Promise<BuildTestComparison> {
+  const { body } = await http.get<BuildTestComparison>(`/build-runs/${id}/tests/compare`, {
+    query: toQuery(params),
+  });
+  return body;
+}
+
+export async function pinBuildRun(id: number): Promise<BuildRun> {
+  const { body } = await http.post<BuildRun>(`/build-runs/${id}/pin`, {});
+  return body;
+}
+
+export async function unpinBuildRun(id: number): Promise<BuildRun> {
+  const { body } = await http.delete<BuildRun>(`/build-runs/${id}/pin`);
+  return body;
+}
+
+export async function listBuildJobReleases(
+  jobId: number,
+  params?: ListQuery,
+): Promise<PageResult<BuildRun>> {
+  const { body } = await http.get<PageResult<BuildRun>>(`/build-jobs/${jobId}/releases`, {
+    query: toQuery(params),
+  });
+  return body;
+}
+
+export async function getBuildJobRelease(jobId: number, version: string): Promise<BuildRun> {
+  const { body } = await http.get<BuildRun>(
+    `/build-jobs/${jobId}/releases/${encodeURIComponent(version)}`,
+  );
+  return body;
+}
+
+/** What each deploy target of the job runs now. */
+export async function listBuildJobDeployments(jobId: number): Promise<TargetDeployment[]> {
+  const { body } = await http.get<TargetDeployment[]>(`/build-jobs/${jobId}/deployments`);
+  return body;
+}
+
+/** Roll one target (or every target when omitted) back to its previous deployment. */
+export async function rollbackBuildJob(
+  jobId: number,
+  body?: { deploy_target_id?: number },
+): Promise<BuildRun[]> {
+  const { body: data } = await http.post<BuildRun[]>(`/build-jobs/${jobId}/rollback`, body ?? {});
+  return data;
+}
+
+export async function getBuildJobWebhookDelivery(
+  jobId: number,
+  deliveryId: number,
+): Promise<WebhookDeliveryRecord> {
+  const { body } = await http.get<WebhookDeliveryRecord>(
+    `/build-jobs/${jobId}/webhook-deliveries/${deliveryId}`,
+  );
+  return body;
+}
+
+export async function replayBuildJobWebhookDelivery(
+  jobId: number,
+  deliveryId: number,
+  bypassDedup = false,
+): Promise<WebhookResult> {
+  const { body } = await http.post<WebhookResult>(
+    `/build-jobs/${jobId}/webhook-deliveries/${deliveryId}/replay`,
+    { bypass_dedup: bypassDedup },
+  );
+  return body;
+}
+
+export async function previewArtifactRetention(jobId: number): Promise<ArtifactRetentionPlan> {
+  const { body } = await http.get<ArtifactRetentionPlan>(
+    `/build-jobs/${jobId}/artifact-retention/preview`,
+  );
+  return body;
+}
+
 export function buildRunLogsWSURL(id: number, token: string): string {
   const proto = location.protocol === "https:" ? "wss:" : "ws:";
   return `${proto}//${location.host}/ws/build-runs/${id}/logs?token=${encodeURIComponent(token)}`;
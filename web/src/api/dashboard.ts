@@ -2,6 +2,7 @@ import { http } from "./http";
 import type {
   AgentRunSummary,
   BuildSummary,
+  CoverageTrend,
   DashboardLayout,
   SystemInfo,
   SystemStatus,
@@ -22,6 +23,16 @@ export async function getBuildSummary(): Promise<BuildSummary> {
   return body;
 }
 
+export async function getCoverageTrend(
+  buildJobId: number,
+  limit?: number,
+): Promise<CoverageTrend> {
+  const { body } = await http.get<CoverageTrend>("/dashboard/coverage-trend", {
+    query: { build_job_id: buildJobId, ...(limit ? { limit } : {}) },
+  });
+  return body;
+}
+
 export async function getAgentRunSummary(): Promise<AgentRunSummary> {
   const { body } = await http.get<AgentRunSummary>("/dashboard/agent-run-summary");
   return body;
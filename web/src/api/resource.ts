@@ -109,6 +109,25 @@ export async function testRepository(id: number): Promise<{ ok: boolean; branche
   return body;
 }
 
+export async function getRepositoryWebhookSecret(
+  id: number,
+): Promise<{ webhook_secret: string; webhook_url: string }> {
+  const { body } = await http.get<{ webhook_secret: string; webhook_url: string }>(
+    `/resource/repositories/${id}/webhook-secret`,
+  );
+  return body;
+}
+
+export async function rotateRepositoryWebhookSecret(
+  id: number,
+): Promise<{ webhook_secret: string; webhook_url: string }> {
+  const { body } = await http.post<{ webhook_secret: string; webhook_url: string }>(
+    `/resource/repositories/${id}/webhook-secret/rotate`,
+    {},
+  );
+  return body;
+}
+
 // —— Servers ——
 export async function listServers(params?: ListQuery): Promise<PageResult<Server>> {
   const { body } = await http.get<PageResult<Server>>("/resource/servers", {
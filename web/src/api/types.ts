@@ -187,6 +187,10 @@ export interface Repository {
   repo_url: string;
   auth_type: string;
   credential_id?: number | null;
+  /** Extra known_hosts lines trusted for ssh_key clones (strict host key checking). */
+  ssh_known_hosts?: string;
+  /** Platform for commit statuses: "" = detect from URL | github | gitlab | gitea | gitee. */
+  platform?: string;
   branches?: string[];
   branches_synced_at?: string | null;
   created_by: number;
@@ -221,6 +225,42 @@ export interface DeployTarget {
   method: string;
   post_deploy_script?: string;
   sort_order: number;
+  /** Named artifact (BuildJob.artifact_defs) to ship; empty = output_dir. */
+  artifact_name?: string;
+  /** Environment label (prod, staging); gated when listed in the job's approval_environments. */
+  environment?: string;
+  requires_approval?: boolean;
+}
+
+/** A named artifact a job collects from the workspace after a successful build. */
+export interface ArtifactDef {
+  name: string;
+  /** Workspace-relative directory, file or glob. */
+  path: string;
+  format?: "gzip" | "zip";
+}
+
+export interface BuildParameter {
+  name: string;
+  type: "string" | "choice" | "boolean" | "secret";
+  description?: string;
+  default?: string;
+  choices?: string[];
+  required?: boolean;
+  pattern?: string;
+}
+
+export interface JobCredentialEnv {
+  id?: number;
+  name: string;
+  credential_id: number;
+  field?: "secret" | "username" | "passphrase";
+}
+
+export interface BuildMatrix {
+  axes: { name: string; values: string[] }[];
+  include?: Record<string, string>[];
+  exclude?: Record<string, string>[];
 }
 
 export interface BuildJob {
@@ -231,15 +271,79 @@ export interface BuildJob {
   enabled: boolean;
   branch: string;
   shallow_clone: boolean;
+  /** Tag, full ref (refs/pull/1/head) or commit SHA checked out instead of `branch`. */
+  git_ref?: string;
+  /** Recursively update submodules with the repository's credentials. */
+  git_submodules?: boolean;
+  /** Run `git lfs pull` after checkout (git-lfs must be installed on the server). */
+  git_lfs?: boolean;
+  /** Cone-mode sparse checkout directories, one per line; empty = full checkout. */
+  sparse_checkout_paths?: string;
+  /** Post run progress as a commit status to the repository's Git platform. */
+  report_commit_status?: boolean;
   build_script_type: string;
   build_script: string;
   work_dir: string;
   output_dir: string;
+  pipeline_file?: string;
   cache_paths: string;
+  /** Cache entry name; the key appends a digest of cache_key_files. Default "default". */
+  cache_key?: string;
+  /** Globs whose content keys the cache (e.g. package-lock.json); empty = one entry replaced per build. */
+  cache_key_files?: string;
+  /** Prefixes tried in order on a miss, newest entry first; default `<cache_key>-`. */
+  cache_restore_keys?: string;
+  /** Key entries by repository so its jobs share them. */
+  cache_shared?: boolean;
+  /** Report globs (one per line or JSON array): JUnit XML or `go test -json`. */
+  test_report_paths?: string;
+  /** Coverage globs: Go coverprofile, Cobertura XML or LCOV. */
+  coverage_report_paths?: string;
+  /** Minimum line coverage percent; a lower result fails the run. 0 = none. */
+  coverage_threshold?: number;
+  /** Remove artifacts older than this many days. 0 = no age limit. */
+  artifact_max_age_days?: number;
+  /** Cap on the job's total artifact size in MB. 0 = no size limit. */
+  artifact_max_total_mb?: number;
+  artifact_defs?: ArtifactDef[];
   env_var_names?: string[];
+  matrix?: BuildMatrix | null;
+  parameters?: BuildParameter[];
+  credential_envs?: JobCredentialEnv[];
   trigger_manual: boolean;
   trigger_webhook: boolean;
   trigger_cron: boolean;
+  /** Build pull/merge requests opened, reopened or pushed to (needs trigger_webhook). */
+  trigger_pull_request?: boolean;
+  /** head = PR head commit; merge = platform merge ref (head where there is none). */
+  pull_request_ref?: "head" | "merge";
+  /** Let PR runs deploy to the job's targets (off by default). */
+  pull_request_distribute?: boolean;
+  /** Build pushed tags matching tag_patterns (needs trigger_webhook). */
+  trigger_tag?: boolean;
+  /** One per line: globs (v*.*.*), semver, or semver:>=1.0.0 <2.0.0. */
+  tag_patterns?: string;
+  /** Branch globs for webhook and polling, one per line (release/*, feature/**); empty = branch only. */
+  branch_patterns?: string;
+  /** Push builds only when a changed file matches path_includes and not path_excludes. */
+  path_includes?: string;
+  path_excludes?: string;
+  /** Poll the repository with git ls-remote and build branches whose head moved. */
+  trigger_poll?: boolean;
+  /** 60–86400; a repository is polled at the smallest interval of its jobs. */
+  poll_interval_seconds?: number;
+  /** Environments whose targets wait for approval before distribution, one per line. */
+  approval_environments?: string;
+  /** Users notified of pending approvals; empty = whoever triggered the run. */
+  deploy_approver_ids?: number[];
+  /** serial (default), parallel, rolling or canary. */
+  distribute_strategy?: "serial" | "parallel" | "rolling" | "canary";
+  /** Parallel limit, or rolling wave size (1–50). */
+  distribute_concurrency?: number;
+  /** Rolling stops once more than this many targets failed. */
+  distribute_max_failures?: number;
+  /** Runs after the canary target deployed; non-zero exit aborts the rest. */
+  canary_verify_script?: string;
   webhook_type?: string;
   webhook_ref_path?: string;
   webhook_commit_path?: string;
@@ -247,6 +351,11 @@ export interface BuildJob {
   cron_expression: string;
   cron_timezone: string;
   max_artifacts: number;
+  /** Time limits in seconds; 0 = none. A fired limit ends the run as timed_out. */
+  timeout_seconds?: number;
+  clone_timeout_seconds?: number;
+  build_timeout_seconds?: number;
+  distribute_timeout_seconds?: number;
   artifact_format: string;
   agent_trigger_event: string;
   agent_id?: number | null;
@@ -264,6 +373,64 @@ interface BuildDeployAttempt {
   status: string;
   error_message?: string;
   created_at: string;
+  /** Digest of the artifact this attempt shipped. */
+  artifact_sha256?: string;
+  /** Strategy wave within the batch (1 for serial and parallel). */
+  wave?: number;
+}
+
+/** A build that went live on a deploy target; current = what it runs now. */
+export interface DeployTargetDeployment {
+  id: number;
+  build_job_id: number;
+  deploy_target_id: number;
+  build_run_id: number;
+  build_number: number;
+  attempt_id: number;
+  commit_hash: string;
+  artifact_sha256?: string;
+  kind: "deploy" | "redeploy" | "rollback";
+  current: boolean;
+  deployed_by: number;
+  /** Set for redeploys and rollbacks. */
+  deployed_by_name?: string;
+  deployed_at: string;
+}
+
+/** A job's target with its current deployment and the one a rollback restores. */
+export interface TargetDeployment {
+  target: DeployTarget;
+  current: DeployTargetDeployment | null;
+  previous: DeployTargetDeployment | null;
+}
+
+/** Deployment approval of one distribution batch (batch_no of its attempts). */
+export interface BuildDeployApproval {
+  id: number;
+  build_run_id: number;
+  batch_no: number;
+  status: "pending" | "approved" | "rejected";
+  environments?: string;
+  /** Gated targets at the time the run parked. */
+  targets?: DeployTarget[];
+  reject_action?: "skip" | "cancel" | "";
+  decided_by?: number | null;
+  decided_by_name?: string;
+  comment?: string;
+  decided_at?: string | null;
+  applied_at?: string | null;
+  created_at: string;
+}
+
+export interface RunArtifact {
+  name: string;
+  stage?: string;
+  path: string;
+  format: string;
+  size: number;
+  object_id?: number;
+  sha256?: string;
+  file_name?: string;
 }
 
 export interface BuildRun {
@@ -279,11 +446,217 @@ export interface BuildRun {
   commit_message: string;
   log_path?: string;
   artifact_path?: string;
+  /** Storage object behind the main artifact; null for pre-store archives. */
+  artifact_object_id?: number | null;
+  artifact_sha256?: string;
+  artifact_name?: string;
+  artifacts?: RunArtifact[];
+  /** hit (exact key) / partial (restore key) / miss; absent without cache_paths. */
+  cache_status?: "hit" | "partial" | "miss";
+  cache_key?: string;
+  cache_restored_key?: string;
+  /** Ref checked out instead of branch (pull request head/merge ref). */
+  git_ref?: string;
+  /** Pull/merge request number; 0 for non-PR runs. */
+  pr_number?: number;
+  pr_source_branch?: string;
+  pr_target_branch?: string;
+  pr_author?: string;
+  /** PR head commit; differs from commit_hash for merge-ref builds. */
+  pr_head_commit?: string;
+  /** Tag built by a tag run; successful tag runs are releases. */
+  tag?: string;
+  /** Release label: the tag's semantic version without v, else the tag name. */
+  release_version?: string;
   distribution_summary: string;
   snapshot_json?: string;
+  parent_run_id?: number | null;
+  matrix_cell?: Record<string, string>;
+  matrix_summary?: string;
   error_message?: string;
   created_at: string;
   deploy_attempts?: BuildDeployAttempt[];
+  deploy_approvals?: BuildDeployApproval[];
+  children?: BuildRun[];
+  log_stages?: BuildLogStage[];
+  test_summary?: BuildTestSummary;
+  /** Only on GET /build-runs/{id}; lists use coverage_percent. */
+  coverage?: BuildCoverageSummary;
+  coverage_percent?: number | null;
+  /** Pinned runs are exempt from artifact retention. */
+  artifact_pinned?: boolean;
+  artifact_removed_at?: string | null;
+  artifact_removed_reason?: ArtifactRemovalReason;
+}
+
+export interface BuildPackageCoverage {
+  name: string;
+  lines_covered: number;
+  lines_total: number;
+  percent: number;
+}
+
+export interface BuildCoverageSummary {
+  lines_covered: number;
+  lines_total: number;
+  percent: number;
+  threshold?: number;
+  files: string[];
+  packages: BuildPackageCoverage[];
+}
+
+export interface BuildTestSummary {
+  total: number;
+  passed: number;
+  failed: number;
+  errors: number;
+  skipped: number;
+  duration_ms: number;
+}
+
+export type BuildTestStatus = "passed" | "failed" | "error" | "skipped";
+
+export interface BuildTestCase {
+  id: number;
+  build_run_id: number;
+  suite_id: number;
+  suite_name: string;
+  class_name: string;
+  name: string;
+  status: BuildTestStatus;
+  duration_ms: number;
+  message?: string;
+  details?: string;
+}
+
+export interface BuildTestSuite {
+  id: number;
+  build_run_id: number;
+  name: string;
+  file: string;
+  tests: number;
+  failures: number;
+  errors: number;
+  skipped: number;
+  duration_ms: number;
+  cases?: BuildTestCase[];
+}
+
+export interface BuildTestResults {
+  summary: BuildTestSummary | null;
+  suites: BuildTestSuite[];
+}
+
+export interface BuildTestComparison {
+  base_run_id: number;
+  base_build_number: number;
+  summary: BuildTestSummary | null;
+  base_summary: BuildTestSummary | null;
+  new_failures: BuildTestCase[];
+  fixed: BuildTestCase[];
+  still_failing: BuildTestCase[];
+}
+
+export type ArtifactRemovalReason = "count" | "age" | "size";
+
+export interface ArtifactRemoval {
+  run_id: number;
+  build_number: number;
+  reason: ArtifactRemovalReason;
+  bytes: number;
+  created_at: string;
+}
+
+/** Dry run of a job's artifact retention policy. */
+export interface ArtifactRetentionPlan {
+  build_job_id: number;
+  dry_run: boolean;
+  remove: ArtifactRemoval[];
+  /** Pinned, currently deployed or in-use runs. */
+  protected: number;
+  kept: number;
+  kept_bytes: number;
+  freed_bytes: number;
+}
+
+/** Start of a pipeline stage in the run log (1-based line, byte offset). */
+export interface BuildLogStage {
+  name: string;
+  line: number;
+  offset: number;
+}
+
+export interface BuildLogLine {
+  n: number;
+  ts?: string;
+  stream: "stdout" | "stderr" | "system";
+  stage?: string;
+  text: string;
+}
+
+export interface BuildLogPage {
+  lines: BuildLogLine[];
+  next: number;
+  has_more: boolean;
+}
+
+/** One build job's outcome of a repository webhook. */
+export interface WebhookJobResult {
+  job_id: number;
+  job_name: string;
+  triggered: boolean;
+  run_id?: number;
+  reason?: string;
+}
+
+export interface WebhookResult {
+  accepted: boolean;
+  duplicate?: boolean;
+  branch?: string;
+  triggered: number;
+  run_ids?: number[];
+  job_ids?: number[];
+  message?: string;
+  outcome: WebhookDeliveryOutcome;
+  jobs?: WebhookJobResult[];
+  delivery_id?: number;
+}
+
+export type WebhookDeliveryOutcome =
+  | "unauthorized"
+  | "invalid"
+  | "ignored"
+  | "disabled"
+  | "duplicate"
+  | "branch_not_matched"
+  | "tag_not_matched"
+  | "path_not_matched"
+  | "enqueue_failed"
+  | "triggered"
+  | "skipped";
+
+/** A received webhook: credential headers masked, body truncated to 60 KiB. */
+export interface WebhookDeliveryRecord {
+  id: number;
+  /** 0 for repository webhook deliveries. */
+  build_job_id: number;
+  repository_id: number;
+  delivery_key: string;
+  platform: string;
+  event: string;
+  ref: string;
+  commit_hash: string;
+  outcome: WebhookDeliveryOutcome;
+  message: string;
+  run_ids: number[];
+  jobs?: WebhookJobResult[];
+  headers: Record<string, string>;
+  /** Only returned by the single-delivery endpoint. */
+  body?: string;
+  body_size: number;
+  body_truncated: boolean;
+  replay_of?: number;
+  created_at: string;
 }
 
 export type DashboardCardID =
@@ -327,6 +700,23 @@ export interface BuildSummary {
   recent: DashboardRecentBuildRun[];
 }
 
+export interface CoveragePoint {
+  run_id: number;
+  build_number: number;
+  status: string;
+  branch: string;
+  coverage_percent: number;
+  created_at: string;
+}
+
+export interface CoverageTrend {
+  build_job_id: number;
+  /** Oldest first. */
+  points: CoveragePoint[];
+  latest: number | null;
+  delta: number | null;
+}
+
 export interface DashboardRecentAgentRun {
   id: number;
   agent_id: number;
@@ -567,6 +957,13 @@ export interface CliCheckUpdateResult {
 export interface AiAgentRepoBinding {
   repository_id: number;
   branch: string;
+  /** Tag, full ref or commit SHA checked out instead of `branch`. */
+  ref?: string;
+  shallow?: boolean;
+  submodules?: boolean;
+  lfs?: boolean;
+  /** Cone-mode sparse checkout directories. */
+  sparse_paths?: string[];
 }
 
 export interface AiAgentEnvVar {
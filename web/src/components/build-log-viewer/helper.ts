@@ -12,7 +12,8 @@ export type BuildLogStatus =
   | "success"
   | "failed"
   | "cancelled"
-  | "interrupted";
+  | "interrupted"
+  | "timed_out";
 
 export const BUILD_LOG_STATUS_LABEL: Record<BuildLogStatus, string> = {
   pending: "等待中",
@@ -26,6 +27,7 @@ export const BUILD_LOG_STATUS_LABEL: Record<BuildLogStatus, string> = {
   failed: "失败",
   cancelled: "已取消",
   interrupted: "已中断",
+  timed_out: "已超时",
 };
 
 export const BUILD_LOG_STATUS_TAG: Record<
@@ -43,6 +45,7 @@ export const BUILD_LOG_STATUS_TAG: Record<
   failed: "danger",
   cancelled: "warning",
   interrupted: "warning",
+  timed_out: "danger",
 };
 
 const TERMINAL_THEME: ITheme = {
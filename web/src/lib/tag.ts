@@ -17,17 +17,37 @@ export const JOB_STATUS_TAG: Record<string, TagType> = {
   failed: "danger",
   cancelled: "warning",
   interrupted: "warning",
+  timed_out: "danger",
+  aborted: "warning",
 };
 
 export const TRIGGER_TYPE_TAG: Record<string, TagType> = {
   manual: undefined,
   api: "info",
   webhook: "info",
+  pull_request: "warning",
+  tag: "success",
   cron: "primary",
+  poll: "primary",
   build_event: "warning",
   docs_generate: "info",
 };
 
+/** Webhook 投递结果 */
+export const WEBHOOK_OUTCOME_TAG: Record<string, TagType> = {
+  triggered: "success",
+  duplicate: undefined,
+  ignored: undefined,
+  skipped: undefined,
+  disabled: "warning",
+  branch_not_matched: "info",
+  tag_not_matched: "info",
+  path_not_matched: "info",
+  enqueue_failed: "danger",
+  invalid: "danger",
+  unauthorized: "danger",
+};
+
 /** 构建流水线阶段 */
 export const BUILD_STAGE_TAG: Record<string, TagType> = {
   pending: undefined,
@@ -35,6 +55,7 @@ export const BUILD_STAGE_TAG: Record<string, TagType> = {
   building: "primary",
   archiving: "primary",
   distributing: "warning",
+  waiting_approval: "warning",
   idle: "success",
 };
 
@@ -46,4 +67,22 @@ export const BUILD_DISTRIBUTION_TAG: Record<string, TagType> = {
   partial: "warning",
   all_failed: "danger",
   cancelled: "warning",
+  timed_out: "danger",
+  waiting_approval: "warning",
+  rejected: "info",
+  aborted: "danger",
+};
+
+/** 目标部署记录 */
+export const DEPLOYMENT_KIND_TAG: Record<string, TagType> = {
+  deploy: "success",
+  redeploy: "primary",
+  rollback: "warning",
+};
+
+/** 部署审批 */
+export const DEPLOY_APPROVAL_TAG: Record<string, TagType> = {
+  pending: "warning",
+  approved: "success",
+  rejected: "danger",
 };